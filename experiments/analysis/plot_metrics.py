@@ -44,6 +44,17 @@ ALL_PHASE_LABELS = [
     "Pre-restore", "Restore", "Switch Update",
 ]
 
+# Phases as spans between the collector's marker rows (migration_phase),
+# mirroring metricsmodel.Phases. main() fills _phase_spans with
+# (label, start_s, end_s) and _draw_migrations shades them.
+MARKER_PHASES = [
+    ("Checkpoint", "checkpoint_start", "checkpoint_done"),
+    ("Transfer", "transfer_start", "transfer_done"),
+    ("Restore", "restore_start", "restore_done"),
+    ("Switch Update", "restore_done", "switch_updated"),
+]
+_phase_spans = []
+
 PING_LABELS = {
     "192.168.12.2":   "Server (192.168.12.2)",
     "192.168.12.10":  "VIP (192.168.12.10)",
@@ -84,7 +95,13 @@ def _short_node(name):
 
 
 def _draw_migrations(ax, m_times, label=True):
-    """Draw vertical dashed lines at each migration start time."""
+    """Draw vertical dashed lines at each migration start time and shade
+    the phases from the marker rows."""
+    shaded = set()
+    for name, t0, t1 in _phase_spans:
+        lbl = name if (label and name not in shaded) else None
+        shaded.add(name)
+        ax.axvspan(t0, t1, color=PHASE_COLORS[name], alpha=0.15, lw=0, label=lbl)
     color = "#D32F2F"
     for i, t in enumerate(m_times):
         lbl = f"Migrations (n={len(m_times)})" if (label and i == 0) else None
@@ -155,6 +172,19 @@ def _migration_times_sec(df, events):
     return [(m - t0) / 1000.0 for m in ms_list]
 
 
+def _phase_spans_from_markers(markers):
+    """Pair the marker rows (t_sec, migration_phase) into phase spans."""
+    spans, seen = [], {}
+    for t, phase in zip(markers["t_sec"], markers["migration_phase"]):
+        if phase == "migration_started":
+            seen = {}
+        seen[phase] = t
+        for name, start, end in MARKER_PHASES:
+            if phase == end and start in seen:
+                spans.append((name, seen[start], t))
+    return spans
+
+
 def _split_legend(ax, metric_loc="upper left"):
     """Place a single unified legend (migrations now have only one entry)."""
     handles, labels = ax.get_legend_handles_labels()
@@ -325,13 +355,14 @@ def plot_ws_latency(df, m_times, output_dir, show, events=None):
 
 
 def plot_throughput(df, m_times, output_dir, show, events=None):
-    """Server data throughput derived from bytes_sent.
+    """Server data throughput derived from wire_bytes_sent (TCP_INFO), or
+    bytes_sent for CSVs from before that column existed.
 
     Shows two metrics when bytes_received is available:
     - Server write rate (bytes_sent delta — includes buffer fills)
     - Client receive confirmation (bytes_received delta on server — actual delivery)
     """
-    col = _col(df, "bytes_sent")
+    col = _col(df, "wire_bytes_sent", "bytes_sent")
     if not col:
         return
 
@@ -425,7 +456,8 @@ def plot_ping_rtt(df, m_times, output_dir, show, events=None):
 def plot_container_resources(df, m_times, output_dir, show, events=None):
     """Container CPU utilisation over time."""
     cpu_cols = [c for c in df.columns
-                if c.endswith("_cpu") or (c.startswith("cpu_") and len(c) > 4)
+                if c.endswith("_cpu") or c.endswith("_cpu_pct")
+                or (c.startswith("cpu_") and len(c) > 4)
                 or c == "cpu_percent"]
     if not cpu_cols:
         return
@@ -446,7 +478,7 @@ def plot_container_resources(df, m_times, output_dir, show, events=None):
 
     palette = sns.color_palette("deep", len(cpu_cols))
     for i, col in enumerate(cpu_cols):
-        name = col.replace("container_", "").replace("_cpu", "").replace("cpu_", "")
+        name = col.replace("container_", "").replace("_cpu_pct", "").replace("_cpu", "").replace("cpu_", "")
         if name == "percent":
             name = "server"
         cpu = df[col].astype(str).str.rstrip("%").str.strip()
@@ -669,8 +701,8 @@ def plot_rtt_by_location(df, m_times, events, output_dir, show):
 
 
 def _compute_throughput_rate(df):
-    """Derive per-second throughput (KB/s) from cumulative bytes_sent."""
-    col = _col(df, "bytes_sent")
+    """Derive per-second throughput (KB/s) from cumulative bytes sent."""
+    col = _col(df, "wire_bytes_sent", "bytes_sent")
     if not col:
         return pd.Series(np.nan, index=df.index)
     raw = _numeric(df, col).where(lambda x: x > 0)
@@ -965,7 +997,18 @@ def main():
         print("CSV needs 'timestamp_unix_milli' or 'elapsed_s'")
         sys.exit(1)
 
-    m_times = _migration_times_sec(df, events)
+    # Marker rows are no samples; they only place the phase bands, and
+    # their migration_started rows the migrations when there are no
+    # timing files.
+    marker_starts = []
+    if "migration_phase" in df.columns:
+        markers = df[df["migration_phase"].notna()]
+        df = df[df["migration_phase"].isna()].reset_index(drop=True)
+        _phase_spans.extend(_phase_spans_from_markers(markers))
+        marker_starts = list(
+            markers.loc[markers["migration_phase"] == "migration_started", "t_sec"])
+
+    m_times = _migration_times_sec(df, events) or marker_starts
 
     print(f"Loaded {len(df)} rows, duration {df['t_sec'].iloc[-1]:.0f}s, "
           f"{len(events)} migrations")
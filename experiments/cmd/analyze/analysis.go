@@ -0,0 +1,231 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// point is one aggregated loadgen interval: the summed receive rate of all
+// peers and the worst freeze any of them saw.
+type point struct {
+	t         time.Time
+	bps       float64
+	connected int
+	maxFreeze float64
+}
+
+// aggregate sums the per-peer samples into one point per bucket.
+func aggregate(samples []peerSample, bucket time.Duration) []point {
+	byBucket := map[int64]*point{}
+	for _, s := range samples {
+		k := s.TimestampUnixMilli / bucket.Milliseconds()
+		p := byBucket[k]
+		if p == nil {
+			p = &point{t: time.UnixMilli(k * bucket.Milliseconds())}
+			byBucket[k] = p
+		}
+		p.bps += s.BytesPerSecond
+		if s.Connected {
+			p.connected++
+		}
+		p.maxFreeze = max(p.maxFreeze, s.MaxFreezeMs)
+	}
+	out := make([]point, 0, len(byBucket))
+	for _, p := range byBucket {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].t.Before(out[j].t) })
+	return out
+}
+
+// seriesFromCSV derives the server's send rate from the collector's
+// cumulative byte counters, for runs without loadgen output. It prefers
+// the on-the-wire counter and falls back to application bytes. Rows whose
+// server fetch failed get no point; the next good row's rate spans the
+// whole gap since the last good one.
+func seriesFromCSV(rows []collectorRow) []point {
+	var out []point
+	prev := -1
+	for i := range rows {
+		if !rows[i].serverOK {
+			continue
+		}
+		if prev < 0 {
+			prev = i
+			continue
+		}
+		p, cur := rows[prev], rows[i]
+		prev = i
+		dt := cur.t.Sub(p.t).Seconds()
+		b0, b1 := p.wireBytes, cur.wireBytes
+		if b1 == 0 {
+			b0, b1 = p.bytesSent, cur.bytesSent
+		}
+		if dt <= 0 || b1 < b0 {
+			// A restarted server resets its counters; skip the step.
+			continue
+		}
+		out = append(out, point{t: cur.t, bps: (b1 - b0) / dt})
+	}
+	return out
+}
+
+type pingLoss struct {
+	Host          string  `json:"host"`
+	StartOffsetMs float64 `json:"start_offset_ms"`
+	DurationMs    float64 `json:"duration_ms"`
+	Lost          int     `json:"lost"`
+}
+
+// migrationResult is the per-migration part of results.json. Offsets are
+// relative to the migration start; rates are bytes/s summed over peers.
+type migrationResult struct {
+	Index            int        `json:"index"`
+	Source           string     `json:"source"`
+	Direction        string     `json:"direction,omitempty"` // forward or back in a ping-pong run
+	Mode             string     `json:"mode,omitempty"`      // stop, precopy or lazy
+	Transfer         string     `json:"transfer,omitempty"`  // rsync, tar, scp or shared
+	StartUnixMilli   int64      `json:"start_unix_milli"`
+	StartOffsetS     float64    `json:"start_offset_s"`
+	TimeToReadyMs    float64    `json:"server_time_to_ready_ms,omitempty"`
+	ScriptTotalMs    float64    `json:"script_total_ms,omitempty"`
+	BaselineBps      float64    `json:"baseline_bps"`
+	MinBps           float64    `json:"min_bps"`
+	DipDepth         float64    `json:"dip_depth"`
+	DipStartOffsetMs float64    `json:"dip_start_offset_ms"`
+	DipDurationMs    float64    `json:"dip_duration_ms"`
+	RecoveryMs       float64    `json:"recovery_ms"`
+	Recovered        bool       `json:"recovered"`
+	ZeroThroughputMs float64    `json:"zero_throughput_ms"`
+	MaxFreezeMs      float64    `json:"max_freeze_ms"`
+	MaxWsRttMs       float64    `json:"max_ws_rtt_ms"`
+	PingLoss         []pingLoss `json:"ping_loss,omitempty"`
+}
+
+type thresholds struct {
+	baseline time.Duration // window before the migration used as baseline
+	window   time.Duration // longest window after the migration to analyze
+	dip      float64       // fraction of baseline below which the stream is degraded
+	zero     float64       // fraction of baseline below which it is down
+	bucket   time.Duration
+}
+
+// analyzeMigration measures one migration starting at start. end bounds
+// the window (the next migration or the end of data).
+func analyzeMigration(start, end time.Time, series []point, rows []collectorRow, hosts []string, th thresholds) migrationResult {
+	r := migrationResult{StartUnixMilli: start.UnixMilli()}
+	if w := start.Add(th.window); w.Before(end) {
+		end = w
+	}
+
+	var sum float64
+	var n int
+	for _, p := range series {
+		if !p.t.Before(start.Add(-th.baseline)) && p.t.Before(start) {
+			sum += p.bps
+			n++
+		}
+	}
+	if n > 0 {
+		r.BaselineBps = sum / float64(n)
+	}
+
+	r.MinBps = math.Inf(1)
+	var dipStart time.Time
+	for _, p := range series {
+		if p.t.Before(start) || !p.t.Before(end) {
+			continue
+		}
+		r.MinBps = min(r.MinBps, p.bps)
+		r.MaxFreezeMs = max(r.MaxFreezeMs, p.maxFreeze)
+		if r.BaselineBps == 0 {
+			continue
+		}
+		if p.bps < th.zero*r.BaselineBps {
+			r.ZeroThroughputMs += float64(th.bucket.Milliseconds())
+		}
+		degraded := p.bps < th.dip*r.BaselineBps
+		switch {
+		case degraded && dipStart.IsZero():
+			// The bucket's rate covers the interval before its timestamp.
+			dipStart = p.t.Add(-th.bucket)
+			if dipStart.Before(start) {
+				dipStart = start
+			}
+			r.DipStartOffsetMs = ms(dipStart.Sub(start))
+		case !degraded && !dipStart.IsZero() && !r.Recovered:
+			r.Recovered = true
+			r.DipDurationMs = ms(p.t.Sub(dipStart))
+			r.RecoveryMs = ms(p.t.Sub(start))
+		}
+	}
+	if math.IsInf(r.MinBps, 1) {
+		r.MinBps = 0
+	}
+	if r.BaselineBps > 0 {
+		r.DipDepth = 1 - r.MinBps/r.BaselineBps
+	}
+	if dipStart.IsZero() {
+		// No dip at all: the migration was invisible at this resolution.
+		r.Recovered = true
+	}
+
+	lossStart := map[string]time.Time{}
+	lost := map[string]int{}
+	flush := func(h string, until time.Time) {
+		if lost[h] > 0 {
+			r.PingLoss = append(r.PingLoss, pingLoss{
+				Host:          h,
+				StartOffsetMs: ms(lossStart[h].Sub(start)),
+				DurationMs:    ms(until.Sub(lossStart[h])),
+				Lost:          lost[h],
+			})
+		}
+		lost[h] = 0
+	}
+	for _, row := range rows {
+		if row.t.Before(start.Add(-th.bucket)) || !row.t.Before(end) {
+			continue
+		}
+		r.MaxWsRttMs = max(r.MaxWsRttMs, row.rttMaxMs)
+		for _, h := range hosts {
+			if n := row.pingLost[h]; n > 0 {
+				if lost[h] == 0 {
+					lossStart[h] = row.t
+				}
+				lost[h] += n
+			} else {
+				flush(h, row.t)
+			}
+		}
+	}
+	for _, h := range hosts {
+		flush(h, end)
+	}
+	return r
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+// stats summarizes one metric across migrations.
+type stats struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	Max  float64 `json:"max"`
+}
+
+func summarize(vals []float64) stats {
+	if len(vals) == 0 {
+		return stats{}
+	}
+	s := append([]float64(nil), vals...)
+	sort.Float64s(s)
+	var sum float64
+	for _, v := range s {
+		sum += v
+	}
+	pct := func(p float64) float64 { return s[int(math.Ceil(p*float64(len(s))))-1] }
+	return stats{Mean: sum / float64(len(s)), P50: pct(0.5), P95: pct(0.95), Max: s[len(s)-1]}
+}
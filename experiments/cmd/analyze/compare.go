@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// metric is one per-migration value compared across configurations.
+type metric struct {
+	name string
+	get  func(migrationResult) (float64, bool)
+}
+
+var compareMetrics = []metric{
+	{"downtime_ms", func(r migrationResult) (float64, bool) { return r.ZeroThroughputMs, true }},
+	{"max_freeze_ms", func(r migrationResult) (float64, bool) { return r.MaxFreezeMs, true }},
+	{"dip_depth", func(r migrationResult) (float64, bool) { return r.DipDepth, true }},
+	{"dip_duration_ms", func(r migrationResult) (float64, bool) { return r.DipDurationMs, r.Recovered }},
+	{"recovery_ms", func(r migrationResult) (float64, bool) { return r.RecoveryMs, r.Recovered }},
+	{"server_time_to_ready_ms", func(r migrationResult) (float64, bool) { return r.TimeToReadyMs, r.TimeToReadyMs > 0 }},
+}
+
+// config is every migration of the runs given under one label.
+type config struct {
+	Label      string   `json:"label"`
+	Files      []string `json:"files"`
+	migrations []migrationResult
+}
+
+// num is a statistic that is NaN when there are too few samples; it
+// encodes as null, which JSON has instead of NaN.
+type num float64
+
+func (n num) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(n))
+}
+
+// described is one metric of one configuration.
+type described struct {
+	N      int `json:"n"`
+	Mean   num `json:"mean"`
+	Median num `json:"median"`
+	StdDev num `json:"stddev"`
+	CILow  num `json:"ci_low"`
+	CIHigh num `json:"ci_high"`
+}
+
+// test compares one metric of a configuration with the reference.
+type test struct {
+	Against      string `json:"against"`
+	MeanDiff     num    `json:"mean_diff"`
+	WelchT       num    `json:"welch_t"`
+	WelchP       num    `json:"welch_p"`
+	MannWhitneyU num    `json:"mann_whitney_u"`
+	MannWhitneyP num    `json:"mann_whitney_p"`
+}
+
+type comparison struct {
+	Confidence float64                         `json:"confidence"`
+	Reference  string                          `json:"reference"`
+	Configs    []config                        `json:"configs"`
+	Metrics    map[string]map[string]described `json:"metrics"` // metric -> label -> stats
+	Tests      map[string]map[string]test      `json:"tests"`   // metric -> label -> test against the reference
+}
+
+// compareMain is `analyze compare [flags] [label=]run ...`: it pools the
+// migrations of every results.json under each run, describes each metric
+// per configuration and tests every configuration against the first.
+// Runs given the same label are pooled into one configuration.
+func compareMain(args []string) {
+	set := flag.NewFlagSet("compare", flag.ExitOnError)
+	output := set.String("output", "", "Also write the comparison as JSON to this file")
+	confidence := set.Float64("confidence", 0.95, "Confidence level of the intervals of the mean")
+	set.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s compare [flags] [label=]run-or-results.json ...\n", os.Args[0])
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if set.NArg() < 2 {
+		set.Usage()
+		os.Exit(2)
+	}
+	if *confidence <= 0 || *confidence >= 1 {
+		log.Fatalf("-confidence must be between 0 and 1, got %g", *confidence)
+	}
+
+	var configs []config
+	byLabel := map[string]int{}
+	for _, arg := range set.Args() {
+		label, path, ok := strings.Cut(arg, "=")
+		if !ok {
+			path = arg
+			label = filepath.Base(filepath.Clean(path))
+			if label == "results.json" {
+				label = filepath.Base(filepath.Dir(filepath.Clean(path)))
+			}
+		}
+		files, err := findResults(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		i, ok := byLabel[label]
+		if !ok {
+			i = len(configs)
+			byLabel[label] = i
+			configs = append(configs, config{Label: label})
+		}
+		for _, f := range files {
+			var res results
+			data, err := os.ReadFile(f)
+			if err == nil {
+				err = json.Unmarshal(data, &res)
+			}
+			if err != nil {
+				log.Fatalf("%s: %v", f, err)
+			}
+			configs[i].Files = append(configs[i].Files, f)
+			configs[i].migrations = append(configs[i].migrations, res.Migrations...)
+		}
+	}
+	if len(configs) < 2 {
+		log.Fatal("compare needs at least two configurations")
+	}
+
+	c := comparison{
+		Confidence: *confidence,
+		Reference:  configs[0].Label,
+		Configs:    configs,
+		Metrics:    map[string]map[string]described{},
+		Tests:      map[string]map[string]test{},
+	}
+	for _, m := range compareMetrics {
+		c.Metrics[m.name] = map[string]described{}
+		c.Tests[m.name] = map[string]test{}
+		ref := values(configs[0].migrations, m)
+		for _, cfg := range configs {
+			v := values(cfg.migrations, m)
+			c.Metrics[m.name][cfg.Label] = describe(v, *confidence)
+			if cfg.Label == c.Reference {
+				continue
+			}
+			t, p := welch(v, ref)
+			u, up := mannWhitney(v, ref)
+			c.Tests[m.name][cfg.Label] = test{
+				Against: c.Reference, MeanDiff: num(mean(v) - mean(ref)),
+				WelchT: num(t), WelchP: num(p), MannWhitneyU: num(u), MannWhitneyP: num(up),
+			}
+		}
+	}
+	printComparison(c)
+
+	if *output != "" {
+		data, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*output, append(data, '\n'), 0o644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Comparison written to %s", *output)
+	}
+}
+
+// findResults is path itself if it is a file, else every results.json
+// below it (one per iteration in a runner directory).
+func findResults(path string) ([]string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == "results.json" {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("%s: no results.json (run analyze on it first)", path)
+	}
+	sort.Strings(files)
+	return files, err
+}
+
+func values(migs []migrationResult, m metric) []float64 {
+	var v []float64
+	for _, r := range migs {
+		if x, ok := m.get(r); ok {
+			v = append(v, x)
+		}
+	}
+	return v
+}
+
+func printComparison(c comparison) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	ci := fmt.Sprintf("%g%% CI", c.Confidence*100)
+	fmt.Fprintf(tw, "metric\tconfig\tn\tmean\tmedian\t%s\tdiff\twelch p\tmann-whitney p\t\n", ci)
+	for _, m := range compareMetrics {
+		// Metrics no run has, such as the time to ready without timing
+		// files, would only be rows of NaN.
+		if !slices.ContainsFunc(c.Configs, func(cfg config) bool { return c.Metrics[m.name][cfg.Label].N > 0 }) {
+			continue
+		}
+		for _, cfg := range c.Configs {
+			d := c.Metrics[m.name][cfg.Label]
+			diff, wp, mp := "ref", "", ""
+			if t, ok := c.Tests[m.name][cfg.Label]; ok {
+				diff = fmt.Sprintf("%+.4g", t.MeanDiff)
+				wp, mp = pValue(float64(t.WelchP)), pValue(float64(t.MannWhitneyP))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.4g\t%.4g\t[%.4g, %.4g]\t%s\t%s\t%s\t\n",
+				m.name, cfg.Label, d.N, d.Mean, d.Median, d.CILow, d.CIHigh, diff, wp, mp)
+		}
+	}
+	tw.Flush()
+}
+
+func pValue(p float64) string {
+	switch {
+	case math.IsNaN(p):
+		return "-"
+	case p < 0.001:
+		return "<0.001"
+	}
+	return fmt.Sprintf("%.3f", p)
+}
+
+func mean(v []float64) float64 {
+	if len(v) == 0 {
+		return math.NaN()
+	}
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+func variance(v []float64) float64 {
+	if len(v) < 2 {
+		return math.NaN()
+	}
+	m := mean(v)
+	var ss float64
+	for _, x := range v {
+		ss += (x - m) * (x - m)
+	}
+	return ss / float64(len(v)-1)
+}
+
+func median(v []float64) float64 {
+	if len(v) == 0 {
+		return math.NaN()
+	}
+	s := append([]float64(nil), v...)
+	sort.Float64s(s)
+	if n := len(s); n%2 == 0 {
+		return (s[n/2-1] + s[n/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+// describe computes the mean with its Student-t confidence interval.
+func describe(v []float64, confidence float64) described {
+	m, sd := mean(v), math.Sqrt(variance(v))
+	d := described{N: len(v), Mean: num(m), Median: num(median(v)), StdDev: num(sd)}
+	d.CILow, d.CIHigh = num(math.NaN()), num(math.NaN())
+	if len(v) >= 2 {
+		h := tQuantile((1+confidence)/2, float64(len(v)-1)) * sd / math.Sqrt(float64(len(v)))
+		d.CILow, d.CIHigh = num(m-h), num(m+h)
+	}
+	return d
+}
+
+// welch is Welch's unequal-variance t-test: t and the two-sided p.
+func welch(a, b []float64) (float64, float64) {
+	if len(a) < 2 || len(b) < 2 {
+		return math.NaN(), math.NaN()
+	}
+	va, vb := variance(a)/float64(len(a)), variance(b)/float64(len(b))
+	if va+vb == 0 {
+		if mean(a) == mean(b) {
+			return 0, 1
+		}
+		return math.Inf(1), 0
+	}
+	t := (mean(a) - mean(b)) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(len(a)-1) + vb*vb/float64(len(b)-1))
+	return t, betaInc(df/2, 0.5, df/(df+t*t))
+}
+
+// mannWhitney is the Mann-Whitney U test of a against b: U of a and the
+// two-sided p from the normal approximation with tie and continuity
+// corrections, which is adequate from about eight samples per side.
+func mannWhitney(a, b []float64) (float64, float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return math.NaN(), math.NaN()
+	}
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]obs, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+	var rankA, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		// Tied values share the average of their ranks i+1..j.
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := rankA - n1*(n1+1)/2
+	n := n1 + n2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	z := max(math.Abs(u-n1*n2/2)-0.5, 0) / sigma
+	return u, math.Erfc(z / math.Sqrt2)
+}
+
+// tCDF is the CDF of Student's t distribution with df degrees of freedom.
+func tCDF(t, df float64) float64 {
+	p := betaInc(df/2, 0.5, df/(df+t*t)) / 2
+	if t > 0 {
+		return 1 - p
+	}
+	return p
+}
+
+// tQuantile inverts tCDF by bisection.
+func tQuantile(p, df float64) float64 {
+	lo, hi := -1e3, 1e3
+	for range 200 {
+		mid := (lo + hi) / 2
+		if tCDF(mid, df) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// betaInc is the regularized incomplete beta function I_x(a, b), by the
+// continued fraction in Numerical Recipes (6.4).
+func betaInc(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaCF(a, b, x) / a
+	}
+	return 1 - front*betaCF(b, a, 1-x)/b
+}
+
+func betaCF(a, b, x float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1.0; m <= 300; m++ {
+		aa := m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		for i := 0; i < 2; i++ {
+			d = 1 + aa*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + aa/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+			if i == 0 {
+				aa = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+			}
+		}
+		if math.Abs(d*c-1) < 3e-14 {
+			break
+		}
+	}
+	return h
+}
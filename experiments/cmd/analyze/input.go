@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+)
+
+// collectorRow is one collector CSV row. Columns the analysis does not
+// use are ignored; missing columns read as zero, so older CSVs still load.
+type collectorRow struct {
+	t         time.Time
+	wireBytes float64
+	bytesSent float64
+	rttMaxMs  float64
+	migration bool
+	// serverOK is false when the collector could not fetch the server's
+	// metrics (server_metrics_ok=0); its counters are then blank, not zero.
+	serverOK bool
+	// pings maps a target to its RTT in ms; pingLost to the probes lost
+	// in the row.
+	pings    map[string]float64
+	pingLost map[string]int
+}
+
+// phaseMark is a marker row of the collector CSV: an orchestrator phase
+// event such as checkpoint_start, at the orchestrator's clock.
+type phaseMark struct {
+	t     time.Time
+	phase string
+}
+
+// readCollectorCSV loads the collector output. Ping columns are any
+// ping_rtt_ms_<ip> / ping_ms_<ip>, with the losses in ping_lost_<ip>.
+// Without a ping_lost_ column (older CSVs, one probe per row) an empty or
+// negative RTT is a lost probe. Marker rows are returned apart from the
+// samples.
+//
+// CSVs from a newer collector than this analyze knows are refused rather
+// than misread, as are CSVs whose schema sidecar does not match them.
+func readCollectorCSV(path string) ([]collectorRow, []phaseMark, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	col := make(map[string]int, len(header))
+	pingCols := map[string]int{}
+	lostCols := map[string]int{}
+	for i, h := range header {
+		col[h] = i
+		if strings.HasPrefix(h, "ping_lost_") {
+			lostCols[strings.ReplaceAll(strings.TrimPrefix(h, "ping_lost_"), "_", ".")] = i
+		}
+		for _, p := range []string{"ping_rtt_ms_", "ping_ms_"} {
+			if strings.HasPrefix(h, p) {
+				pingCols[strings.ReplaceAll(strings.TrimPrefix(h, p), "_", ".")] = i
+			}
+		}
+	}
+	if _, ok := col["timestamp_unix_milli"]; !ok {
+		return nil, nil, nil, fmt.Errorf("%s: no timestamp_unix_milli column", path)
+	}
+	if err := checkSchema(path, header); err != nil {
+		return nil, nil, nil, err
+	}
+	versionCol := colOr(col, "schema_version")
+	phaseCol := colOr(col, "migration_phase")
+	num := func(rec []string, name string) float64 {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return 0
+		}
+		v, _ := strconv.ParseFloat(rec[i], 64)
+		return v
+	}
+
+	var rows []collectorRow
+	var marks []phaseMark
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := checkVersion(path, valueAt(rec, versionCol)); err != nil {
+			return nil, nil, nil, err
+		}
+		if phase := valueAt(rec, phaseCol); phase != "" {
+			marks = append(marks, phaseMark{time.UnixMilli(int64(num(rec, "timestamp_unix_milli"))), phase})
+			continue
+		}
+		row := collectorRow{
+			t:         time.UnixMilli(int64(num(rec, "timestamp_unix_milli"))),
+			wireBytes: num(rec, "wire_bytes_sent"),
+			bytesSent: num(rec, "bytes_sent"),
+			rttMaxMs:  num(rec, "ws_rtt_max_ms"),
+			migration: num(rec, "migration_event") == 1,
+			serverOK:  valueAt(rec, colOr(col, "server_metrics_ok")) != "0",
+			pings:     map[string]float64{},
+			pingLost:  map[string]int{},
+		}
+		for host, i := range pingCols {
+			v, err := strconv.ParseFloat(strings.TrimSpace(valueAt(rec, i)), 64)
+			if err == nil && v >= 0 {
+				row.pings[host] = v
+			}
+			if j, ok := lostCols[host]; ok {
+				row.pingLost[host], _ = strconv.Atoi(valueAt(rec, j))
+			} else if err != nil || v < 0 {
+				row.pingLost[host] = 1
+			}
+		}
+		rows = append(rows, row)
+	}
+	hosts := make([]string, 0, len(pingCols))
+	for h := range pingCols {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return rows, marks, hosts, nil
+}
+
+// checkSchema checks the CSV's sidecar, if it has one: its version must
+// be one this analyze reads and its columns those of the CSV.
+func checkSchema(path string, header []string) error {
+	s, err := metricsmodel.ReadSchema(metricsmodel.SchemaPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(path, strconv.Itoa(s.Version)); err != nil {
+		return err
+	}
+	if strings.Join(s.Names(), ",") != strings.Join(header, ",") {
+		return fmt.Errorf("%s: header does not match %s", path, metricsmodel.SchemaPath(path))
+	}
+	return nil
+}
+
+// checkVersion refuses a schema_version newer than metricsmodel's. Empty
+// is a version 1 CSV, which predates the column.
+func checkVersion(path, v string) error {
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: schema version %q: %w", path, v, err)
+	}
+	if n > metricsmodel.SchemaVersion {
+		return fmt.Errorf("%s: written with schema version %d, this analyze reads up to %d; rebuild it", path, n, metricsmodel.SchemaVersion)
+	}
+	return nil
+}
+
+// colOr is the index of name in col, or -1 if there is no such column.
+func colOr(col map[string]int, name string) int {
+	if i, ok := col[name]; ok {
+		return i
+	}
+	return -1
+}
+
+func valueAt(rec []string, i int) string {
+	if i >= 0 && i < len(rec) {
+		return rec[i]
+	}
+	return ""
+}
+
+// peerSample is one per-peer line of the loadgen's stdout JSONL.
+type peerSample struct {
+	PeerID             int     `json:"peer_id"`
+	TimestampUnixMilli int64   `json:"timestamp_unix_milli"`
+	Connected          bool    `json:"connected"`
+	BytesPerSecond     float64 `json:"bytes_per_second"`
+	MaxFreezeMs        float64 `json:"max_freeze_ms"`
+}
+
+// readLoadgenJSONL loads the per-peer samples. The loadgen log mixes
+// these with log lines, so anything that is not a JSON object is skipped.
+// The collector's peers.jsonl has them as the fields of peer_snapshot
+// events; both are read.
+// offset is added to every timestamp to correct for clock skew between
+// the loadgen host and the collector host.
+func readLoadgenJSONL(path string, offset time.Duration) ([]peerSample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []peerSample
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var s peerSample
+		if json.Unmarshal(snapshotFields(line), &s) != nil || s.TimestampUnixMilli == 0 {
+			continue
+		}
+		s.TimestampUnixMilli += offset.Milliseconds()
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TimestampUnixMilli < out[j].TimestampUnixMilli })
+	return out, sc.Err()
+}
+
+// snapshotFields returns the fields of a peer_snapshot event line, and
+// any other line as is.
+func snapshotFields(line []byte) []byte {
+	var ev struct {
+		Type   string          `json:"type"`
+		Fields json.RawMessage `json:"fields"`
+	}
+	if json.Unmarshal(line, &ev) == nil && ev.Type == "peer_snapshot" {
+		return ev.Fields
+	}
+	return line
+}
+
+// migrationTiming is one migration_timing*.txt written by cr_hw.sh or the
+// orchestrator.
+type migrationTiming struct {
+	File          string
+	Start         time.Time
+	TimeToReadyMs float64
+	TotalMs       float64
+	Fields        map[string]string
+}
+
+// readTimings loads every migration_timing*.txt in dir. cr_hw.sh runs
+// leave a migration_timing.txt copy of the last numbered file; it is
+// skipped when numbered ones exist.
+func readTimings(dir string) ([]migrationTiming, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "migration_timing_*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(filepath.Join(dir, "migration_timing.txt")); err == nil {
+			files = []string{filepath.Join(dir, "migration_timing.txt")}
+		}
+	}
+	var out []migrationTiming
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m := migrationTiming{File: filepath.Base(path), Fields: map[string]string{}}
+		for _, line := range strings.Split(string(data), "\n") {
+			k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if ok {
+				m.Fields[k] = v
+			}
+		}
+		startNs, err := strconv.ParseInt(m.Fields["migration_start_ns"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: no migration_start_ns", path)
+		}
+		m.Start = time.Unix(0, startNs)
+		m.TimeToReadyMs, _ = strconv.ParseFloat(m.Fields["time_to_ready_ms"], 64)
+		m.TotalMs, _ = strconv.ParseFloat(m.Fields["total_ms"], 64)
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out, nil
+}
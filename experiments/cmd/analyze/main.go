@@ -0,0 +1,208 @@
+// Command analyze turns one run's collector CSV, loadgen JSONL and
+// migration timing files into results.json: per-migration downtime,
+// throughput dip depth and duration, recovery time and ping-loss windows,
+// plus a summary across migrations. With -plots it also draws the
+// throughput and ping series with migration markers.
+//
+//	analyze -run-dir results/baseline_20260101_120000/iter_1 -plots plots
+//	analyze compare stop=results/stop_a precopy=results/precopy_a precopy=results/precopy_b
+//	analyze pcap -run-dir results/baseline_20260101_120000/iter_1 results/baseline_20260101_120000/iter_1/capture.pcap
+//
+// compare pools the migrations of several runs per configuration and
+// prints means, medians and confidence intervals of downtime and
+// throughput dip, with Welch and Mann-Whitney tests against the first.
+// pcap measures the blackout, duplicates and reordering of the server's
+// media flows in packet captures of each migration window, next to the
+// application-level numbers.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	runDir        = flag.String("run-dir", "", "Run directory; sets the defaults of -csv, -loadgen, -timings and -output")
+	csvPath       = flag.String("csv", "", "Collector CSV (default <run-dir>/metrics.csv)")
+	loadgenPath   = flag.String("loadgen", "", "Loadgen stdout with per-peer JSON lines, or the collector's peers.jsonl (default <run-dir>/peers.jsonl if present, else loadgen.log)")
+	timingsDir    = flag.String("timings", "", "Directory with migration_timing*.txt (default <run-dir>); without any, migrations come from the CSV's migration_started markers or migration_event column")
+	outputPath    = flag.String("output", "", "results JSON path (default <run-dir>/results.json)")
+	plotDir       = flag.String("plots", "", "Write plots to this directory (empty = no plots)")
+	plotFormat    = flag.String("plot-format", "png", "Plot format: png or svg")
+	loadgenOffset = flag.Duration("loadgen-clock-offset", 0, "Added to loadgen timestamps to align them with the collector's clock")
+	bucket        = flag.Duration("bucket", time.Second, "Aggregation interval for loadgen samples (the loadgen's -interval)")
+	baselineWin   = flag.Duration("baseline", 10*time.Second, "Window before each migration used as the throughput baseline")
+	maxWindow     = flag.Duration("window", 60*time.Second, "Longest window after a migration start to analyze")
+	dipFrac       = flag.Float64("dip-threshold", 0.9, "Throughput below this fraction of baseline counts as a dip")
+	zeroFrac      = flag.Float64("zero-threshold", 0.05, "Throughput below this fraction of baseline counts as down")
+)
+
+type results struct {
+	RunStartUnixMilli int64             `json:"run_start_unix_milli"`
+	Migrations        []migrationResult `json:"migrations"`
+	Summary           struct {
+		Count            int     `json:"count"`
+		Unrecovered      int     `json:"unrecovered"`
+		TimeToReadyMs    stats   `json:"server_time_to_ready_ms"`
+		MaxFreezeMs      stats   `json:"max_freeze_ms"`
+		ZeroThroughputMs stats   `json:"zero_throughput_ms"`
+		DipDurationMs    stats   `json:"dip_duration_ms"`
+		RecoveryMs       stats   `json:"recovery_ms"`
+		DipDepth         stats   `json:"dip_depth"`
+		PingLossMs       float64 `json:"ping_loss_total_ms"`
+	} `json:"summary"`
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	switch flag.Arg(0) {
+	case "compare":
+		compareMain(flag.Args()[1:])
+		return
+	case "pcap":
+		pcapMain(flag.Args()[1:])
+		return
+	}
+	withDefault := func(p *string, name string) {
+		if *p == "" && *runDir != "" {
+			*p = filepath.Join(*runDir, name)
+		}
+	}
+	withDefault(csvPath, "metrics.csv")
+	// The runner has the loadgen push its samples to the collector.
+	if _, err := os.Stat(filepath.Join(*runDir, "peers.jsonl")); err == nil {
+		withDefault(loadgenPath, "peers.jsonl")
+	}
+	withDefault(loadgenPath, "loadgen.log")
+	withDefault(outputPath, "results.json")
+	if *timingsDir == "" {
+		*timingsDir = *runDir
+	}
+	if *csvPath == "" || *outputPath == "" {
+		log.Fatal("-run-dir, or -csv and -output, are required")
+	}
+	if *plotFormat != "png" && *plotFormat != "svg" {
+		log.Fatalf("-plot-format must be png or svg, got %q", *plotFormat)
+	}
+
+	rows, marks, hosts, err := readCollectorCSV(*csvPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(rows) == 0 {
+		log.Fatalf("%s has no rows", *csvPath)
+	}
+	var samples []peerSample
+	if *loadgenPath != "" {
+		if samples, err = readLoadgenJSONL(*loadgenPath, *loadgenOffset); err != nil {
+			log.Printf("loadgen samples: %v (throughput metrics will be empty)", err)
+		}
+	}
+	series := aggregate(samples, *bucket)
+	if len(series) == 0 {
+		log.Printf("No loadgen samples; using the server's send rate from %s", *csvPath)
+		series = seriesFromCSV(rows)
+	}
+
+	var timings []migrationTiming
+	if *timingsDir != "" {
+		if timings, err = readTimings(*timingsDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	type mig struct {
+		start  time.Time
+		source string
+		t      *migrationTiming
+	}
+	var migs []mig
+	for i := range timings {
+		migs = append(migs, mig{timings[i].Start, timings[i].File, &timings[i]})
+	}
+	// Without timing files, the collector's migration_started markers
+	// are the starts, or else its migration_event samples.
+	if len(migs) == 0 {
+		for _, mk := range marks {
+			if mk.phase == "migration_started" {
+				migs = append(migs, mig{start: mk.t, source: mk.phase})
+			}
+		}
+	}
+	if len(migs) == 0 {
+		for _, r := range rows {
+			if r.migration {
+				migs = append(migs, mig{start: r.t, source: "migration_event"})
+			}
+		}
+	}
+
+	res := results{RunStartUnixMilli: rows[0].t.UnixMilli()}
+	end := rows[len(rows)-1].t
+	if n := len(series); n > 0 && series[n-1].t.After(end) {
+		end = series[n-1].t
+	}
+	th := thresholds{baseline: *baselineWin, window: *maxWindow, dip: *dipFrac, zero: *zeroFrac, bucket: *bucket}
+	var ttr, freeze, zero, dip, rec, depth []float64
+	for i, m := range migs {
+		winEnd := end
+		if i+1 < len(migs) {
+			winEnd = migs[i+1].start
+		}
+		r := analyzeMigration(m.start, winEnd, series, rows, hosts, th)
+		r.Index, r.Source = i+1, m.source
+		r.StartOffsetS = m.start.Sub(rows[0].t).Seconds()
+		if m.t != nil {
+			r.TimeToReadyMs, r.ScriptTotalMs = m.t.TimeToReadyMs, m.t.TotalMs
+			r.Direction = m.t.Fields["migration_direction"]
+			r.Mode, r.Transfer = m.t.Fields["migration_mode"], m.t.Fields["transfer_method"]
+			ttr = append(ttr, r.TimeToReadyMs)
+		}
+		res.Migrations = append(res.Migrations, r)
+
+		freeze = append(freeze, r.MaxFreezeMs)
+		zero = append(zero, r.ZeroThroughputMs)
+		depth = append(depth, r.DipDepth)
+		if r.Recovered {
+			dip = append(dip, r.DipDurationMs)
+			rec = append(rec, r.RecoveryMs)
+		} else {
+			res.Summary.Unrecovered++
+		}
+		for _, pl := range r.PingLoss {
+			res.Summary.PingLossMs += pl.DurationMs
+		}
+		log.Printf("Migration %d at +%.1fs: ready %.0f ms, freeze %.0f ms, dip %.0f%% for %.0f ms, recovered after %.0f ms",
+			r.Index, r.StartOffsetS, r.TimeToReadyMs, r.MaxFreezeMs, r.DipDepth*100, r.DipDurationMs, r.RecoveryMs)
+	}
+	res.Summary.Count = len(migs)
+	res.Summary.TimeToReadyMs = summarize(ttr)
+	res.Summary.MaxFreezeMs = summarize(freeze)
+	res.Summary.ZeroThroughputMs = summarize(zero)
+	res.Summary.DipDurationMs = summarize(dip)
+	res.Summary.RecoveryMs = summarize(rec)
+	res.Summary.DipDepth = summarize(depth)
+
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*outputPath, append(data, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d migrations analyzed, results in %s", len(migs), *outputPath)
+
+	if *plotDir != "" {
+		starts := make([]time.Time, len(migs))
+		for i, m := range migs {
+			starts[i] = m.start
+		}
+		if err := writePlots(*plotDir, *plotFormat, rows[0].t, series, rows, hosts, starts); err != nil {
+			log.Fatalf("plots: %v", err)
+		}
+	}
+}
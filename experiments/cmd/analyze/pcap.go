@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// flowKey is a 5-tuple in one direction.
+type flowKey struct {
+	proto    string
+	src, dst string // address:port
+}
+
+// dataPacket is one captured packet carrying payload.
+type dataPacket struct {
+	t       time.Time
+	flow    int // index into the flow list
+	dup     bool
+	reorder bool
+}
+
+// pcapFlow is one direction of a TCP or UDP flow in the captures.
+type pcapFlow struct {
+	Proto      string `json:"proto"`
+	Src        string `json:"src"`
+	Dst        string `json:"dst"`
+	Media      bool   `json:"media"`
+	Packets    int    `json:"packets"`
+	Bytes      int    `json:"payload_bytes"`
+	Duplicates int    `json:"duplicates"`
+	Reordered  int    `json:"reordered"`
+	MaxGapMs   num    `json:"max_gap_ms"`
+	srcIP      string
+	// TCP sequence tracking: unwrapped sequence numbers relative to the
+	// first segment, the highest end seen and every (seq, len) seen; for
+	// UDP, hashes of the payloads seen.
+	seqBase  uint32
+	lastSeq  int64
+	maxEnd   int64
+	segments map[[2]int64]bool
+	hashes   map[uint64]bool
+}
+
+// pcapMigration is the packet-level view of one migration window, next to
+// the application-level numbers of results.json for the same migration.
+type pcapMigration struct {
+	Index               int   `json:"index"`
+	StartUnixMilli      int64 `json:"start_unix_milli"`
+	Packets             int   `json:"packets"`
+	BlackoutMs          num   `json:"blackout_ms"`
+	BlackoutStartMs     num   `json:"blackout_start_offset_ms"`
+	WorstFlowGapMs      num   `json:"worst_flow_gap_ms"`
+	Duplicates          int   `json:"duplicates"`
+	Reordered           int   `json:"reordered"`
+	AppZeroThroughput   num   `json:"app_zero_throughput_ms"`
+	AppMaxFreezeMs      num   `json:"app_max_freeze_ms"`
+	BlackoutMinusFreeze num   `json:"blackout_minus_freeze_ms"`
+}
+
+type pcapResults struct {
+	Captures   []string        `json:"captures"`
+	ServerIP   string          `json:"server_ip"`
+	Flows      []*pcapFlow     `json:"flows"`
+	Migrations []pcapMigration `json:"migrations"`
+}
+
+// pcapMain is `analyze pcap [flags] capture.pcap ...`: it finds the
+// media flows the server sends on and measures, per migration window,
+// the packet-level blackout (the longest time no media flow got a data
+// packet), duplicates and reordering, to cross-check the loadgen's
+// application-level downtime.
+func pcapMain(args []string) {
+	set := flag.NewFlagSet("pcap", flag.ExitOnError)
+	dir := set.String("run-dir", "", "Directory with migration_timing*.txt and results.json to cross-check (default the first capture's directory)")
+	serverIP := set.String("server-ip", "", "Server address; media flows are the ones it sends payload on (default the address sending the most payload)")
+	minShare := set.Float64("min-share", 0.01, "Flows carrying less than this share of the server's payload are not media (pings, signaling)")
+	window := set.Duration("window", 60*time.Second, "Longest window after a migration start to analyze")
+	offset := set.Duration("clock-offset", 0, "Added to capture timestamps to align them with the orchestrator's clock")
+	output := set.String("output", "", "results JSON path (default <run-dir>/pcap_results.json)")
+	set.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s pcap [flags] capture.pcap ...\n", os.Args[0])
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if set.NArg() == 0 {
+		set.Usage()
+		os.Exit(2)
+	}
+	if *dir == "" {
+		*dir = filepath.Dir(set.Arg(0))
+	}
+	if *output == "" {
+		*output = filepath.Join(*dir, "pcap_results.json")
+	}
+
+	res := pcapResults{Captures: set.Args()}
+	index := map[flowKey]int{}
+	var packets []dataPacket
+	for _, path := range set.Args() {
+		n, err := readCapture(path, *offset, index, &res.Flows, &packets)
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		log.Printf("%s: %d data packets", path, n)
+	}
+	if len(packets) == 0 {
+		log.Fatal("no TCP or UDP payload in the captures")
+	}
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].t.Before(packets[j].t) })
+
+	bySrc := map[string]int{}
+	for _, f := range res.Flows {
+		bySrc[f.srcIP] += f.Bytes
+	}
+	res.ServerIP = *serverIP
+	if res.ServerIP == "" {
+		for ip, b := range bySrc {
+			if b > bySrc[res.ServerIP] {
+				res.ServerIP = ip
+			}
+		}
+	}
+	for _, f := range res.Flows {
+		f.Media = f.srcIP == res.ServerIP && float64(f.Bytes) >= *minShare*float64(bySrc[res.ServerIP])
+	}
+	var media []dataPacket
+	for _, p := range packets {
+		if res.Flows[p.flow].Media {
+			media = append(media, p)
+		}
+	}
+	if len(media) == 0 {
+		log.Fatalf("no media flows from %s", res.ServerIP)
+	}
+	for i, f := range res.Flows {
+		f.MaxGapMs = num(maxGap(packets, i, packets[0].t, packets[len(packets)-1].t).dur)
+	}
+
+	timings, err := readTimings(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var app []migrationResult
+	if data, err := os.ReadFile(filepath.Join(*dir, "results.json")); err == nil {
+		var r results
+		if err := json.Unmarshal(data, &r); err != nil {
+			log.Fatalf("results.json: %v", err)
+		}
+		app = r.Migrations
+	}
+	starts := make([]time.Time, len(timings))
+	for i, t := range timings {
+		starts[i] = t.Start
+	}
+	if len(starts) == 0 {
+		// Without timings the whole capture is one window.
+		log.Printf("No migration timings in %s; analyzing the whole capture", *dir)
+		starts = []time.Time{media[0].t}
+		*window = media[len(media)-1].t.Sub(media[0].t) + time.Nanosecond
+	}
+	for i, start := range starts {
+		end := start.Add(*window)
+		if i+1 < len(starts) && starts[i+1].Before(end) {
+			end = starts[i+1]
+		}
+		m := pcapMigration{Index: i + 1, StartUnixMilli: start.UnixMilli()}
+		for _, p := range media {
+			if p.t.Before(start) || !p.t.Before(end) {
+				continue
+			}
+			m.Packets++
+			if p.dup {
+				m.Duplicates++
+			}
+			if p.reorder {
+				m.Reordered++
+			}
+		}
+		g := maxGap(media, -1, start, end)
+		m.BlackoutMs, m.BlackoutStartMs = num(g.dur), num(g.startOffset)
+		var worst float64
+		for fi, f := range res.Flows {
+			if f.Media {
+				worst = max(worst, maxGap(media, fi, start, end).dur)
+			}
+		}
+		m.WorstFlowGapMs = num(worst)
+		m.AppZeroThroughput, m.AppMaxFreezeMs, m.BlackoutMinusFreeze = num(math.NaN()), num(math.NaN()), num(math.NaN())
+		if i < len(app) {
+			m.AppZeroThroughput = num(app[i].ZeroThroughputMs)
+			m.AppMaxFreezeMs = num(app[i].MaxFreezeMs)
+			m.BlackoutMinusFreeze = num(g.dur - app[i].MaxFreezeMs)
+		}
+		res.Migrations = append(res.Migrations, m)
+		log.Printf("Migration %d: blackout %.1f ms from +%.1f ms (worst flow %.1f ms), %d duplicates, %d reordered; app freeze %.0f ms",
+			m.Index, g.dur, g.startOffset, worst, m.Duplicates, m.Reordered, float64(m.AppMaxFreezeMs))
+	}
+
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, append(data, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d media flows of %d, results in %s", countMedia(res.Flows), len(res.Flows), *output)
+}
+
+func countMedia(flows []*pcapFlow) int {
+	n := 0
+	for _, f := range flows {
+		if f.Media {
+			n++
+		}
+	}
+	return n
+}
+
+type gap struct {
+	dur         float64 // ms
+	startOffset float64 // ms after the window start
+}
+
+// maxGap is the longest interval between consecutive data packets of
+// flow (-1 = any media flow) that overlaps [start, end). Packets just
+// outside the window bound the first and last interval, so a blackout
+// that starts before the window or lasts past it is measured whole.
+func maxGap(pkts []dataPacket, flow int, start, end time.Time) gap {
+	var g gap
+	var prev time.Time
+	for _, p := range pkts {
+		if flow >= 0 && p.flow != flow {
+			continue
+		}
+		if !prev.IsZero() && p.t.After(start) && prev.Before(end) {
+			if d := ms(p.t.Sub(prev)); d > g.dur {
+				g = gap{dur: d, startOffset: ms(prev.Sub(start))}
+			}
+		}
+		prev = p.t
+		if !p.t.Before(end) {
+			break
+		}
+	}
+	return g
+}
+
+// readCapture appends the data packets of one pcap or pcapng file,
+// marking duplicates and reordering per flow as it goes.
+func readCapture(path string, offset time.Duration, index map[flowKey]int, flows *[]*pcapFlow, out *[]dataPacket) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	type reader interface {
+		ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+		LinkType() layers.LinkType
+	}
+	br := bufio.NewReader(f)
+	var r reader
+	magic, err := br.Peek(4)
+	if err != nil {
+		return 0, err
+	}
+	if string(magic) == "\x0a\x0d\x0d\x0a" {
+		r, err = pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	} else {
+		r, err = pcapgo.NewReader(br)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		data, ci, err := r.ReadPacketData()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// tcpdump killed mid-write leaves a truncated last packet.
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		pkt := gopacket.NewPacket(data, r.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		nl, tl := pkt.NetworkLayer(), pkt.TransportLayer()
+		if nl == nil || tl == nil || len(tl.LayerPayload()) == 0 {
+			continue
+		}
+		srcIP, dstIP := nl.NetworkFlow().Endpoints()
+		key := flowKey{src: srcIP.String(), dst: dstIP.String()}
+		switch t := tl.(type) {
+		case *layers.TCP:
+			key.proto = "tcp"
+			key.src += fmt.Sprintf(":%d", t.SrcPort)
+			key.dst += fmt.Sprintf(":%d", t.DstPort)
+		case *layers.UDP:
+			key.proto = "udp"
+			key.src += fmt.Sprintf(":%d", t.SrcPort)
+			key.dst += fmt.Sprintf(":%d", t.DstPort)
+		default:
+			continue
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(*flows)
+			index[key] = i
+			*flows = append(*flows, &pcapFlow{Proto: key.proto, Src: key.src, Dst: key.dst, srcIP: srcIP.String()})
+		}
+		fl := (*flows)[i]
+		payload := tl.LayerPayload()
+		fl.Packets++
+		fl.Bytes += len(payload)
+		p := dataPacket{t: ci.Timestamp.Add(offset), flow: i}
+		if tcp, ok := tl.(*layers.TCP); ok {
+			p.dup, p.reorder = fl.trackTCP(tcp.Seq, len(payload))
+		} else {
+			h := fnv.New64a()
+			h.Write(payload)
+			if fl.hashes == nil {
+				fl.hashes = map[uint64]bool{}
+			}
+			// QUIC payloads are encrypted, so only exact copies are
+			// recognizable; reordering is not.
+			p.dup = fl.hashes[h.Sum64()]
+			fl.hashes[h.Sum64()] = true
+		}
+		if p.dup {
+			fl.Duplicates++
+		}
+		if p.reorder {
+			fl.Reordered++
+		}
+		*out = append(*out, p)
+		n++
+	}
+}
+
+// trackTCP classifies a data segment: a duplicate repeats a segment
+// already seen, a reordered one starts below the highest sequence number
+// seen without being a repeat (a retransmission with other boundaries
+// counts here too).
+func (f *pcapFlow) trackTCP(seq uint32, size int) (dup, reorder bool) {
+	if f.segments == nil {
+		f.segments = map[[2]int64]bool{}
+		f.seqBase = seq
+	}
+	// Unwrap relative to the previous segment; flows of a run never move
+	// 2 GB between two segments.
+	s := f.lastSeq + int64(int32(seq-f.seqBase-uint32(f.lastSeq)))
+	f.lastSeq = s
+	k := [2]int64{s, int64(size)}
+	switch {
+	case f.segments[k]:
+		dup = true
+	case s < f.maxEnd:
+		reorder = true
+	}
+	f.segments[k] = true
+	f.maxEnd = max(f.maxEnd, s+int64(size))
+	return dup, reorder
+}
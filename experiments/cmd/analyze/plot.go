@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+var migrationColor = color.RGBA{R: 0xd3, G: 0x2f, B: 0x2f, A: 0xff}
+
+// writePlots draws throughput.<ext> and, when the CSV has ping columns,
+// ping_rtt.<ext>. The x axis is seconds since the first CSV row and every
+// migration start is a vertical red line.
+func writePlots(dir, ext string, t0 time.Time, series []point, rows []collectorRow, hosts []string, migs []time.Time) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	sec := func(t time.Time) float64 { return t.Sub(t0).Seconds() }
+
+	p := plot.New()
+	p.Title.Text = "Loadgen receive throughput"
+	p.X.Label.Text = "Time (s)"
+	p.Y.Label.Text = "MB/s (all peers)"
+	xy := make(plotter.XYs, len(series))
+	for i, pt := range series {
+		xy[i] = plotter.XY{X: sec(pt.t), Y: pt.bps / 1e6}
+	}
+	if len(xy) > 0 {
+		line, err := plotter.NewLine(xy)
+		if err != nil {
+			return err
+		}
+		p.Add(line)
+	}
+	addMarkers(p, migs, sec)
+	if err := p.Save(10*vg.Inch, 4*vg.Inch, filepath.Join(dir, "throughput."+ext)); err != nil {
+		return err
+	}
+
+	if len(hosts) == 0 {
+		return nil
+	}
+	p = plot.New()
+	p.Title.Text = "Ping RTT"
+	p.X.Label.Text = "Time (s)"
+	p.Y.Label.Text = "RTT (ms)"
+	for i, h := range hosts {
+		var xy plotter.XYs
+		for _, r := range rows {
+			if v, ok := r.pings[h]; ok {
+				xy = append(xy, plotter.XY{X: sec(r.t), Y: v})
+			}
+		}
+		if len(xy) == 0 {
+			continue
+		}
+		s, err := plotter.NewScatter(xy)
+		if err != nil {
+			return err
+		}
+		s.Color = plotter.DefaultLineStyle.Color
+		if i < len(palette) {
+			s.Color = palette[i]
+		}
+		p.Add(s)
+		p.Legend.Add(h, s)
+	}
+	addMarkers(p, migs, sec)
+	return p.Save(10*vg.Inch, 4*vg.Inch, filepath.Join(dir, "ping_rtt."+ext))
+}
+
+var palette = []color.Color{
+	color.RGBA{R: 0x19, G: 0x76, B: 0xd2, A: 0xff},
+	color.RGBA{R: 0x38, G: 0x8e, B: 0x3c, A: 0xff},
+	color.RGBA{R: 0xf5, G: 0x7c, B: 0x00, A: 0xff},
+	color.RGBA{R: 0x7b, G: 0x1f, B: 0xa2, A: 0xff},
+}
+
+func addMarkers(p *plot.Plot, migs []time.Time, sec func(time.Time) float64) {
+	// Plot.Add has grown the Y range to the data, so the markers span it.
+	for _, m := range migs {
+		x := sec(m)
+		line, err := plotter.NewLine(plotter.XYs{{X: x, Y: p.Y.Min}, {X: x, Y: p.Y.Max}})
+		if err != nil {
+			continue
+		}
+		line.Color = migrationColor
+		line.Dashes = []vg.Length{vg.Points(4), vg.Points(2)}
+		p.Add(line)
+	}
+}
@@ -0,0 +1,281 @@
+// Command bundle packs a runner results directory into one tar.gz for
+// archiving: every file of the run (CSVs, events and gap JSONL, pcaps,
+// migration timings, logs, scenario.yaml, versions.txt) plus a
+// MANIFEST.json and a SHA256SUMS that `sha256sum -c` accepts after
+// unpacking.
+//
+//	bundle results/baseline_20260101_120000
+//	bundle -extra config_hw.env -output /archive/baseline.tar.gz results/baseline_20260101_120000
+//	bundle -verify /archive/baseline.tar.gz
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	output = flag.String("output", "", "Archive to write (default <run-dir>.tar.gz)")
+	extra  = flag.String("extra", "", "Comma-separated extra files to include under extra/ (e.g. config_hw.env)")
+	verify = flag.Bool("verify", false, "Check an existing archive against its SHA256SUMS instead of creating one")
+)
+
+// manifest is MANIFEST.json at the top of the archive.
+type manifest struct {
+	Run       string      `json:"run"`
+	CreatedAt string      `json:"created_at"`
+	Host      string      `json:"host"`
+	GitCommit string      `json:"git_commit,omitempty"`
+	GitDirty  bool        `json:"git_dirty"`
+	GoVersion string      `json:"go_version"`
+	Files     []fileEntry `json:"files"`
+}
+
+type fileEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	ModTime string `json:"mod_time"`
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <run-dir>\n       %s -verify <archive.tar.gz>\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *verify {
+		n, err := verifyArchive(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("%s: %v", flag.Arg(0), err)
+		}
+		log.Printf("%s: %d files match SHA256SUMS", flag.Arg(0), n)
+		return
+	}
+
+	runDir := filepath.Clean(flag.Arg(0))
+	if st, err := os.Stat(runDir); err != nil || !st.IsDir() {
+		log.Fatalf("%s is not a results directory", runDir)
+	}
+	if *output == "" {
+		*output = runDir + ".tar.gz"
+	}
+	files, err := collect(runDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *extra != "" {
+		for _, p := range strings.Split(*extra, ",") {
+			files = append(files, source{path: p, name: path.Join("extra", filepath.Base(p))})
+		}
+	}
+	if err := write(runDir, files); err != nil {
+		os.Remove(*output)
+		log.Fatal(err)
+	}
+	log.Printf("Bundled %d files into %s", len(files), *output)
+}
+
+// source is one file to bundle: where it is and its name in the archive,
+// relative to the run directory's top level.
+type source struct {
+	path string
+	name string
+}
+
+// collect lists every regular file under runDir, skipping a previous
+// bundle written into it.
+func collect(runDir string) ([]source, error) {
+	var files []source
+	err := filepath.WalkDir(runDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(p, ".tar.gz") {
+			return nil
+		}
+		rel, err := filepath.Rel(runDir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, source{path: p, name: filepath.ToSlash(rel)})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, err
+}
+
+func write(runDir string, files []source) error {
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw := gzip.NewWriter(out)
+	tw := tar.NewWriter(zw)
+
+	top := filepath.Base(runDir)
+	host, _ := os.Hostname()
+	m := manifest{Run: top, CreatedAt: time.Now().Format(time.RFC3339), Host: host, GoVersion: runtime.Version()}
+	m.GitCommit, m.GitDirty = gitState()
+	var sums strings.Builder
+	for _, f := range files {
+		e, err := addFile(tw, top, f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		m.Files = append(m.Files, e)
+		fmt.Fprintf(&sums, "%s  %s\n", e.SHA256, e.Path)
+	}
+	mj, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addBytes(tw, path.Join(top, "MANIFEST.json"), append(mj, '\n')); err != nil {
+		return err
+	}
+	if err := addBytes(tw, path.Join(top, "SHA256SUMS"), []byte(sums.String())); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// addFile copies one file into the archive while hashing it.
+func addFile(tw *tar.Writer, top string, f source) (fileEntry, error) {
+	in, err := os.Open(f.path)
+	if err != nil {
+		return fileEntry{}, err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return fileEntry{}, err
+	}
+	hdr := &tar.Header{Name: path.Join(top, f.name), Mode: 0o644, Size: st.Size(), ModTime: st.ModTime(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fileEntry{}, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tw, h), in)
+	if err != nil {
+		return fileEntry{}, err
+	}
+	if n != st.Size() {
+		return fileEntry{}, errors.New("file changed while bundling")
+	}
+	return fileEntry{Path: f.name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil)), ModTime: st.ModTime().Format(time.RFC3339Nano)}, nil
+}
+
+func addBytes(tw *tar.Writer, name string, b []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// gitState is the commit of the experiments checkout and whether it had
+// uncommitted changes; empty outside a git checkout.
+func gitState() (string, bool) {
+	rev, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	status, _ := exec.Command("git", "status", "--porcelain").Output()
+	return strings.TrimSpace(string(rev)), len(strings.TrimSpace(string(status))) > 0
+}
+
+// verifyArchive hashes every file in the archive and compares it with
+// SHA256SUMS. Files missing from either side count as mismatches.
+func verifyArchive(p string) (int, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	tr := tar.NewReader(zr)
+	got := map[string]string{}
+	var sums []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		_, name, _ := strings.Cut(hdr.Name, "/")
+		switch name {
+		case "SHA256SUMS":
+			if sums, err = io.ReadAll(tr); err != nil {
+				return 0, err
+			}
+		case "MANIFEST.json":
+		default:
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return 0, err
+			}
+			got[name] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	if sums == nil {
+		return 0, errors.New("no SHA256SUMS in archive")
+	}
+	var bad []string
+	want := 0
+	sc := bufio.NewScanner(strings.NewReader(string(sums)))
+	for sc.Scan() {
+		sum, name, ok := strings.Cut(sc.Text(), "  ")
+		if !ok {
+			continue
+		}
+		want++
+		if got[name] != sum {
+			bad = append(bad, name)
+		}
+		delete(got, name)
+	}
+	for name := range got {
+		bad = append(bad, name+" (not in SHA256SUMS)")
+	}
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return 0, fmt.Errorf("%d mismatches: %s", len(bad), strings.Join(bad, ", "))
+	}
+	return want, nil
+}
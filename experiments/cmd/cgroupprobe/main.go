@@ -0,0 +1,87 @@
+// Command cgroupprobe serves the server container's cgroup counters (CPU
+// time in ns, memory, block I/O) on /metrics, read straight from the
+// cgroup v2 files instead of `podman stats`. Run it on each node the
+// container can migrate to; the node it is not on answers running=false.
+//
+//	sudo cgroupprobe -container stream-server -metrics-addr :9201
+//
+// The collector samples it through -cgroup-url.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/cgroup"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+var (
+	container   = flag.String("container", "stream-server", "Container to report")
+	engineName  = flag.String("engine", "podman", "Container engine: "+podman.Engines)
+	cgroupDir   = flag.String("cgroup", "", "Read this cgroup directory or the cgroup of this PID instead of looking up -container")
+	metricsAddr = flag.String("metrics-addr", ":9201", "HTTP address for /metrics")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	engine, err := podman.NewEngine(*engineName)
+	if err != nil {
+		log.Fatalf("-engine: %v", err)
+	}
+
+	sample := (&cgroup.Container{Name: *container, Engine: engine, Runner: sshmux.Host{Name: "local"}}).Sample
+	if *cgroupDir != "" {
+		dir := *cgroupDir
+		if pid, err := strconv.Atoi(dir); err == nil {
+			if dir, err = cgroup.Dir(pid); err != nil {
+				log.Fatalf("-cgroup: %v", err)
+			}
+		}
+		sample = func(context.Context) (metricsmodel.CgroupMetrics, error) {
+			m, err := cgroup.Read(dir)
+			m.Container, m.Running = dir, err == nil
+			return m, err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m, err := sample(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	})
+	lis, err := net.Listen("tcp", *metricsAddr)
+	if err != nil {
+		log.Fatalf("-metrics-addr: %v", err)
+	}
+	srv := &http.Server{Handler: mux}
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	log.Printf("cgroupprobe: %s (%s) on %s", *container, engine.Name(), lis.Addr())
+	if err := srv.Serve(lis); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
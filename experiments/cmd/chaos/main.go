@@ -0,0 +1,252 @@
+// Command chaos injects faults other than clean migrations into a running
+// experiment, at times relative to its start, and publishes each one to
+// the collector's event bus so the metrics show what the loadgen and the
+// collector did about it:
+//
+//	chaos -hosts lakewood=user@source-server,loveland=user@target-server \
+//	  -links lakewood=192.168.10.2,loveland=192.168.10.3 -event-bus localhost:50070 \
+//	  -faults 'pause:lakewood@40s/5s,netem:loveland@70s/10s,kill:lakewood@120s'
+//
+// A fault is kind:node[:target]@at[/duration]:
+//
+//	kill   SIGKILL the container (target, default -container); with a
+//	       duration, start it again afterwards
+//	pause  pause the container (podman or docker pause) for the duration
+//	netem  apply -netem on the direct link for the duration; target is an
+//	       interface or an address on it (default the node's -links entry)
+//
+// Faults without a duration are not undone. On SIGINT/SIGTERM, active
+// faults are cleared before exiting.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+var (
+	hostList     = flag.String("hosts", "", "Comma-separated name=ssh-destination pairs (empty destination = this machine)")
+	linkList     = flag.String("links", "", "Comma-separated name=interface-or-address of each node's direct link, for netem faults without a target")
+	faultList    = flag.String("faults", "", "Comma-separated faults, kind:node[:target]@at[/duration] (required)")
+	container    = flag.String("container", "stream-server", "Default container for kill and pause faults")
+	engineName   = flag.String("engine", "podman", "Container engine on the nodes: "+podman.Engines)
+	netemArgs    = flag.String("netem", "loss 100%", "tc netem parameters for netem faults")
+	sshOpts      = flag.String("ssh-opts", "-o BatchMode=yes -o StrictHostKeyChecking=no -o ConnectTimeout=10", "Options for ssh")
+	eventBusAddr = flag.String("event-bus", "", "Publish fault events to the collector's event bus at this address (host:port)")
+	dryRun       = flag.Bool("dry-run", false, "Print the schedule and the commands without running them")
+)
+
+// engine is the -engine container CLI.
+var engine podman.Engine
+
+// fault is one scheduled injection.
+type fault struct {
+	spec     string
+	kind     string
+	node     string
+	target   string
+	at       time.Duration
+	duration time.Duration
+}
+
+// parseFault parses kind:node[:target]@at[/duration].
+func parseFault(spec string) (fault, error) {
+	f := fault{spec: spec}
+	what, when, ok := strings.Cut(spec, "@")
+	if !ok {
+		return f, fmt.Errorf("%q: no @time", spec)
+	}
+	parts := strings.SplitN(what, ":", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return f, fmt.Errorf("%q: want kind:node", spec)
+	}
+	f.kind, f.node = parts[0], parts[1]
+	if len(parts) == 3 {
+		f.target = parts[2]
+	}
+	switch f.kind {
+	case "kill", "pause", "netem":
+	default:
+		return f, fmt.Errorf("%q: unknown kind %q", spec, f.kind)
+	}
+	at, dur, hasDur := strings.Cut(when, "/")
+	var err error
+	if f.at, err = time.ParseDuration(at); err != nil {
+		return f, fmt.Errorf("%q: %w", spec, err)
+	}
+	if hasDur {
+		if f.duration, err = time.ParseDuration(dur); err != nil {
+			return f, fmt.Errorf("%q: %w", spec, err)
+		}
+	}
+	if f.kind != "kill" && f.duration <= 0 {
+		return f, fmt.Errorf("%q: %s needs a duration", spec, f.kind)
+	}
+	return f, nil
+}
+
+// scripts are the shell commands that inject and clear f; clear is empty
+// when the fault is not undone.
+func (f fault) scripts() (inject, clear string) {
+	switch f.kind {
+	case "kill":
+		inject = engine.KillCmd(f.target, "SIGKILL")
+		if f.duration > 0 {
+			clear = engine.StartCmd(f.target)
+		}
+	case "pause":
+		inject, clear = engine.PauseCmd(f.target), engine.UnpauseCmd(f.target)
+	case "netem":
+		// The target may be the address on the link rather than its name.
+		dev := fmt.Sprintf(`dev=%s; [ -e "/sys/class/net/$dev" ] || dev=$(ip -o addr show | awk -v a="$dev" '{split($4, p, "/")} p[1] == a {print $2; exit}'); [ -n "$dev" ] || { echo "no interface for %[1]s" >&2; exit 1; }; `, f.target)
+		inject = dev + "sudo tc qdisc replace dev \"$dev\" root netem " + *netemArgs
+		clear = dev + "sudo tc qdisc del dev \"$dev\" root"
+	}
+	return inject, clear
+}
+
+func parseList(s string) map[string]string {
+	m := map[string]string{}
+	if s == "" {
+		return m
+	}
+	for _, f := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(f), "=")
+		m[k] = v
+	}
+	return m
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *faultList == "" {
+		log.Fatal("-faults is required")
+	}
+	var err error
+	if engine, err = podman.NewEngine(*engineName); err != nil {
+		log.Fatalf("-engine: %v", err)
+	}
+	dests, links := parseList(*hostList), parseList(*linkList)
+	var faults []fault
+	for _, spec := range strings.Split(*faultList, ",") {
+		f, err := parseFault(strings.TrimSpace(spec))
+		if err != nil {
+			log.Fatalf("-faults: %v", err)
+		}
+		if _, ok := dests[f.node]; !ok {
+			log.Fatalf("-faults: %q: node %q is not in -hosts", f.spec, f.node)
+		}
+		if f.target == "" {
+			f.target = *container
+			if f.kind == "netem" {
+				if f.target = links[f.node]; f.target == "" {
+					log.Fatalf("-faults: %q: no target and no -links entry for %s", f.spec, f.node)
+				}
+			}
+		}
+		faults = append(faults, f)
+	}
+
+	if *dryRun {
+		for _, f := range faults {
+			inject, clear := f.scripts()
+			fmt.Printf("%s at +%s on %s: %s\n", f.kind, f.at, f.node, inject)
+			if clear != "" {
+				fmt.Printf("%s at +%s on %s: %s\n", f.kind, f.at+f.duration, f.node, clear)
+			}
+		}
+		return
+	}
+
+	var bus *eventbus.Client
+	if *eventBusAddr != "" {
+		var err error
+		if bus, err = eventbus.Dial(*eventBusAddr, "chaos"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+	}
+	mux, err := sshmux.New(*sshOpts, time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mux.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	failed := false
+	var mu sync.Mutex
+	for _, f := range faults {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(ctx, mux.Host(f.node, dests[f.node]), f, start, bus); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("%s: %v", f.spec, err)
+				bus.Publish("fault_failed", map[string]any{"kind": f.kind, "node": f.node, "target": f.target, "error": err.Error()})
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	bus.Close(2 * time.Second)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// run waits until f is due, injects it and, after its duration, clears
+// it. A fault that is active when ctx ends is still cleared.
+func run(ctx context.Context, h sshmux.Host, f fault, start time.Time, bus *eventbus.Client) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(start.Add(f.at))):
+	}
+	inject, clear := f.scripts()
+	log.Printf("Injecting %s on %s (%s) for %s", f.kind, f.node, f.target, f.duration)
+	injected := time.Now()
+	if _, err := h.Run(ctx, inject); err != nil {
+		return fmt.Errorf("inject: %w", err)
+	}
+	bus.Publish("fault_injected", map[string]any{
+		"kind": f.kind, "node": f.node, "target": f.target,
+		"duration_ms": float64(f.duration) / float64(time.Millisecond),
+	})
+	if clear == "" {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(f.duration):
+	}
+	// Cleared even when interrupted: a leftover netem qdisc or paused
+	// container would break the next iteration.
+	cctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := h.Run(cctx, clear); err != nil {
+		return fmt.Errorf("clear: %w", err)
+	}
+	log.Printf("Cleared %s on %s", f.kind, f.node)
+	bus.Publish("fault_cleared", map[string]any{
+		"kind": f.kind, "node": f.node, "target": f.target,
+		"fault_ms": float64(time.Since(injected)) / float64(time.Millisecond),
+	})
+	return nil
+}
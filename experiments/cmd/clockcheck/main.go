@@ -0,0 +1,308 @@
+// Command clockcheck measures the clock offsets between the lab nodes (and
+// the switch CPU, if reachable). Every cross-node number the experiments
+// report, such as the loadgen's first packet after a restore against the
+// orchestrator's restore time, is only as good as these offsets.
+//
+//	clockcheck -hosts lakewood=user@source-server,loveland=user@target-server,switch=root@tofino
+//
+// Each host gets one long-lived ssh session (local bash for an empty
+// destination) that answers with its time. Of -samples round trips the
+// one with the lowest RTT gives the offset against this machine, with half
+// the RTT as its uncertainty. The sync daemon's own estimate (chronyc
+// tracking, or linuxptp's pmc) is recorded next to it.
+//
+// With -interval 0 it measures once and exits non-zero when the spread
+// between hosts or any daemon offset exceeds -max-offset, or a host that
+// is not -optional is unreachable; the runner uses this as a preflight.
+// With -interval it keeps appending rows to -output until interrupted.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	hostList  = flag.String("hosts", "", "Comma-separated name=ssh-destination pairs (empty destination = this machine)")
+	optional  = flag.String("optional", "switch", "Comma-separated host names that may be unreachable")
+	sshOpts   = flag.String("ssh-opts", "-o BatchMode=yes -o StrictHostKeyChecking=no -o ConnectTimeout=5", "Options for ssh")
+	samples   = flag.Int("samples", 8, "Round trips per host per measurement")
+	maxOffset = flag.Duration("max-offset", time.Millisecond, "Largest acceptable offset between hosts")
+	interval  = flag.Duration("interval", 0, "Measure every interval until interrupted (0 = once, exit status reports the check)")
+	output    = flag.String("output", "", "Append CSV rows to this file (default stdout)")
+)
+
+// timeScript prints the time in Unix nanoseconds, from bash's
+// EPOCHREALTIME (microseconds) where it exists, since forking date costs
+// more than the LAN round trip.
+const timeScript = `if [ -n "$EPOCHREALTIME" ]; then t=${EPOCHREALTIME/[.,]/}; echo ${t}000; else date +%s%N; fi`
+
+// syncScript prints the sync daemon and its current offset estimate:
+// "chrony <seconds>" (System time from chronyc tracking, sign as chronyc
+// reports it), "ptp <ns>" (master_offset from ptp4l) or "none 0".
+const syncScript = `if o=$(chronyc -c tracking 2>/dev/null) && [ -n "$o" ]; then echo "chrony $(echo "$o" | cut -d, -f5)"
+elif o=$(sudo -n pmc -u -b 0 'GET TIME_STATUS_NP' 2>/dev/null | awk '/master_offset/{print $2}') && [ -n "$o" ]; then echo "ptp $o"
+else echo "none 0"; fi`
+
+// session is a shell on one host that stays open between measurements, so
+// the sampled RTT is the link and not ssh's handshake.
+type session struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string
+}
+
+func dial(dest string) (*session, error) {
+	var cmd *exec.Cmd
+	if dest == "" {
+		cmd = exec.Command("bash", "-s")
+	} else {
+		cmd = exec.Command("ssh", append(strings.Fields(*sshOpts), dest, "bash -s")...)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s := &session{cmd: cmd, stdin: stdin, lines: make(chan string, 16)}
+	go func() {
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			s.lines <- sc.Text()
+		}
+		close(s.lines)
+	}()
+	return s, nil
+}
+
+// ask runs one line of shell and returns the first line it prints.
+func (s *session) ask(script string, timeout time.Duration) (string, error) {
+	if _, err := io.WriteString(s.stdin, script+"\n"); err != nil {
+		return "", err
+	}
+	select {
+	case line, ok := <-s.lines:
+		if !ok {
+			return "", errors.New("session closed")
+		}
+		return line, nil
+	case <-time.After(timeout):
+		return "", errors.New("no answer")
+	}
+}
+
+func (s *session) close() {
+	s.stdin.Close()
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+}
+
+type host struct {
+	name     string
+	dest     string
+	optional bool
+	s        *session
+}
+
+// result is one measurement of one host.
+type result struct {
+	host       string
+	reachable  bool
+	offset     time.Duration // host clock minus this machine's
+	rtt        time.Duration
+	sync       string
+	syncOffset time.Duration
+	err        error
+}
+
+func (h *host) measure() result {
+	r := result{host: h.name}
+	if h.s == nil {
+		s, err := dial(h.dest)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		h.s = s
+	}
+	r.rtt = time.Duration(math.MaxInt64)
+	for i := 0; i < *samples; i++ {
+		t0 := time.Now()
+		line, err := h.s.ask(timeScript, 10*time.Second)
+		t1 := time.Now()
+		if err != nil {
+			h.s.close()
+			h.s = nil
+			r.err = err
+			return r
+		}
+		remote, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+		if err != nil {
+			r.err = fmt.Errorf("time printed as %q", line)
+			return r
+		}
+		if rtt := t1.Sub(t0); rtt < r.rtt {
+			r.rtt = rtt
+			r.offset = time.Duration(remote - (t0.UnixNano()+t1.UnixNano())/2)
+		}
+	}
+	r.reachable = true
+	line, err := h.s.ask(syncScript, 10*time.Second)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	name, val, _ := strings.Cut(line, " ")
+	r.sync = name
+	switch name {
+	case "chrony":
+		if v, err := strconv.ParseFloat(val, 64); err == nil {
+			r.syncOffset = time.Duration(v * float64(time.Second))
+		}
+	case "ptp":
+		if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+			r.syncOffset = time.Duration(v)
+		}
+	}
+	return r
+}
+
+func msf(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// check logs the round and reports whether it passes.
+func check(results []result) bool {
+	ok := true
+	var offsets []time.Duration
+	for _, r := range results {
+		if !r.reachable {
+			log.Printf("%s: unreachable: %v", r.host, r.err)
+			continue
+		}
+		log.Printf("%s: offset %s ms (+-%s), %s offset %s ms", r.host, msf(r.offset), msf(r.rtt/2), r.sync, msf(r.syncOffset))
+		offsets = append(offsets, r.offset)
+		if r.syncOffset.Abs() > *maxOffset {
+			log.Printf("%s: %s reports %s ms, over %s", r.host, r.sync, msf(r.syncOffset), *maxOffset)
+			ok = false
+		}
+		if r.sync == "none" {
+			log.Printf("%s: no chrony or ptp4l found", r.host)
+		}
+	}
+	if len(offsets) > 1 {
+		spread := slices.Max(offsets) - slices.Min(offsets)
+		log.Printf("Spread between hosts: %s ms (limit %s)", msf(spread), *maxOffset)
+		if spread > *maxOffset {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *hostList == "" {
+		log.Fatal("-hosts is required")
+	}
+	opt := strings.Split(*optional, ",")
+	var hosts []*host
+	for _, f := range strings.Split(*hostList, ",") {
+		name, dest, found := strings.Cut(strings.TrimSpace(f), "=")
+		if !found {
+			dest = name
+		}
+		hosts = append(hosts, &host{name: name, dest: dest, optional: slices.Contains(opt, name)})
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("-output: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	cw := csv.NewWriter(w)
+	if st, err := w.Stat(); err != nil || st.Size() == 0 {
+		cw.Write([]string{"timestamp", "timestamp_unix_milli", "host", "reachable",
+			"offset_ms", "uncertainty_ms", "rtt_ms", "sync", "sync_offset_ms"})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() { <-sigCh; cancel() }()
+
+	for {
+		now := time.Now()
+		results := make([]result, len(hosts))
+		passed := true
+		for i, h := range hosts {
+			results[i] = h.measure()
+			r := results[i]
+			if !r.reachable && !h.optional {
+				passed = false
+			}
+			row := []string{now.Format(time.RFC3339Nano), strconv.FormatInt(now.UnixMilli(), 10), r.host, strconv.FormatBool(r.reachable)}
+			if r.reachable {
+				row = append(row, msf(r.offset), msf(r.rtt/2), msf(r.rtt), r.sync, msf(r.syncOffset))
+			} else {
+				row = append(row, "", "", "", "", "")
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		passed = check(results) && passed
+		if *interval == 0 {
+			for _, h := range hosts {
+				if h.s != nil {
+					h.s.close()
+				}
+			}
+			if !passed {
+				log.Print("Clock check FAILED")
+				os.Exit(1)
+			}
+			log.Print("Clock check passed")
+			return
+		}
+		if !passed {
+			log.Print("WARNING: clock offsets out of bounds")
+		}
+		select {
+		case <-ctx.Done():
+			for _, h := range hosts {
+				if h.s != nil {
+					h.s.close()
+				}
+			}
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
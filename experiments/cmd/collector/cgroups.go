@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/cgroup"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+// cgroupSource is one place the server container's cgroup counters come
+// from: a cmd/cgroupprobe per node, or this host's cgroup files.
+type cgroupSource func(ctx context.Context) (metricsmodel.CgroupMetrics, bool)
+
+func cgroupURL(url string) cgroupSource {
+	return func(context.Context) (metricsmodel.CgroupMetrics, bool) {
+		return fetchJSON[metricsmodel.CgroupMetrics](httpClient, url+"/metrics")
+	}
+}
+
+// cgroupLocal reads spec on this host: a cgroup directory, the cgroup of
+// a PID, or a container looked up through engine.
+func cgroupLocal(spec string, engine podman.Engine) (cgroupSource, error) {
+	read := func(dir string) cgroupSource {
+		return func(context.Context) (metricsmodel.CgroupMetrics, bool) {
+			m, err := cgroup.Read(dir)
+			m.Container, m.Running = spec, err == nil
+			return m, err == nil
+		}
+	}
+	if pid, err := strconv.Atoi(spec); err == nil {
+		dir, err := cgroup.Dir(pid)
+		if err != nil {
+			return nil, err
+		}
+		return read(dir), nil
+	}
+	if strings.Contains(spec, "/") {
+		return read(spec), nil
+	}
+	c := &cgroup.Container{Name: spec, Engine: engine, Runner: sshmux.Host{Name: "local"}}
+	return func(ctx context.Context) (metricsmodel.CgroupMetrics, bool) {
+		m, err := c.Sample(ctx)
+		return m, err == nil
+	}, nil
+}
+
+// cgroupRow sums the sources the container is running at; normally one,
+// both for a moment around a restore. cgroup_ok is 0 if any source did
+// not answer, and the counters are blank if none had the container.
+func cgroupRow(ctx context.Context, sources []cgroupSource) []string {
+	var sum metricsmodel.CgroupMetrics
+	allOK, running := true, 0
+	for _, s := range sources {
+		m, ok := s(ctx)
+		allOK = allOK && ok
+		if !ok || !m.Running {
+			continue
+		}
+		running++
+		sum.CPUUsageNs += m.CPUUsageNs
+		sum.CPUUserNs += m.CPUUserNs
+		sum.CPUSystemNs += m.CPUSystemNs
+		sum.ThrottledNs += m.ThrottledNs
+		sum.MemoryBytes += m.MemoryBytes
+		sum.IOReadBytes += m.IOReadBytes
+		sum.IOWriteBytes += m.IOWriteBytes
+	}
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	cells := blankUnless(running > 0,
+		u(sum.CPUUsageNs), u(sum.CPUUserNs), u(sum.CPUSystemNs), u(sum.ThrottledNs),
+		u(sum.MemoryBytes), u(sum.IOReadBytes), u(sum.IOWriteBytes))
+	return append(cells, strconv.Itoa(running), okFlag(allOK))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+// execProbe is one -exec-probe: a testbed-specific command run every tick
+// whose first output line becomes the exec_<name> column, for one-off
+// measurements that do not deserve a probe of their own.
+type execProbe struct {
+	name   string
+	ssh    string // node to run on, "" for this host
+	script string
+	host   sshmux.Host
+
+	failed bool // a failure was logged; the next success is logged too
+}
+
+// parseExecProbe parses name=command or name=ssh://<addr>/command.
+func parseExecProbe(s string) (*execProbe, error) {
+	name, script, ok := strings.Cut(s, "=")
+	if !ok || name == "" || script == "" {
+		return nil, fmt.Errorf("%q is not name=command", s)
+	}
+	p := &execProbe{name: name, script: script}
+	if rest, ok := strings.CutPrefix(script, "ssh://"); ok {
+		p.ssh, p.script, _ = strings.Cut(rest, "/")
+		if p.ssh == "" || p.script == "" {
+			return nil, fmt.Errorf("%q is not name=ssh://<addr>/command", s)
+		}
+	}
+	return p, nil
+}
+
+func execColumns(probes []*execProbe) []metricsmodel.Column {
+	var cols []metricsmodel.Column
+	for _, p := range probes {
+		cols = append(cols, column("exec_"+p.name, "string", "", "exec"))
+	}
+	return cols
+}
+
+// execRow runs the probes in parallel, each for at most timeout. A probe
+// that fails or times out leaves its cell empty.
+func execRow(ctx context.Context, probes []*execProbe, timeout time.Duration) []string {
+	cells := make([]string, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			out, err := p.host.Run(ctx, p.script)
+			switch {
+			case err != nil && !p.failed && ctx.Err() == nil:
+				log.Printf("exec probe %s: %v", p.name, err)
+				p.failed = true
+			case err != nil && !p.failed:
+				log.Printf("exec probe %s: no output within %s", p.name, timeout)
+				p.failed = true
+			case err == nil && p.failed:
+				log.Printf("exec probe %s: back", p.name)
+				p.failed = false
+			}
+			if err == nil {
+				cells[i], _, _ = strings.Cut(out, "\n")
+			}
+		}()
+	}
+	wg.Wait()
+	return cells
+}
@@ -8,60 +8,173 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/netns"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/remotewrite"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
 )
 
 var (
 	serverMetricsURL = flag.String("server-metrics-url", "", "HTTP URL for server /metrics")
 	loadgenURL       = flag.String("loadgen-url", "", "HTTP URL for loadgen /metrics")
+	serverNetns      = flag.String("server-netns", "", "Reach -server-metrics-url from this network namespace (PID, path or ip-netns name)")
+	loadgenNetns     = flag.String("loadgen-netns", "", "Reach -loadgen-url from this network namespace (PID, path or ip-netns name)")
 	migrationFlg     = flag.String("migration-flag", "/tmp/collector_migration_flag", "File whose presence marks a migration event")
 	outputFile       = flag.String("output", "metrics.csv", "CSV output path")
 	interval         = flag.Duration("interval", 1*time.Second, "Collection interval")
+	burstInterval    = flag.Duration("burst-interval", 100*time.Millisecond, "Collection interval during a migration burst")
+	burstWindow      = flag.Duration("burst-window", 0, "Sample at -burst-interval for this long after migration_started on the event bus or the migration flag (0 = never)")
+	eventBusListen   = flag.String("event-bus-listen", "", "Host the event bus on this address (e.g. :50070); components started with -event-bus publish to it, and the orchestrator's phase events become marker rows")
+	eventsFile       = flag.String("events", "", "JSONL file the event bus appends to (default events.jsonl next to -output)")
+	peersFile        = flag.String("peers", "", "JSONL file for the loadgen's peer_snapshot events, kept out of -events (default peers.jsonl next to -output)")
+	probeURLs        = flag.String("probe-url", "", "Comma-separated HTTP URLs of cmd/ebpfprobe /metrics, one per node (adds probe_* columns)")
+	cgroupURLs       = flag.String("cgroup-url", "", "Comma-separated HTTP URLs of cmd/cgroupprobe /metrics, one per node (adds cgroup_* columns)")
+	cgroupSpec       = flag.String("cgroup", "", "Read the server container's cgroup on this host: container name, cgroup directory or PID (adds cgroup_* columns)")
+	statsTargets     = flag.String("container-stats", "", "Comma-separated containers to sample with podman/docker stats, as name or name@ssh-host (adds container_<name>_* columns)")
+	engineName       = flag.String("engine", "podman", "Container engine of -cgroup and -container-stats: "+podman.Engines)
+	pingTargets      = flag.String("ping", "", "Comma-separated IPs to ping continuously (adds per-target ping_* columns)")
+	pingInterval     = flag.Duration("ping-interval", 20*time.Millisecond, "Interval between pings to each -ping target")
+	pingTimeout      = flag.Duration("ping-timeout", time.Second, "Count a ping as lost after this long without a reply")
+	pingNetns        = flag.String("ping-netns", "", "Ping from this network namespace (PID, path or ip-netns name; on -loadgen-ssh's node if set)")
+	pingFrom         = flag.String("ping-from", "", "Comma-separated name=where vantage points, each pinging every -ping target into ping_*_<ip>@<name> columns; where is host, netns:<spec> or container:<name>, after ssh://<addr>/ for another node (default: one from -ping-netns, -loadgen-container and -loadgen-ssh)")
+	loadgenSSH       = flag.String("loadgen-ssh", "", "SSH address of the loadgen's node when it is not this host; -ping then runs ping(8) there, in -loadgen-container's or -ping-netns's namespace")
+	loadgenCtr       = flag.String("loadgen-container", "", "Loadgen container whose network namespace -ping uses (looked up with -engine, on -loadgen-ssh's node if set)")
+	sshOpts          = flag.String("ssh-opts", "", "Extra ssh options for -loadgen-ssh and ssh:// vantage points and exec probes")
+	execTimeout      = flag.Duration("exec-timeout", 500*time.Millisecond, "Longest an -exec-probe may run per tick")
+	switchGRPC       = flag.String("switch-grpc", "", "BF Runtime gRPC address; poll the server IP's forward entry (adds switch_* columns)")
+	switchServerIP   = flag.String("switch-server-ip", "192.168.12.2", "Server IP whose forward entry -switch-grpc polls")
+	switchPorts      = flag.String("switch-ports", "", "Comma-separated node=switch-port pairs to name switch_target by node")
+	switchPoll       = flag.Duration("switch-poll", 50*time.Millisecond, "Poll interval of -switch-grpc")
+	remoteWrite      = flag.String("remote-write", "", "Prometheus remote-write URL to push every numeric column to as p4cf_<column> (e.g. http://mimir:9009/api/v1/push)")
+	remoteLabels     = flag.String("remote-write-labels", "", "Comma-separated name=value labels added to every -remote-write series (e.g. scenario=baseline,iteration=iter_1)")
 
 	httpClient = &http.Client{Timeout: 2 * time.Second}
+
+	execProbes []*execProbe
 )
 
-type ServerMetrics struct {
-	ConnectedClients int     `json:"connected_clients"`
-	TotalClients     int64   `json:"total_clients"`
-	BytesSent        uint64  `json:"bytes_sent"`
-	BytesReceived    uint64  `json:"bytes_received"`
-	UptimeSeconds    float64 `json:"uptime_seconds"`
-	CPUPercent       float64 `json:"cpu_percent"`
-	MemoryMB         float64 `json:"memory_mb"`
+func init() {
+	flag.Func("exec-probe", "Run `name=command` (or name=ssh://<addr>/command) every tick and record its first output line as column exec_<name>; repeatable", func(s string) error {
+		p, err := parseExecProbe(s)
+		if err != nil {
+			return err
+		}
+		execProbes = append(execProbes, p)
+		return nil
+	})
+}
+
+// probeRow sums the probes. The server is behind one of them at a time,
+// so the newest last packet across nodes is when traffic was last seen.
+// probe_ok is 0 if any probe did not answer; the sums are then partial.
+func probeRow(urls []string, t time.Time) []string {
+	var sum metricsmodel.ProbeMetrics
+	allOK := true
+	for _, u := range urls {
+		pm, ok := fetchJSON[metricsmodel.ProbeMetrics](httpClient, u+"/metrics")
+		allOK = allOK && ok
+		sum.PacketsIn += pm.PacketsIn
+		sum.PacketsOut += pm.PacketsOut
+		sum.RxDropped += pm.RxDropped
+		sum.TxDropped += pm.TxDropped
+		sum.Gaps += pm.Gaps
+		sum.MaxGapMs = max(sum.MaxGapMs, pm.MaxGapMs)
+		sum.LastPacketNs = max(sum.LastPacketNs, pm.LastPacketNs)
+	}
+	silence := ""
+	if sum.LastPacketNs > 0 {
+		silence = fmt.Sprintf("%.3f", float64(t.UnixNano()-sum.LastPacketNs)/1e6)
+	}
+	return []string{
+		strconv.FormatUint(sum.PacketsIn, 10), strconv.FormatUint(sum.PacketsOut, 10),
+		strconv.FormatUint(sum.RxDropped+sum.TxDropped, 10),
+		strconv.FormatUint(sum.Gaps, 10),
+		fmt.Sprintf("%.3f", sum.MaxGapMs),
+		silence,
+		okFlag(allOK),
+	}
 }
 
-type LoadgenMetrics struct {
-	ConnectedClients int     `json:"connected_clients"`
-	AvgRttMs         float64 `json:"avg_rtt_ms"`
-	P50RttMs         float64 `json:"p50_rtt_ms"`
-	P95RttMs         float64 `json:"p95_rtt_ms"`
-	P99RttMs         float64 `json:"p99_rtt_ms"`
-	MaxRttMs         float64 `json:"max_rtt_ms"`
-	JitterMs         float64 `json:"jitter_ms"`
-	ConnectionDrops  int64   `json:"connection_drops"`
+// fetchJSON reports whether url answered with a JSON document; a failed
+// fetch must not be mistaken for an idle component's zeros.
+func fetchJSON[T any](c *http.Client, url string) (T, bool) {
+	v, _, ok := fetchTimed[T](c, url)
+	return v, ok
 }
 
-func fetchJSON[T any](url string) T {
+// fetchTimed is fetchJSON that also returns the request's round trip. The
+// response's own timestamp lies within it, so with both a sample can be
+// placed on the collector's timeline, and the remote clock's offset
+// bounded, instead of assuming it was taken at the tick.
+func fetchTimed[T any](c *http.Client, url string) (T, time.Duration, bool) {
 	var v T
-	resp, err := httpClient.Get(url)
+	start := time.Now()
+	ok := get(c, url, &v)
+	return v, time.Since(start), ok
+}
+
+func get(c *http.Client, url string, v any) bool {
+	resp, err := c.Get(url)
 	if err != nil {
-		return v
+		return false
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return v
+		return false
+	}
+	return json.Unmarshal(body, v) == nil
+}
+
+// timing is the server_/loadgen_ rtt_ms and remote_unix_milli cells.
+func timing(ok bool, rtt time.Duration, remoteNs int64) []string {
+	remote := ""
+	if remoteNs > 0 {
+		remote = fmt.Sprintf("%.3f", float64(remoteNs)/1e6)
+	}
+	return blankUnless(ok, fmt.Sprintf("%.3f", float64(rtt)/1e6), remote)
+}
+
+// okFlag is the value of the *_ok columns.
+func okFlag(ok bool) string {
+	if ok {
+		return "1"
+	}
+	return "0"
+}
+
+// blankUnless empties cells when ok is false, so a failed fetch shows up
+// as missing data rather than zeros.
+func blankUnless(ok bool, cells ...string) []string {
+	if !ok {
+		for i := range cells {
+			cells[i] = ""
+		}
 	}
-	_ = json.Unmarshal(body, &v)
-	return v
+	return cells
 }
 
+// markerBacklog is how many migrations' marker events the collector
+// buffers while its main loop is busy sampling.
+const markerBacklog = 32
+
 func main() {
 	flag.Parse()
 	if *serverMetricsURL == "" || *loadgenURL == "" {
@@ -76,53 +189,272 @@ func main() {
 	w := csv.NewWriter(f)
 	defer w.Flush()
 
-	header := []string{
-		"timestamp", "timestamp_unix_milli", "elapsed_s",
-		"connected_clients", "total_clients", "bytes_sent", "bytes_received", "uptime_s",
-		"lg_connected_clients",
-		"ws_rtt_avg_ms", "ws_rtt_p50_ms", "ws_rtt_p95_ms", "ws_rtt_p99_ms", "ws_rtt_max_ms",
-		"ws_jitter_ms", "connection_drops",
-		"cpu_percent", "memory_mb",
-		"migration_event",
+	schema := metricsmodel.Schema{Version: metricsmodel.SchemaVersion, Columns: baseColumns()}
+	var probes []string
+	if *probeURLs != "" {
+		probes = strings.Split(*probeURLs, ",")
+		schema.Columns = append(schema.Columns, probeColumns()...)
+	}
+	engine, err := podman.NewEngine(*engineName)
+	if err != nil {
+		log.Fatalf("-engine: %v", err)
+	}
+	var cgroups []cgroupSource
+	if *cgroupURLs != "" {
+		for _, u := range strings.Split(*cgroupURLs, ",") {
+			cgroups = append(cgroups, cgroupURL(u))
+		}
+	}
+	if *cgroupSpec != "" {
+		src, err := cgroupLocal(*cgroupSpec, engine)
+		if err != nil {
+			log.Fatalf("-cgroup: %v", err)
+		}
+		cgroups = append(cgroups, src)
+	}
+	if cgroups != nil {
+		schema.Columns = append(schema.Columns, cgroupColumns()...)
+	}
+	var stats []*containerStats
+	if *statsTargets != "" {
+		stats = parseContainerStats(*statsTargets, engine)
+		schema.Columns = append(schema.Columns, statsColumns(stats)...)
+	}
+	serverClient, loadgenClient := httpClient, httpClient
+	if *serverNetns != "" {
+		serverClient = netns.HTTPClient(netns.Path(*serverNetns), httpClient.Timeout)
+	}
+	if *loadgenNetns != "" {
+		loadgenClient = netns.HTTPClient(netns.Path(*loadgenNetns), httpClient.Timeout)
+	}
+
+	// Vantage points and exec probes on other nodes share one SSH
+	// connection per node.
+	var mux *sshmux.Mux
+	node := func(addr string) sshmux.Host {
+		if addr == "" {
+			return sshmux.Host{Name: "local"}
+		}
+		if mux == nil {
+			var err error
+			if mux, err = sshmux.New(*sshOpts, time.Minute); err != nil {
+				log.Fatalf("ssh: %v", err)
+			}
+		}
+		return mux.Host(addr, addr)
 	}
-	_ = w.Write(header)
+	defer func() {
+		if mux != nil {
+			mux.Close()
+		}
+	}()
+	for _, p := range execProbes {
+		p.host = node(p.ssh)
+	}
+	schema.Columns = append(schema.Columns, execColumns(execProbes)...)
+
+	var pings []*pinger
+	if *pingTargets != "" {
+		var targets []netip.Addr
+		for _, t := range strings.Split(*pingTargets, ",") {
+			a, err := netip.ParseAddr(strings.TrimSpace(t))
+			if err != nil {
+				log.Fatalf("-ping: %v", err)
+			}
+			targets = append(targets, a)
+		}
+		vantages := []vantage{{ssh: *loadgenSSH, netns: *pingNetns, container: *loadgenCtr}}
+		if *pingFrom != "" {
+			var err error
+			if vantages, err = parseVantages(*pingFrom); err != nil {
+				log.Fatalf("-ping-from: %v", err)
+			}
+		}
+		for _, v := range vantages {
+			p, err := v.pinger(targets, *pingTimeout, engine, node)
+			if err != nil {
+				log.Fatalf("-ping from %q: %v", v.name, err)
+			}
+			defer p.close()
+			pings = append(pings, p)
+			schema.Columns = append(schema.Columns, pingColumns(targets, v.name)...)
+		}
+	}
+
+	var sw *switchProbe
+	if *switchGRPC != "" {
+		ip, err := netip.ParseAddr(*switchServerIP)
+		if err != nil {
+			log.Fatalf("-switch-server-ip: %v", err)
+		}
+		nodes, err := parsePorts(*switchPorts)
+		if err != nil {
+			log.Fatalf("-switch-ports: %v", err)
+		}
+		sw = &switchProbe{addr: *switchGRPC, ip: ip, nodes: nodes}
+		schema.Columns = append(schema.Columns, switchColumns()...)
+	}
+	if err := metricsmodel.WriteSchema(metricsmodel.SchemaPath(*outputFile), schema); err != nil {
+		log.Fatalf("Cannot write schema: %v", err)
+	}
+	_ = w.Write(schema.Names())
 	w.Flush()
 
+	var rw *remotewrite.Client
+	if *remoteWrite != "" {
+		labels, err := parseLabels(*remoteLabels)
+		if err != nil {
+			log.Fatalf("-remote-write-labels: %v", err)
+		}
+		rw = remotewrite.New(*remoteWrite, labels)
+		defer rw.Close(5 * time.Second)
+	}
+
+	var bus *eventbus.Server
+	// started carries migration_started from the bus, which arrives
+	// before the checkpoint; the migration flag only after the restore.
+	started := make(chan eventbus.Event, 1)
+	// markers waits for the main loop, which can be stuck in a /metrics
+	// fetch for seconds while the server is frozen, just when the phase
+	// events arrive. The bus drops what does not fit, so it holds the
+	// markers of many migrations, not one.
+	markers := make(chan eventbus.Event, markerBacklog*len(metricsmodel.MarkerEvents))
+	if *eventBusListen != "" {
+		if *eventsFile == "" {
+			*eventsFile = filepath.Join(filepath.Dir(*outputFile), "events.jsonl")
+		}
+		lis, err := net.Listen("tcp", *eventBusListen)
+		if err != nil {
+			log.Fatalf("-event-bus-listen: %v", err)
+		}
+		if bus, err = eventbus.NewServer(*eventsFile); err != nil {
+			log.Fatalf("-events: %v", err)
+		}
+		if *peersFile == "" {
+			*peersFile = filepath.Join(filepath.Dir(*outputFile), "peers.jsonl")
+		}
+		if err := bus.Route("peer_snapshot", *peersFile); err != nil {
+			log.Fatalf("-peers: %v", err)
+		}
+		bus.Notify("migration_started", started)
+		for _, typ := range metricsmodel.MarkerEvents {
+			bus.Notify(typ, markers)
+		}
+		defer bus.Close()
+		go bus.Serve(lis)
+		log.Printf("Event bus on %s, events in %s", lis.Addr(), *eventsFile)
+		bus.Publish("collector", "collector_started", map[string]any{"output": *outputFile})
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() { <-sigCh; log.Println("Shutting down..."); cancel() }()
+	for _, p := range pings {
+		go p.run(*pingInterval, ctx.Done())
+	}
+	for _, c := range stats {
+		go c.run(ctx, *interval)
+	}
+	if sw != nil {
+		sw.bus = bus
+		go sw.run(ctx, *switchPoll)
+	}
 
 	startTime := time.Now()
 	ticker := time.NewTicker(*interval)
 	defer ticker.Stop()
+	// A burst samples at -burst-interval until burstUntil, so the window
+	// around a migration has fine resolution and the rest of the file
+	// stays small.
+	current := *interval
+	var burstUntil time.Time
+	burst := func(now time.Time, why string) {
+		if *burstWindow <= 0 {
+			return
+		}
+		if current != *burstInterval {
+			log.Printf("Burst sampling every %s for %s (%s)", *burstInterval, *burstWindow, why)
+			current = *burstInterval
+			ticker.Reset(current)
+		}
+		burstUntil = now.Add(*burstWindow)
+	}
 
 	log.Printf("Collector: server=%s loadgen=%s interval=%s", *serverMetricsURL, *loadgenURL, *interval)
 
+	// The migration flag may say which migration of a ping-pong run it
+	// marks (migration=<n>, direction=forward|back lines); every sample
+	// after it carries that until the next flag.
+	migNumber, migDirection := "0", ""
+
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("Collector stopped.")
 			return
+		case <-started:
+			burst(time.Now(), "migration_started")
+		case ev := <-markers:
+			_ = w.Write(markerRow(schema.Columns, ev, startTime))
+			w.Flush()
 		case t := <-ticker.C:
-			sm := fetchJSON[ServerMetrics](*serverMetricsURL + "/metrics")
-			lm := fetchJSON[LoadgenMetrics](*loadgenURL + "/metrics")
+			sampled := current
+			if current != *interval && t.After(burstUntil) {
+				current = *interval
+				ticker.Reset(current)
+			}
+			// Both requests leave at the tick, so a hung server does not
+			// delay the loadgen's sample.
+			var (
+				sm    metricsmodel.ServerMetrics
+				smRTT time.Duration
+				smOK  bool
+				wg    sync.WaitGroup
+			)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sm, smRTT, smOK = fetchTimed[metricsmodel.ServerMetrics](serverClient, *serverMetricsURL+"/metrics")
+			}()
+			lm, lmRTT, lmOK := fetchTimed[metricsmodel.LoadgenMetrics](loadgenClient, *loadgenURL+"/metrics")
+			wg.Wait()
 
 			migEvent := "0"
-			if _, err := os.Stat(*migrationFlg); err == nil {
+			if data, err := os.ReadFile(*migrationFlg); err == nil {
 				_ = os.Remove(*migrationFlg)
 				migEvent = "1"
-				log.Println("Migration event detected")
+				for _, line := range strings.Split(string(data), "\n") {
+					k, v, _ := strings.Cut(strings.TrimSpace(line), "=")
+					switch k {
+					case "migration":
+						migNumber = v
+					case "direction":
+						migDirection = v
+					}
+				}
+				log.Printf("Migration event detected (migration %s %s)", migNumber, migDirection)
+				burst(t, "migration flag")
+				if bus != nil {
+					bus.Publish("collector", "migration_flag_seen", nil)
+				}
 			}
 
 			row := []string{
 				t.Format(time.RFC3339Nano),
 				fmt.Sprintf("%d", t.UnixMilli()),
 				fmt.Sprintf("%.3f", t.Sub(startTime).Seconds()),
+			}
+			row = append(row, blankUnless(smOK,
 				strconv.Itoa(sm.ConnectedClients), fmt.Sprintf("%d", sm.TotalClients),
 				strconv.FormatUint(sm.BytesSent, 10), strconv.FormatUint(sm.BytesReceived, 10),
+				strconv.FormatUint(sm.WireBytesSent, 10),
 				fmt.Sprintf("%.1f", sm.UptimeSeconds),
+				strconv.FormatUint(sm.SessionSetup.Count, 10),
+				fmt.Sprintf("%.3f", sm.SessionSetup.LastMs),
+				strconv.FormatUint(sm.SessionsResumed, 10))...)
+			row = append(row, blankUnless(lmOK,
 				strconv.Itoa(lm.ConnectedClients),
 				fmt.Sprintf("%.3f", lm.AvgRttMs),
 				fmt.Sprintf("%.3f", lm.P50RttMs),
@@ -130,13 +462,36 @@ func main() {
 				fmt.Sprintf("%.3f", lm.P99RttMs),
 				fmt.Sprintf("%.3f", lm.MaxRttMs),
 				fmt.Sprintf("%.3f", lm.JitterMs),
-				fmt.Sprintf("%d", lm.ConnectionDrops),
+				fmt.Sprintf("%d", lm.ConnectionDrops))...)
+			row = append(row, blankUnless(smOK,
 				fmt.Sprintf("%.2f", sm.CPUPercent),
-				fmt.Sprintf("%.2f", sm.MemoryMB),
-				migEvent,
+				fmt.Sprintf("%.2f", sm.MemoryMB))...)
+			row = append(row, migEvent, migNumber, migDirection, "", okFlag(smOK), okFlag(lmOK),
+				strconv.FormatInt(sampled.Milliseconds(), 10))
+			row = append(row, timing(smOK, smRTT, sm.TimestampNs)...)
+			row = append(row, timing(lmOK, lmRTT, lm.TimestampNs)...)
+			row = append(row, strconv.Itoa(metricsmodel.SchemaVersion))
+			if probes != nil {
+				row = append(row, probeRow(probes, t)...)
+			}
+			if cgroups != nil {
+				row = append(row, cgroupRow(ctx, cgroups)...)
+			}
+			for _, c := range stats {
+				row = append(row, c.row()...)
+			}
+			if execProbes != nil {
+				row = append(row, execRow(ctx, execProbes, *execTimeout)...)
+			}
+			for _, p := range pings {
+				row = append(row, p.row(t)...)
+			}
+			if sw != nil {
+				row = append(row, sw.row()...)
 			}
 			_ = w.Write(row)
 			w.Flush()
+			rw.Push(remoteSamples(schema.Columns, row, t)...)
 		}
 	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+)
+
+// markerRow is the row for one of the orchestrator's phase events, written
+// as it arrives between the samples. Its timestamp is the orchestrator's
+// clock when the phase began or ended, so it can be a few milliseconds
+// before the sample written just above it. Only the timestamp, migration
+// and schema_version columns are set; it is not a sample.
+func markerRow(cols []metricsmodel.Column, ev eventbus.Event, start time.Time) []string {
+	t := time.Unix(0, ev.TsUnixNano)
+	field := func(k string) string {
+		if v, ok := ev.Fields[k]; ok {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	cells := map[string]string{
+		"timestamp":            t.Format(time.RFC3339Nano),
+		"timestamp_unix_milli": strconv.FormatInt(t.UnixMilli(), 10),
+		"elapsed_s":            fmt.Sprintf("%.3f", t.Sub(start).Seconds()),
+		"migration_number":     field("migration"),
+		"migration_direction":  field("direction"),
+		"migration_phase":      ev.Type,
+		"schema_version":       strconv.Itoa(metricsmodel.SchemaVersion),
+	}
+	row := make([]string, len(cols))
+	for i, c := range cols {
+		row[i] = cells[c.Name]
+	}
+	return row
+}
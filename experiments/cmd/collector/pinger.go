@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/netns"
+)
+
+// pinger sends ICMP echo requests to every target each -ping-interval,
+// independent of the CSV interval, and keeps per-target statistics of the
+// replies since the last row. A probe is counted where it resolves: in
+// the row whose window its reply or its -ping-timeout falls into.
+type pinger struct {
+	targets []netip.Addr
+	conns   map[bool]*icmp.PacketConn // by IPv6
+	timeout time.Duration
+	remote  *remotePing // pings from the loadgen's node instead
+
+	mu      sync.Mutex
+	seq     uint16
+	pending map[uint16]probe
+	windows map[netip.Addr]*pingWindow
+}
+
+type probe struct {
+	target netip.Addr
+	sent   time.Time
+}
+
+// pingWindow accumulates one target's probes for one row.
+type pingWindow struct {
+	sent, recv, lost int
+	min, max, sum    float64
+	jitter           float64 // sum of |rtt - previous rtt|
+	last             float64
+}
+
+// newPinger opens an ICMP socket per address family of targets, in the
+// network namespace at nsPath if set. Unprivileged ping sockets
+// (net.ipv4.ping_group_range) are tried first, then raw ones.
+func newPinger(targets []netip.Addr, timeout time.Duration, nsPath string) (*pinger, error) {
+	p := &pinger{
+		targets: targets,
+		conns:   map[bool]*icmp.PacketConn{},
+		timeout: timeout,
+		pending: map[uint16]probe{},
+		windows: map[netip.Addr]*pingWindow{},
+	}
+	for _, t := range targets {
+		p.windows[t] = &pingWindow{}
+		v6 := t.Is6()
+		if p.conns[v6] != nil {
+			continue
+		}
+		var c *icmp.PacketConn
+		open := func() (err error) {
+			c, err = listenICMP(v6)
+			return err
+		}
+		var err error
+		if nsPath != "" {
+			err = netns.Do(nsPath, open)
+		} else {
+			err = open()
+		}
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.conns[v6] = c
+	}
+	return p, nil
+}
+
+func listenICMP(v6 bool) (*icmp.PacketConn, error) {
+	networks := []string{"udp4", "ip4:icmp"}
+	addr := "0.0.0.0"
+	if v6 {
+		networks = []string{"udp6", "ip6:ipv6-icmp"}
+		addr = "::"
+	}
+	var err error
+	for _, n := range networks {
+		var c *icmp.PacketConn
+		if c, err = icmp.ListenPacket(n, addr); err == nil {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("ICMP socket: %w", err)
+}
+
+func (p *pinger) close() {
+	for _, c := range p.conns {
+		c.Close()
+	}
+}
+
+// run sends until stop is closed; the readers run until close.
+func (p *pinger) run(every time.Duration, stop <-chan struct{}) {
+	if p.remote != nil {
+		p.runRemote(every, stop)
+		return
+	}
+	for v6, c := range p.conns {
+		go p.read(c, v6)
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	id := os.Getpid() & 0xffff
+	for {
+		for _, target := range p.targets {
+			p.mu.Lock()
+			p.seq++
+			seq := p.seq
+			now := time.Now()
+			p.pending[seq] = probe{target, now}
+			p.windows[target].sent++
+			p.mu.Unlock()
+			var typ icmp.Type = ipv4.ICMPTypeEcho
+			if target.Is6() {
+				typ = ipv6.ICMPTypeEchoRequest
+			}
+			msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: id, Seq: int(seq), Data: []byte("p4cf-collector")}}
+			b, _ := msg.Marshal(nil)
+			c := p.conns[target.Is6()]
+			var dst net.Addr = &net.UDPAddr{IP: target.AsSlice()}
+			if strings.HasPrefix(c.LocalAddr().Network(), "ip") {
+				dst = &net.IPAddr{IP: target.AsSlice()}
+			}
+			if _, err := c.WriteTo(b, dst); err != nil {
+				log.Printf("ping %s: %v", target, err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *pinger) read(c *icmp.PacketConn, v6 bool) {
+	proto := 1
+	if v6 {
+		proto = 58
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || (m.Type != ipv4.ICMPTypeEchoReply && m.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+		echo, ok := m.Body.(*icmp.Echo)
+		if !ok {
+			continue
+		}
+		var from netip.Addr
+		switch a := peer.(type) {
+		case *net.UDPAddr:
+			from, _ = netip.AddrFromSlice(a.IP)
+		case *net.IPAddr:
+			from, _ = netip.AddrFromSlice(a.IP)
+		}
+		// Unprivileged sockets rewrite the ID, so replies are matched by
+		// sequence number and sender.
+		p.mu.Lock()
+		pr, ok := p.pending[uint16(echo.Seq)]
+		if ok && pr.target == from.Unmap() {
+			delete(p.pending, uint16(echo.Seq))
+			p.windows[pr.target].add(float64(now.Sub(pr.sent)) / 1e6)
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (w *pingWindow) add(rtt float64) {
+	if w.recv == 0 {
+		w.min, w.max = rtt, rtt
+	} else {
+		w.min, w.max = math.Min(w.min, rtt), math.Max(w.max, rtt)
+		w.jitter += math.Abs(rtt - w.last)
+	}
+	w.recv++
+	w.sum += rtt
+	w.last = rtt
+}
+
+// pingColumns are the per-target columns, with the address's dots and
+// colons as underscores and "@vantage" appended for a named vantage point.
+func pingColumns(targets []netip.Addr, vantage string) []metricsmodel.Column {
+	var cols []metricsmodel.Column
+	for _, t := range targets {
+		ip := strings.NewReplacer(".", "_", ":", "_").Replace(t.String())
+		if vantage != "" {
+			ip += "@" + vantage
+		}
+		cols = append(cols,
+			column("ping_sent_"+ip, "int", "probes", "ping"),
+			column("ping_lost_"+ip, "int", "probes", "ping"),
+			column("ping_rtt_min_ms_"+ip, "float", "ms", "ping"),
+			column("ping_rtt_ms_"+ip, "float", "ms", "ping"),
+			column("ping_rtt_max_ms_"+ip, "float", "ms", "ping"),
+			column("ping_jitter_ms_"+ip, "float", "ms", "ping"))
+	}
+	return cols
+}
+
+// row expires the probes older than the timeout as lost and returns the
+// windows since the last row: sent, lost, then min/avg/max RTT and jitter
+// (mean change between consecutive RTTs), empty without replies.
+func (p *pinger) row(now time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for seq, pr := range p.pending {
+		if now.Sub(pr.sent) > p.timeout {
+			delete(p.pending, seq)
+			p.windows[pr.target].lost++
+		}
+	}
+	var out []string
+	for _, t := range p.targets {
+		w := p.windows[t]
+		cells := []string{fmt.Sprint(w.sent), fmt.Sprint(w.lost), "", "", "", ""}
+		if w.recv > 0 {
+			cells[2] = fmt.Sprintf("%.3f", w.min)
+			cells[3] = fmt.Sprintf("%.3f", w.sum/float64(w.recv))
+			cells[4] = fmt.Sprintf("%.3f", w.max)
+			if w.recv > 1 {
+				cells[5] = fmt.Sprintf("%.3f", w.jitter/float64(w.recv-1))
+			}
+		}
+		out = append(out, cells...)
+		p.windows[t] = &pingWindow{}
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/remotewrite"
+)
+
+// parseLabels parses -remote-write-labels, name=value pairs.
+func parseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	if s == "" {
+		return labels, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not name=value", f)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// remoteSamples are the row's int, float and bool cells as p4cf_<column>
+// samples. Empty cells, a failed fetch or a window without ping replies,
+// are left out rather than sent as zeros.
+func remoteSamples(cols []metricsmodel.Column, row []string, t time.Time) []remotewrite.Sample {
+	var out []remotewrite.Sample
+	for i, c := range cols {
+		if i >= len(row) || row[i] == "" {
+			continue
+		}
+		switch c.Type {
+		case "int", "float", "bool":
+		default:
+			continue
+		}
+		v, err := strconv.ParseFloat(row[i], 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, remotewrite.Sample{Name: "p4cf_" + c.Name, Value: v, Time: t})
+	}
+	return out
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+// remotePing runs ping(8) on the loadgen's node (-loadgen-ssh) instead of
+// pinging from here, for topologies where the clients sit behind another
+// switch port than this host. Each target gets its own ping streaming its
+// output back over SSH; -O makes it report unanswered probes too.
+type remotePing struct {
+	host sshmux.Host
+	// prefix is the command that enters the loadgen's namespace. It is
+	// resolved again whenever ping exits: a restarted loadgen container
+	// has a new PID.
+	prefix func(ctx context.Context) (string, error)
+}
+
+func newRemotePinger(targets []netip.Addr, timeout time.Duration, r *remotePing) *pinger {
+	p := &pinger{targets: targets, timeout: timeout, remote: r, windows: map[netip.Addr]*pingWindow{}}
+	for _, t := range targets {
+		p.windows[t] = &pingWindow{}
+	}
+	return p
+}
+
+func (p *pinger) runRemote(every time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { <-stop; cancel() }()
+	var wg sync.WaitGroup
+	for _, t := range p.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A node that stays unreachable logs once, not every second.
+			last := ""
+			for ctx.Err() == nil {
+				err := p.pingRemote(ctx, t, every)
+				if ctx.Err() != nil {
+					return
+				}
+				if err.Error() != last {
+					log.Printf("ping %s on %s: %v; restarting", t, p.remote.host.Name, err)
+					last = err.Error()
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// pingRemote runs one ping to target until it exits or ctx ends.
+func (p *pinger) pingRemote(ctx context.Context, target netip.Addr, every time.Duration) error {
+	prefix, err := p.remote.prefix(ctx)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("%s ping -n -D -O -i %.3f -W %d %s",
+		prefix, every.Seconds(), int(math.Ceil(p.timeout.Seconds())), target)
+	cmd := p.remote.host.Command(ctx, script)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		p.observe(target, sc.Text())
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return errors.New("ping exited")
+}
+
+// observe counts one line of ping -D -O output:
+//
+//	[1700000000.123456] 64 bytes from 10.0.0.1: icmp_seq=1 ttl=64 time=0.045 ms
+//	[1700000000.234567] no answer yet for icmp_seq=2
+func (p *pinger) observe(target netip.Addr, line string) {
+	rtt, lost := 0.0, false
+	if _, after, ok := strings.Cut(line, " time="); ok {
+		v, err := strconv.ParseFloat(strings.Fields(after)[0], 64)
+		if err != nil {
+			return
+		}
+		rtt = v
+	} else if strings.Contains(line, "no answer yet") {
+		lost = true
+	} else {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w := p.windows[target]
+	w.sent++
+	if lost {
+		w.lost++
+	} else {
+		w.add(rtt)
+	}
+}
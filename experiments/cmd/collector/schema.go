@@ -0,0 +1,87 @@
+package main
+
+import "github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+
+func column(name, typ, unit, source string) metricsmodel.Column {
+	return metricsmodel.Column{Name: name, Type: typ, Unit: unit, Source: source}
+}
+
+// baseColumns are the columns of every CSV, in row order. The server's
+// and loadgen's counters are cumulative since they started.
+// migration_phase is only set on marker rows, see markerRow.
+func baseColumns() []metricsmodel.Column {
+	return []metricsmodel.Column{
+		column("timestamp", "time", "", "collector"),
+		column("timestamp_unix_milli", "int", "ms", "collector"),
+		column("elapsed_s", "float", "s", "collector"),
+		column("connected_clients", "int", "peers", "server"),
+		column("total_clients", "int", "peers", "server"),
+		column("bytes_sent", "int", "bytes", "server"),
+		column("bytes_received", "int", "bytes", "server"),
+		column("wire_bytes_sent", "int", "bytes", "server"),
+		column("uptime_s", "float", "s", "server"),
+		column("session_setups", "int", "sessions", "server"),
+		column("session_setup_last_ms", "float", "ms", "server"),
+		column("sessions_resumed", "int", "sessions", "server"),
+		column("lg_connected_clients", "int", "peers", "loadgen"),
+		column("ws_rtt_avg_ms", "float", "ms", "loadgen"),
+		column("ws_rtt_p50_ms", "float", "ms", "loadgen"),
+		column("ws_rtt_p95_ms", "float", "ms", "loadgen"),
+		column("ws_rtt_p99_ms", "float", "ms", "loadgen"),
+		column("ws_rtt_max_ms", "float", "ms", "loadgen"),
+		column("ws_jitter_ms", "float", "ms", "loadgen"),
+		column("connection_drops", "int", "drops", "loadgen"),
+		column("cpu_percent", "float", "%", "server"),
+		column("memory_mb", "float", "MB", "server"),
+		column("migration_event", "bool", "", "migration flag"),
+		column("migration_number", "int", "", "migration flag"),
+		column("migration_direction", "string", "", "migration flag"),
+		column("migration_phase", "string", "", "event bus"),
+		column("server_metrics_ok", "bool", "", "collector"),
+		column("loadgen_metrics_ok", "bool", "", "collector"),
+		column("sample_interval_ms", "int", "ms", "collector"),
+		column("server_rtt_ms", "float", "ms", "collector"),
+		column("server_remote_unix_milli", "float", "ms", "server"),
+		column("loadgen_rtt_ms", "float", "ms", "collector"),
+		column("loadgen_remote_unix_milli", "float", "ms", "loadgen"),
+		column("schema_version", "int", "", "collector"),
+	}
+}
+
+// probeColumns are the -probe-url columns, summed over the probes.
+func probeColumns() []metricsmodel.Column {
+	return []metricsmodel.Column{
+		column("probe_packets_in", "int", "packets", "probe"),
+		column("probe_packets_out", "int", "packets", "probe"),
+		column("probe_drops", "int", "packets", "probe"),
+		column("probe_gaps", "int", "gaps", "probe"),
+		column("probe_max_gap_ms", "float", "ms", "probe"),
+		column("probe_silence_ms", "float", "ms", "probe"),
+		column("probe_ok", "bool", "", "collector"),
+	}
+}
+
+// cgroupColumns are the -cgroup / -cgroup-url columns, cumulative
+// counters of the server container's cgroup.
+func cgroupColumns() []metricsmodel.Column {
+	return []metricsmodel.Column{
+		column("cgroup_cpu_ns", "int", "ns", "cgroup"),
+		column("cgroup_cpu_user_ns", "int", "ns", "cgroup"),
+		column("cgroup_cpu_system_ns", "int", "ns", "cgroup"),
+		column("cgroup_throttled_ns", "int", "ns", "cgroup"),
+		column("cgroup_memory_bytes", "int", "bytes", "cgroup"),
+		column("cgroup_io_read_bytes", "int", "bytes", "cgroup"),
+		column("cgroup_io_write_bytes", "int", "bytes", "cgroup"),
+		column("cgroup_running", "int", "nodes", "cgroup"),
+		column("cgroup_ok", "bool", "", "collector"),
+	}
+}
+
+// switchColumns are the -switch-grpc columns.
+func switchColumns() []metricsmodel.Column {
+	return []metricsmodel.Column{
+		column("switch_target", "string", "", "switch"),
+		column("switch_port", "int", "", "switch"),
+		column("switch_changed_unix_milli", "int", "ms", "switch"),
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+// containerStats samples `podman stats` (or docker's) for one container
+// in the background. A sample takes most of a second, longer than a burst
+// tick, so rows take the latest one instead of waiting for it.
+type containerStats struct {
+	name   string
+	host   sshmux.Host
+	engine podman.Engine
+
+	mu     sync.Mutex
+	last   metricsmodel.ContainerStats
+	ok     bool
+	failed bool // a failure was logged; the next success is logged too
+}
+
+// parseContainerStats parses -container-stats, name or name@ssh-host
+// entries.
+func parseContainerStats(s string, engine podman.Engine) []*containerStats {
+	var out []*containerStats
+	for _, f := range strings.Split(s, ",") {
+		name, addr, _ := strings.Cut(strings.TrimSpace(f), "@")
+		h := sshmux.Host{Name: "local"}
+		if addr != "" {
+			h = sshmux.Host{Name: addr, Addr: addr}
+		}
+		out = append(out, &containerStats{name: name, host: h, engine: engine})
+	}
+	return out
+}
+
+// statsColumns are the numeric columns of each container: podman's
+// "2.41%" and "18.35MB / 67.15GB" with the units stripped.
+func statsColumns(cs []*containerStats) []metricsmodel.Column {
+	var cols []metricsmodel.Column
+	for _, c := range cs {
+		p := "container_" + c.name
+		cols = append(cols,
+			column(p+"_cpu_pct", "float", "%", "container stats"),
+			column(p+"_mem_bytes", "int", "bytes", "container stats"),
+			column(p+"_mem_pct", "float", "%", "container stats"))
+	}
+	return cols
+}
+
+// run samples back to back, at most every interval, until ctx ends.
+func (c *containerStats) run(ctx context.Context, every time.Duration) {
+	for ctx.Err() == nil {
+		start := time.Now()
+		s, err := c.engine.Stats(ctx, c.host, c.name)
+		c.mu.Lock()
+		c.last, c.ok = s, err == nil
+		switch {
+		case err != nil && !c.failed && ctx.Err() == nil:
+			log.Printf("%s stats on %s: %v", c.name, c.host.Name, err)
+			c.failed = true
+		case err == nil && c.failed:
+			log.Printf("%s stats on %s: back", c.name, c.host.Name)
+			c.failed = false
+		}
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(every - time.Since(start)):
+		}
+	}
+}
+
+// row is the latest sample, blank while the last one failed.
+func (c *containerStats) row() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return blankUnless(c.ok,
+		fmt.Sprintf("%.2f", c.last.CPUPercent),
+		strconv.FormatUint(c.last.MemBytes, 10),
+		fmt.Sprintf("%.2f", c.last.MemPercent))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/p4rt"
+)
+
+// switchProbe polls the forward entry of the server IP on the Tofino,
+// faster than the CSV interval, so the moment the data plane flipped to
+// the other node is known to within one poll rather than one row.
+type switchProbe struct {
+	addr  string
+	ip    netip.Addr
+	nodes map[uint16]string // switch port -> node name
+	bus   *eventbus.Server
+
+	mu      sync.Mutex
+	port    uint16
+	read    bool
+	changed time.Time
+}
+
+// parsePorts parses name=port pairs into port -> name.
+func parsePorts(s string) (map[uint16]string, error) {
+	nodes := map[uint16]string{}
+	if s == "" {
+		return nodes, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		name, port, _ := strings.Cut(strings.TrimSpace(f), "=")
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		nodes[uint16(p)] = name
+	}
+	return nodes, nil
+}
+
+// run polls until ctx ends, redialing after errors.
+func (p *switchProbe) run(ctx context.Context, every time.Duration) {
+	var c *p4rt.Client
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		if c == nil {
+			dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			var err error
+			c, err = p4rt.Dial(dctx, p.addr, 0, 11, "")
+			cancel()
+			if err != nil {
+				log.Printf("switch probe: %v", err)
+				c = nil
+			}
+		}
+		if c != nil {
+			rctx, cancel := context.WithTimeout(ctx, every*5)
+			entries, err := c.ReadForward(rctx, p.ip)
+			cancel()
+			if err != nil {
+				log.Printf("switch probe: %v", err)
+				c.Close()
+				c = nil
+			} else {
+				p.observe(entries, time.Now())
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *switchProbe) observe(entries []p4rt.ForwardEntry, t time.Time) {
+	for _, e := range entries {
+		if e.Table != p4rt.ForwardTable {
+			continue
+		}
+		p.mu.Lock()
+		prev, had := p.port, p.read
+		p.port, p.read = e.Port, true
+		if had && prev != e.Port {
+			p.changed = t
+		}
+		p.mu.Unlock()
+		if had && prev != e.Port {
+			log.Printf("Switch target %s -> %s", p.name(prev), p.name(e.Port))
+			if p.bus != nil {
+				p.bus.Publish("collector", "switch_target_changed", map[string]any{
+					"from": p.name(prev), "to": p.name(e.Port), "sw_port": e.Port, "observed_unix_nano": t.UnixNano(),
+				})
+			}
+		}
+		return
+	}
+}
+
+func (p *switchProbe) name(port uint16) string {
+	if n, ok := p.nodes[port]; ok {
+		return n
+	}
+	return strconv.Itoa(int(port))
+}
+
+// row is switch_target, switch_port and switch_changed_unix_milli; empty
+// before the first read.
+func (p *switchProbe) row() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.read {
+		return []string{"", "", ""}
+	}
+	changed := ""
+	if !p.changed.IsZero() {
+		changed = strconv.FormatInt(p.changed.UnixMilli(), 10)
+	}
+	return []string{p.name(p.port), strconv.Itoa(int(p.port)), changed}
+}
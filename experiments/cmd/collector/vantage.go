@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/netns"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+// vantage is one place the -ping targets are pinged from. The same target
+// pinged from several (the loadgen's container, its node, this host)
+// tells a blackholed container path from a dead node.
+type vantage struct {
+	name      string // column suffix; "" for the default vantage point
+	ssh       string // node to ping from over SSH, "" for this host
+	netns     string // namespace spec, "" for the host's
+	container string // container whose namespace to use, looked up with -engine
+}
+
+// parseVantages parses -ping-from: name=where pairs, where is host,
+// netns:<spec> or container:<name>, after ssh://<addr>/ to ping from
+// another node.
+func parseVantages(s string) ([]vantage, error) {
+	var out []vantage
+	for _, f := range strings.Split(s, ",") {
+		name, where, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not name=where", f)
+		}
+		// Readers turn the underscores of ping_*_<ip>@<name> into the
+		// address's dots, so a name with one would come out mangled.
+		if strings.ContainsAny(name, "_@") {
+			return nil, fmt.Errorf("vantage point name %q: no _ or @", name)
+		}
+		v := vantage{name: name}
+		if rest, ok := strings.CutPrefix(where, "ssh://"); ok {
+			v.ssh, where, _ = strings.Cut(rest, "/")
+			if where == "" {
+				where = "host"
+			}
+		}
+		switch {
+		case where == "host":
+		case strings.HasPrefix(where, "netns:"):
+			v.netns = strings.TrimPrefix(where, "netns:")
+		case strings.HasPrefix(where, "container:"):
+			v.container = strings.TrimPrefix(where, "container:")
+		default:
+			return nil, fmt.Errorf("%s: %q is not host, netns:<spec> or container:<name>", name, where)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// pinger starts pinging targets from v; node gives the Host of an SSH
+// address, or of this host for "".
+func (v vantage) pinger(targets []netip.Addr, timeout time.Duration, engine podman.Engine, node func(addr string) sshmux.Host) (*pinger, error) {
+	if v.ssh == "" {
+		ns := ""
+		switch {
+		case v.netns != "":
+			ns = netns.Path(v.netns)
+		case v.container != "":
+			pid, err := engine.PID(context.Background(), node(""), v.container)
+			if err != nil {
+				return nil, err
+			}
+			ns = netns.Path(pid)
+		}
+		return newPinger(targets, timeout, ns)
+	}
+	r := &remotePing{host: node(v.ssh)}
+	r.prefix = func(ctx context.Context) (string, error) {
+		switch {
+		case v.container != "":
+			pid, err := engine.PID(ctx, r.host, v.container)
+			if err != nil {
+				return "", err
+			}
+			return "sudo nsenter -t " + pid + " -n", nil
+		case v.netns != "":
+			return "sudo nsenter --net=" + netns.Path(v.netns), nil
+		}
+		return "sudo", nil
+	}
+	// The pings start regardless and retry; this only reports early.
+	detect, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if prefix, err := r.prefix(detect); err != nil {
+		log.Printf("Ping vantage point %q on %s not found yet: %v", v.name, v.ssh, err)
+	} else {
+		log.Printf("Pinging from %s: %s", v.ssh, prefix)
+	}
+	return newRemotePinger(targets, timeout, r), nil
+}
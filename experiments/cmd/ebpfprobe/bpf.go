@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+)
+
+// record is what the TC programs put in the ring buffer per packet. The
+// layout is fixed by the stores in tcProgram.
+//
+//	0  u64 ktime_get_ns
+//	8  u32 skb->len
+//	12 u32 skb->ifindex
+//	16 u32 IPv4 saddr (network order, 0 for non-IPv4)
+//	20 u32 IPv4 daddr
+//	24 u16 sport (network order, 0 without TCP/UDP ports in reach)
+//	26 u16 dport
+//	28 u8  IP protocol
+//	29 u8  direction (0 ingress, 1 egress)
+const recordLen = 32
+
+type record struct {
+	ktimeNs  uint64
+	length   uint32
+	src, dst netip.Addr
+	sport    uint16
+	dport    uint16
+	proto    uint8
+	egress   bool
+}
+
+func parseRecord(b []byte) (record, bool) {
+	if len(b) < recordLen {
+		return record{}, false
+	}
+	r := record{
+		ktimeNs: binary.LittleEndian.Uint64(b[0:8]),
+		length:  binary.LittleEndian.Uint32(b[8:12]),
+		src:     netip.AddrFrom4([4]byte(b[16:20])),
+		dst:     netip.AddrFrom4([4]byte(b[20:24])),
+		sport:   binary.BigEndian.Uint16(b[24:26]),
+		dport:   binary.BigEndian.Uint16(b[26:28]),
+		proto:   b[28],
+		egress:  b[29] == 1,
+	}
+	return r, true
+}
+
+// __sk_buff field offsets (include/uapi/linux/bpf.h).
+const (
+	skbLen     = 0
+	skbIfindex = 40
+	skbData    = 76
+	skbDataEnd = 80
+)
+
+// tcProgram is a TC classifier that copies a few header fields of every
+// packet into events and lets the packet through unchanged. It is written
+// with the asm package so the probe needs no clang or generated objects.
+func tcProgram(events *ebpf.Map, egress bool) asm.Instructions {
+	dir := int64(0)
+	if egress {
+		dir = 1
+	}
+	return asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.LoadMapPtr(asm.R1, events.FD()),
+		asm.Mov.Imm(asm.R2, recordLen),
+		asm.Mov.Imm(asm.R3, 0),
+		asm.FnRingbufReserve.Call(),
+		asm.JEq.Imm(asm.R0, 0, "out"),
+		asm.Mov.Reg(asm.R7, asm.R0),
+		asm.StoreImm(asm.R7, 16, 0, asm.Word),
+		asm.StoreImm(asm.R7, 20, 0, asm.Word),
+		asm.StoreImm(asm.R7, 24, 0, asm.Word),
+		asm.StoreImm(asm.R7, 28, 0, asm.Word),
+		asm.FnKtimeGetNs.Call(),
+		asm.StoreMem(asm.R7, 0, asm.R0, asm.DWord),
+		asm.LoadMem(asm.R1, asm.R6, skbLen, asm.Word),
+		asm.StoreMem(asm.R7, 8, asm.R1, asm.Word),
+		asm.LoadMem(asm.R1, asm.R6, skbIfindex, asm.Word),
+		asm.StoreMem(asm.R7, 12, asm.R1, asm.Word),
+		asm.StoreImm(asm.R7, 29, dir, asm.Byte),
+
+		// Ethernet + a 20-byte IPv4 header + both ports must be in the
+		// linear data for the header fields.
+		asm.LoadMem(asm.R2, asm.R6, skbData, asm.Word),
+		asm.LoadMem(asm.R3, asm.R6, skbDataEnd, asm.Word),
+		asm.Mov.Reg(asm.R4, asm.R2),
+		asm.Add.Imm(asm.R4, 38),
+		asm.JGT.Reg(asm.R4, asm.R3, "submit"),
+		asm.LoadMem(asm.R1, asm.R2, 12, asm.Half),
+		asm.JNE.Imm(asm.R1, 0x0008, "submit"), // ETH_P_IP, read little-endian
+		asm.LoadMem(asm.R1, asm.R2, 26, asm.Word),
+		asm.StoreMem(asm.R7, 16, asm.R1, asm.Word),
+		asm.LoadMem(asm.R1, asm.R2, 30, asm.Word),
+		asm.StoreMem(asm.R7, 20, asm.R1, asm.Word),
+		asm.LoadMem(asm.R1, asm.R2, 23, asm.Byte),
+		asm.StoreMem(asm.R7, 28, asm.R1, asm.Byte),
+		asm.LoadMem(asm.R4, asm.R2, 14, asm.Byte),
+		asm.And.Imm(asm.R4, 0x0f),
+		asm.JNE.Imm(asm.R4, 5, "submit"), // options move the ports
+		asm.LoadMem(asm.R4, asm.R2, 34, asm.Half),
+		asm.StoreMem(asm.R7, 24, asm.R4, asm.Half),
+		asm.LoadMem(asm.R4, asm.R2, 36, asm.Half),
+		asm.StoreMem(asm.R7, 26, asm.R4, asm.Half),
+
+		asm.Mov.Reg(asm.R1, asm.R7).WithSymbol("submit"),
+		asm.Mov.Imm(asm.R2, 0),
+		asm.FnRingbufSubmit.Call(),
+		asm.Mov.Imm(asm.R0, -1).WithSymbol("out"), // TCX_NEXT
+		asm.Return(),
+	}
+}
+
+// probe is the ring buffer and the two attached programs.
+type probe struct {
+	events *ebpf.Map
+	progs  []*ebpf.Program
+	links  []link.Link
+}
+
+// attach loads the programs and attaches them with TCX (Linux 6.6+) to
+// both directions of the interface with index ifindex.
+func attach(ifindex int, ringSize uint32) (*probe, error) {
+	events, err := ebpf.NewMap(&ebpf.MapSpec{Name: "pkt_events", Type: ebpf.RingBuf, MaxEntries: ringSize})
+	if err != nil {
+		return nil, fmt.Errorf("ring buffer: %w", err)
+	}
+	p := &probe{events: events}
+	for _, egress := range []bool{false, true} {
+		prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+			Name:         "pkt_probe",
+			Type:         ebpf.SchedCLS,
+			Instructions: tcProgram(events, egress),
+			License:      "GPL",
+		})
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("load program: %w", err)
+		}
+		p.progs = append(p.progs, prog)
+		at := ebpf.AttachTCXIngress
+		if egress {
+			at = ebpf.AttachTCXEgress
+		}
+		l, err := link.AttachTCX(link.TCXOptions{Interface: ifindex, Program: prog, Attach: at})
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("attach: %w", err)
+		}
+		p.links = append(p.links, l)
+	}
+	return p, nil
+}
+
+func (p *probe) close() {
+	for _, l := range p.links {
+		l.Close()
+	}
+	for _, prog := range p.progs {
+		prog.Close()
+	}
+	p.events.Close()
+}
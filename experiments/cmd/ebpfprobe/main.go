@@ -0,0 +1,335 @@
+// Command ebpfprobe timestamps every packet to and from the stream server
+// in the kernel. Two TC programs (ingress and egress, attached with TCX, so
+// Linux 6.6+) copy the arrival time and headers of each packet into a BPF
+// ring buffer; this process reads it, keeps per-direction counters and
+// writes every silence longer than -gap as one JSON line with the exact
+// kernel time the traffic stopped and resumed.
+//
+// Run it as root on each node, on the NIC the server's macvlan sits on (it
+// survives the restore, the container's eth0 does not), or inside the
+// container's netns with -pid:
+//
+//	sudo ebpfprobe -iface enp1s0 -ip 192.168.12.2 -output probe_gaps.jsonl -metrics-addr :9200
+//
+// The collector samples /metrics through -probe-url; device drop counters
+// come from /proc/net/dev of the same netns.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+)
+
+var (
+	ifName       = flag.String("iface", "eth0", "Interface to attach to")
+	pid          = flag.Int("pid", 0, "Attach inside the network namespace of this PID (0 = own namespace)")
+	serverIP     = flag.String("ip", "", "Only count IPv4 packets from or to this address (empty = all packets)")
+	gapMin       = flag.Duration("gap", 20*time.Millisecond, "Log silences longer than this as gaps")
+	ringSize     = flag.Int("ring-size", 1<<22, "Ring buffer size in bytes (power of two, multiple of the page size)")
+	output       = flag.String("output", "", "Append gap JSON lines to this file (default stdout)")
+	metricsAddr  = flag.String("metrics-addr", ":9200", "HTTP address for /metrics (empty = none)")
+	eventBusAddr = flag.String("event-bus", "", "Publish traffic_resumed events to the collector's event bus at this address (host:port)")
+)
+
+// gap is one silence in one direction, in the JSON lines output.
+type gap struct {
+	Type       string  `json:"type"` // "gap"
+	Iface      string  `json:"iface"`
+	Direction  string  `json:"direction"` // "in" or "out", seen from the interface
+	StartNs    int64   `json:"start_unix_nano"`
+	EndNs      int64   `json:"end_unix_nano"`
+	DurationMs float64 `json:"duration_ms"`
+	// Resumed with: the first packet after the silence.
+	Src   string `json:"src,omitempty"`
+	Dst   string `json:"dst,omitempty"`
+	Proto uint8  `json:"proto"`
+	Len   uint32 `json:"len"`
+}
+
+// metrics is the /metrics response.
+type metrics struct {
+	Iface         string  `json:"iface"`
+	PacketsIn     uint64  `json:"packets_in"`
+	PacketsOut    uint64  `json:"packets_out"`
+	BytesIn       uint64  `json:"bytes_in"`
+	BytesOut      uint64  `json:"bytes_out"`
+	RxDropped     uint64  `json:"rx_dropped"`
+	TxDropped     uint64  `json:"tx_dropped"`
+	Gaps          uint64  `json:"gaps"`
+	MaxGapMs      float64 `json:"max_gap_ms"`
+	LastGapMs     float64 `json:"last_gap_ms"`
+	LastPacketNs  int64   `json:"last_packet_unix_nano"`
+	TimestampNs   int64   `json:"timestamp_unix_nano"`
+	SilenceMs     float64 `json:"silence_ms"`
+	ShortRecords  uint64  `json:"short_records"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// counters turns ring buffer records into metrics and gaps.
+type counters struct {
+	mu      sync.Mutex
+	m       metrics
+	last    [2]int64 // last packet per direction, Unix ns
+	started time.Time
+}
+
+func (c *counters) observe(r record, at int64) *gap {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dir := 0
+	if r.egress {
+		dir = 1
+		c.m.PacketsOut++
+		c.m.BytesOut += uint64(r.length)
+	} else {
+		c.m.PacketsIn++
+		c.m.BytesIn += uint64(r.length)
+	}
+	c.m.LastPacketNs = max(c.m.LastPacketNs, at)
+	prev := c.last[dir]
+	c.last[dir] = max(prev, at)
+	if prev == 0 || time.Duration(at-prev) < *gapMin {
+		return nil
+	}
+	ms := float64(at-prev) / 1e6
+	c.m.Gaps++
+	c.m.LastGapMs = ms
+	c.m.MaxGapMs = max(c.m.MaxGapMs, ms)
+	g := &gap{
+		Type: "gap", Iface: c.m.Iface, Direction: [2]string{"in", "out"}[dir],
+		StartNs: prev, EndNs: at, DurationMs: ms,
+		Proto: r.proto, Len: r.length,
+	}
+	if r.src.IsValid() && !r.src.IsUnspecified() {
+		g.Src = addrPort(r.src, r.sport)
+		g.Dst = addrPort(r.dst, r.dport)
+	}
+	return g
+}
+
+func addrPort(a netip.Addr, port uint16) string {
+	if port == 0 {
+		return a.String()
+	}
+	return netip.AddrPortFrom(a, port).String()
+}
+
+func (c *counters) snapshot() metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.m
+	m.TimestampNs = time.Now().UnixNano()
+	if m.LastPacketNs > 0 {
+		m.SilenceMs = float64(m.TimestampNs-m.LastPacketNs) / 1e6
+	}
+	m.RxDropped, m.TxDropped = devDrops(*pid, m.Iface)
+	m.UptimeSeconds = time.Since(c.started).Seconds()
+	return m
+}
+
+// devDrops reads the interface's rx/tx drop counters from /proc/net/dev of
+// the netns of pid (own netns for 0).
+func devDrops(pid int, iface string) (rx, tx uint64) {
+	path := "/proc/self/net/dev"
+	if pid != 0 {
+		path = fmt.Sprintf("/proc/%d/net/dev", pid)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) != iface {
+			continue
+		}
+		// rx: bytes packets errs drop ... (8 fields), tx: bytes packets errs drop ...
+		f := strings.Fields(rest)
+		if len(f) < 12 {
+			return 0, 0
+		}
+		rx, _ = strconv.ParseUint(f[3], 10, 64)
+		tx, _ = strconv.ParseUint(f[11], 10, 64)
+		return rx, tx
+	}
+	return 0, 0
+}
+
+// monoOffset is what to add to CLOCK_MONOTONIC (bpf_ktime_get_ns) to get
+// Unix time.
+func monoOffset() int64 {
+	var ts unix.Timespec
+	before := time.Now().UnixNano()
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	after := time.Now().UnixNano()
+	return (before+after)/2 - ts.Nano()
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	var filter netip.Addr
+	if *serverIP != "" {
+		var err error
+		if filter, err = netip.ParseAddr(*serverIP); err != nil || !filter.Is4() {
+			log.Fatalf("-ip: need an IPv4 address, got %q", *serverIP)
+		}
+	}
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("-output: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+
+	if err := rlimit.RemoveMemlock(); err != nil {
+		log.Fatalf("memlock: %v", err)
+	}
+	p, err := attachIn(*pid, *ifName)
+	if err != nil {
+		log.Fatalf("%s: %v", *ifName, err)
+	}
+	defer p.close()
+	rd, err := ringbuf.NewReader(p.events)
+	if err != nil {
+		log.Fatalf("ring buffer reader: %v", err)
+	}
+	defer rd.Close()
+
+	var bus *eventbus.Client
+	if *eventBusAddr != "" {
+		if bus, err = eventbus.Dial(*eventBusAddr, "ebpfprobe"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+		defer bus.Close(time.Second)
+	}
+
+	c := &counters{m: metrics{Iface: *ifName}, started: time.Now()}
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(c.snapshot())
+		})
+		lis, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			log.Fatalf("-metrics-addr: %v", err)
+		}
+		go http.Serve(lis, mux)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() { <-sigCh; cancel(); rd.Close() }()
+
+	hostname, _ := os.Hostname()
+	log.Printf("ebpfprobe: %s (pid %d netns), filter %q, gaps over %s", *ifName, *pid, *serverIP, *gapMin)
+	bus.Publish("ebpfprobe_started", map[string]any{"iface": *ifName, "ip": *serverIP, "node": hostname})
+
+	// Re-derive the clock offset now and then so NTP steps do not skew
+	// the wall-clock times.
+	offset := monoOffset()
+	offsetAt := time.Now()
+	for {
+		rec, err := rd.Read()
+		if err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) || ctx.Err() != nil {
+				break
+			}
+			log.Printf("ring buffer: %v", err)
+			continue
+		}
+		r, ok := parseRecord(rec.RawSample)
+		if !ok {
+			c.mu.Lock()
+			c.m.ShortRecords++
+			c.mu.Unlock()
+			continue
+		}
+		if filter.IsValid() && r.src != filter && r.dst != filter {
+			continue
+		}
+		if time.Since(offsetAt) > 10*time.Second {
+			offset, offsetAt = monoOffset(), time.Now()
+		}
+		g := c.observe(r, int64(r.ktimeNs)+offset)
+		if g == nil {
+			continue
+		}
+		log.Printf("%s %s: traffic resumed after %.1f ms", g.Iface, g.Direction, g.DurationMs)
+		enc.Encode(g)
+		bus.Publish("traffic_resumed", map[string]any{
+			"node":             hostname,
+			"iface":            g.Iface,
+			"direction":        g.Direction,
+			"gap_ms":           g.DurationMs,
+			"last_packet_ns":   g.StartNs,
+			"first_packet_ns":  g.EndNs,
+			"first_packet_src": g.Src,
+			"first_packet_dst": g.Dst,
+		})
+	}
+	m := c.snapshot()
+	log.Printf("ebpfprobe: %d in, %d out, %d gaps, longest %.1f ms", m.PacketsIn, m.PacketsOut, m.Gaps, m.MaxGapMs)
+}
+
+// attachIn attaches the probe to iface in the netns of pid. Setns only
+// moves the calling thread, which is locked for the duration; the TCX
+// links stay on the interface after the thread goes back.
+func attachIn(pid int, iface string) (*probe, error) {
+	if pid == 0 {
+		ifc, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		return attach(ifc.Index, uint32(*ringSize))
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	self, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		return nil, err
+	}
+	defer self.Close()
+	target, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		return nil, fmt.Errorf("setns: %w", err)
+	}
+	defer unix.Setns(int(self.Fd()), unix.CLONE_NEWNET)
+	ifc, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	return attach(ifc.Index, uint32(*ringSize))
+}
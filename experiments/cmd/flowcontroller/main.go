@@ -0,0 +1,369 @@
+// Command flowcontroller keeps the Tofino's forward entries for the
+// server pointing at whichever node runs it. It follows `podman events`
+// for the container on every node, and when the container starts or is
+// restored somewhere else, retargets the forward and arp_forward entries
+// of its IP to that node's switch port over BF Runtime gRPC:
+//
+//	flowcontroller -hosts lakewood=user@source-server,loveland=user@target-server \
+//	  -ports lakewood=140,loveland=148 -switch-grpc tofino:50052 -event-bus localhost:50070
+//
+// With it running, the orchestrator can migrate without -controller-url
+// or -switch-grpc: the restore on the target is what moves the traffic.
+// Every -resync it also inspects all nodes and reads the switch back, to
+// repair what a missed event or another client left behind.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/p4rt"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+var (
+	hostList     = flag.String("hosts", "", "Comma-separated name=ssh-destination pairs of the nodes (empty destination = this machine)")
+	portList     = flag.String("ports", "", "Comma-separated name=switch-port pairs, the port behind each node's NIC")
+	container    = flag.String("container", "stream-server", "Container to follow")
+	engineName   = flag.String("engine", "podman", "Container engine on the nodes: "+podman.Engines)
+	serverIP     = flag.String("server-ip", "", "Forward entry key (default the container's IP from podman inspect)")
+	serverMAC    = flag.String("server-mac", "", "Destination MAC to rewrite to (default the container's MAC from podman inspect)")
+	switchGRPC   = flag.String("switch-grpc", "127.0.0.1:50052", "BF Runtime gRPC address of bf_switchd")
+	deviceID     = flag.Uint("device-id", 0, "Switch device ID")
+	clientID     = flag.Uint("client-id", 10, "BF Runtime client ID; must differ from the controller's, p4ctl's and p4digest's")
+	p4Name       = flag.String("p4-name", "", "P4 program name (empty = the first program on the switch)")
+	sshOpts      = flag.String("ssh-opts", "-o BatchMode=yes -o StrictHostKeyChecking=no -o ConnectTimeout=10 -o ServerAliveInterval=10", "Options for ssh")
+	resync       = flag.Duration("resync", 30*time.Second, "Interval of full inspections and switch read-backs (0 = only on events)")
+	retry        = flag.Duration("retry", 2*time.Second, "Wait before restarting a broken events stream")
+	timeout      = flag.Duration("timeout", 10*time.Second, "Timeout of each inspection and switch write")
+	dryRun       = flag.Bool("dry-run", false, "Log the retargets instead of writing them")
+	eventBusAddr = flag.String("event-bus", "", "Publish placement_changed and switch_reprogrammed events to the collector's event bus at this address (host:port)")
+)
+
+// podmanEvent is one container event on one node.
+type podmanEvent struct {
+	node     string
+	status   string
+	received time.Time
+}
+
+// target is where the forward entries should point.
+type target struct {
+	node string
+	port uint16
+	ip   netip.Addr
+	mac  net.HardwareAddr
+}
+
+func (t target) String() string {
+	return fmt.Sprintf("%s (port %d, %s, %s)", t.node, t.port, t.ip, t.mac)
+}
+
+type controller struct {
+	engine podman.Engine
+	hosts  map[string]sshmux.Host
+	ports  map[string]uint16
+	bus    *eventbus.Client
+
+	placement  map[string]podman.Container // last inspection per node
+	programmed *target                     // what this process last wrote or read back
+	sw         *p4rt.Client
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *hostList == "" || *portList == "" {
+		log.Fatal("-hosts and -ports are required")
+	}
+	engine, err := podman.NewEngine(*engineName)
+	if err != nil {
+		log.Fatalf("-engine: %v", err)
+	}
+	mux, err := sshmux.New(*sshOpts, 10*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mux.Close()
+	c := &controller{engine: engine, hosts: map[string]sshmux.Host{}, ports: map[string]uint16{}, placement: map[string]podman.Container{}}
+	for _, f := range strings.Split(*hostList, ",") {
+		name, dest, _ := strings.Cut(strings.TrimSpace(f), "=")
+		c.hosts[name] = mux.Host(name, dest)
+	}
+	for _, f := range strings.Split(*portList, ",") {
+		name, port, _ := strings.Cut(strings.TrimSpace(f), "=")
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			log.Fatalf("-ports: %s: %v", name, err)
+		}
+		if _, ok := c.hosts[name]; !ok {
+			log.Fatalf("-ports: %s is not in -hosts", name)
+		}
+		c.ports[name] = uint16(p)
+	}
+	for name := range c.hosts {
+		if _, ok := c.ports[name]; !ok {
+			log.Fatalf("-ports: no port for %s", name)
+		}
+	}
+	if *eventBusAddr != "" {
+		if c.bus, err = eventbus.Dial(*eventBusAddr, "flowcontroller"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+		defer c.bus.Close(2 * time.Second)
+	}
+	defer func() {
+		if c.sw != nil {
+			c.sw.Close()
+		}
+	}()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	events := make(chan podmanEvent, 64)
+	for name, h := range c.hosts {
+		go watch(ctx, engine, name, h, events)
+	}
+
+	c.reconcile(ctx, "startup", time.Now())
+	var tick <-chan time.Time
+	if *resync > 0 {
+		t := time.NewTicker(*resync)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Print("Stopping")
+			return
+		case ev := <-events:
+			if !relevant(ev.status) {
+				continue
+			}
+			log.Printf("%s: %s %s", ev.node, *container, ev.status)
+			c.inspect(ctx, ev.node)
+			c.apply(ctx, ev.status, ev.received)
+		case <-tick:
+			c.reconcile(ctx, "resync", time.Now())
+		}
+	}
+}
+
+// relevant reports whether a podman event can change where the container
+// runs; exec, health and similar events cannot.
+func relevant(status string) bool {
+	switch status {
+	case "start", "restore", "unpause", "init",
+		"died", "stop", "kill", "pause", "checkpoint", "remove", "cleanup":
+		return true
+	}
+	return false
+}
+
+// watch streams the engine's events for the container on one node into
+// events, restarting the stream when SSH or the engine drops it.
+func watch(ctx context.Context, engine podman.Engine, name string, h sshmux.Host, events chan<- podmanEvent) {
+	for ctx.Err() == nil {
+		cmd := h.Command(ctx, engine.EventsCmd(*container))
+		out, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			log.Printf("%s: %s events: %v", name, engine.Name(), err)
+		} else {
+			log.Printf("%s: following %s events", name, engine.Name())
+			sc := bufio.NewScanner(out)
+			for sc.Scan() {
+				e, err := engine.ParseEvent(sc.Bytes())
+				if err != nil {
+					log.Printf("%s: %v", name, err)
+					continue
+				}
+				events <- podmanEvent{node: name, status: e.Status, received: time.Now()}
+			}
+			err = cmd.Wait()
+			if ctx.Err() == nil {
+				log.Printf("%s: %s events ended: %v", name, engine.Name(), err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(*retry):
+		}
+	}
+}
+
+func (c *controller) inspect(ctx context.Context, node string) {
+	ictx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	ct, err := c.engine.Inspect(ictx, c.hosts[node], *container)
+	if err != nil {
+		// Keep the last known state rather than guess.
+		log.Printf("%s: inspect: %v", node, err)
+		return
+	}
+	c.placement[node] = ct
+}
+
+// desired is the target for the node running the container. If it runs
+// on more than one (a restore before the source was removed), the latest
+// started wins.
+func (c *controller) desired() (target, bool) {
+	var running []string
+	for node, ct := range c.placement {
+		if ct.Running {
+			running = append(running, node)
+		}
+	}
+	if len(running) == 0 {
+		return target{}, false
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return c.placement[running[i]].StartedAt.After(c.placement[running[j]].StartedAt)
+	})
+	node := running[0]
+	ct := c.placement[node]
+	t := target{node: node, port: c.ports[node]}
+	ipStr, macStr := ct.IP, ct.MAC
+	if *serverIP != "" {
+		ipStr = *serverIP
+	}
+	if *serverMAC != "" {
+		macStr = *serverMAC
+	}
+	var err error
+	if t.ip, err = netip.ParseAddr(ipStr); err != nil {
+		log.Printf("%s: no usable IP for %s (%q); set -server-ip", node, *container, ipStr)
+		return target{}, false
+	}
+	if macStr != "" {
+		if t.mac, err = net.ParseMAC(macStr); err != nil {
+			log.Printf("%s: bad MAC %q: %v", node, macStr, err)
+			return target{}, false
+		}
+	}
+	if len(running) > 1 {
+		log.Printf("%s runs on %s; using %s, started last", *container, strings.Join(running, " and "), node)
+	}
+	return t, true
+}
+
+// reconcile inspects every node and reads the switch back before
+// applying, so a stale view of either gets corrected.
+func (c *controller) reconcile(ctx context.Context, trigger string, since time.Time) {
+	for node := range c.hosts {
+		c.inspect(ctx, node)
+	}
+	want, ok := c.desired()
+	if !ok {
+		c.apply(ctx, trigger, since)
+		return
+	}
+	c.programmed = nil
+	rctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	if sw, err := c.client(rctx); err != nil {
+		log.Printf("switch: %v", err)
+	} else if entries, err := sw.ReadForward(rctx, want.ip); err != nil {
+		log.Printf("switch: %v", err)
+		c.dropClient()
+	} else if matches(entries, want) {
+		c.programmed = &want
+	}
+	c.apply(ctx, trigger, since)
+}
+
+// matches reports whether both entries of the IP already point at t.
+func matches(entries []p4rt.ForwardEntry, t target) bool {
+	if len(entries) != 2 {
+		return false
+	}
+	for _, e := range entries {
+		if e.Port != t.port {
+			return false
+		}
+		if e.Table == p4rt.ForwardTable && t.mac != nil && e.MAC.String() != t.mac.String() {
+			return false
+		}
+	}
+	return true
+}
+
+// apply retargets the switch if the desired target differs from what it
+// was last set to.
+func (c *controller) apply(ctx context.Context, trigger string, since time.Time) {
+	want, ok := c.desired()
+	if !ok {
+		if c.programmed != nil {
+			log.Printf("%s runs nowhere; leaving the switch on %s", *container, c.programmed.node)
+		}
+		return
+	}
+	if p := c.programmed; p != nil && p.node == want.node && p.ip == want.ip && p.mac.String() == want.mac.String() {
+		return
+	}
+	if c.programmed == nil || c.programmed.node != want.node {
+		c.bus.Publish("placement_changed", map[string]any{"node": want.node, "ip": want.ip.String(), "trigger": trigger})
+	}
+	if *dryRun {
+		log.Printf("Would retarget %s to %s", want.ip, want)
+		c.programmed = &want
+		return
+	}
+	wctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	sw, err := c.client(wctx)
+	if err == nil {
+		err = sw.Retarget(wctx, want.ip, want.port, want.mac)
+	}
+	if err != nil {
+		log.Printf("Retarget %s to %s: %v", want.ip, want, err)
+		c.dropClient()
+		return
+	}
+	c.programmed = &want
+	reactMs := float64(time.Since(since)) / float64(time.Millisecond)
+	log.Printf("Retargeted %s to %s after %s (%.1f ms)", want.ip, want, trigger, reactMs)
+	fields := map[string]any{"node": want.node, "sw_port": want.port, "ip": want.ip.String(), "trigger": trigger, "react_ms": reactMs}
+	if want.mac != nil {
+		fields["mac"] = want.mac.String()
+	}
+	c.bus.Publish("switch_reprogrammed", fields)
+}
+
+// client returns the BF Runtime connection, dialing it if needed.
+func (c *controller) client(ctx context.Context) (*p4rt.Client, error) {
+	if c.sw != nil {
+		return c.sw, nil
+	}
+	sw, err := p4rt.Dial(ctx, *switchGRPC, uint32(*deviceID), uint32(*clientID), *p4Name)
+	if err != nil {
+		return nil, err
+	}
+	c.sw = sw
+	return sw, nil
+}
+
+// dropClient closes the connection after an error, so the next write
+// dials again, e.g. after bf_switchd restarted.
+func (c *controller) dropClient() {
+	if c.sw != nil {
+		c.sw.Close()
+		c.sw = nil
+	}
+}
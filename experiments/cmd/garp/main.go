@@ -0,0 +1,162 @@
+// Command garp announces an address from inside a container's network
+// namespace right after restore: gratuitous ARP for IPv4, an unsolicited
+// neighbor advertisement for IPv6. Upstream ARP/neighbor caches then move
+// to the restored container at once instead of after their timeout, so
+// the measured blackout is the migration's, not the cache's.
+//
+// It prints the send times as key=value lines (garp_sent_ns,
+// garp_last_ns) for the migration timing file.
+//
+//	sudo garp -pid $(podman inspect -f '{{.State.Pid}}' stream-server) -ip 192.168.12.2
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/netns"
+)
+
+var (
+	pid      = flag.Int("pid", 0, "Enter the network namespace of this process first (0 = stay in the current one)")
+	ifName   = flag.String("iface", "eth0", "Interface to announce on")
+	ipAddr   = flag.String("ip", "", "Address to announce (required); IPv6 sends an unsolicited NA")
+	macAddr  = flag.String("mac", "", "Link-layer address to announce (default: the interface's)")
+	count    = flag.Int("count", 3, "Announcements to send")
+	interval = flag.Duration("interval", 50*time.Millisecond, "Pause between announcements")
+	reply    = flag.Bool("reply", false, "Send ARP replies instead of requests (arping -A instead of -U)")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	ip, err := netip.ParseAddr(*ipAddr)
+	if err != nil {
+		log.Fatalf("-ip: %v", err)
+	}
+	ip = ip.Unmap()
+
+	// setns applies to the calling thread only; everything below, the
+	// interface lookup included, has to stay on it.
+	if *pid != 0 {
+		if err := netns.Enter(netns.Path(strconv.Itoa(*pid))); err != nil {
+			log.Fatalf("-pid: %v", err)
+		}
+	}
+	ifc, err := net.InterfaceByName(*ifName)
+	if err != nil {
+		log.Fatalf("-iface: %v", err)
+	}
+	mac := ifc.HardwareAddr
+	if *macAddr != "" {
+		if mac, err = net.ParseMAC(*macAddr); err != nil {
+			log.Fatalf("-mac: %v", err)
+		}
+	}
+	if len(mac) != 6 {
+		log.Fatalf("%s has no Ethernet address", *ifName)
+	}
+
+	var frame []byte
+	var proto uint16
+	if ip.Is4() {
+		frame, proto = garpFrame(ip, mac, *reply), unix.ETH_P_ARP
+	} else {
+		frame, proto = unsolicitedNA(ip, mac), unix.ETH_P_IPV6
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(proto)))
+	if err != nil {
+		log.Fatalf("raw socket: %v", err)
+	}
+	defer unix.Close(fd)
+	to := &unix.SockaddrLinklayer{Protocol: htons(proto), Ifindex: ifc.Index, Halen: 6}
+	copy(to.Addr[:], frame[0:6])
+
+	var first, last time.Time
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		if err := unix.Sendto(fd, frame, 0, to); err != nil {
+			log.Fatalf("send: %v", err)
+		}
+		last = time.Now()
+		if first.IsZero() {
+			first = last
+		}
+	}
+	fmt.Printf("garp_sent_ns=%d\ngarp_last_ns=%d\n", first.UnixNano(), last.UnixNano())
+}
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
+
+// garpFrame builds a broadcast ARP request (or reply) whose sender and
+// target address are both ip.
+func garpFrame(ip netip.Addr, mac net.HardwareAddr, reply bool) []byte {
+	b := make([]byte, 0, 42)
+	b = append(b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	b = append(b, mac...)
+	b = binary.BigEndian.AppendUint16(b, unix.ETH_P_ARP)
+	b = binary.BigEndian.AppendUint16(b, 1) // Ethernet
+	b = binary.BigEndian.AppendUint16(b, unix.ETH_P_IP)
+	b = append(b, 6, 4)
+	op, target := uint16(1), net.HardwareAddr{0, 0, 0, 0, 0, 0}
+	if reply {
+		op, target = 2, net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+	b = binary.BigEndian.AppendUint16(b, op)
+	a := ip.As4()
+	b = append(b, mac...)
+	b = append(b, a[:]...)
+	b = append(b, target...)
+	return append(b, a[:]...)
+}
+
+// unsolicitedNA builds a neighbor advertisement for ip to all nodes
+// (ff02::1) with the override flag and a target link-layer address
+// option, as RFC 4861 7.2.6 describes.
+func unsolicitedNA(ip netip.Addr, mac net.HardwareAddr) []byte {
+	src := ip.As16()
+	dst := netip.MustParseAddr("ff02::1").As16()
+
+	icmp := []byte{136, 0, 0, 0, 0x20, 0, 0, 0} // type NA, override
+	icmp = append(icmp, src[:]...)
+	icmp = append(icmp, 2, 1) // target link-layer address, 8 bytes
+	icmp = append(icmp, mac...)
+
+	// Checksum over the IPv6 pseudo-header and the message.
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+	}
+	add(src[:])
+	add(dst[:])
+	add(binary.BigEndian.AppendUint32(nil, uint32(len(icmp))))
+	add([]byte{0, 0, 0, 58})
+	add(icmp)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	binary.BigEndian.PutUint16(icmp[2:], ^uint16(sum))
+
+	b := make([]byte, 0, 14+40+len(icmp))
+	b = append(b, 0x33, 0x33, 0, 0, 0, 1)
+	b = append(b, mac...)
+	b = binary.BigEndian.AppendUint16(b, unix.ETH_P_IPV6)
+	b = append(b, 0x60, 0, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(icmp)))
+	b = append(b, 58, 255) // ICMPv6, hop limit 255
+	b = append(b, src[:]...)
+	b = append(b, dst[:]...)
+	return append(b, icmp...)
+}
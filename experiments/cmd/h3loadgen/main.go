@@ -0,0 +1,356 @@
+// Command h3loadgen is the client for cmd/h3server: -connections peers,
+// each on its own QUIC connection, read GET /stream and measure RTT with
+// POST /ping on the same connection. /metrics uses the field names of
+// cmd/loadgen's, so the collector takes either with -loadgen-url.
+//
+// A peer keeps its QUIC connection across a migration as long as the
+// server comes back within -idle-timeout; only when the connection or the
+// stream fails does it count a connection drop and dial again.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+)
+
+var (
+	serverURL    = flag.String("server", "https://192.168.12.2:8443", "h3server base URL")
+	numConns     = flag.Int("connections", 4, "Number of concurrent QUIC connections")
+	pingMs       = flag.Int("ping-interval-ms", 100, "Ping interval in milliseconds")
+	rttCapMs     = flag.Float64("rtt-cap-ms", 1000, "Discard ping RTTs above this threshold (requests stuck across the migration freeze)")
+	reportIval   = flag.Duration("interval", time.Second, "Metrics reporting interval (stdout)")
+	testDur      = flag.Duration("duration", 0, "Test duration (0 = until interrupted)")
+	metricsPort  = flag.Int("metrics-port", 9090, "HTTP port for /metrics endpoint")
+	idleTimeout  = flag.Duration("idle-timeout", 30*time.Second, "QUIC idle timeout; must outlast the checkpoint/restore freeze")
+	eventBusAddr = flag.String("event-bus", "", "Publish first_packet_after_gap events to the collector's event bus at this address (host:port)")
+	gapEvent     = flag.Duration("gap-event", 100*time.Millisecond, "Shortest stream gap reported as first_packet_after_gap on -event-bus (0 = none)")
+)
+
+// Frame on /stream, see cmd/h3server: u32 length of the rest, u64
+// sequence number, u64 send time in Unix nanoseconds, padding.
+const frameHeaderLen = 20
+
+// bus is the -event-bus client; nil (discarding) without the flag.
+var bus *eventbus.Client
+
+type peer struct {
+	id     int
+	client *http.Client
+	tr     *http3.Transport
+
+	connected atomic.Bool
+	bytesRecv atomic.Uint64
+	frames    atomic.Uint64
+	missed    atomic.Uint64
+	drops     atomic.Int64
+
+	mu         sync.Mutex
+	rttSamples []float64
+	lastRtt    float64
+	jitterSum  float64
+	jitterN    int
+	maxFreeze  time.Duration
+	owdSum     float64
+	owdN       int
+}
+
+func newPeer(id int) *peer {
+	tr := &http3.Transport{
+		// h3server's certificate is self-signed by default.
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		QUICConfig: &quic.Config{
+			MaxIdleTimeout:  *idleTimeout,
+			KeepAlivePeriod: time.Second,
+		},
+	}
+	return &peer{id: id, tr: tr, client: &http.Client{Transport: tr}}
+}
+
+// run reads /stream until ctx ends, dialing again after every failure.
+func (p *peer) run(ctx context.Context) {
+	backoff := 500 * time.Millisecond
+	for ctx.Err() == nil {
+		err := p.stream(ctx)
+		p.connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		p.drops.Add(1)
+		log.Printf("[peer-%d] stream ended: %v; reconnecting in %s", p.id, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (p *peer) stream(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, *serverURL+"/stream", nil)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	p.connected.Store(true)
+	log.Printf("[peer-%d] streaming (server instance %s)", p.id, resp.Header.Get("X-Server-Instance"))
+
+	var hdr [frameHeaderLen]byte
+	var last time.Time
+	expect := uint64(0)
+	for {
+		if _, err := io.ReadFull(resp.Body, hdr[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(hdr[0:4])
+		if n < frameHeaderLen-4 {
+			return fmt.Errorf("bad frame length %d", n)
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, int64(n)-(frameHeaderLen-4)); err != nil {
+			return err
+		}
+		now := time.Now()
+		seq := binary.BigEndian.Uint64(hdr[4:12])
+		sentNs := int64(binary.BigEndian.Uint64(hdr[12:20]))
+		p.bytesRecv.Add(uint64(n) + 4)
+		p.frames.Add(1)
+		if seq > expect {
+			p.missed.Add(seq - expect)
+		}
+		expect = seq + 1
+		p.mu.Lock()
+		if !last.IsZero() {
+			p.maxFreeze = max(p.maxFreeze, now.Sub(last))
+		}
+		p.owdSum += float64(now.UnixNano()-sentNs) / 1e6
+		p.owdN++
+		p.mu.Unlock()
+		if gap := now.Sub(last); !last.IsZero() && *gapEvent > 0 && gap >= *gapEvent {
+			bus.Publish("first_packet_after_gap", map[string]any{
+				"peer":          p.id,
+				"transport":     "h3",
+				"gap_ms":        float64(gap) / 1e6,
+				"gap_start_ns":  last.UnixNano(),
+				"first_recv_ns": now.UnixNano(),
+				"first_sent_ns": sentNs,
+			})
+		}
+		last = now
+	}
+}
+
+// pingLoop times POST /ping on the peer's QUIC connection.
+func (p *peer) pingLoop(ctx context.Context) {
+	t := time.NewTicker(time.Duration(*pingMs) * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !p.connected.Load() {
+			continue
+		}
+		start := time.Now()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, *serverURL+"/ping", bytes.NewReader([]byte("ping")))
+		resp, err := p.client.Do(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		rtt := float64(time.Since(start)) / 1e6
+		if rtt > *rttCapMs {
+			continue
+		}
+		p.mu.Lock()
+		if p.lastRtt > 0 {
+			p.jitterSum += math.Abs(rtt - p.lastRtt)
+			p.jitterN++
+		}
+		p.lastRtt = rtt
+		p.rttSamples = append(p.rttSamples, rtt)
+		p.mu.Unlock()
+	}
+}
+
+// aggregatedMetrics is the subset of cmd/loadgen's /metrics that applies
+// to the HTTP/3 stream, with the same JSON names.
+type aggregatedMetrics struct {
+	TimestampNs      int64   `json:"timestamp_unix_nano"` // when the snapshot was taken
+	ConnectedClients int     `json:"connected_clients"`
+	TotalClients     int     `json:"total_clients"`
+	AvgRttMs         float64 `json:"avg_rtt_ms"`
+	P50RttMs         float64 `json:"p50_rtt_ms"`
+	P95RttMs         float64 `json:"p95_rtt_ms"`
+	P99RttMs         float64 `json:"p99_rtt_ms"`
+	MaxRttMs         float64 `json:"max_rtt_ms"`
+	JitterMs         float64 `json:"jitter_ms"`
+	BytesReceived    uint64  `json:"bytes_received"`
+	ConnectionDrops  int64   `json:"connection_drops"`
+	FramesReceived   uint64  `json:"frames_received"`
+	FramesMissed     uint64  `json:"frames_missed"`
+	MaxFreezeMs      float64 `json:"max_freeze_ms"`
+	OwdAvgMs         float64 `json:"owd_avg_ms"`
+	Transport        string  `json:"transport"`
+}
+
+// computeMetrics returns the metrics since the last reset; /metrics resets
+// the RTT, jitter, freeze and delay accumulators, the stdout report does
+// not, so it does not take samples away from the collector.
+func computeMetrics(peers []*peer, reset bool) aggregatedMetrics {
+	m := aggregatedMetrics{TimestampNs: time.Now().UnixNano(), TotalClients: len(peers), Transport: "h3"}
+	var all []float64
+	var jitter, owd float64
+	var jitterN, owdN int
+	for _, p := range peers {
+		if p.connected.Load() {
+			m.ConnectedClients++
+		}
+		m.BytesReceived += p.bytesRecv.Load()
+		m.FramesReceived += p.frames.Load()
+		m.FramesMissed += p.missed.Load()
+		m.ConnectionDrops += p.drops.Load()
+		p.mu.Lock()
+		all = append(all, p.rttSamples...)
+		jitter += p.jitterSum
+		jitterN += p.jitterN
+		owd += p.owdSum
+		owdN += p.owdN
+		m.MaxFreezeMs = max(m.MaxFreezeMs, float64(p.maxFreeze)/1e6)
+		if reset {
+			p.rttSamples = p.rttSamples[:0]
+			p.jitterSum, p.jitterN, p.owdSum, p.owdN, p.maxFreeze = 0, 0, 0, 0, 0
+		}
+		p.mu.Unlock()
+	}
+	if jitterN > 0 {
+		m.JitterMs = jitter / float64(jitterN)
+	}
+	if owdN > 0 {
+		m.OwdAvgMs = owd / float64(owdN)
+	}
+	if len(all) > 0 {
+		sort.Float64s(all)
+		var sum float64
+		for _, v := range all {
+			sum += v
+		}
+		m.AvgRttMs = sum / float64(len(all))
+		m.P50RttMs = percentile(all, 50)
+		m.P95RttMs = percentile(all, 95)
+		m.P99RttMs = percentile(all, 99)
+		m.MaxRttMs = all[len(all)-1]
+	}
+	return m
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := (p / 100.0) * float64(len(sorted)-1)
+	lower := int(math.Floor(idx))
+	upper := int(math.Ceil(idx))
+	if lower == upper || upper >= len(sorted) {
+		return sorted[lower]
+	}
+	frac := idx - float64(lower)
+	return sorted[lower]*(1-frac) + sorted[upper]*frac
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.Printf("HTTP/3 load generator: server=%s connections=%d ping=%dms interval=%s",
+		*serverURL, *numConns, *pingMs, *reportIval)
+
+	if *eventBusAddr != "" {
+		var err error
+		if bus, err = eventbus.Dial(*eventBusAddr, "h3loadgen"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+		defer bus.Close(time.Second)
+		bus.Publish("loadgen_started", map[string]any{"server": *serverURL, "connections": *numConns, "transport": "h3"})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() { <-sigCh; log.Println("Shutting down..."); cancel() }()
+	if *testDur > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *testDur)
+		defer cancel()
+	}
+
+	peers := make([]*peer, *numConns)
+	for i := range peers {
+		peers[i] = newPeer(i)
+	}
+	go func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(computeMetrics(peers, true))
+		})
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"ok"}`)
+		})
+		addr := fmt.Sprintf(":%d", *metricsPort)
+		log.Printf("Metrics endpoint on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(2)
+		go func() { defer wg.Done(); p.run(ctx) }()
+		go func() { defer wg.Done(); p.pingLoop(ctx) }()
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	ticker := time.NewTicker(*reportIval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			for _, p := range peers {
+				p.tr.Close()
+			}
+			log.Printf("Load generator finished")
+			return
+		case <-ticker.C:
+			enc.Encode(computeMetrics(peers, false))
+		}
+	}
+}
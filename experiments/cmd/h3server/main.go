@@ -0,0 +1,343 @@
+// Command h3server is the HTTP/3 (QUIC) counterpart of cmd/server for
+// comparing QUIC against the WebSocket stream across a migration. Each GET
+// /stream is one client: it receives length-prefixed synthetic frames at
+// -fps until it goes away. /metrics (on TCP, like cmd/server's) uses the
+// same JSON field names, so the collector and analysis tools read both.
+//
+// It runs in the "h3" container next to stream-server and migrates the
+// same way; cmd/h3loadgen is the client.
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+)
+
+var (
+	listenAddr   = flag.String("addr", ":8443", "UDP address for HTTP/3 (/stream, /ping, /health)")
+	metricsAddr  = flag.String("metrics-addr", ":8081", "TCP address for /metrics")
+	dataFPS      = flag.Int("fps", 30, "Frames per second sent to each client")
+	frameSize    = flag.Int("frame-size", 512, "Padding bytes per frame")
+	targetBps    = flag.Int("target-bitrate", 0, "Per-client bitrate in bits/s; overrides -frame-size (0 = use -frame-size)")
+	idleTimeout  = flag.Duration("idle-timeout", 30*time.Second, "QUIC idle timeout; must outlast the checkpoint/restore freeze")
+	tlsCert      = flag.String("tls-cert", "", "TLS certificate (default: generate a self-signed one)")
+	tlsKey       = flag.String("tls-key", "", "TLS key")
+	eventBusAddr = flag.String("event-bus", "", "Publish client events to the collector's event bus at this address (host:port)")
+)
+
+// Frame on /stream: u32 length of the rest, u64 sequence number, u64 send
+// time in Unix nanoseconds, padding. Mirrored in cmd/h3loadgen.
+const frameHeaderLen = 20
+
+// connKey carries the *quic.Conn into handlers (http3.Server.ConnContext).
+type connKey struct{}
+
+type server struct {
+	startTime  time.Time
+	instanceID string
+	bus        *eventbus.Client
+
+	nextID       atomic.Uint64
+	totalClients atomic.Int64
+	bytesSent    atomic.Uint64
+	bytesRecv    atomic.Uint64
+
+	mu      sync.Mutex
+	clients map[uint64]*quic.Conn // by /stream client ID
+	conns   map[*quic.Conn]struct{}
+	// Counters of QUIC connections that are gone, so wire_bytes_sent does
+	// not drop when a client leaves.
+	retiredWire uint64
+	retiredLost uint64
+	setupCount  uint64
+	setupLastMs float64
+
+	lastCPU        time.Duration
+	lastCPUAt      time.Time
+	lastCPUPercent float64
+}
+
+func (s *server) trackConn(c *quic.Conn) {
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		<-c.Context().Done()
+		st := c.ConnectionStats()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.retiredWire += st.BytesSent
+		s.retiredLost += st.PacketsLost
+		s.mu.Unlock()
+	}()
+}
+
+func (s *server) handleStream(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	qc, _ := r.Context().Value(connKey{}).(*quic.Conn)
+	fl, ok := w.(http.Flusher)
+	if !ok || qc == nil {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	id := s.nextID.Add(1)
+	s.totalClients.Add(1)
+	s.mu.Lock()
+	s.clients[id] = qc
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, id)
+		s.mu.Unlock()
+	}()
+	log.Printf("[client-%d] connected from %s", id, qc.RemoteAddr())
+	s.bus.Publish("peer_connected", map[string]any{"peer": id, "remote": qc.RemoteAddr().String(), "transport": "h3"})
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Server-Instance", s.instanceID)
+	w.WriteHeader(http.StatusOK)
+
+	size := *frameSize
+	if *targetBps > 0 {
+		size = max(*targetBps/8 / *dataFPS - frameHeaderLen, 0)
+	}
+	buf := make([]byte, frameHeaderLen+size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(buf)-4))
+	t := time.NewTicker(time.Second / time.Duration(*dataFPS))
+	defer t.Stop()
+	var sent uint64
+loop:
+	for seq := uint64(0); ; seq++ {
+		binary.BigEndian.PutUint64(buf[4:12], seq)
+		binary.BigEndian.PutUint64(buf[12:20], uint64(time.Now().UnixNano()))
+		if _, err := w.Write(buf); err != nil {
+			break
+		}
+		fl.Flush()
+		sent += uint64(len(buf))
+		s.bytesSent.Add(uint64(len(buf)))
+		if seq == 0 {
+			ms := float64(time.Since(start)) / 1e6
+			s.mu.Lock()
+			s.setupCount++
+			s.setupLastMs = ms
+			s.mu.Unlock()
+		}
+		select {
+		case <-r.Context().Done():
+			break loop
+		case <-t.C:
+		}
+	}
+	log.Printf("[client-%d] disconnected after %s, sent %d B", id, time.Since(start).Round(time.Millisecond), sent)
+	s.bus.Publish("peer_disconnected", map[string]any{"peer": id, "duration_s": time.Since(start).Seconds(), "bytes_sent": sent})
+}
+
+// handlePing answers the loadgen's RTT probes; the request body (if any)
+// is echoed back.
+func (s *server) handlePing(w http.ResponseWriter, r *http.Request) {
+	var b [64]byte
+	n, _ := r.Body.Read(b[:])
+	s.bytesRecv.Add(uint64(n))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(b[:n])
+}
+
+type latencySummary struct {
+	Count  uint64  `json:"count"`
+	LastMs float64 `json:"last_ms"`
+}
+
+// metricsResponse keeps cmd/server's field names for what both servers
+// have. Wire bytes are QUIC payload incl. retransmissions, the closest
+// thing to cmd/server's TCP_INFO counters.
+type metricsResponse struct {
+	TimestampNs      int64          `json:"timestamp_unix_nano"` // when the snapshot was taken
+	ConnectedClients int            `json:"connected_clients"`
+	TotalClients     int64          `json:"total_clients"`
+	UptimeSeconds    float64        `json:"uptime_seconds"`
+	BytesSent        uint64         `json:"bytes_sent"`
+	BytesReceived    uint64         `json:"bytes_received"`
+	CPUPercent       float64        `json:"cpu_percent"`
+	MemoryMB         float64        `json:"memory_mb"`
+	ServerInstance   string         `json:"server_instance"`
+	SessionsResumed  uint64         `json:"sessions_resumed"`
+	SessionSetup     latencySummary `json:"session_setup"`
+	WireBytesSent    uint64         `json:"wire_bytes_sent"`
+	QUICPacketsLost  uint64         `json:"quic_packets_lost"`
+	QUICSmoothedRtt  float64        `json:"quic_srtt_ms"`
+	Transport        string         `json:"transport"`
+}
+
+func (s *server) metricsSnapshot() metricsResponse {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wire, lost := s.retiredWire, s.retiredLost
+	var srtt time.Duration
+	for c := range s.conns {
+		st := c.ConnectionStats()
+		wire += st.BytesSent
+		lost += st.PacketsLost
+		srtt = max(srtt, st.SmoothedRTT)
+	}
+	return metricsResponse{
+		TimestampNs:      time.Now().UnixNano(),
+		ConnectedClients: len(s.clients),
+		TotalClients:     s.totalClients.Load(),
+		UptimeSeconds:    time.Since(s.startTime).Seconds(),
+		BytesSent:        s.bytesSent.Load(),
+		BytesReceived:    s.bytesRecv.Load(),
+		CPUPercent:       s.cpuPercent(),
+		MemoryMB:         float64(m.Sys) / 1024 / 1024,
+		ServerInstance:   s.instanceID,
+		SessionSetup:     latencySummary{Count: s.setupCount, LastMs: s.setupLastMs},
+		WireBytesSent:    wire,
+		QUICPacketsLost:  lost,
+		QUICSmoothedRtt:  float64(srtt) / 1e6,
+		Transport:        "h3",
+	}
+}
+
+// cpuPercent is the process CPU use since the previous call; s.mu is held.
+func (s *server) cpuPercent() float64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return s.lastCPUPercent
+	}
+	used := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	now := time.Now()
+	if dt := now.Sub(s.lastCPUAt); !s.lastCPUAt.IsZero() && dt > 0 && used >= s.lastCPU {
+		s.lastCPUPercent = float64(used-s.lastCPU) / float64(dt) * 100
+	}
+	s.lastCPU, s.lastCPUAt = used, now
+	return s.lastCPUPercent
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *dataFPS <= 0 {
+		log.Fatal("-fps must be positive")
+	}
+	var idb [8]byte
+	rand.Read(idb[:])
+	s := &server{
+		startTime:  time.Now(),
+		instanceID: hex.EncodeToString(idb[:]),
+		clients:    map[uint64]*quic.Conn{},
+		conns:      map[*quic.Conn]struct{}{},
+	}
+	if *eventBusAddr != "" {
+		var err error
+		if s.bus, err = eventbus.Dial(*eventBusAddr, "h3server"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+		defer s.bus.Close(time.Second)
+	}
+
+	cert, err := loadOrGenerateCert(*tlsCert, *tlsKey)
+	if err != nil {
+		log.Fatalf("-tls-cert: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", s.handleStream)
+	mux.HandleFunc("/ping", s.handlePing)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+	h3 := &http3.Server{
+		Addr:      *listenAddr,
+		Handler:   mux,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		QUICConfig: &quic.Config{
+			MaxIdleTimeout:  *idleTimeout,
+			KeepAlivePeriod: time.Second,
+		},
+		ConnContext: func(ctx context.Context, c *quic.Conn) context.Context {
+			s.trackConn(c)
+			return context.WithValue(ctx, connKey{}, c)
+		},
+	}
+	pc, err := net.ListenPacket("udp", *listenAddr)
+	if err != nil {
+		log.Fatalf("-addr: %v", err)
+	}
+	go func() {
+		if err := h3.Serve(pc); err != nil {
+			log.Printf("HTTP/3 server: %v", err)
+		}
+	}()
+
+	metricsMux := http.NewServeMux()
+	metricsMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.metricsSnapshot())
+	})
+	metricsLn, err := net.Listen("tcp", *metricsAddr)
+	if err != nil {
+		log.Fatalf("-metrics-addr: %v", err)
+	}
+	go http.Serve(metricsLn, metricsMux)
+	log.Printf("HTTP/3 stream server on udp %s (instance %s), metrics on %s", pc.LocalAddr(), s.instanceID, metricsLn.Addr())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	log.Println("Shutting down...")
+	h3.Close()
+	pc.Close()
+}
+
+// loadOrGenerateCert loads -tls-cert/-tls-key, or makes a throwaway
+// self-signed certificate when neither is set.
+func loadOrGenerateCert(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "h3server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,297 @@
+// Command heartbeat measures downtime across the migrated path with
+// 10 ms UDP heartbeats. The echo side runs in the server container (it
+// migrates with it); the client side runs where the loadgen does:
+//
+//	podman exec -d stream-server ./heartbeat -mode echo -listen :9100
+//	heartbeat -mode client -target 192.168.12.2:9100 -output gaps.jsonl
+//
+// The client sends a timestamped datagram every -interval and the echo
+// side returns it with its own receive time. Every stretch longer than
+// -gap without a datagram is written as one JSON line with its start and
+// end: on the client for the round trip, on the echo side for the uplink.
+// The round-trip gaps are the downtime number; uplink gaps tell which
+// direction broke first.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/netns"
+)
+
+var (
+	mode     = flag.String("mode", "client", "client or echo")
+	listen   = flag.String("listen", ":9100", "UDP address the echo side listens on")
+	target   = flag.String("target", "192.168.12.2:9100", "Echo side address (client)")
+	bindAddr = flag.String("bind", "", "Local address the client sends from (empty = any)")
+	nsSpec   = flag.String("netns", "", "Open the socket in this network namespace (PID, path or ip-netns name) instead of running under nsenter")
+	interval = flag.Duration("interval", 10*time.Millisecond, "Heartbeat interval (client)")
+	gapMin   = flag.Duration("gap", 0, "Log silences longer than this as gaps (default 3x -interval)")
+	output   = flag.String("output", "", "Append gap and summary JSON lines to this file (default stdout)")
+	duration = flag.Duration("duration", 0, "Stop after this long (0 = until interrupted)")
+)
+
+// Wire format: magic, sequence number, client send time; the echo adds
+// its receive time. Times are Unix nanoseconds.
+const (
+	magic      = "P4HB"
+	requestLen = 20
+	replyLen   = 28
+)
+
+// gap is one silence, in the JSON lines output.
+type gap struct {
+	Type       string  `json:"type"` // "gap"
+	Direction  string  `json:"direction"`
+	Peer       string  `json:"peer,omitempty"`
+	StartNs    int64   `json:"start_unix_nano"`
+	EndNs      int64   `json:"end_unix_nano"`
+	DurationMs float64 `json:"duration_ms"`
+	Lost       uint64  `json:"lost"`
+	// Open marks a gap still in progress at exit.
+	Open bool `json:"open,omitempty"`
+}
+
+type summary struct {
+	Type       string  `json:"type"` // "summary"
+	Direction  string  `json:"direction"`
+	Peer       string  `json:"peer,omitempty"`
+	Sent       uint64  `json:"sent,omitempty"`
+	Received   uint64  `json:"received"`
+	Gaps       int     `json:"gaps"`
+	DowntimeMs float64 `json:"downtime_ms"`
+	MaxGapMs   float64 `json:"max_gap_ms"`
+	RttMinMs   float64 `json:"rtt_min_ms,omitempty"`
+	RttMaxMs   float64 `json:"rtt_max_ms,omitempty"`
+}
+
+// tracker turns arrival times into gaps.
+type tracker struct {
+	direction string
+	peer      string
+	last      time.Time
+	lastSeq   uint64
+	received  uint64
+	sum       summary
+}
+
+func (t *tracker) observe(seq uint64, now time.Time) *gap {
+	t.received++
+	defer func() { t.last, t.lastSeq = now, max(t.lastSeq, seq) }()
+	if t.last.IsZero() || seq <= t.lastSeq {
+		return nil
+	}
+	d := now.Sub(t.last)
+	if d < *gapMin {
+		return nil
+	}
+	return t.record(now, seq-t.lastSeq-1, false)
+}
+
+func (t *tracker) record(end time.Time, lost uint64, open bool) *gap {
+	ms := float64(end.Sub(t.last)) / float64(time.Millisecond)
+	t.sum.Gaps++
+	t.sum.DowntimeMs += ms
+	t.sum.MaxGapMs = max(t.sum.MaxGapMs, ms)
+	return &gap{
+		Type: "gap", Direction: t.direction, Peer: t.peer,
+		StartNs: t.last.UnixNano(), EndNs: end.UnixNano(),
+		DurationMs: ms, Lost: lost, Open: open,
+	}
+}
+
+// close reports a silence still open at exit.
+func (t *tracker) close(now time.Time) *gap {
+	if t.last.IsZero() || now.Sub(t.last) < *gapMin {
+		return nil
+	}
+	return t.record(now, 0, true)
+}
+
+// out serializes JSON lines from the receive loop and main.
+type out struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (o *out) write(v any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enc.Encode(v)
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *gapMin == 0 {
+		*gapMin = 3 * *interval
+	}
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("-output: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	o := &out{enc: json.NewEncoder(w)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() { <-sigCh; cancel() }()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	switch *mode {
+	case "client":
+		runClient(ctx, o)
+	case "echo":
+		runEcho(ctx, o)
+	default:
+		log.Fatalf("-mode must be client or echo, got %q", *mode)
+	}
+}
+
+func runClient(ctx context.Context, o *out) {
+	raddr, err := net.ResolveUDPAddr("udp", *target)
+	if err != nil {
+		log.Fatalf("-target: %v", err)
+	}
+	var laddr *net.UDPAddr
+	if *bindAddr != "" {
+		if laddr, err = net.ResolveUDPAddr("udp", *bindAddr); err != nil {
+			log.Fatalf("-bind: %v", err)
+		}
+	}
+	conn, err := netns.DialUDP(nsPath(), laddr, raddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("heartbeat: %s -> %s every %s, gaps over %s", conn.LocalAddr(), raddr, *interval, *gapMin)
+
+	var mu sync.Mutex
+	t := &tracker{direction: "round_trip"}
+	t.sum = summary{Type: "summary", Direction: t.direction}
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// ECONNREFUSED while the echo side is gone.
+				continue
+			}
+			now := time.Now()
+			if n < replyLen || string(buf[:4]) != magic {
+				continue
+			}
+			seq := binary.BigEndian.Uint64(buf[4:12])
+			rtt := float64(now.UnixNano()-int64(binary.BigEndian.Uint64(buf[12:20]))) / 1e6
+			mu.Lock()
+			if t.sum.RttMinMs == 0 || rtt < t.sum.RttMinMs {
+				t.sum.RttMinMs = rtt
+			}
+			t.sum.RttMaxMs = max(t.sum.RttMaxMs, rtt)
+			g := t.observe(seq, now)
+			mu.Unlock()
+			if g != nil {
+				log.Printf("Gap of %.1f ms (%d lost)", g.DurationMs, g.Lost)
+				o.write(g)
+			}
+		}
+	}()
+
+	buf := make([]byte, requestLen)
+	copy(buf, magic)
+	tick := time.NewTicker(*interval)
+	defer tick.Stop()
+	var seq uint64
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			mu.Lock()
+			if g := t.close(time.Now()); g != nil {
+				o.write(g)
+			}
+			t.sum.Sent, t.sum.Received = seq, t.received
+			o.write(t.sum)
+			mu.Unlock()
+			log.Printf("heartbeat: %d sent, %d echoed, %d gaps, %.1f ms down, longest %.1f ms",
+				t.sum.Sent, t.sum.Received, t.sum.Gaps, t.sum.DowntimeMs, t.sum.MaxGapMs)
+			return
+		case now := <-tick.C:
+			seq++
+			binary.BigEndian.PutUint64(buf[4:12], seq)
+			binary.BigEndian.PutUint64(buf[12:20], uint64(now.UnixNano()))
+			conn.Write(buf)
+		}
+	}
+}
+
+func runEcho(ctx context.Context, o *out) {
+	pc, err := netns.ListenPacket(nsPath(), "udp", *listen)
+	if err != nil {
+		log.Fatalf("-listen: %v", err)
+	}
+	log.Printf("heartbeat echo on %s, gaps over %s", pc.LocalAddr(), *gapMin)
+	go func() { <-ctx.Done(); pc.Close() }()
+
+	peers := map[string]*tracker{}
+	buf := make([]byte, 64)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		now := time.Now()
+		if n < requestLen || string(buf[:4]) != magic {
+			continue
+		}
+		binary.BigEndian.PutUint64(buf[20:28], uint64(now.UnixNano()))
+		pc.WriteTo(buf[:replyLen], from)
+
+		t := peers[from.String()]
+		if t == nil {
+			t = &tracker{direction: "uplink", peer: from.String()}
+			t.sum = summary{Type: "summary", Direction: t.direction, Peer: t.peer}
+			peers[from.String()] = t
+			log.Printf("New heartbeat client %s", from)
+		}
+		if g := t.observe(binary.BigEndian.Uint64(buf[4:12]), now); g != nil {
+			log.Printf("Uplink gap from %s of %.1f ms (%d lost)", from, g.DurationMs, g.Lost)
+			o.write(g)
+		}
+	}
+	for _, t := range peers {
+		if g := t.close(time.Now()); g != nil {
+			o.write(g)
+		}
+		t.sum.Received = t.received
+		o.write(t.sum)
+	}
+}
+
+func nsPath() string {
+	if *nsSpec == "" {
+		return ""
+	}
+	return netns.Path(*nsSpec)
+}
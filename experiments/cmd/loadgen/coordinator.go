@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Coordinator mode: one loadgen opens no connections itself and drives a set
+// of worker loadgens on other hosts over gRPC. Each worker serves the
+// loadgen.Worker service on -worker-addr; the coordinator calls Metrics on
+// every worker each interval, serves the merged result on its own /metrics
+// (so the collector needs no changes), and calls Shutdown on each when the
+// run ends. Every call carries the shared -worker-token.
+//
+// As in internal/eventbus there is no .proto: the messages are JSON over
+// gRPC with a forced codec.
+
+const (
+	workerMetricsMethod  = "/loadgen.Worker/Metrics"
+	workerShutdownMethod = "/loadgen.Worker/Shutdown"
+)
+
+// workerCallTimeout bounds one call to a worker, so a dead worker cannot
+// hold up an interval.
+const workerCallTimeout = 2 * time.Second
+
+// workerEmpty is the request of both methods and Shutdown's reply.
+type workerEmpty struct{}
+
+// workerCodec marshals the worker messages as JSON. Its name stays
+// "proto" so the default content-type works, as with eventbus.
+type workerCodec struct{}
+
+func (workerCodec) Name() string { return "proto" }
+
+func (workerCodec) Marshal(v any) ([]byte, error) {
+	switch v.(type) {
+	case *workerEmpty, *aggregatedMetrics:
+		return json.Marshal(v)
+	}
+	return nil, fmt.Errorf("worker: cannot marshal %T", v)
+}
+
+func (workerCodec) Unmarshal(data []byte, v any) error {
+	switch v.(type) {
+	case *workerEmpty, *aggregatedMetrics:
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("worker: cannot unmarshal into %T", v)
+}
+
+// workerService is the handler type of the service description; the
+// worker's cancel func is one.
+type workerService interface {
+	shutdown()
+}
+
+type workerServer struct{ cancel context.CancelFunc }
+
+func (w workerServer) shutdown() {
+	log.Println("Shutdown requested by the coordinator")
+	w.cancel()
+}
+
+// checkWorkerToken accepts a call carrying "authorization: Bearer
+// <-worker-token>" metadata.
+func checkWorkerToken(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var got string
+	if v := md.Get("authorization"); len(v) > 0 {
+		got, _ = strings.CutPrefix(v[0], "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(*workerToken)) != 1 {
+		return status.Error(codes.Unauthenticated, "missing or wrong worker token")
+	}
+	return nil
+}
+
+var workerServiceDesc = grpc.ServiceDesc{
+	ServiceName: "loadgen.Worker",
+	HandlerType: (*workerService)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Metrics",
+		Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			if err := dec(new(workerEmpty)); err != nil {
+				return nil, err
+			}
+			if err := checkWorkerToken(ctx); err != nil {
+				return nil, err
+			}
+			m := computeMetrics()
+			return &m, nil
+		},
+	}, {
+		MethodName: "Shutdown",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			if err := dec(new(workerEmpty)); err != nil {
+				return nil, err
+			}
+			if err := checkWorkerToken(ctx); err != nil {
+				return nil, err
+			}
+			srv.(workerService).shutdown()
+			return &workerEmpty{}, nil
+		},
+	}},
+}
+
+// serveWorker serves the worker service on addr until ctx ends. Shutdown
+// ends the run through cancel. stopped is closed once the calls in flight,
+// such as that Shutdown, have been answered; the run waits for it before
+// exiting so the coordinator gets its reply.
+func serveWorker(ctx context.Context, addr string, cancel context.CancelFunc) (stopped <-chan struct{}, err error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(workerCodec{}))
+	srv.RegisterService(&workerServiceDesc, workerServer{cancel: cancel})
+	done := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+		close(done)
+	}()
+	go srv.Serve(lis)
+	log.Printf("Worker service on %s", addr)
+	return done, nil
+}
+
+// workerConn is the coordinator's connection to one worker.
+type workerConn struct {
+	addr string
+	conn *grpc.ClientConn
+}
+
+// dialWorkers prepares a connection per worker address. Connections are
+// made lazily and retried, so workers may come up after the coordinator.
+func dialWorkers(addrs []string) ([]workerConn, error) {
+	out := make([]workerConn, 0, len(addrs))
+	for _, a := range addrs {
+		conn, err := grpc.NewClient(a,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(workerCodec{})))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a, err)
+		}
+		out = append(out, workerConn{addr: a, conn: conn})
+	}
+	return out, nil
+}
+
+func (w workerConn) call(method string, reply any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), workerCallTimeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*workerToken)
+	return w.conn.Invoke(ctx, method, &workerEmpty{}, reply)
+}
+
+type workerSample struct {
+	Worker             string            `json:"worker"`
+	TimestampUnixMilli int64             `json:"timestamp_unix_milli"`
+	OK                 bool              `json:"ok"`
+	Metrics            aggregatedMetrics `json:"metrics"`
+}
+
+// mergeMetrics combines per-worker aggregates. Counters are summed; average
+// RTT, jitter and one-way delay are weighted by each worker's connected
+// clients. Percentiles cannot be merged exactly from summaries, so the worst
+// worker's value is reported (an upper bound).
+func mergeMetrics(samples []workerSample) aggregatedMetrics {
+	m := aggregatedMetrics{TimestampNs: time.Now().UnixNano()}
+	var rttWeight, jitterWeight, owdWeight float64
+	for _, s := range samples {
+		if !s.OK {
+			continue
+		}
+		w := s.Metrics
+		m.ConnectedClients += w.ConnectedClients
+		m.TotalClients += w.TotalClients
+		m.BytesSent += w.BytesSent
+		m.BytesReceived += w.BytesReceived
+		m.ConnectionDrops += w.ConnectionDrops
+		m.InstanceChanges += w.InstanceChanges
+		m.FramesReceived += w.FramesReceived
+		m.KeyframesReceived += w.KeyframesReceived
+		m.FramesMissed += w.FramesMissed
+		m.FramesUndecodable += w.FramesUndecodable
+		m.PLISent += w.PLISent
+		m.SessionsResumed += w.SessionsResumed
+		m.ClientDrops += w.ClientDrops
+		m.RxQueueMaxBytes = max(m.RxQueueMaxBytes, w.RxQueueMaxBytes)
+		m.ReoffersAccepted += w.ReoffersAccepted
+		m.ReofferMaxMs = max(m.ReofferMaxMs, w.ReofferMaxMs)
+		m.StallRedials += w.StallRedials
+		m.RetryAfterWaits += w.RetryAfterWaits
+		for name, p := range w.SignalProbes {
+			if m.SignalProbes == nil {
+				m.SignalProbes = map[string]probeMetrics{}
+			}
+			// Worst worker per transport, like the percentiles below.
+			q, seen := m.SignalProbes[name]
+			q.Up = p.Up && (q.Up || !seen)
+			q.Failures += p.Failures
+			q.OutageMs = max(q.OutageMs, p.OutageMs)
+			q.RttMs = max(q.RttMs, p.RttMs)
+			m.SignalProbes[name] = q
+		}
+		m.MaxFreezeMs = max(m.MaxFreezeMs, w.MaxFreezeMs)
+		m.AudioFrames += w.AudioFrames
+		m.AudioMaxFreezeMs = max(m.AudioMaxFreezeMs, w.AudioMaxFreezeMs)
+		m.P50RttMs = max(m.P50RttMs, w.P50RttMs)
+		m.P95RttMs = max(m.P95RttMs, w.P95RttMs)
+		m.P99RttMs = max(m.P99RttMs, w.P99RttMs)
+		m.MaxRttMs = max(m.MaxRttMs, w.MaxRttMs)
+		m.OwdMaxMs = max(m.OwdMaxMs, w.OwdMaxMs)
+		if w.OwdAvgMs > 0 {
+			weight := float64(max(w.ConnectedClients, 1))
+			m.OwdAvgMs += w.OwdAvgMs * weight
+			owdWeight += weight
+		}
+		if w.AvgRttMs > 0 {
+			weight := float64(max(w.ConnectedClients, 1))
+			m.AvgRttMs += w.AvgRttMs * weight
+			rttWeight += weight
+		}
+		if w.JitterMs > 0 {
+			weight := float64(max(w.ConnectedClients, 1))
+			m.JitterMs += w.JitterMs * weight
+			jitterWeight += weight
+		}
+	}
+	if rttWeight > 0 {
+		m.AvgRttMs /= rttWeight
+	}
+	if jitterWeight > 0 {
+		m.JitterMs /= jitterWeight
+	}
+	if owdWeight > 0 {
+		m.OwdAvgMs /= owdWeight
+	}
+	// Groups merge the same way, over the workers that have them.
+	byGroup := map[string][]workerSample{}
+	for _, s := range samples {
+		if !s.OK {
+			continue
+		}
+		for name, g := range s.Metrics.Groups {
+			byGroup[name] = append(byGroup[name], workerSample{OK: true, Metrics: g})
+		}
+	}
+	for name, gs := range byGroup {
+		if m.Groups == nil {
+			m.Groups = map[string]aggregatedMetrics{}
+		}
+		m.Groups[name] = mergeMetrics(gs)
+	}
+	return m
+}
+
+// scrapeWorker fetches a worker's metrics. Any failed call, including a
+// refused token, leaves the sample not OK.
+func scrapeWorker(w workerConn) workerSample {
+	s := workerSample{Worker: w.addr, TimestampUnixMilli: time.Now().UnixMilli()}
+	if err := w.call(workerMetricsMethod, &s.Metrics); err != nil {
+		log.Printf("[worker %s] metrics failed: %v", w.addr, err)
+		return s
+	}
+	s.OK = true
+	return s
+}
+
+func shutdownWorkers(workers []workerConn) {
+	for _, w := range workers {
+		if err := w.call(workerShutdownMethod, &workerEmpty{}); err != nil {
+			log.Printf("[worker %s] shutdown failed: %v", w.addr, err)
+		}
+		w.conn.Close()
+	}
+}
+
+// parseWorkers splits "host:9091,host2:9091" into worker addresses.
+func parseWorkers(list string) []string {
+	var out []string
+	for _, w := range strings.Split(list, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func runCoordinator(ctx context.Context, addrs []string) {
+	log.Printf("Coordinator: %d workers (%s)", len(addrs), strings.Join(addrs, ", "))
+	workers, err := dialWorkers(addrs)
+	if err != nil {
+		log.Fatalf("-workers: %v", err)
+	}
+
+	var mu sync.Mutex
+	var latest aggregatedMetrics
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			m := latest
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(m)
+		})
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"ok"}`)
+		})
+		addr := fmt.Sprintf(":%d", *metricsPort)
+		log.Printf("Metrics endpoint on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	ticker := time.NewTicker(*reportIval)
+	defer ticker.Stop()
+
+	var durationCh <-chan time.Time
+	if *testDur > 0 {
+		durationCh = time.After(*testDur)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownWorkers(workers)
+			log.Printf("Coordinator finished")
+			return
+		case <-durationCh:
+			log.Printf("Duration reached, stopping workers")
+			shutdownWorkers(workers)
+			return
+		case <-ticker.C:
+			samples := make([]workerSample, len(workers))
+			var wg sync.WaitGroup
+			for i, w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					samples[i] = scrapeWorker(w)
+				}()
+			}
+			wg.Wait()
+			merged := mergeMetrics(samples)
+			mu.Lock()
+			latest = merged
+			mu.Unlock()
+			for _, s := range samples {
+				enc.Encode(s)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// delayStats estimates one-way server→client delay of data frames. The
+// server clock offset comes from echoes NTP-style: offset = server_ts -
+// (client_send + client_recv)/2, taken from the lowest-RTT echo of each
+// window because queueing inflates the error of slower samples. Frames
+// stamped with the server's send time then give
+// owd = recv - (frame_ts - offset). With -server-clock-offset the offset
+// is fixed instead, e.g. to clockcheck's, which does not assume symmetric
+// paths the way the echo estimate does.
+type delayStats struct {
+	mu         sync.Mutex
+	offset     time.Duration
+	haveOffset bool
+	fixed      bool
+
+	// best echo of the current offset window
+	winRTT    time.Duration
+	winOffset time.Duration
+	winHave   bool
+
+	snap, agg owdWindow
+
+	// lastTs and lastRecv are the newest frame's server timestamp and
+	// local arrival, the LSR of the next receiver report.
+	lastTs, lastRecv int64
+}
+
+type owdWindow struct {
+	sum time.Duration
+	n   int
+	max time.Duration
+}
+
+func (w *owdWindow) add(d time.Duration) {
+	w.sum += d
+	w.n++
+	if d > w.max {
+		w.max = d
+	}
+}
+
+func (w *owdWindow) take() (avg, maxD time.Duration) {
+	if w.n > 0 {
+		avg = w.sum / time.Duration(w.n)
+	}
+	maxD = w.max
+	*w = owdWindow{}
+	return avg, maxD
+}
+
+// observeEcho feeds one echo (all times in UnixNano of the respective clock).
+func (d *delayStats) observeEcho(clientSend, serverTs, clientRecv int64) {
+	rtt := time.Duration(clientRecv - clientSend)
+	off := time.Duration(serverTs - (clientSend+clientRecv)/2)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.winHave || rtt < d.winRTT {
+		d.winRTT, d.winOffset, d.winHave = rtt, off, true
+	}
+	if !d.haveOffset {
+		d.offset, d.haveOffset = off, true
+	}
+}
+
+// fix uses offset (server clock minus this host's) from now on.
+func (d *delayStats) fix(offset time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.offset, d.haveOffset, d.fixed = offset, true, true
+}
+
+// observeFrame feeds one data frame's server send timestamp and returns
+// its OWD, ok=false while there is no clock offset yet.
+func (d *delayStats) observeFrame(serverTs, clientRecv int64) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastTs, d.lastRecv = serverTs, clientRecv
+	if !d.haveOffset {
+		return 0, false
+	}
+	owd := time.Duration(clientRecv-serverTs) + d.offset
+	if owd < 0 {
+		owd = 0
+	}
+	d.snap.add(owd)
+	d.agg.add(owd)
+	return owd, true
+}
+
+// take returns the window's average and max OWD plus the clock offset in
+// use, and rotates the window. The snapshot (stdout) consumer also rolls
+// the offset estimate forward to the best echo seen since the last call.
+func (d *delayStats) take(agg bool) (avg, maxD, offset time.Duration, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if agg {
+		avg, maxD = d.agg.take()
+	} else {
+		avg, maxD = d.snap.take()
+		if d.winHave && !d.fixed {
+			d.offset = d.winOffset
+			d.winHave = false
+		}
+	}
+	return avg, maxD, d.offset, d.haveOffset
+}
+
+// lastFrame returns the newest frame's server timestamp and the time since
+// it arrived, RTCP's LSR and DLSR: the server takes both off its clock to
+// get the round trip. ts is 0 before the first frame.
+func (d *delayStats) lastFrame(now int64) (ts int64, since time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastTs == 0 {
+		return 0, 0
+	}
+	return d.lastTs, time.Duration(now - d.lastRecv)
+}
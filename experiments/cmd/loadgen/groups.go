@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// peerGroup is a set of peers with their own stream settings, so background
+// load and measured peers can share one loadgen. Its name labels the
+// peers' snapshots, /metrics and histograms.
+type peerGroup struct {
+	name     string
+	count    int
+	audio    bool
+	bitrate  int
+	maxRate  int
+	readRate int
+	pingMs   int
+	// rid and tid are used when pinned, else the current layer, which a
+	// -config reload may switch.
+	rid    string
+	tid    int
+	pinned bool
+}
+
+// groups are the -groups groups in peer ID order, or one unnamed group
+// from the flags without it.
+var groups []*peerGroup
+
+// flagGroup is the group of count peers with the flags' settings.
+func flagGroup(name string, count int) *peerGroup {
+	return &peerGroup{
+		name: name, count: count,
+		audio: *withAudio, bitrate: *peerBitrate, maxRate: *peerMaxRate, readRate: *readRate,
+		pingMs: *pingMs, rid: *simulcastRID, tid: *temporalLayer,
+	}
+}
+
+// parseGroups parses -groups: comma-separated name=count entries, each
+// optionally followed by :option=value settings named like the flags they
+// override (audio, bitrate, max-rate, read-rate, ping-interval-ms, rid,
+// tid), e.g. "background=4:bitrate=500000,measured=2:ping-interval-ms=20".
+func parseGroups(s string) ([]*peerGroup, error) {
+	var out []*peerGroup
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		opts := strings.Split(entry, ":")
+		name, count, ok := strings.Cut(opts[0], "=")
+		n, err := strconv.Atoi(count)
+		if !ok || name == "" || err != nil || n < 1 {
+			return nil, fmt.Errorf("%q: want name=count with count >= 1", opts[0])
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("group %s given twice", name)
+		}
+		seen[name] = true
+		g := flagGroup(name, n)
+		for _, o := range opts[1:] {
+			k, v, _ := strings.Cut(o, "=")
+			if err := g.set(k, v); err != nil {
+				return nil, fmt.Errorf("group %s: %s: %w", name, k, err)
+			}
+		}
+		out = append(out, g)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no groups in %q", s)
+	}
+	return out, nil
+}
+
+func (g *peerGroup) set(k, v string) error {
+	if k == "audio" {
+		b, err := strconv.ParseBool(v)
+		g.audio = b
+		return err
+	}
+	if k == "rid" {
+		if v != "f" && v != "h" && v != "q" {
+			return fmt.Errorf("want f, h or q, got %q", v)
+		}
+		g.rid, g.pinned = v, true
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	switch k {
+	case "bitrate":
+		g.bitrate = n
+	case "max-rate":
+		g.maxRate = n
+	case "read-rate":
+		g.readRate = n
+	case "ping-interval-ms":
+		if n < 1 {
+			return fmt.Errorf("must be >= 1")
+		}
+		g.pingMs = n
+	case "tid":
+		g.tid, g.pinned = n, true
+	default:
+		return fmt.Errorf("unknown option")
+	}
+	return nil
+}
+
+// groupOf is the group of peer id. Peers past the groups' total, added by
+// a -config reload, join the last group.
+func groupOf(id int) *peerGroup {
+	for _, g := range groups {
+		if id < g.count {
+			return g
+		}
+		id -= g.count
+	}
+	return groups[len(groups)-1]
+}
+
+// throttled reports whether peer id's read loop is paced to its group's
+// read rate. -slow-peers picks the peers without -groups.
+func (g *peerGroup) throttled(id int) bool {
+	if g.readRate <= 0 {
+		return false
+	}
+	return g.name != "" || *slowPeers == 0 || id < *slowPeers
+}
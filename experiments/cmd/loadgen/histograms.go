@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/histogram"
+)
+
+// histPercentiles are the rows of each -histograms table, spaced towards
+// the tail where a migration's freezes are.
+var histPercentiles = []float64{0, 50, 75, 90, 95, 99, 99.9, 99.99, 100}
+
+// writeHistograms writes the -histograms CSV: a percentile table per
+// metric (video_gap, audio_gap, rtt) for every peer, for each -groups
+// group (peer "all") and for all peers together (group and peer "all"),
+// values in ms. The overall tables are logged too.
+func writeHistograms(path string) error {
+	type table struct {
+		metric, group, peer string
+		h                   *histogram.Histogram
+	}
+	var tables []table
+	// merged holds the per-group and overall tables, keyed by metric and
+	// group.
+	merged := map[[2]string]*histogram.Histogram{}
+	var order [][2]string
+	mergeInto := func(metric, group string, h *histogram.Histogram) {
+		k := [2]string{metric, group}
+		if merged[k] == nil {
+			merged[k] = &histogram.Histogram{}
+			order = append(order, k)
+		}
+		merged[k].Merge(h)
+	}
+	add := func(metric, group, peer string, h *histogram.Histogram) {
+		if h.Count() == 0 {
+			return
+		}
+		c := &histogram.Histogram{}
+		c.Merge(h)
+		tables = append(tables, table{metric, group, peer, c})
+		if group != "" {
+			mergeInto(metric, group, h)
+		}
+		mergeInto(metric, "all", h)
+	}
+	connsMu.RLock()
+	for _, c := range conns {
+		if c == nil {
+			continue
+		}
+		peer, group := strconv.Itoa(c.id), c.group.name
+		c.frames.mu.Lock()
+		add("video_gap", group, peer, &c.frames.gaps)
+		c.frames.mu.Unlock()
+		c.audio.mu.Lock()
+		add("audio_gap", group, peer, &c.audio.gaps)
+		c.audio.mu.Unlock()
+		c.rttMu.Lock()
+		add("rtt", group, peer, &c.rttHist)
+		c.rttMu.Unlock()
+	}
+	connsMu.RUnlock()
+	// The overall tables after the groups'.
+	sort.SliceStable(order, func(i, j int) bool { return order[i][1] != "all" && order[j][1] == "all" })
+	for _, k := range order {
+		h := merged[k]
+		tables = append(tables, table{k[0], k[1], "all", h})
+		log.Printf("%s of %s peers over %d samples: p50 %.1f ms, p99 %.1f ms, p99.9 %.1f ms, max %.1f ms", k[0], k[1], h.Count(),
+			ms(h.ValueAt(50)), ms(h.ValueAt(99)), ms(h.ValueAt(99.9)), ms(h.Max()))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"metric", "group", "peer", "percentile", "value_ms", "samples", "mean_ms"})
+	for _, t := range tables {
+		for _, p := range histPercentiles {
+			w.Write([]string{t.metric, t.group, t.peer, strconv.FormatFloat(p, 'f', -1, 64),
+				fmt.Sprintf("%.3f", ms(t.h.ValueAt(p))), strconv.FormatUint(t.h.Count(), 10),
+				fmt.Sprintf("%.3f", t.h.Mean()/1000)})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ms converts a histogram value in µs.
+func ms(us int64) float64 { return float64(us) / 1000 }
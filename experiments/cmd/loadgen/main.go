@@ -2,52 +2,386 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/histogram"
 )
 
 var (
-	serverURL   = flag.String("server", "http://localhost:8080", "Server base URL")
-	numConns    = flag.Int("connections", 4, "Number of concurrent WebSocket connections")
-	pingMs      = flag.Int("ping-interval-ms", 100, "Ping interval in milliseconds")
-	rttCapMs    = flag.Float64("rtt-cap-ms", 1000, "Discard echo RTTs above this threshold (stale echoes from migration freeze)")
-	reportIval  = flag.Duration("interval", time.Second, "Metrics reporting interval (stdout)")
-	testDur     = flag.Duration("duration", 0, "Test duration (0 = until interrupted)")
-	metricsPort = flag.Int("metrics-port", 9090, "HTTP port for /metrics endpoint")
-	rampUp      = flag.Duration("ramp-up", 200*time.Millisecond, "Delay between connecting each peer")
+	serverURL        = flag.String("server", "http://localhost:8080", "Server base URL")
+	numConns         = flag.Int("connections", 4, "Number of concurrent WebSocket connections")
+	pingMs           = flag.Int("ping-interval-ms", 100, "Ping interval in milliseconds")
+	rttCapMs         = flag.Float64("rtt-cap-ms", 1000, "Discard echo RTTs above this threshold (stale echoes from migration freeze)")
+	reportIval       = flag.Duration("interval", time.Second, "Metrics reporting interval (stdout)")
+	testDur          = flag.Duration("duration", 0, "Test duration (0 = until interrupted)")
+	metricsPort      = flag.Int("metrics-port", 9090, "HTTP port for /metrics endpoint")
+	rampUp           = flag.Duration("ramp-up", 200*time.Millisecond, "Delay between connecting each peer")
+	dscp             = flag.Int("dscp", -1, "DSCP codepoint (0-63) to mark on client sockets (-1 = leave unset)")
+	retryMaxAttempts = flag.Int("retry-max-attempts", 0, "Give up connecting a peer after this many attempts (0 = unlimited)")
+	retryMaxElapsed  = flag.Duration("retry-max-elapsed", 0, "Give up connecting a peer after this much time (0 = unlimited)")
+	retryJitter      = flag.Float64("retry-jitter", 0, "Randomise each backoff by ±this fraction (0-1)")
+	reconnect        = flag.Bool("reconnect", false, "Redial peers whose connection drops (default: rely on the TCP connection surviving migration)")
+	workersList      = flag.String("workers", "", "Coordinator mode: comma-separated worker loadgen gRPC addresses (host:port, their -worker-addr) to drive and aggregate instead of connecting locally")
+	workerAddr       = flag.String("worker-addr", "", "Worker mode: serve a -workers coordinator's gRPC calls (metrics, shutdown) on this address, e.g. :9091 (empty = off)")
+	workerToken      = flag.String("worker-token", "", "Bearer token shared by a coordinator and its workers, required with -workers and -worker-addr (default $LOADGEN_WORKER_TOKEN)")
+	readRate         = flag.Int("read-rate", 0, "Throttle each slow peer's read loop to this many bytes/s to simulate slow receivers (0 = unthrottled)")
+	slowPeers        = flag.Int("slow-peers", 0, "Number of peers (lowest IDs first) throttled by -read-rate (0 = all)")
+	withAudio        = flag.Bool("audio", false, "Request the server's audio stream alongside video")
+	peerBitrate      = flag.Int("bitrate", 0, "Ask the server for this synthetic bitrate (bits/s) on every peer (0 = server default)")
+	simulcastRID     = flag.String("rid", "", "Simulcast layer to request from the server: f, h or q (empty = server default, f)")
+	temporalLayer    = flag.Int("tid", -1, "Highest temporal layer to request (0-2, -1 = all)")
+	feedbackIval     = flag.Duration("feedback-interval", 0, "Send receiver reports (bytes and frames received, frames lost, last frame timestamp) this often, for the server's -adaptive mode and its per-peer RTT and loss (0 = off)")
+	peerMaxRate      = flag.Int("max-rate", 0, "Ask the server to cap each peer's send rate at this many bits/s (0 = server default)")
+	authToken        = flag.String("auth-token", "", "Bearer token for the server's /ws (default $STREAM_AUTH_TOKEN)")
+	pliAfter         = flag.Duration("pli-after", 0, "Request a keyframe (PLI) when the video stream breaks or resumes after a freeze longer than this (0 = never)")
+	configFile       = flag.String("config", "", "JSON file with connections/interval/server, applied at startup and re-read on SIGHUP")
+	ipFamily         = flag.String("ip-family", "", "Dial the server over IPv4 (4) or IPv6 (6) only (empty = the family of -source-subnet, else whatever the name resolves to)")
+	probeIval        = flag.Duration("signal-probe-interval", 0, "Poll the server's /health over TCP (and HTTP/3 with -h3-server) this often and report outages per transport (0 = off)")
+	probeTimeout     = flag.Duration("signal-probe-timeout", time.Second, "Timeout of one signaling probe")
+	h3Server         = flag.String("h3-server", "", "HTTPS base URL of the server's -h3-addr listener for the HTTP/3 probe, e.g. https://192.168.12.2:8443")
+	eventBusAddr     = flag.String("event-bus", "", "Publish events (first_packet_after_gap, connect_attempt_failed, ...) to the collector's event bus at this address (host:port)")
+	pushSnapshots    = flag.Bool("push-snapshots", false, "Also publish each interval's per-peer snapshots to -event-bus as peer_snapshot events")
+	snapStdout       = flag.Bool("stdout", true, "Write each interval's per-peer snapshots to stdout as JSON lines")
+	gapEvent         = flag.Duration("gap-event", 100*time.Millisecond, "Shortest video gap reported as first_packet_after_gap on -event-bus (0 = none)")
+	srcSubnet        = flag.String("source-subnet", "", "Only use a local address in this CIDR (e.g. 192.168.12.0/24) and refuse servers outside it, so traffic always crosses the switch")
+	rcvBuf           = flag.Int("rcvbuf", 0, "SO_RCVBUF of each peer's socket in bytes (0 = kernel default with autotuning)")
+	readBufSize      = flag.Int("read-buffer", 0, "WebSocket read buffer per peer in bytes; a larger one reads more frames per syscall (0 = 4096)")
+	fastRead         = flag.Bool("fast-read", false, "Read each message into a reused per-peer buffer instead of a new allocation, for high peer counts")
+	groupSpec        = flag.String("groups", "", "Comma-separated peer groups as name=count[:option=value...], options audio, bitrate, max-rate, read-rate, ping-interval-ms, rid and tid overriding the flags of the same name; replaces -connections and labels every metric with the group")
+	stallTimeout     = flag.Duration("stall-timeout", 0, "With -reconnect, also redial a peer that received no video frame for this long although its connection looks up; other peers keep theirs (0 = only on errors)")
+	transcriptDir    = flag.String("transcript-dir", "", "Write each peer's signaling (dials with their local address, handshakes, control messages in and out) with timestamps to conn-<id>.jsonl in this directory (empty = off)")
+	acceptReoffer    = flag.Bool("accept-reoffer", false, "Ask the server for reoffers (?reoffer=1) and redial with the session token when one arrives, even without -reconnect, reporting the time from push to first frame")
+	clockOffset      = flag.String("server-clock-offset", "", "Server clock minus this host's (e.g. clockcheck's offset_ms for the server node, as 0.35ms), used for one-way delay instead of the echo estimate (empty = estimate)")
+	owdLogPath       = flag.String("owd-log", "", "Write a CSV row per video frame with its server send timestamp, arrival and one-way delay to this file")
+	histFile         = flag.String("histograms", "", "At exit, write per-peer and overall percentile tables of the inter-frame gaps and echo RTTs to this CSV")
 )
 
+// serverOffset is -server-clock-offset, nil without it.
+var serverOffset *time.Duration
+
+// retry is built from the -retry-* flags in main.
+var retry retryPolicy
+
+// bus is the -event-bus client; nil (discarding) without the flag.
+var bus *eventbus.Client
+
+// sourceAddr is the local address bound for every dial when -source-subnet
+// is set. Without it the kernel may pick the management interface and the
+// data path silently bypasses the Tofino.
+var sourceAddr *net.TCPAddr
+
+// markDSCP sets the DSCP bits of the IP TOS / traffic class byte on the
+// socket so the P4 program can classify experiment traffic.
+func markDSCP(network string, rc syscall.RawConn) error {
+	tos := *dscp << 2
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		if network == "tcp6" {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			return
+		}
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// dialNetwork narrows "tcp" to -ip-family, or to the family of the
+// -source-subnet address so a dual-stack server name resolves to an address
+// the bound socket can reach.
+func dialNetwork(network string) string {
+	switch {
+	case *ipFamily != "":
+		return "tcp" + *ipFamily
+	case sourceAddr != nil && sourceAddr.IP.To4() == nil:
+		return "tcp6"
+	case sourceAddr != nil:
+		return "tcp4"
+	}
+	return network
+}
+
+// resolveSourceAddr returns the first local interface address inside cidr.
+func resolveSourceAddr(cidr string) (*net.TCPAddr, *net.IPNet, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse %q: %w", cidr, err)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("list interface addresses: %w", err)
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if ok && subnet.Contains(ipn.IP) {
+			return &net.TCPAddr{IP: ipn.IP}, subnet, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no local address in %s", cidr)
+}
+
 type conn struct {
-	id  int
-	ws  *websocket.Conn
-	mu  sync.Mutex
-	seq int
+	id     int
+	ws     *websocket.Conn
+	mu     sync.Mutex
+	seq    int
+	cancel context.CancelFunc
+
+	// previous stdout snapshot, only touched by the report loop
+	snapBytes  uint64
+	snapFrames uint64
+	snapTime   time.Time
 
 	bytesRecv atomic.Uint64
 	bytesSent atomic.Uint64
 	msgsRecv  atomic.Uint64
 	msgsSent  atomic.Uint64
 	connected atomic.Bool
+	pliSent   atomic.Uint64
+	// rrBase is bytesRecv at connect time; receiver reports count from
+	// there because the server compares them with what it sent on the
+	// current connection.
+	rrBase   atomic.Uint64
+	instance atomic.Value // string, from instanceHeader
+	session  atomic.Value // string, from sessionHeader
+	resumes  atomic.Uint64
+	layer    atomic.Value // string, simulcast rid of the last frame
+	size     atomic.Value // string, WIDTHxHEIGHT of the last frame
+	path     atomic.Value // connPath of the current connection
+
+	frames frameStats
+	audio  frameStats
+	delay  delayStats
 
 	rttMu      sync.Mutex
 	rttSamples []float64
 	lastRTT    float64
 	jitterSum  float64
 	jitterN    int
+	// rttHist has every echo RTT of the run in µs, for -histograms.
+	rttHist histogram.Histogram
+
+	// readBuf is the -fast-read message buffer, owned by readLoop.
+	readBuf []byte
+
+	group *peerGroup
+	// drops counts this peer's connection drops, for its group's
+	// connection_drops.
+	drops atomic.Int64
+
+	// reofferAt is when the pending server reoffer arrived (UnixNano), 0
+	// when none is pending. The first video frame after the redial clears
+	// it and sets reofferMax if that recovery took longer.
+	reofferAt  atomic.Int64
+	reoffers   atomic.Uint64
+	reofferMax atomic.Int64 // ns
+
+	// connectedAt is when the current connection came up (UnixNano), the
+	// start of a stall before its first frame.
+	connectedAt atomic.Int64
+	stalls      atomic.Uint64
+
+	// retryAfters counts dials refused with a Retry-After and retried
+	// after it.
+	retryAfters atomic.Uint64
+
+	tr *transcript // -transcript-dir, nil without it
+}
+
+// connPath is the TCP 4-tuple of a peer's current connection, recorded so
+// runs can verify traffic used the intended path through the switch.
+type connPath struct {
+	Local  string
+	Remote string
+}
+
+// frameStats tracks the server's data frames as the "video" stream: frames
+// received, sequence gaps, and freezes (time between consecutive frames).
+// Freeze maxima are kept separately for the stdout snapshot and the
+// /metrics endpoint because each consumer resets its own window.
+type frameStats struct {
+	mu            sync.Mutex
+	received      uint64
+	keyframes     uint64
+	missed        uint64
+	undecodable   uint64
+	broken        bool
+	nextSeq       int
+	lastAt        time.Time
+	maxFreezeSnap time.Duration
+	maxFreezeAgg  time.Duration
+	// gaps has every inter-frame gap of the run in µs, for -histograms.
+	gaps histogram.Histogram
+}
+
+// observe records a frame and returns the gap since the previous one
+// (0 for the first frame) and whether this frame broke the stream. A delta
+// frame is only decodable if nothing was lost since the last keyframe, so a
+// seq gap, or a new stream that starts on a delta frame, leaves the stream
+// broken until the next keyframe arrives.
+func (f *frameStats) observe(seq int, now time.Time, key bool) (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// A lower seq means the server started a new stream for this peer
+	// (reconnect), not reordering.
+	restart := f.received == 0 || seq < f.nextSeq
+	if !restart && seq > f.nextSeq {
+		f.missed += uint64(seq - f.nextSeq)
+	}
+	wasBroken := f.broken
+	switch {
+	case key:
+		f.broken = false
+	case restart || seq > f.nextSeq:
+		f.broken = true
+	}
+	if f.broken {
+		f.undecodable++
+	}
+	f.nextSeq = seq + 1
+	var gap time.Duration
+	if !f.lastAt.IsZero() {
+		gap = now.Sub(f.lastAt)
+		f.noteFreeze(gap)
+		f.gaps.Record(gap.Microseconds())
+	}
+	f.lastAt = now
+	f.received++
+	if key {
+		f.keyframes++
+	}
+	return gap, f.broken && !wasBroken
+}
+
+// undecodableFrames is the number of delta frames received while the
+// stream was broken.
+func (f *frameStats) undecodableFrames() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.undecodable
+}
+
+func (f *frameStats) noteFreeze(gap time.Duration) {
+	if gap > f.maxFreezeSnap {
+		f.maxFreezeSnap = gap
+	}
+	if gap > f.maxFreezeAgg {
+		f.maxFreezeAgg = gap
+	}
+}
+
+// take returns the counters and the max freeze in the caller's window, and
+// resets that window. An ongoing freeze (no frame yet) counts up to now.
+func (f *frameStats) take(now time.Time, agg bool) (received, keyframes, missed uint64, maxFreeze time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.lastAt.IsZero() {
+		f.noteFreeze(now.Sub(f.lastAt))
+	}
+	if agg {
+		maxFreeze, f.maxFreezeAgg = f.maxFreezeAgg, 0
+	} else {
+		maxFreeze, f.maxFreezeSnap = f.maxFreezeSnap, 0
+	}
+	return f.received, f.keyframes, f.missed, maxFreeze
+}
+
+// receiverReport is the "rr" control message as of now.
+func (c *conn) receiverReport(now time.Time) controlMsg {
+	c.frames.mu.Lock()
+	recv, lost := c.frames.received, c.frames.missed
+	c.frames.mu.Unlock()
+	lsr, dlsr := c.delay.lastFrame(now.UnixNano())
+	return controlMsg{
+		Type: "rr", BytesReceived: c.bytesRecv.Load() - c.rrBase.Load(), Ts: now.UnixNano(),
+		FramesReceived: recv, FramesLost: lost, LSR: lsr, DLSR: int64(dlsr),
+	}
+}
+
+// controlMsg is a control message to the server, e.g. {"type":"pli"} or
+// {"type":"layer","rid":"q"}.
+type controlMsg struct {
+	Type          string `json:"type"`
+	RID           string `json:"rid,omitempty"`
+	TID           *int   `json:"tid,omitempty"`
+	BytesReceived uint64 `json:"bytes_received,omitempty"`
+	Ts            int64  `json:"ts,omitempty"`
+	// Receiver reports also carry the running frame counts and LSR/DLSR
+	// (see delayStats.lastFrame), from which the server derives the peer's
+	// fraction lost and round trip.
+	FramesReceived uint64 `json:"frames_received,omitempty"`
+	FramesLost     uint64 `json:"frames_lost,omitempty"`
+	LSR            int64  `json:"lsr,omitempty"`
+	DLSR           int64  `json:"dlsr,omitempty"`
+}
+
+// sendControl sends a control message without parameters.
+func (c *conn) sendControl(typ string) error {
+	return c.sendControlMsg(controlMsg{Type: typ})
+}
+
+func (c *conn) sendControlMsg(cm controlMsg) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ws == nil {
+		return fmt.Errorf("not connected")
+	}
+	data, _ := json.Marshal(cm)
+	if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	c.bytesSent.Add(uint64(len(data)))
+	c.tr.record("out", cm.Type, map[string]any{"msg": json.RawMessage(data)})
+	return nil
+}
+
+// onVideoFrame reports the end of a gap longer than -gap-event to the
+// event bus, and requests a keyframe when the stream breaks (a lost or
+// missing reference) or after a freeze longer than -pli-after.
+func (c *conn) onVideoFrame(gap time.Duration, broke bool) {
+	c.reofferRecovered()
+	if *gapEvent > 0 && gap >= *gapEvent {
+		fields := map[string]any{"peer": c.id, "gap_ms": float64(gap) / 1e6, "broken": broke}
+		if c.group.name != "" {
+			fields["group"] = c.group.name
+		}
+		bus.Publish("first_packet_after_gap", fields)
+	}
+	if *pliAfter <= 0 || (gap < *pliAfter && !broke) {
+		return
+	}
+	if err := c.sendControl("pli"); err != nil {
+		return
+	}
+	c.pliSent.Add(1)
+	if broke {
+		log.Printf("[conn-%d] sent PLI: stream broken, waiting for keyframe", c.id)
+	} else {
+		log.Printf("[conn-%d] sent PLI after %s freeze", c.id, gap.Round(time.Millisecond))
+	}
 }
 
 func (c *conn) sendPing() error {
@@ -76,17 +410,47 @@ func (c *conn) sendPing() error {
 }
 
 type aggregatedMetrics struct {
-	ConnectedClients int     `json:"connected_clients"`
-	TotalClients     int     `json:"total_clients"`
-	AvgRttMs         float64 `json:"avg_rtt_ms"`
-	P50RttMs         float64 `json:"p50_rtt_ms"`
-	P95RttMs         float64 `json:"p95_rtt_ms"`
-	P99RttMs         float64 `json:"p99_rtt_ms"`
-	MaxRttMs         float64 `json:"max_rtt_ms"`
-	JitterMs         float64 `json:"jitter_ms"`
-	BytesSent        uint64  `json:"bytes_sent"`
-	BytesReceived    uint64  `json:"bytes_received"`
-	ConnectionDrops  int64   `json:"connection_drops"`
+	TimestampNs       int64   `json:"timestamp_unix_nano"` // when the snapshot was taken
+	ConnectedClients  int     `json:"connected_clients"`
+	TotalClients      int     `json:"total_clients"`
+	AvgRttMs          float64 `json:"avg_rtt_ms"`
+	P50RttMs          float64 `json:"p50_rtt_ms"`
+	P95RttMs          float64 `json:"p95_rtt_ms"`
+	P99RttMs          float64 `json:"p99_rtt_ms"`
+	MaxRttMs          float64 `json:"max_rtt_ms"`
+	JitterMs          float64 `json:"jitter_ms"`
+	BytesSent         uint64  `json:"bytes_sent"`
+	BytesReceived     uint64  `json:"bytes_received"`
+	ConnectionDrops   int64   `json:"connection_drops"`
+	InstanceChanges   int64   `json:"server_instance_changes"`
+	SessionsResumed   uint64  `json:"sessions_resumed"`
+	FramesReceived    uint64  `json:"frames_received"`
+	KeyframesReceived uint64  `json:"keyframes_received"`
+	FramesMissed      uint64  `json:"frames_missed"`
+	FramesUndecodable uint64  `json:"frames_undecodable"`
+	MaxFreezeMs       float64 `json:"max_freeze_ms"`
+	OwdAvgMs          float64 `json:"owd_avg_ms"`
+	OwdMaxMs          float64 `json:"owd_max_ms"`
+	AudioFrames       uint64  `json:"audio_frames_received"`
+	AudioMaxFreezeMs  float64 `json:"audio_max_freeze_ms"`
+	PLISent           uint64  `json:"pli_sent"`
+	RxQueueMaxBytes   int     `json:"rx_queue_max_bytes"`
+	// ClientDrops counts segments the loadgen host's TCP stack dropped
+	// for full socket buffers since it started, see clientDrops.
+	ClientDrops uint64 `json:"client_drops"`
+	// ReoffersAccepted counts server reoffers the peers redialed for, and
+	// ReofferMaxMs is the longest of them from push to first frame.
+	ReoffersAccepted uint64  `json:"reoffers_accepted,omitempty"`
+	ReofferMaxMs     float64 `json:"reoffer_max_ms,omitempty"`
+	// StallRedials counts peers redialed by -stall-timeout.
+	StallRedials uint64 `json:"stall_redials,omitempty"`
+	// RetryAfterWaits counts dials refused with 503 + Retry-After that the
+	// peers retried after waiting it out.
+	RetryAfterWaits uint64 `json:"retry_after_waits,omitempty"`
+	// SignalProbes is keyed by transport (tcp, h3), with -signal-probe-interval.
+	SignalProbes map[string]probeMetrics `json:"signal_probes,omitempty"`
+	// Groups has the same metrics per -groups group, for its peers only.
+	Groups map[string]aggregatedMetrics `json:"groups,omitempty"`
 }
 
 var (
@@ -95,56 +459,131 @@ var (
 	connectionDrops atomic.Int64
 )
 
+// metricsAcc sums the peers of one aggregate of computeMetrics: all peers,
+// or one group.
+type metricsAcc struct {
+	m         aggregatedMetrics
+	owdSum    float64
+	owdN      int
+	rtt       []float64
+	jitterSum float64
+	jitterN   int
+}
+
 // computeMetrics returns per-interval metrics and resets RTT/jitter accumulators.
+// With -groups, each group's peers are also aggregated on their own.
 func computeMetrics() aggregatedMetrics {
 	connsMu.RLock()
 	defer connsMu.RUnlock()
 
-	m := aggregatedMetrics{
+	all := &metricsAcc{m: aggregatedMetrics{
+		TimestampNs:     time.Now().UnixNano(),
 		TotalClients:    len(conns),
 		ConnectionDrops: connectionDrops.Load(),
-	}
+		InstanceChanges: instanceChanges.Load(),
+		SignalProbes:    probeSnapshot(),
+		ClientDrops:     clientDrops() - dropsBase,
+	}}
+	byGroup := map[string]*metricsAcc{}
 
-	var allRTT []float64
-	var totalJitter float64
-	var jitterCount int
-	for _, c := range conns {
+	now := time.Now()
+	for i, c := range conns {
+		accs := []*metricsAcc{all}
+		if g := groupOf(i); g.name != "" {
+			if byGroup[g.name] == nil {
+				byGroup[g.name] = &metricsAcc{m: aggregatedMetrics{TimestampNs: all.m.TimestampNs}}
+			}
+			accs = append(accs, byGroup[g.name])
+			byGroup[g.name].m.TotalClients++
+			if c != nil {
+				byGroup[g.name].m.ConnectionDrops += c.drops.Load()
+			}
+		}
 		if c == nil {
 			continue
 		}
-		if c.connected.Load() {
-			m.ConnectedClients++
-		}
-		m.BytesSent += c.bytesSent.Load()
-		m.BytesReceived += c.bytesRecv.Load()
+		connected := c.connected.Load()
+		frames, keyframes, missed, freeze := c.frames.take(now, true)
+		undecodable := c.frames.undecodableFrames()
+		audioFrames, _, _, audioFreeze := c.audio.take(now, true)
+		owdAvg, owdMax, _, owdOK := c.delay.take(true)
+		rxQueue := c.rxQueue()
 
 		c.rttMu.Lock()
-		allRTT = append(allRTT, c.rttSamples...)
-		totalJitter += c.jitterSum
-		jitterCount += c.jitterN
-		c.rttSamples = c.rttSamples[:0]
+		rtt, jitterSum, jitterN := c.rttSamples, c.jitterSum, c.jitterN
+		c.rttSamples = nil
 		c.jitterSum = 0
 		c.jitterN = 0
 		c.rttMu.Unlock()
+
+		for _, a := range accs {
+			m := &a.m
+			if connected {
+				m.ConnectedClients++
+			}
+			m.FramesReceived += frames
+			m.KeyframesReceived += keyframes
+			m.FramesMissed += missed
+			m.FramesUndecodable += undecodable
+			if ms := float64(freeze) / 1e6; ms > m.MaxFreezeMs {
+				m.MaxFreezeMs = ms
+			}
+			m.AudioFrames += audioFrames
+			if audioFrames > 0 {
+				m.AudioMaxFreezeMs = max(m.AudioMaxFreezeMs, float64(audioFreeze)/1e6)
+			}
+			if owdOK && owdAvg > 0 {
+				a.owdSum += float64(owdAvg) / 1e6
+				a.owdN++
+				m.OwdMaxMs = max(m.OwdMaxMs, float64(owdMax)/1e6)
+			}
+			m.BytesSent += c.bytesSent.Load()
+			m.BytesReceived += c.bytesRecv.Load()
+			m.PLISent += c.pliSent.Load()
+			m.RxQueueMaxBytes = max(m.RxQueueMaxBytes, rxQueue)
+			m.SessionsResumed += c.resumes.Load()
+			m.ReoffersAccepted += c.reoffers.Load()
+			m.StallRedials += c.stalls.Load()
+			m.RetryAfterWaits += c.retryAfters.Load()
+			m.ReofferMaxMs = max(m.ReofferMaxMs, float64(c.reofferMax.Load())/1e6)
+			a.rtt = append(a.rtt, rtt...)
+			a.jitterSum += jitterSum
+			a.jitterN += jitterN
+		}
 	}
 
-	if jitterCount > 0 {
-		m.JitterMs = totalJitter / float64(jitterCount)
+	m := all.finish()
+	for name, a := range byGroup {
+		if m.Groups == nil {
+			m.Groups = map[string]aggregatedMetrics{}
+		}
+		m.Groups[name] = a.finish()
 	}
+	return m
+}
 
-	if len(allRTT) > 0 {
-		sort.Float64s(allRTT)
+// finish computes the averages and RTT percentiles.
+func (a *metricsAcc) finish() aggregatedMetrics {
+	m := a.m
+	if a.jitterN > 0 {
+		m.JitterMs = a.jitterSum / float64(a.jitterN)
+	}
+	if a.owdN > 0 {
+		m.OwdAvgMs = a.owdSum / float64(a.owdN)
+	}
+
+	if len(a.rtt) > 0 {
+		sort.Float64s(a.rtt)
 		var sum float64
-		for _, v := range allRTT {
+		for _, v := range a.rtt {
 			sum += v
 		}
-		m.AvgRttMs = sum / float64(len(allRTT))
-		m.P50RttMs = percentile(allRTT, 50)
-		m.P95RttMs = percentile(allRTT, 95)
-		m.P99RttMs = percentile(allRTT, 99)
-		m.MaxRttMs = allRTT[len(allRTT)-1]
+		m.AvgRttMs = sum / float64(len(a.rtt))
+		m.P50RttMs = percentile(a.rtt, 50)
+		m.P95RttMs = percentile(a.rtt, 95)
+		m.P99RttMs = percentile(a.rtt, 99)
+		m.MaxRttMs = a.rtt[len(a.rtt)-1]
 	}
-
 	return m
 }
 
@@ -162,13 +601,83 @@ func percentile(sorted []float64, p float64) float64 {
 	return sorted[lower]*(1-frac) + sorted[upper]*frac
 }
 
-func connectWS(ctx context.Context, id int, serverURL string) (*conn, error) {
+// Binary video frame header, mirroring cmd/server/source.go.
+const (
+	videoHeaderLen = 17
+	flagKeyframe   = 1 << 0
+	flagAudio      = 1 << 1
+)
+
+// instanceHeader carries the server's per-process instance ID in the
+// WebSocket handshake response. A CRIU-restored server keeps its ID; a
+// freshly started one gets a new ID.
+const instanceHeader = "X-Server-Instance"
+
+// sessionHeader carries the server's session token. Redialing with it as
+// ?session= resumes the same server-side peer (ID and counters), and the
+// server answers with sessionResumedHeader.
+const (
+	sessionHeader        = "X-Session-Token"
+	sessionResumedHeader = "X-Session-Resumed"
+)
+
+func connectWS(ctx context.Context, c *conn, serverURL string) error {
 	wsURL := "ws" + serverURL[4:] + "/ws"
+	q := url.Values{}
+	g := c.group
+	if g.audio {
+		q.Set("audio", "1")
+	}
+	if g.bitrate > 0 {
+		q.Set("bitrate", strconv.Itoa(g.bitrate))
+	}
+	if g.maxRate > 0 {
+		q.Set("max_rate", strconv.Itoa(g.maxRate))
+	}
+	layer := currentLayer.Load()
+	if g.pinned {
+		layer = &layerPref{rid: g.rid, tid: g.tid}
+	}
+	if layer != nil {
+		if layer.rid != "" {
+			q.Set("rid", layer.rid)
+		}
+		if layer.tid >= 0 {
+			q.Set("tid", strconv.Itoa(layer.tid))
+		}
+	}
+	if tok, ok := c.session.Load().(string); ok && tok != "" {
+		q.Set("session", tok)
+	}
+	if *acceptReoffer {
+		q.Set("reoffer", "1")
+	}
+	q.Set("notify", "1")
+	if len(q) > 0 {
+		wsURL += "?" + q.Encode()
+	}
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 5 * time.Second,
+		ReadBufferSize:   *readBufSize,
 		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			d := net.Dialer{}
-			c, err := d.DialContext(ctx, network, addr)
+			if sourceAddr != nil {
+				d.LocalAddr = sourceAddr
+			}
+			if *dscp >= 0 || *rcvBuf > 0 {
+				d.Control = func(network, _ string, rc syscall.RawConn) error {
+					if *rcvBuf > 0 {
+						if err := setRcvBuf(rc); err != nil {
+							return err
+						}
+					}
+					if *dscp >= 0 {
+						return markDSCP(network, rc)
+					}
+					return nil
+				}
+			}
+			c, err := d.DialContext(ctx, dialNetwork(network), addr)
 			if err != nil {
 				return nil, err
 			}
@@ -179,46 +688,215 @@ func connectWS(ctx context.Context, id int, serverURL string) (*conn, error) {
 			return c, nil
 		},
 	}
-	ws, _, err := dialer.DialContext(ctx, wsURL, nil)
+	var hdr http.Header
+	if *authToken != "" {
+		hdr = http.Header{"Authorization": {"Bearer " + *authToken}}
+	}
+	source := "any"
+	if sourceAddr != nil {
+		source = sourceAddr.String()
+	}
+	c.tr.record("out", "handshake", map[string]any{"url": wsURL, "source": source})
+	ws, resp, err := dialer.DialContext(ctx, wsURL, hdr)
 	if err != nil {
-		return nil, fmt.Errorf("dial %s: %w", wsURL, err)
+		fields := map[string]any{"error": err.Error()}
+		if resp != nil {
+			fields["status"] = resp.StatusCode
+		}
+		c.tr.record("in", "handshake_failed", fields)
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("dial %s: unauthorized (check -auth-token)", wsURL)
+		}
+		if resp != nil && resp.StatusCode == http.StatusServiceUnavailable {
+			if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs >= 0 {
+				return &retryAfterError{wait: time.Duration(secs) * time.Second, err: fmt.Errorf("dial %s: %w", wsURL, err)}
+			}
+		}
+		return fmt.Errorf("dial %s: %w", wsURL, err)
 	}
 
-	c := &conn{
-		id: id,
-		ws: ws,
-	}
+	c.mu.Lock()
+	c.ws = ws
+	c.mu.Unlock()
+	c.rrBase.Store(c.bytesRecv.Load())
+	c.connectedAt.Store(time.Now().UnixNano())
+	c.path.Store(connPath{Local: ws.LocalAddr().String(), Remote: ws.RemoteAddr().String()})
+	log.Printf("[conn-%d] path local=%s remote=%s", c.id, ws.LocalAddr(), ws.RemoteAddr())
 	c.connected.Store(true)
-	return c, nil
+	c.observeInstance(resp.Header.Get(instanceHeader))
+	c.tr.record("in", "handshake", map[string]any{
+		"status": resp.StatusCode, "instance": resp.Header.Get(instanceHeader),
+		"session": resp.Header.Get(sessionHeader), "resumed": resp.Header.Get(sessionResumedHeader) == "1",
+		"local": ws.LocalAddr().String(), "remote": ws.RemoteAddr().String(),
+	})
+	if tok := resp.Header.Get(sessionHeader); tok != "" {
+		c.session.Store(tok)
+	}
+	if resp.Header.Get(sessionResumedHeader) == "1" {
+		c.resumes.Add(1)
+		log.Printf("[conn-%d] session resumed", c.id)
+	}
+	return nil
 }
 
-func connectWithRetry(ctx context.Context, id int, serverURL string) *conn {
-	backoff := 500 * time.Millisecond
-	maxBackoff := 3 * time.Second
-	for {
+var (
+	instanceMu      sync.Mutex
+	lastInstance    string
+	instanceChanges atomic.Int64
+)
+
+// observeInstance records the server instance seen by a (re)connect and logs
+// a "server instance changed" event when it differs from the last one seen
+// by any peer.
+func (c *conn) observeInstance(id string) {
+	if id == "" {
+		return
+	}
+	c.instance.Store(id)
+	instanceMu.Lock()
+	prev := lastInstance
+	lastInstance = id
+	instanceMu.Unlock()
+	if prev != "" && prev != id {
+		instanceChanges.Add(1)
+		log.Printf("[conn-%d] server instance changed: %s -> %s (server restarted, not restored)", c.id, prev, id)
+	}
+}
+
+// retryPolicy bounds how long connectWithRetry keeps trying. Zero values
+// for maxAttempts / maxElapsed mean unlimited.
+type retryPolicy struct {
+	initial     time.Duration
+	max         time.Duration
+	maxAttempts int
+	maxElapsed  time.Duration
+	jitter      float64
+}
+
+// delay returns the backoff for the given attempt (1-based) with jitter of
+// ±jitter*backoff applied, so peers that dropped together don't retry in
+// lockstep.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.initial
+	for i := 1; i < attempt && d < p.max; i++ {
+		d *= 2
+	}
+	if d > p.max {
+		d = p.max
+	}
+	if p.jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.jitter * float64(d))
+	}
+	return d
+}
+
+// retryAfterError is a 503 refusal with a Retry-After, e.g. from a server
+// quiesced for a checkpoint (-quiesce-retry-after) or pacing new sessions.
+// connectWithRetry waits exactly that long instead of its own backoff, so
+// peers refused together retry together.
+type retryAfterError struct {
+	wait time.Duration
+	err  error
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %s)", e.err, e.wait)
+}
+
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// connectWithRetry dials until it connects, gives up or ctx ends. Each
+// failed attempt is published to -event-bus as connect_attempt_failed,
+// and the outcome after a failure as connect_recovered or connect_gave_up
+// with how long signaling was unavailable.
+func connectWithRetry(ctx context.Context, c *conn, serverURL string) bool {
+	id := c.id
+	start := time.Now()
+	publish := func(typ string, fields map[string]any) {
+		fields["peer"] = id
+		if c.group.name != "" {
+			fields["group"] = c.group.name
+		}
+		bus.Publish(typ, fields)
+	}
+	for attempt := 1; ; attempt++ {
 		select {
 		case <-ctx.Done():
-			return nil
+			return false
 		default:
 		}
-		c, err := connectWS(ctx, id, serverURL)
+		err := connectWS(ctx, c, serverURL)
 		if err == nil {
-			return c
+			if attempt > 1 {
+				unavailable := time.Since(start)
+				log.Printf("[conn-%d] connected after %d attempts, signaling unavailable for %s",
+					id, attempt, unavailable.Round(time.Millisecond))
+				publish("connect_recovered", map[string]any{"attempts": attempt, "unavailable_ms": float64(unavailable) / 1e6})
+			}
+			return true
+		}
+		elapsed := time.Since(start)
+		failed := map[string]any{"attempt": attempt, "elapsed_ms": float64(elapsed) / 1e6, "error": err.Error()}
+		gaveUp := func(reason string) bool {
+			publish("connect_attempt_failed", failed)
+			publish("connect_gave_up", map[string]any{"attempts": attempt, "unavailable_ms": float64(elapsed) / 1e6, "reason": reason})
+			return false
+		}
+		if retry.maxAttempts > 0 && attempt >= retry.maxAttempts {
+			log.Printf("[conn-%d] connect attempt %d failed after %s: %v (giving up: max attempts reached)",
+				id, attempt, elapsed.Round(time.Millisecond), err)
+			return gaveUp("max_attempts")
+		}
+		backoff := retry.delay(attempt)
+		var ra *retryAfterError
+		if errors.As(err, &ra) {
+			backoff = ra.wait
+			c.retryAfters.Add(1)
 		}
-		log.Printf("[conn-%d] connect failed: %v (retrying in %s)", id, err, backoff)
+		if retry.maxElapsed > 0 && elapsed+backoff > retry.maxElapsed {
+			log.Printf("[conn-%d] connect attempt %d failed after %s: %v (giving up: max elapsed %s reached)",
+				id, attempt, elapsed.Round(time.Millisecond), err, retry.maxElapsed)
+			return gaveUp("max_elapsed")
+		}
+		log.Printf("[conn-%d] connect attempt %d failed after %s: %v (retrying in %s)",
+			id, attempt, elapsed.Round(time.Millisecond), err, backoff.Round(time.Millisecond))
+		failed["retry_in_ms"] = float64(backoff) / 1e6
+		publish("connect_attempt_failed", failed)
 		select {
 		case <-ctx.Done():
-			return nil
+			return false
 		case <-time.After(backoff):
 		}
-		backoff *= 2
-		if backoff > maxBackoff {
-			backoff = maxBackoff
+	}
+}
+
+// readThrottle paces a read loop to a byte rate. Sleeping between reads
+// leaves data in the kernel receive buffer, so the TCP window closes and
+// the server sees real receiver-side backpressure.
+type readThrottle struct {
+	rate float64 // bytes per second
+	next time.Time
+}
+
+func (t *readThrottle) wait(ctx context.Context, n int) {
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / t.rate * float64(time.Second)))
+	if d := time.Until(t.next); d > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
 		}
 	}
 }
 
 func readLoop(ctx context.Context, c *conn) {
+	var throttle *readThrottle
+	if c.group.throttled(c.id) {
+		throttle = &readThrottle{rate: float64(c.group.readRate)}
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -226,25 +904,81 @@ func readLoop(ctx context.Context, c *conn) {
 		default:
 		}
 
-		_, raw, err := c.ws.ReadMessage()
+		msgType, raw, err := c.readMessage(c.ws)
 		if err != nil {
+			c.tr.record("", "closed", map[string]any{"error": err.Error()})
 			if c.connected.Load() {
 				c.connected.Store(false)
 				connectionDrops.Add(1)
+				c.drops.Add(1)
 				log.Printf("[conn-%d] disconnected: %v", c.id, err)
 			}
 			return
 		}
 		c.bytesRecv.Add(uint64(len(raw)))
 		c.msgsRecv.Add(1)
+		if throttle != nil {
+			throttle.wait(ctx, len(raw))
+		}
 
-		var echo struct {
-			Seq      int   `json:"seq"`
-			ClientTs int64 `json:"client_ts"`
-			ServerTs int64 `json:"server_ts"`
+		// Echoes carry client_ts; data frames carry ts instead, and control
+		// messages from the server a type.
+		var msg struct {
+			Type     string `json:"type"`
+			Seq      int    `json:"seq"`
+			Ts       int64  `json:"ts"`
+			Key      bool   `json:"key"`
+			RID      string `json:"rid"`
+			Width    int    `json:"width"`
+			Height   int    `json:"height"`
+			ClientTs int64  `json:"client_ts"`
+			ServerTs int64  `json:"server_ts"`
+		}
+		recvAt := time.Now()
+		if msgType == websocket.BinaryMessage {
+			// Binary video frame: seq, send time, flags, payload (see
+			// -video-file in cmd/server).
+			if len(raw) >= videoHeaderLen {
+				seq := int(binary.BigEndian.Uint64(raw[0:8]))
+				ts := int64(binary.BigEndian.Uint64(raw[8:16]))
+				if raw[16]&flagAudio != 0 {
+					// Every Opus frame decodes on its own.
+					c.audio.observe(seq, recvAt, true)
+				} else {
+					c.onVideoFrame(c.frames.observe(seq, recvAt, raw[16]&flagKeyframe != 0))
+				}
+				owd, ok := c.delay.observeFrame(ts, recvAt.UnixNano())
+				if raw[16]&flagAudio == 0 {
+					owds.record(c.id, seq, raw[16]&flagKeyframe != 0, ts, recvAt.UnixNano(), owd, ok)
+				}
+			}
+			continue
 		}
-		if err := json.Unmarshal(raw, &echo); err == nil && echo.ClientTs > 0 {
-			rtt := float64(time.Now().UnixNano()-echo.ClientTs) / 1e6
+		err = json.Unmarshal(raw, &msg)
+		if err == nil && msg.Type == "reoffer" {
+			c.tr.record("in", "reoffer", map[string]any{"msg": json.RawMessage(raw)})
+			c.acceptReoffer(recvAt)
+			return
+		}
+		if err == nil && msg.Type != "" {
+			// A server notice, not a frame.
+			c.tr.record("in", msg.Type, map[string]any{"msg": json.RawMessage(raw)})
+			serverNotice(msg.Type, raw)
+			continue
+		}
+		if err == nil && msg.ClientTs == 0 && msg.Ts > 0 {
+			if msg.RID != "" {
+				c.layer.Store(msg.RID)
+			}
+			if msg.Width > 0 {
+				c.size.Store(fmt.Sprintf("%dx%d", msg.Width, msg.Height))
+			}
+			c.onVideoFrame(c.frames.observe(msg.Seq, recvAt, msg.Key))
+			owd, ok := c.delay.observeFrame(msg.Ts, recvAt.UnixNano())
+			owds.record(c.id, msg.Seq, msg.Key, msg.Ts, recvAt.UnixNano(), owd, ok)
+		}
+		if err == nil && msg.ClientTs > 0 {
+			rtt := float64(recvAt.UnixNano()-msg.ClientTs) / 1e6
 			if rtt >= 0 && rtt < *rttCapMs {
 				c.rttMu.Lock()
 				if c.lastRTT > 0 {
@@ -253,37 +987,111 @@ func readLoop(ctx context.Context, c *conn) {
 				}
 				c.lastRTT = rtt
 				c.rttSamples = append(c.rttSamples, rtt)
+				c.rttHist.Record(int64(rtt * 1000))
 				c.rttMu.Unlock()
+				if msg.ServerTs > 0 {
+					c.delay.observeEcho(msg.ClientTs, msg.ServerTs, recvAt.UnixNano())
+				}
 			}
 		}
 	}
 }
 
-func pingLoop(ctx context.Context, c *conn) {
-	interval := time.Duration(*pingMs) * time.Millisecond
+func pingLoop(ctx context.Context, c *conn, done <-chan struct{}) {
+	interval := time.Duration(c.group.pingMs) * time.Millisecond
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// Receiver reports for the server's -adaptive mode; a nil channel
+	// disables them.
+	var rrC <-chan time.Time
+	if *feedbackIval > 0 {
+		rr := time.NewTicker(*feedbackIval)
+		defer rr.Stop()
+		rrC = rr.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-done:
+			return
+		case <-rrC:
+			// Errors surface through the next ping.
+			c.sendControlMsg(c.receiverReport(time.Now()))
 		case <-ticker.C:
 			if !c.connected.Load() {
 				return
 			}
+			if c.stalled(time.Now()) {
+				return
+			}
 			if err := c.sendPing(); err != nil {
 				if c.connected.Load() {
 					c.connected.Store(false)
 					connectionDrops.Add(1)
+					c.drops.Add(1)
 					log.Printf("[conn-%d] ping failed: %v", c.id, err)
 				}
+				if *reconnect {
+					// Unblock readLoop so runPeer can redial.
+					c.ws.Close()
+				}
 				return
 			}
 		}
 	}
 }
 
+// stalled reports whether the current connection went -stall-timeout
+// without a video frame, and if so closes it so that runPeer redials this
+// peer alone. A migration that breaks some flows then costs only those a
+// reconnect, and the other peers' numbers stay those of a live stream.
+func (c *conn) stalled(now time.Time) bool {
+	if *stallTimeout <= 0 {
+		return false
+	}
+	last := time.Unix(0, c.connectedAt.Load())
+	c.frames.mu.Lock()
+	if c.frames.lastAt.After(last) {
+		last = c.frames.lastAt
+	}
+	c.frames.mu.Unlock()
+	idle := now.Sub(last)
+	if idle < *stallTimeout {
+		return false
+	}
+	c.connected.Store(false)
+	c.stalls.Add(1)
+	c.tr.record("", "stalled", map[string]any{"idle_ms": idle.Milliseconds()})
+	log.Printf("[conn-%d] no frame for %s, redialing", c.id, idle.Round(time.Millisecond))
+	// Unblock readLoop so runPeer can redial.
+	c.ws.Close()
+	return true
+}
+
+// runPeer drives one peer's read and ping loops. With -reconnect it redials
+// after every drop, and with -accept-reoffer after every server reoffer;
+// otherwise it returns once the connection is gone, which keeps the
+// default transparent-TCP migration semantics.
+func runPeer(ctx context.Context, c *conn) {
+	for {
+		done := make(chan struct{})
+		go pingLoop(ctx, c, done)
+		readLoop(ctx, c)
+		close(done)
+		if (!*reconnect && c.reofferAt.Load() == 0) || ctx.Err() != nil {
+			return
+		}
+		c.ws.Close()
+		if !connectWithRetry(ctx, c, serverBase()) {
+			return
+		}
+		log.Printf("[conn-%d] reconnected", c.id)
+	}
+}
+
 type peerMetrics struct {
 	PeerID             int     `json:"peer_id"`
 	TimestampUnixMilli int64   `json:"timestamp_unix_milli"`
@@ -292,16 +1100,44 @@ type peerMetrics struct {
 	Connected          bool    `json:"connected"`
 	BytesPerSecond     float64 `json:"bytes_per_second"`
 	RttMs              float64 `json:"rtt_ms"`
+	ServerInstance     string  `json:"server_instance,omitempty"`
+	SimulcastRID       string  `json:"rid,omitempty"`
+	Resolution         string  `json:"resolution,omitempty"`
+	LocalAddr          string  `json:"local_addr,omitempty"`
+	RemoteAddr         string  `json:"remote_addr,omitempty"`
+	FramesReceived     uint64  `json:"frames_received"`
+	KeyframesReceived  uint64  `json:"keyframes_received"`
+	FramesPerSecond    float64 `json:"frames_per_second"`
+	FramesMissed       uint64  `json:"frames_missed"`
+	FramesUndecodable  uint64  `json:"frames_undecodable"`
+	MaxFreezeMs        float64 `json:"max_freeze_ms"`
+	OwdAvgMs           float64 `json:"owd_avg_ms"`
+	OwdMaxMs           float64 `json:"owd_max_ms"`
+	ClockOffsetMs      float64 `json:"clock_offset_ms"`
+	AudioFrames        uint64  `json:"audio_frames_received,omitempty"`
+	AudioMaxFreezeMs   float64 `json:"audio_max_freeze_ms,omitempty"`
+	RxQueueBytes       int     `json:"rx_queue_bytes"`
+	Group              string  `json:"group,omitempty"`
 }
 
-func snapshotConn(c *conn, prevBytes *uint64, prevTime *time.Time) peerMetrics {
+// fields is pm as event bus fields, under the same keys as on stdout.
+func (pm peerMetrics) fields() map[string]any {
+	var f map[string]any
+	data, _ := json.Marshal(pm)
+	json.Unmarshal(data, &f)
+	return f
+}
+
+func snapshotConn(c *conn) peerMetrics {
 	now := time.Now()
 	totalBytes := c.bytesRecv.Load()
-	dt := now.Sub(*prevTime).Seconds()
+	frames, keyframes, missed, freeze := c.frames.take(now, false)
+	dt := now.Sub(c.snapTime).Seconds()
 
-	var bps float64
+	var bps, fps float64
 	if dt > 0 {
-		bps = float64(totalBytes-*prevBytes) / dt
+		bps = float64(totalBytes-c.snapBytes) / dt
+		fps = float64(frames-c.snapFrames) / dt
 	}
 
 	c.rttMu.Lock()
@@ -316,19 +1152,187 @@ func snapshotConn(c *conn, prevBytes *uint64, prevTime *time.Time) peerMetrics {
 		Connected:          c.connected.Load(),
 		BytesPerSecond:     bps,
 		RttMs:              rtt,
+		FramesReceived:     frames,
+		KeyframesReceived:  keyframes,
+		FramesPerSecond:    fps,
+		FramesMissed:       missed,
+		FramesUndecodable:  c.frames.undecodableFrames(),
+		MaxFreezeMs:        float64(freeze) / 1e6,
+		RxQueueBytes:       c.rxQueue(),
+		Group:              c.group.name,
+	}
+	if audioFrames, _, _, audioFreeze := c.audio.take(now, false); audioFrames > 0 {
+		m.AudioFrames = audioFrames
+		m.AudioMaxFreezeMs = float64(audioFreeze) / 1e6
+	}
+	if avg, mx, off, ok := c.delay.take(false); ok {
+		m.OwdAvgMs = float64(avg) / 1e6
+		m.OwdMaxMs = float64(mx) / 1e6
+		m.ClockOffsetMs = float64(off) / 1e6
+	}
+	if rid, ok := c.layer.Load().(string); ok {
+		m.SimulcastRID = rid
+	}
+	if size, ok := c.size.Load().(string); ok {
+		m.Resolution = size
+	}
+	if id, ok := c.instance.Load().(string); ok {
+		m.ServerInstance = id
+	}
+	if p, ok := c.path.Load().(connPath); ok {
+		m.LocalAddr = p.Local
+		m.RemoteAddr = p.Remote
 	}
 
-	*prevBytes = totalBytes
-	*prevTime = now
+	c.snapBytes = totalBytes
+	c.snapFrames = frames
+	c.snapTime = now
 	return m
 }
 
 func main() {
 	flag.Parse()
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *authToken == "" {
+		*authToken = os.Getenv("STREAM_AUTH_TOKEN")
+	}
+	if *workerToken == "" {
+		*workerToken = os.Getenv("LOADGEN_WORKER_TOKEN")
+	}
+	if (*workersList != "" || *workerAddr != "") && *workerToken == "" {
+		log.Fatal("-workers and -worker-addr need -worker-token (or $LOADGEN_WORKER_TOKEN)")
+	}
+
+	if *configFile != "" {
+		rc, err := loadReloadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("-config: %v", err)
+		}
+		if rc.Server != nil {
+			*serverURL = *rc.Server
+		}
+		if rc.Connections != nil {
+			*numConns = *rc.Connections
+		}
+		if rc.Interval != nil {
+			*reportIval, _ = time.ParseDuration(*rc.Interval)
+		}
+		if rc.RID != nil {
+			*simulcastRID = *rc.RID
+		}
+		if rc.TID != nil {
+			*temporalLayer = *rc.TID
+		}
+		log.Printf("Loaded config %s", *configFile)
+	}
+
+	groups = []*peerGroup{flagGroup("", *numConns)}
+	if *groupSpec != "" {
+		var err error
+		if groups, err = parseGroups(*groupSpec); err != nil {
+			log.Fatalf("-groups: %v", err)
+		}
+		*numConns = 0
+		for _, g := range groups {
+			*numConns += g.count
+			log.Printf("Group %s: %d peers (audio=%t bitrate=%d max-rate=%d read-rate=%d ping=%dms)",
+				g.name, g.count, g.audio, g.bitrate, g.maxRate, g.readRate, g.pingMs)
+		}
+	}
 
 	log.Printf("Load generator: server=%s connections=%d ping=%dms interval=%s",
 		*serverURL, *numConns, *pingMs, *reportIval)
+	dropsBase = clientDrops()
+
+	retry = retryPolicy{
+		initial:     500 * time.Millisecond,
+		max:         3 * time.Second,
+		maxAttempts: *retryMaxAttempts,
+		maxElapsed:  *retryMaxElapsed,
+		jitter:      *retryJitter,
+	}
+
+	if *readRate > 0 && *groupSpec == "" {
+		n := "all"
+		if *slowPeers > 0 {
+			n = strconv.Itoa(*slowPeers)
+		}
+		log.Printf("Slow-reader mode: %s peers throttled to %d B/s", n, *readRate)
+	}
+
+	if *dscp > 63 {
+		log.Fatalf("-dscp must be between 0 and 63, got %d", *dscp)
+	}
+	if *retryJitter < 0 || *retryJitter > 1 {
+		log.Fatalf("-retry-jitter must be between 0 and 1, got %g", *retryJitter)
+	}
+	if *stallTimeout > 0 && !*reconnect {
+		log.Fatalf("-stall-timeout needs -reconnect")
+	}
+
+	if *ipFamily != "" && *ipFamily != "4" && *ipFamily != "6" {
+		log.Fatalf("-ip-family must be 4, 6 or empty, got %q", *ipFamily)
+	}
+	if *srcSubnet != "" {
+		addr, subnet, err := resolveSourceAddr(*srcSubnet)
+		if err != nil {
+			log.Fatalf("-source-subnet: %v", err)
+		}
+		u, err := url.Parse(*serverURL)
+		if err != nil {
+			log.Fatalf("-server: %v", err)
+		}
+		ips, err := net.LookupIP(u.Hostname())
+		if err != nil {
+			log.Fatalf("-server: resolve %s: %v", u.Hostname(), err)
+		}
+		inSubnet := false
+		for _, ip := range ips {
+			if subnet.Contains(ip) {
+				inSubnet = true
+				break
+			}
+		}
+		if !inSubnet {
+			log.Fatalf("-source-subnet: server %s (%v) is outside %s", u.Hostname(), ips, subnet)
+		}
+		if v6 := addr.IP.To4() == nil; *ipFamily != "" && v6 != (*ipFamily == "6") {
+			log.Fatalf("-source-subnet %s does not match -ip-family %s", subnet, *ipFamily)
+		}
+		sourceAddr = addr
+		log.Printf("Binding all connections to %s (subnet %s)", addr.IP, subnet)
+	}
+
+	if *transcriptDir != "" {
+		if err := os.MkdirAll(*transcriptDir, 0o755); err != nil {
+			log.Fatalf("-transcript-dir: %v", err)
+		}
+	}
+	if *clockOffset != "" {
+		d, err := time.ParseDuration(*clockOffset)
+		if err != nil {
+			log.Fatalf("-server-clock-offset: %v", err)
+		}
+		serverOffset = &d
+	}
+	if *owdLogPath != "" {
+		var err error
+		if owds, err = openOWDLog(*owdLogPath); err != nil {
+			log.Fatalf("-owd-log: %v", err)
+		}
+	}
+
+	if *pushSnapshots && *eventBusAddr == "" {
+		log.Fatal("-push-snapshots needs -event-bus")
+	}
+	if *eventBusAddr != "" {
+		var err error
+		if bus, err = eventbus.Dial(*eventBusAddr, "loadgen"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+		defer bus.Close(time.Second)
+		bus.Publish("loadgen_started", map[string]any{"server": *serverURL, "connections": *numConns})
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -341,6 +1345,25 @@ func main() {
 		cancel()
 	}()
 
+	if *workersList != "" {
+		runCoordinator(ctx, parseWorkers(*workersList))
+		return
+	}
+	var workerStopped <-chan struct{}
+	if *workerAddr != "" {
+		var err error
+		if workerStopped, err = serveWorker(ctx, *workerAddr, cancel); err != nil {
+			log.Fatalf("-worker-addr: %v", err)
+		}
+	}
+
+	if *probeIval > 0 {
+		probes = newSignalProbes()
+		for _, p := range probes {
+			go p.run(ctx, *probeIval)
+		}
+	}
+
 	go func() {
 		mux := http.NewServeMux()
 		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
@@ -358,43 +1381,28 @@ func main() {
 		}
 	}()
 
-	conns = make([]*conn, *numConns)
-	for i := 0; i < *numConns; i++ {
-		c := connectWithRetry(ctx, i, *serverURL)
-		if c == nil {
-			break
-		}
-		connsMu.Lock()
-		conns[i] = c
-		connsMu.Unlock()
-		log.Printf("[conn-%d] connected", i)
+	currentServer.Store(*serverURL)
+	currentLayer.Store(&layerPref{rid: *simulcastRID, tid: *temporalLayer})
 
-		go readLoop(ctx, c)
-		go pingLoop(ctx, c)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
 
-		if i < *numConns-1 {
-			time.Sleep(*rampUp)
-		}
-	}
+	scalePeers(ctx, *numConns)
 
 	connectedCount := 0
+	connsMu.RLock()
 	for _, c := range conns {
 		if c != nil {
 			connectedCount++
 		}
 	}
+	connsMu.RUnlock()
 	log.Printf("Connected %d / %d clients", connectedCount, *numConns)
 
 	enc := json.NewEncoder(os.Stdout)
 	ticker := time.NewTicker(*reportIval)
 	defer ticker.Stop()
 
-	prevBytes := make([]uint64, *numConns)
-	prevTimes := make([]time.Time, *numConns)
-	for i := range prevTimes {
-		prevTimes[i] = time.Now()
-	}
-
 	var durationCh <-chan time.Time
 	if *testDur > 0 {
 		durationCh = time.After(*testDur)
@@ -406,13 +1414,31 @@ func main() {
 			goto cleanup
 		case <-ticker.C:
 			connsMu.RLock()
-			for i, c := range conns {
+			for _, c := range conns {
 				if c != nil {
-					m := snapshotConn(c, &prevBytes[i], &prevTimes[i])
-					enc.Encode(m)
+					pm := snapshotConn(c)
+					if *snapStdout {
+						enc.Encode(pm)
+					}
+					if *pushSnapshots {
+						bus.Publish("peer_snapshot", pm.fields())
+					}
 				}
 			}
 			connsMu.RUnlock()
+		case <-hupCh:
+			if *configFile == "" {
+				log.Printf("SIGHUP ignored: no -config file")
+				continue
+			}
+			rc, err := loadReloadConfig(*configFile)
+			if err != nil {
+				log.Printf("SIGHUP: reload failed, keeping current config: %v", err)
+				continue
+			}
+			if ival := applyReload(ctx, rc); ival > 0 {
+				ticker.Reset(ival)
+			}
 		case <-durationCh:
 			log.Printf("Duration reached, shutting down")
 			goto cleanup
@@ -427,5 +1453,20 @@ cleanup:
 		}
 	}
 	connsMu.RUnlock()
+	if n := clientDrops() - dropsBase; n > 0 {
+		log.Printf("WARNING: this host's TCP stack dropped %d received segments for full socket buffers; raise -rcvbuf or use -fast-read", n)
+	}
+	if *histFile != "" {
+		if err := writeHistograms(*histFile); err != nil {
+			log.Printf("-histograms: %v", err)
+		}
+	}
+	if err := owds.close(); err != nil {
+		log.Printf("-owd-log: %v", err)
+	}
+	if workerStopped != nil {
+		cancel()
+		<-workerStopped
+	}
 	log.Printf("Load generator finished")
 }
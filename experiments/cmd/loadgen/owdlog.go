@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// owdLog is the -owd-log CSV: a row per video frame with the server's send
+// timestamp the frame carries (the abs-send-time of this stream) and its
+// arrival here, so one-way delay is available per frame rather than as
+// the snapshot averages. owd_ms uses the peer's clock offset in use, which
+// is -server-clock-offset when given; without an offset yet it is empty,
+// and the raw timestamps still allow offsetting later. A nil log discards.
+type owdLog struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+var owds *owdLog
+
+func openOWDLog(path string) (*owdLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &owdLog{f: f, w: bufio.NewWriterSize(f, 64<<10)}
+	l.w.WriteString("peer,seq,key,server_ts_ns,recv_ns,owd_ms\n")
+	return l, nil
+}
+
+func (l *owdLog) record(peer, seq int, key bool, serverTs, recv int64, owd time.Duration, ok bool) {
+	if l == nil {
+		return
+	}
+	owdMs := ""
+	if ok {
+		owdMs = strconv.FormatFloat(float64(owd)/1e6, 'f', 3, 64)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "%d,%d,%t,%d,%d,%s\n", peer, seq, key, serverTs, recv, owdMs)
+}
+
+func (l *owdLog) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
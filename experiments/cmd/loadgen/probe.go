@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// signalProbe polls the server's /health over one transport every
+// -signal-probe-interval (TCP: the startup -server URL; h3: -h3-server). Both keep
+// their connection between probes, so after a migration the outage shows
+// how long each transport's existing connection took to carry requests
+// again rather than how fast a fresh dial works.
+type signalProbe struct {
+	name   string
+	url    string
+	client *http.Client
+
+	mu         sync.Mutex
+	up         bool
+	failures   uint64
+	downSince  time.Time
+	lastOutage time.Duration
+	rtt        time.Duration
+}
+
+type probeMetrics struct {
+	Up       bool    `json:"up"`
+	Failures uint64  `json:"failures"`
+	OutageMs float64 `json:"last_outage_ms"`
+	RttMs    float64 `json:"rtt_ms"`
+}
+
+// probes is set once in main, before the metrics endpoint starts.
+var probes []*signalProbe
+
+func newSignalProbes() []*signalProbe {
+	ps := []*signalProbe{{
+		name:   "tcp",
+		url:    *serverURL + "/health",
+		client: &http.Client{Timeout: *probeTimeout},
+	}}
+	if *h3Server != "" {
+		ps = append(ps, &signalProbe{
+			name: "h3",
+			url:  *h3Server + "/health",
+			client: &http.Client{
+				Timeout: *probeTimeout,
+				Transport: &http3.Transport{
+					// The server's certificate is self-signed by default;
+					// the probe measures reachability, not identity.
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QUICConfig: &quic.Config{
+						MaxIdleTimeout:  30 * time.Second,
+						KeepAlivePeriod: time.Second,
+					},
+				},
+			},
+		})
+	}
+	return ps
+}
+
+func (p *signalProbe) run(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.probe(ctx)
+		}
+	}
+}
+
+func (p *signalProbe) probe(ctx context.Context) {
+	start := time.Now()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	resp, err := p.client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failures++
+		if p.up || p.downSince.IsZero() {
+			p.downSince = start
+			log.Printf("[probe-%s] signaling down: %v", p.name, err)
+		}
+		p.up = false
+		return
+	}
+	if !p.up && !p.downSince.IsZero() {
+		p.lastOutage = now.Sub(p.downSince)
+		log.Printf("[probe-%s] signaling back after %s", p.name, p.lastOutage.Round(time.Millisecond))
+	}
+	p.up, p.downSince, p.rtt = true, time.Time{}, now.Sub(start)
+}
+
+func (p *signalProbe) metrics() probeMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return probeMetrics{
+		Up:       p.up,
+		Failures: p.failures,
+		OutageMs: float64(p.lastOutage) / 1e6,
+		RttMs:    float64(p.rtt) / 1e6,
+	}
+}
+
+func probeSnapshot() map[string]probeMetrics {
+	if len(probes) == 0 {
+		return nil
+	}
+	m := make(map[string]probeMetrics, len(probes))
+	for _, p := range probes {
+		m[p.name] = p.metrics()
+	}
+	return m
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadConfig is the -config file. Every field is optional; fields left
+// out keep their current value. Example:
+//
+//	{"connections": 8, "interval": "500ms", "server": "http://192.168.12.2:8080"}
+//
+// The file is read at startup (overriding the flags) and again on SIGHUP.
+// Existing connections are never dropped by a reload: a new server URL only
+// applies to peers that connect or reconnect afterwards. "rid" and "tid"
+// switch the simulcast layer of live peers and of future connections.
+type reloadConfig struct {
+	Connections *int    `json:"connections"`
+	Interval    *string `json:"interval"`
+	Server      *string `json:"server"`
+	RID         *string `json:"rid"`
+	TID         *int    `json:"tid"`
+}
+
+func loadReloadConfig(path string) (reloadConfig, error) {
+	var rc reloadConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rc, err
+	}
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return rc, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if rc.Connections != nil && *rc.Connections < 0 {
+		return rc, fmt.Errorf("connections must be >= 0, got %d", *rc.Connections)
+	}
+	if rc.RID != nil && *rc.RID != "f" && *rc.RID != "h" && *rc.RID != "q" {
+		return rc, fmt.Errorf("rid must be f, h or q, got %q", *rc.RID)
+	}
+	if rc.Interval != nil {
+		if d, err := time.ParseDuration(*rc.Interval); err != nil || d <= 0 {
+			return rc, fmt.Errorf("invalid interval %q", *rc.Interval)
+		}
+	}
+	return rc, nil
+}
+
+// currentServer holds the server base URL used for new (re)connections.
+var currentServer atomic.Value
+
+func serverBase() string { return currentServer.Load().(string) }
+
+// layerPref is the simulcast layer requested on (re)connect.
+type layerPref struct {
+	rid string
+	tid int
+}
+
+// currentLayer starts from -rid/-tid and changes on reload.
+var currentLayer atomic.Pointer[layerPref]
+
+// peersMu serialises scalePeers so overlapping reloads apply in order.
+var peersMu sync.Mutex
+
+// startPeer connects peer id and starts its loops. On failure conns[id]
+// stays nil, as with the initial ramp-up.
+func startPeer(ctx context.Context, id int) {
+	pctx, pcancel := context.WithCancel(ctx)
+	c := &conn{id: id, cancel: pcancel, snapTime: time.Now(), group: groupOf(id)}
+	c.tr = openTranscript(*transcriptDir, id)
+	if serverOffset != nil {
+		c.delay.fix(*serverOffset)
+	}
+	if !connectWithRetry(pctx, c, serverBase()) {
+		pcancel()
+		c.tr.close()
+		return
+	}
+	connsMu.Lock()
+	conns[id] = c
+	connsMu.Unlock()
+	log.Printf("[conn-%d] connected", id)
+	go runPeer(pctx, c)
+}
+
+// stop closes a peer on purpose, without counting it as a connection drop.
+func (c *conn) stop() {
+	c.connected.Store(false)
+	c.cancel()
+	c.mu.Lock()
+	if c.ws != nil {
+		c.ws.Close()
+	}
+	c.mu.Unlock()
+	c.tr.record("", "stopped", nil)
+	c.tr.close()
+}
+
+// scalePeers grows or shrinks the peer set to target. New peers are ramped
+// up with -ramp-up between them; surplus peers (highest IDs) are closed.
+func scalePeers(ctx context.Context, target int) {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+
+	connsMu.Lock()
+	cur := len(conns)
+	if target < cur {
+		for _, c := range conns[target:] {
+			if c != nil {
+				c.stop()
+			}
+		}
+		conns = conns[:target]
+	} else {
+		conns = append(conns, make([]*conn, target-cur)...)
+	}
+	connsMu.Unlock()
+	if target < cur {
+		log.Printf("Scaled down %d -> %d peers", cur, target)
+	}
+
+	for i := cur; i < target; i++ {
+		startPeer(ctx, i)
+		if ctx.Err() != nil {
+			return
+		}
+		if i < target-1 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(*rampUp):
+			}
+		}
+	}
+}
+
+// applyReload applies a reloaded config. It returns the new report interval
+// if that changed, else 0.
+func applyReload(ctx context.Context, rc reloadConfig) time.Duration {
+	var ival time.Duration
+	if rc.Server != nil && *rc.Server != serverBase() {
+		log.Printf("Reload: server %s -> %s (applies to new connections)", serverBase(), *rc.Server)
+		currentServer.Store(*rc.Server)
+	}
+	if rc.Interval != nil {
+		ival, _ = time.ParseDuration(*rc.Interval)
+		log.Printf("Reload: interval -> %s", ival)
+	}
+	if rc.RID != nil || rc.TID != nil {
+		switchLayers(rc.RID, rc.TID)
+	}
+	if rc.Connections != nil {
+		connsMu.RLock()
+		cur := len(conns)
+		connsMu.RUnlock()
+		if *rc.Connections != cur {
+			log.Printf("Reload: connections %d -> %d", cur, *rc.Connections)
+			go scalePeers(ctx, *rc.Connections)
+		}
+	}
+	return ival
+}
+
+// switchLayers asks every live peer's server for a new simulcast layer and
+// makes it the default for later connections. Peers of a group with its
+// own rid or tid keep theirs.
+func switchLayers(rid *string, tid *int) {
+	cm := controlMsg{Type: "layer", TID: tid}
+	pref := *currentLayer.Load()
+	if rid != nil {
+		pref.rid = *rid
+		cm.RID = *rid
+	}
+	if tid != nil {
+		pref.tid = *tid
+	}
+	currentLayer.Store(&pref)
+	log.Printf("Reload: layer rid=%q tid=%d", pref.rid, pref.tid)
+	connsMu.RLock()
+	defer connsMu.RUnlock()
+	for _, c := range conns {
+		if c != nil && c.connected.Load() && !c.group.pinned {
+			c.sendControlMsg(cm)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// acceptReoffer handles a server reoffer that arrived at at: the peer
+// leaves its connection, without counting a drop, and runPeer redials with
+// the session token. Against a -reconnect run this is server-driven
+// recovery versus the client noticing the broken connection itself.
+func (c *conn) acceptReoffer(at time.Time) {
+	c.reofferAt.Store(at.UnixNano())
+	c.reoffers.Add(1)
+	c.connected.Store(false)
+	log.Printf("[conn-%d] server reoffer, redialing", c.id)
+}
+
+// reofferRecovered ends a pending reoffer on the first video frame after
+// it and publishes the time from push to that frame as reoffer_recovered.
+// Only readLoop calls it.
+func (c *conn) reofferRecovered() {
+	at := c.reofferAt.Swap(0)
+	if at == 0 {
+		return
+	}
+	d := time.Duration(time.Now().UnixNano() - at)
+	if int64(d) > c.reofferMax.Load() {
+		c.reofferMax.Store(int64(d))
+	}
+	log.Printf("[conn-%d] first frame %s after reoffer", c.id, d.Round(time.Millisecond))
+	fields := map[string]any{"peer": c.id, "recovery_ms": float64(d) / 1e6}
+	if c.group.name != "" {
+		fields["group"] = c.group.name
+	}
+	bus.Publish("reoffer_recovered", fields)
+}
+
+// lastNotice is the ts of the last server notice handled per type, so
+// that a notice every peer gets is logged and published once.
+var (
+	lastNoticeMu sync.Mutex
+	lastNotice   = map[string]int64{}
+)
+
+// serverNotice handles a server notice (?notify=1) and publishes it as
+// server_<type>: restored when the server saw its own restore, at ts by
+// its clock, from a clock jump or the orchestrator's -restore-marker, and
+// checkpoint when it quiesced for one with -quiesce-retry-after.
+func serverNotice(typ string, raw []byte) {
+	var n struct {
+		Ts           int64  `json:"ts"`
+		Source       string `json:"source"`
+		RetryAfterMs int64  `json:"retry_after_ms"`
+	}
+	if json.Unmarshal(raw, &n) != nil || n.Ts == 0 {
+		return
+	}
+	lastNoticeMu.Lock()
+	seen := n.Ts <= lastNotice[typ]
+	if !seen {
+		lastNotice[typ] = n.Ts
+	}
+	lastNoticeMu.Unlock()
+	if seen {
+		return
+	}
+	at := time.Unix(0, n.Ts).Format(time.RFC3339Nano)
+	switch typ {
+	case "restored":
+		log.Printf("Server restored at %s (%s)", at, n.Source)
+		bus.Publish("server_restored", map[string]any{"restored_at_ns": n.Ts, "source": n.Source})
+	case "checkpoint":
+		log.Printf("Server quiesced for a checkpoint at %s, new sessions retry after %d ms", at, n.RetryAfterMs)
+		bus.Publish("server_checkpoint", map[string]any{"quiesced_at_ns": n.Ts, "retry_after_ms": n.RetryAfterMs})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/sys/unix"
+)
+
+// setRcvBuf sets SO_RCVBUF on the socket. The kernel doubles the value
+// and caps it at net.core.rmem_max, and a fixed size turns off receive
+// buffer autotuning.
+func setRcvBuf(rc syscall.RawConn) error {
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, *rcvBuf)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// readMessage is ws.ReadMessage, except that with -fast-read the message
+// goes into c.readBuf, grown as needed and reused, instead of a new slice
+// per message. The result is only valid until the next call.
+func (c *conn) readMessage(ws *websocket.Conn) (int, []byte, error) {
+	if !*fastRead {
+		return ws.ReadMessage()
+	}
+	typ, r, err := ws.NextReader()
+	if err != nil {
+		return typ, nil, err
+	}
+	buf := c.readBuf[:0]
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			c.readBuf = buf
+			return typ, buf, nil
+		}
+		if err != nil {
+			return typ, nil, err
+		}
+	}
+}
+
+// rxQueue is the number of bytes in the socket's receive queue that the
+// read loop has not read yet. A queue that stays full means the loadgen,
+// not the network, is what holds the stream back.
+func rxQueue(ws *websocket.Conn) int {
+	tc, ok := ws.UnderlyingConn().(*net.TCPConn)
+	if !ok {
+		return 0
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var n int
+	rc.Control(func(fd uintptr) {
+		n, _ = unix.IoctlGetInt(int(fd), unix.SIOCINQ)
+	})
+	return n
+}
+
+// rxQueue is rxQueue of the peer's current connection, 0 when it has none.
+func (c *conn) rxQueue() int {
+	c.mu.Lock()
+	ws := c.ws
+	c.mu.Unlock()
+	if ws == nil || !c.connected.Load() {
+		return 0
+	}
+	return rxQueue(ws)
+}
+
+// clientDropCounters are the TcpExt counters of segments this host's TCP
+// stack dropped on receive because a socket's buffers were full: the
+// receive queue (TCPRcvQDrop), the backlog while the reader held the
+// socket (TCPBacklogDrop), pruning (RcvPruned) and the out-of-order queue
+// (TCPOFODrop). Over TCP the client never loses a frame, but these drops
+// are retransmitted and look like network loss from the outside.
+var clientDropCounters = []string{"TCPRcvQDrop", "TCPBacklogDrop", "RcvPruned", "TCPOFODrop"}
+
+// clientDrops sums clientDropCounters from /proc/net/netstat. They count
+// every socket in the loadgen's network namespace; dropsBase, taken at
+// startup, keeps earlier drops out.
+func clientDrops() uint64 {
+	f, err := os.Open("/proc/net/netstat")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	// The file is pairs of lines, names then values, per protocol.
+	var names []string
+	var sum uint64
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] != "TcpExt:" {
+			continue
+		}
+		if names == nil {
+			names = fields
+			continue
+		}
+		for i, name := range names {
+			for _, want := range clientDropCounters {
+				if name == want && i < len(fields) {
+					n, _ := strconv.ParseUint(fields[i], 10, 64)
+					sum += n
+				}
+			}
+		}
+		break
+	}
+	return sum
+}
+
+// dropsBase is clientDrops at startup.
+var dropsBase uint64
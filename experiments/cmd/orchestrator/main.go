@@ -0,0 +1,566 @@
+// Command orchestrator runs one cross-node CRIU migration of the stream
+// server: checkpoint on the source, transfer over the direct link, restore
+// on the target, re-plumb the macvlan, retarget the switch, then write
+// migration_timing.txt and touch the collector's migration flag. It does
+// what cr_hw.sh does, with each phase timed in-process instead of through
+// date +%s%N around SSH calls.
+//
+// With -count N it runs N migrations back and forth between the two nodes
+// (ping-pong), -interval apart, writing one numbered timing file each.
+// Events, timings and the migration flag carry the migration's number and
+// direction, so the collector's samples can be grouped by migration.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/p4rt"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+var (
+	sourceAddr    = flag.String("source", "", "SSH destination of the source node (empty = run locally on the source)")
+	targetAddr    = flag.String("target", "", "SSH destination of the target node (required)")
+	targetDirect  = flag.String("target-direct", "", "Target address on the direct link, used by the source for the transfer (default: -target)")
+	container     = flag.String("container", "stream-server", "Container to migrate")
+	engineName    = flag.String("engine", "podman", "Container engine on both nodes: "+podman.Engines+" (docker: -mode stop only)")
+	renameTo      = flag.String("rename", "", "Rename the restored container to this (empty = keep the name)")
+	checkpointDir = flag.String("checkpoint-dir", "/tmp/checkpoints", "Directory for checkpoint.tar on both nodes")
+	transferVia   = flag.String("transfer", transferRsync, "How the source sends the checkpoint to the target: rsync, tar (streamed over ssh), scp, or shared (no copy, see -shared-dir)")
+	sharedDir     = flag.String("shared-dir", "", "Shared (NFS) directory mounted on both nodes, used instead of -checkpoint-dir with -transfer shared")
+	progressEvery = flag.Duration("progress-interval", time.Second, "Log and publish transfer progress this often (0 = never)")
+	skipVerify    = flag.Bool("skip-verify", false, "Do not compare the checkpoint size on the target after the transfer")
+	quiesce       = flag.Bool("quiesce", true, "SIGUSR2 the server before checkpoint and after restore so send queues drain")
+	drainTimeout  = flag.Duration("drain-timeout", 2*time.Second, "Longest to wait for the server's TCP send queues to drain before checkpoint")
+	targetNIC     = flag.String("target-nic", "", "Recreate the restored container's eth0 as a macvlan on this target NIC (empty = leave the network alone)")
+	serverIP      = flag.String("server-ip", "192.168.12.2", "Server IP kept across the migration")
+	serverMAC     = flag.String("server-mac", "02:42:c0:a8:0c:02", "Server MAC kept across the migration")
+	prefixLen     = flag.Int("prefix-len", 24, "Prefix length of -server-ip on the recreated eth0")
+	controllerURL = flag.String("controller-url", "", "P4 controller base URL for /updateForward (empty = do not touch the switch)")
+	switchGRPC    = flag.String("switch-grpc", "", "Retarget the forward entries directly over BF Runtime gRPC at this address instead of -controller-url")
+	targetSwPort  = flag.Int("target-sw-port", 148, "Switch port of the target node")
+	migrationFlag = flag.String("migration-flag", "/tmp/collector_migration_flag", "File touched on both nodes once the migration is done (empty = none)")
+	timingFile    = flag.String("timing-file", "migration_timing.txt", "Where to write the key=value phase timings")
+	sshOptions    = flag.String("ssh-opts", "-o BatchMode=yes -o StrictHostKeyChecking=no -o ConnectTimeout=10", "Options passed to every ssh call")
+	timeout       = flag.Duration("timeout", 5*time.Minute, "Abort each migration after this long (pauses between -count migrations do not count)")
+	garp          = flag.Bool("garp", true, "Send gratuitous ARP / unsolicited NA for -server-ip from the restored container and record when")
+	restoreMarker = flag.String("restore-marker", "", "Create this file inside the restored container (the server's -restore-marker) so the server announces the restore itself (empty = none)")
+	garpBin       = flag.String("garp-bin", "/tmp/p4cf-garp", "cmd/garp binary on the target (falls back to arping when missing)")
+	eventBusAddr  = flag.String("event-bus", "", "Publish phase events (migration_started, checkpoint_start, checkpoint_done, ...) to the collector's event bus at this address (host:port); the collector marks them in its CSV")
+	mode          = flag.String("mode", modeStop, "Migration mode: stop (dump all, copy, restore), precopy (a pre-dump while running, then only dirty pages) or lazy (post-copy restore with CRIU lazy-pages)")
+	preDumps      = flag.Int("pre-dumps", 1, "Pre-checkpoint rounds before the final checkpoint (-mode precopy); only 1 is supported, podman cannot chain them")
+	lazyWait      = flag.Duration("lazy-wait", 30*time.Second, "How long to wait after the restore for all lazy pages to arrive (-mode lazy)")
+	count         = flag.Int("count", 1, "Migrations to run, alternating between source and target (ping-pong)")
+	pause         = flag.Duration("interval", 30*time.Second, "Pause between migrations with -count > 1")
+	firstNumber   = flag.Int("number", 1, "Number of the first migration, tagged on events, timings and the migration flag")
+	direction     = flag.String("direction", "forward", "Direction tag of the first migration (forward or back); later ones alternate")
+	sourceDirect  = flag.String("source-direct", "", "Source address on the direct link, for migrations back with -count > 1 (default: -source)")
+	sourceNIC     = flag.String("source-nic", "", "Like -target-nic, for migrations back to the source")
+	sourceSwPort  = flag.Int("source-sw-port", 0, "Switch port of the source node, for migrations back with -count > 1")
+)
+
+// bus is the -event-bus client; nil (discarding) without the flag.
+var bus *eventbus.Client
+
+// engine is the -engine container CLI.
+var engine podman.Engine
+
+var httpClient = &http.Client{Timeout: 4 * time.Second}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *targetAddr == "" {
+		log.Fatal("-target is required")
+	}
+	switch *transferVia {
+	case transferRsync, transferTar, transferSCP:
+	case transferShared:
+		if *sharedDir == "" {
+			log.Fatal("-transfer shared needs -shared-dir")
+		}
+	default:
+		log.Fatalf("-transfer must be rsync, tar, scp or shared, got %q", *transferVia)
+	}
+	if err := checkMode(); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	if engine, err = podman.NewEngine(*engineName); err != nil {
+		log.Fatalf("-engine: %v", err)
+	}
+	if engine.Name() == "docker" && *mode != modeStop {
+		log.Fatalf("-engine docker has no pre-checkpoints or lazy pages; -mode %s needs podman", *mode)
+	}
+	if *targetDirect == "" {
+		*targetDirect = *targetAddr
+	}
+	if *renameTo == "" {
+		*renameTo = *container
+	}
+	if *count > 1 {
+		if *sourceDirect == "" {
+			*sourceDirect = *sourceAddr
+		}
+		if *sourceDirect == "" {
+			log.Fatal("-source-direct is required with -count > 1 when running on the source")
+		}
+		if *sourceSwPort == 0 && (*controllerURL != "" || *switchGRPC != "") {
+			log.Fatal("-source-sw-port is required with -count > 1 when the switch is updated")
+		}
+	}
+	if *direction != "forward" && *direction != "back" {
+		log.Fatalf("-direction must be forward or back, got %q", *direction)
+	}
+
+	mux, err := sshmux.New(*sshOptions, 30*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mux.Close()
+
+	// ctx ends on a signal; each migration gets its own -timeout below, so
+	// the pauses of a -count run do not eat into it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() { <-sigCh; log.Println("Interrupted"); cancel() }()
+
+	if *eventBusAddr != "" {
+		if bus, err = eventbus.Dial(*eventBusAddr, "orchestrator"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+	}
+
+	source, target := mux.Host("source", *sourceAddr), mux.Host("target", *targetAddr)
+	for i := 0; i < *count; i++ {
+		m := &migration{
+			src: source, dst: target,
+			targetDirect: *targetDirect, targetNIC: *targetNIC, targetSwPort: *targetSwPort,
+			container: *container, renameTo: *renameTo,
+			number: *firstNumber + i, direction: *direction,
+		}
+		// Every other migration goes back, and after the first the
+		// container already carries its new name.
+		if i%2 == 1 {
+			m.src, m.dst = target, source
+			m.targetDirect, m.targetNIC, m.targetSwPort = *sourceDirect, *sourceNIC, *sourceSwPort
+			m.direction = map[string]string{"forward": "back", "back": "forward"}[*direction]
+		}
+		if i > 0 {
+			m.container = *renameTo
+		}
+		path := timingPath(m.number)
+		if *count > 1 {
+			log.Printf("Migration %d (%d/%d), %s: %s -> %s", m.number, i+1, *count, m.direction, m.src.Name, m.dst.Name)
+		}
+		bus.Tag("migration", m.number)
+		bus.Tag("direction", m.direction)
+		mctx, mcancel := context.WithTimeout(ctx, *timeout)
+		err := m.run(mctx)
+		mcancel()
+		if werr := m.t.write(path); werr != nil {
+			log.Printf("write %s: %v", path, werr)
+		}
+		if err != nil {
+			bus.Publish("migration_failed", map[string]any{"error": err.Error()})
+			bus.Close(2 * time.Second)
+			log.Fatalf("Migration failed: %v", err)
+		}
+		bus.Publish("migration_done", map[string]any{"total_ms": ms(m.t.start, m.t.end)})
+		log.Printf("Migration done: downtime %d ms (checkpoint %d, transfer %d, restore %d, switch %d), timings in %s",
+			ms(m.t.start, m.t.ready()), ms(m.t.start, m.t.checkpointDone),
+			ms(m.t.transferStart, m.t.transferDone), ms(m.t.restoreStart, m.t.restoreDone),
+			ms(m.t.restoreDone, m.t.switchDone), path)
+		if i < *count-1 {
+			if err := sleepCtx(ctx, *pause); err != nil {
+				bus.Close(2 * time.Second)
+				log.Fatalf("Interrupted after migration %d", m.number)
+			}
+		}
+	}
+	bus.Close(2 * time.Second)
+}
+
+// timingPath is -timing-file, numbered like the runner's
+// migration_timing_<n>.txt when several migrations run.
+func timingPath(number int) string {
+	if *count == 1 {
+		return *timingFile
+	}
+	ext := filepath.Ext(*timingFile)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(*timingFile, ext), number, ext)
+}
+
+type migration struct {
+	src, dst sshmux.Host
+	// Where the container goes in this migration: the target's address on
+	// the direct link, the NIC for the re-plumbed eth0 and its switch port.
+	targetDirect string
+	targetNIC    string
+	targetSwPort int
+	// container is the name on the source, renameTo the one after restore.
+	container, renameTo string
+	// number and direction tag a migration of a ping-pong series.
+	number    int
+	direction string
+	t         timing
+	// lazyDone receives the time the lazy-pages daemon exits (-mode lazy).
+	lazyDone chan time.Time
+}
+
+func (m *migration) tarPath() string { return filepath.Join(archiveDir(), "checkpoint.tar") }
+
+func (m *migration) run(ctx context.Context) error {
+	// Target prep overlaps with the checkpoint.
+	var prepErr error
+	prepDone := make(chan struct{})
+	go func() {
+		script := fmt.Sprintf("sudo mkdir -p %[1]s && sudo chmod 777 %[1]s && sudo rm -f %[2]s %[3]s && ",
+			*checkpointDir, m.tarPath(), m.preTarPath())
+		if *transferVia == transferShared {
+			// The source is writing to the same files right now.
+			script = ""
+		}
+		_, prepErr = m.dst.Run(ctx, script+"sudo mkdir -p /etc/criu && echo skip-in-flight | sudo tee /etc/criu/default.conf >/dev/null")
+		close(prepDone)
+	}()
+
+	// Pre-copy runs while the server still serves; the migration proper,
+	// and migration_start_ns, begin with the final checkpoint.
+	if *mode == modePrecopy {
+		<-prepDone
+		if prepErr != nil {
+			return fmt.Errorf("target prep: %w", prepErr)
+		}
+		if err := m.precopy(ctx); err != nil {
+			return err
+		}
+	}
+
+	m.t.start = time.Now()
+	m.t.set("source_node", hostLabel(m.src))
+	m.t.set("target_node", hostLabel(m.dst))
+	m.t.set("server_ip", *serverIP)
+	m.t.set("target_sw_port", m.targetSwPort)
+	m.t.set("transfer_method", *transferVia)
+	if *transferVia == transferShared {
+		m.t.set("shared_dir", *sharedDir)
+	}
+	m.t.set("migration_mode", *mode)
+	m.t.set("migration_number", m.number)
+	m.t.set("migration_direction", m.direction)
+	bus.Publish("migration_started", map[string]any{
+		"source_node": hostLabel(m.src), "target_node": hostLabel(m.dst), "container": m.container,
+		"migration_start_ns": m.t.start.UnixNano(), "mode": *mode,
+	})
+
+	bus.Publish("checkpoint_start", nil)
+	if err := m.checkpoint(ctx); err != nil {
+		return err
+	}
+	m.t.checkpointDone = time.Now()
+	log.Printf("Checkpoint done in %d ms", ms(m.t.start, m.t.checkpointDone))
+	bus.Publish("checkpoint_done", map[string]any{"checkpoint_ms": ms(m.t.start, m.t.checkpointDone)})
+
+	<-prepDone
+	if prepErr != nil {
+		return fmt.Errorf("target prep: %w", prepErr)
+	}
+	if err := m.transfer(ctx); err != nil {
+		return err
+	}
+	log.Printf("Transfer of %d bytes done in %d ms (%.0f Mbit/s)", m.t.checkpointSize, ms(m.t.transferStart, m.t.transferDone), m.t.transferMbps)
+	bus.Publish("transfer_done", map[string]any{
+		"transfer_ms": ms(m.t.transferStart, m.t.transferDone), "bytes": m.t.checkpointSize,
+		"throughput_mbps": m.t.transferMbps,
+	})
+
+	// The source container still answers ARP for the server IP; it has to
+	// be gone before the restored one comes up.
+	m.src.Try(ctx, engine.RemoveCmd(m.container))
+
+	m.t.restoreStart = time.Now()
+	bus.Publish("restore_start", nil)
+	if err := m.restore(ctx); err != nil {
+		return err
+	}
+	m.t.restoreDone = time.Now()
+	log.Printf("Restore done in %d ms", ms(m.t.restoreStart, m.t.restoreDone))
+	bus.Publish("restore_done", map[string]any{"restore_ms": ms(m.t.restoreStart, m.t.restoreDone)})
+
+	if *controllerURL != "" || *switchGRPC != "" {
+		if err := updateForward(ctx, m.targetSwPort); err != nil {
+			log.Printf("WARNING: switch update: %v", err)
+		} else {
+			m.t.switchDone = time.Now()
+			bus.Publish("switch_updated", map[string]any{"switch_ms": ms(m.t.restoreDone, m.t.switchDone)})
+		}
+	}
+
+	if *migrationFlag != "" {
+		// The collector tags the samples after the flag with these.
+		mark := fmt.Sprintf("printf 'migration=%d\\ndirection=%s\\n' > %s", m.number, m.direction, *migrationFlag)
+		m.src.Try(ctx, mark)
+		m.dst.Try(ctx, mark)
+	}
+	m.t.end = time.Now()
+
+	if m.lazyDone != nil {
+		select {
+		case m.t.lazyDone = <-m.lazyDone:
+			log.Printf("All lazy pages in %d ms after restore", ms(m.t.restoreDone, m.t.lazyDone))
+			bus.Publish("lazy_pages_done", map[string]any{"lazy_pages_ms": ms(m.t.restoreDone, m.t.lazyDone)})
+		case <-time.After(*lazyWait):
+			log.Printf("WARNING: lazy pages still arriving %s after restore", *lazyWait)
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+func (m *migration) checkpoint(ctx context.Context) error {
+	m.src.Try(ctx, "sudo mkdir -p /etc/criu && echo skip-in-flight | sudo tee /etc/criu/default.conf >/dev/null")
+	if *quiesce {
+		if _, err := m.src.Run(ctx, engine.KillCmd(m.container, "SIGUSR2")); err != nil {
+			return fmt.Errorf("quiesce: %w", err)
+		}
+		m.waitDrained(ctx)
+	}
+	_, err := m.src.Run(ctx, fmt.Sprintf("sudo mkdir -p %s && %s", archiveDir(),
+		engine.CheckpointCmd(m.container, podman.CheckpointOptions{Export: m.tarPath(), WithPrevious: *mode == modePrecopy})))
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
+// waitDrained polls the server's established sockets until their send
+// queues are empty: CRIU has to replay unsent data on restore, which is
+// the step that fails when the new path is not up yet.
+func (m *migration) waitDrained(ctx context.Context) {
+	pid, err := engine.PID(ctx, m.src, m.container)
+	if err != nil {
+		time.Sleep(200 * time.Millisecond)
+		return
+	}
+	start := time.Now()
+	deadline := start.Add(*drainTimeout)
+	for {
+		out, err := m.src.Run(ctx, fmt.Sprintf(
+			"sudo nsenter -t %s -n ss -tn state established | awk 'NR>1{s+=$2} END{print s+0}'", pid))
+		if err == nil && out == "0" {
+			log.Printf("Send queues drained in %d ms", ms(start, time.Now()))
+			return
+		}
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			log.Printf("WARNING: send queues still hold %s bytes after %s", out, *drainTimeout)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (m *migration) transfer(ctx context.Context) error {
+	out, err := m.src.Run(ctx, "sudo stat -c%s "+m.tarPath())
+	if err != nil {
+		return fmt.Errorf("stat checkpoint: %w", err)
+	}
+	m.t.checkpointSize, _ = strconv.ParseInt(out, 10, 64)
+
+	m.t.transferStart = time.Now()
+	bus.Publish("transfer_start", map[string]any{"bytes": m.t.checkpointSize})
+	s, err := m.sendFile(ctx, m.tarPath(), m.t.checkpointSize, "checkpoint")
+	if err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
+	m.t.transferDone = time.Now()
+	m.t.transferMbps = s.mbps()
+
+	if *skipVerify {
+		return nil
+	}
+	out, err = m.dst.Run(ctx, "stat -c%s "+m.tarPath())
+	if err != nil {
+		return fmt.Errorf("verify transfer: %w", err)
+	}
+	if got, _ := strconv.ParseInt(out, 10, 64); got != m.t.checkpointSize {
+		return fmt.Errorf("verify transfer: got %d bytes, expected %d", got, m.t.checkpointSize)
+	}
+	return nil
+}
+
+func (m *migration) restore(ctx context.Context) error {
+	opts := podman.RestoreOptions{Import: m.tarPath(), Name: m.container}
+	switch *mode {
+	case modePrecopy:
+		opts.ImportPrevious = m.preTarPath()
+	case modeLazy:
+		done, err := m.startLazyDaemon(ctx)
+		if err != nil {
+			m.resetCRIUConfig(ctx)
+			return fmt.Errorf("lazy-pages: %w", err)
+		}
+		m.lazyDone = done
+		defer m.resetCRIUConfig(ctx)
+	}
+	_, err := m.dst.Run(ctx, engine.RemoveCmd(m.renameTo, m.container)+" 2>/dev/null; "+engine.RestoreCmd(opts))
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	if m.renameTo != m.container {
+		m.dst.Try(ctx, engine.RenameCmd(m.container, m.renameTo))
+	}
+	if m.targetNIC != "" || *garp || *restoreMarker != "" {
+		pid, err := engine.PID(ctx, m.dst, m.renameTo)
+		if err != nil {
+			return err
+		}
+		if *restoreMarker != "" {
+			// Through the process's root, as the container's image may
+			// have no touch.
+			m.dst.Try(ctx, fmt.Sprintf("sudo touch /proc/%s/root%s", pid, *restoreMarker))
+		}
+		if m.targetNIC != "" {
+			if err := m.replumb(ctx, pid); err != nil {
+				return fmt.Errorf("re-plumb eth0: %w", err)
+			}
+		}
+		if *garp {
+			if err := m.announce(ctx, pid); err != nil {
+				log.Printf("WARNING: gratuitous ARP: %v", err)
+			} else {
+				log.Printf("Gratuitous ARP sent %d ms after restore start", ms(m.t.restoreStart, m.t.garpSent))
+				bus.Publish("garp_sent", map[string]any{"garp_sent_ns": m.t.garpSent.UnixNano()})
+			}
+		}
+	}
+	if *quiesce {
+		m.dst.Try(ctx, engine.KillCmd(m.renameTo, "SIGUSR2"))
+	}
+	return nil
+}
+
+// replumb recreates eth0 in the restored container. CRIU restores the
+// macvlan against the source host's NIC index, so it is replaced with one
+// on the target NIC carrying the same MAC and IP, keeping peers' ARP
+// caches valid.
+func (m *migration) replumb(ctx context.Context, pid string) error {
+	ns := "sudo nsenter -t " + pid + " -n "
+	_, err := m.dst.Run(ctx, fmt.Sprintf(`set -e
+%[1]sip link del eth0 2>/dev/null || true
+sudo ip link add cr_mv_eth0 link %[2]s address %[3]s type macvlan mode vepa
+sudo ip link set cr_mv_eth0 netns %[4]s
+%[1]sip link set cr_mv_eth0 name eth0
+%[1]sip addr add %[5]s/%[6]d dev eth0
+%[1]sip link set eth0 up
+%[1]sip route flush cache 2>/dev/null || true
+%[1]sip tcp_metrics flush all 2>/dev/null || true`,
+		ns, m.targetNIC, *serverMAC, pid, *serverIP, *prefixLen))
+	return err
+}
+
+// announce sends gratuitous ARP (unsolicited NA for IPv6) for the server
+// IP from the restored container's namespace, so no upstream cache keeps
+// pointing at the source, and records when it went out. It uses
+// cmd/garp at -garp-bin and falls back to arping (IPv4 only) when the
+// target does not have it.
+func (m *migration) announce(ctx context.Context, pid string) error {
+	out, err := m.dst.Run(ctx, fmt.Sprintf(`if [ -x %[1]s ]; then
+sudo %[1]s -pid %[2]s -iface eth0 -ip %[3]s -mac %[4]s && echo garp_method=garp
+else
+echo garp_sent_ns=$(date +%%s%%N); (sudo nsenter -t %[2]s -n arping -U -c 2 -I eth0 %[3]s >/dev/null 2>&1 &); echo garp_method=arping
+fi`, *garpBin, pid, *serverIP, *serverMAC))
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		k, v, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch k {
+		case "garp_sent_ns":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("garp_sent_ns %q: %w", v, err)
+			}
+			m.t.garpSent = time.Unix(0, n)
+		case "garp_method":
+			m.t.set(k, v)
+		}
+	}
+	if m.t.garpSent.IsZero() {
+		return fmt.Errorf("no send time in %q", out)
+	}
+	return nil
+}
+
+// updateForward points the switch's forward entries for the server IP at
+// the target's switch port, through the controller or straight over gRPC.
+func updateForward(ctx context.Context, port int) error {
+	if *switchGRPC != "" {
+		return retargetGRPC(ctx, port)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"ipv4":    *serverIP,
+		"sw_port": port,
+		"dst_mac": *serverMAC,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *controllerURL+"/updateForward", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func retargetGRPC(ctx context.Context, port int) error {
+	ip, err := netip.ParseAddr(*serverIP)
+	if err != nil {
+		return err
+	}
+	mac, err := net.ParseMAC(*serverMAC)
+	if err != nil {
+		return err
+	}
+	c, err := p4rt.Dial(ctx, *switchGRPC, 0, 7, "")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Retarget(ctx, ip, uint16(port), mac)
+}
+
+func hostLabel(h sshmux.Host) string {
+	if h.Addr == "" {
+		if name, err := os.Hostname(); err == nil {
+			return name
+		}
+		return "local"
+	}
+	return h.Addr
+}
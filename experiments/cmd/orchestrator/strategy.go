@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/podman"
+)
+
+// Migration modes (-mode).
+//
+// stop: checkpoint everything with the container frozen, transfer,
+// restore. The default and what cr_hw.sh does.
+//
+// precopy: one memory-only pre-checkpoint (podman --pre-checkpoint) while
+// the server keeps running, sent to the target. The final checkpoint then
+// only dumps the pages dirtied since the pre-dump (--with-previous), and
+// the target restores from both (--import-previous). podman cannot chain
+// pre-checkpoints (--with-previous is refused with --pre-checkpoint, and
+// restore imports a single previous archive), so there is one round.
+//
+// lazy: a normal checkpoint and transfer, but the restore is post-copy:
+// CRIU restores with --lazy-pages and a `criu lazy-pages` daemon on the
+// target faults memory in from the checkpoint images on demand, so the
+// process runs before all of its memory is back. Needs userfaultfd on the
+// target kernel.
+const (
+	modeStop    = "stop"
+	modePrecopy = "precopy"
+	modeLazy    = "lazy"
+)
+
+// lazyDir holds the lazy-pages daemon's copy of the images and the work
+// dir whose socket CRIU's restore connects to.
+const lazyDir = "/tmp/p4cf-lazy"
+
+func (m *migration) preTarPath() string {
+	return filepath.Join(archiveDir(), "pre-checkpoint.tar")
+}
+
+// precopy runs the pre-dump and sends it to the target. Its dump and
+// transfer time and size go into the timing file as pre_dump_*.
+func (m *migration) precopy(ctx context.Context) error {
+	m.t.precopyStart = time.Now()
+	_, err := m.src.Run(ctx, engine.CheckpointCmd(m.container, podman.CheckpointOptions{Export: m.preTarPath(), PreCheckpoint: true}))
+	if err != nil {
+		return fmt.Errorf("pre-dump: %w", err)
+	}
+	dumped := time.Now()
+	out, err := m.src.Run(ctx, "sudo stat -c%s "+m.preTarPath())
+	if err != nil {
+		return fmt.Errorf("stat pre-dump: %w", err)
+	}
+	size, _ := strconv.ParseInt(out, 10, 64)
+	s, err := m.sendFile(ctx, m.preTarPath(), size, "pre-dump")
+	if err != nil {
+		return fmt.Errorf("pre-dump transfer: %w", err)
+	}
+	dumpMs := ms(m.t.precopyStart, dumped)
+	m.t.set("pre_dump_ms", dumpMs)
+	m.t.set("pre_dump_transfer_ms", s.duration.Milliseconds())
+	m.t.set("pre_dump_bytes", size)
+	m.t.set("pre_dump_throughput_mbps", fmt.Sprintf("%.1f", s.mbps()))
+	log.Printf("Pre-dump: %d bytes, dump %d ms, transfer %d ms (%.0f Mbit/s)", size, dumpMs, s.duration.Milliseconds(), s.mbps())
+	bus.Publish("pre_dump_done", map[string]any{
+		"bytes": size, "dump_ms": dumpMs, "transfer_ms": s.duration.Milliseconds(), "throughput_mbps": s.mbps(),
+	})
+	m.t.precopyDone = time.Now()
+	return nil
+}
+
+// startLazyDaemon unpacks the checkpoint images on the target, points
+// CRIU's restore at the daemon's work dir and starts `criu lazy-pages`.
+// The daemon exits once every page has been faulted in; lazyDone then
+// receives the time.
+func (m *migration) startLazyDaemon(ctx context.Context) (lazyDone chan time.Time, err error) {
+	_, err = m.dst.Run(ctx, fmt.Sprintf(`set -e
+sudo rm -rf %[1]s && sudo mkdir -p %[1]s/images %[1]s/work
+sudo tar -C %[1]s/images --strip-components=1 -xf %[2]s checkpoint
+printf 'skip-in-flight\nlazy-pages\nwork-dir %[1]s/work\n' | sudo tee /etc/criu/default.conf >/dev/null`,
+		lazyDir, m.tarPath()))
+	if err != nil {
+		return nil, err
+	}
+	daemon := m.dst.Command(ctx, fmt.Sprintf(
+		"sudo criu lazy-pages -D %[1]s/images -W %[1]s/work -o %[1]s/lazy-pages.log", lazyDir))
+	if err := daemon.Start(); err != nil {
+		return nil, err
+	}
+	lazyDone = make(chan time.Time, 1)
+	exited := make(chan struct{})
+	go func() {
+		err := daemon.Wait()
+		lazyDone <- time.Now()
+		if err != nil {
+			log.Printf("WARNING: criu lazy-pages: %v (see %s/lazy-pages.log on the target)", err, lazyDir)
+		}
+		close(exited)
+	}()
+	// The socket appears once the daemon has read the images.
+	for {
+		if _, err := m.dst.Run(ctx, fmt.Sprintf("sudo test -S %s/work/lazy-pages.socket", lazyDir)); err == nil {
+			return lazyDone, nil
+		}
+		select {
+		case <-exited:
+			return nil, fmt.Errorf("criu lazy-pages exited before listening (see %s/lazy-pages.log on the target)", lazyDir)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// resetCRIUConfig puts the target's CRIU config back to the plain one, so
+// the next restore on it is not lazy.
+func (m *migration) resetCRIUConfig(ctx context.Context) {
+	m.dst.Try(ctx, "echo skip-in-flight | sudo tee /etc/criu/default.conf >/dev/null")
+}
+
+func checkMode() error {
+	switch *mode {
+	case modeStop, modeLazy:
+	case modePrecopy:
+		if *preDumps != 1 {
+			return fmt.Errorf("-pre-dumps must be 1 with -mode precopy (podman cannot chain pre-checkpoints), got %d", *preDumps)
+		}
+	default:
+		return fmt.Errorf("-mode must be one of %s, got %q", strings.Join([]string{modeStop, modePrecopy, modeLazy}, ", "), *mode)
+	}
+	return nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
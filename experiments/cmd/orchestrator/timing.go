@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// timing records when each migration phase finished. It is written as
+// the key=value migration_timing.txt that cr_hw.sh produces, so the
+// analysis scripts read either one.
+type timing struct {
+	start, checkpointDone, transferDone, restoreDone, switchDone, end time.Time
+	// transferStart excludes target preparation and the size check.
+	transferStart time.Time
+	// restoreStart is after the source container is gone.
+	restoreStart time.Time
+	// garpSent is when the first gratuitous ARP left the target.
+	garpSent time.Time
+	// precopyStart/Done bracket the pre-dump (-mode precopy), which
+	// runs before start.
+	precopyStart, precopyDone time.Time
+	// lazyDone is when the last lazy page arrived (-mode lazy).
+	lazyDone       time.Time
+	checkpointSize int64
+	// transferMbps is the checkpoint transfer's achieved throughput.
+	transferMbps float64
+	fields       [][2]string
+}
+
+// set adds a descriptive key (node names, method, ...) to the file.
+func (t *timing) set(key string, value any) {
+	t.fields = append(t.fields, [2]string{key, fmt.Sprint(value)})
+}
+
+func ms(from, to time.Time) int64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return to.Sub(from).Milliseconds()
+}
+
+func ns(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func (t *timing) write(path string) error {
+	var b strings.Builder
+	kv := func(k string, v any) { fmt.Fprintf(&b, "%s=%v\n", k, v) }
+	kv("migration_start_ns", ns(t.start))
+	kv("checkpoint_done_ns", ns(t.checkpointDone))
+	kv("transfer_done_ns", ns(t.transferDone))
+	kv("restore_done_ns", ns(t.restoreDone))
+	kv("switch_update_done_ns", ns(t.switchDone))
+	kv("garp_sent_ns", ns(t.garpSent))
+	kv("migration_end_ns", ns(t.end))
+	kv("total_ms", ms(t.start, t.end))
+	kv("checkpoint_ms", ms(t.start, t.checkpointDone))
+	kv("transfer_ms", ms(t.transferStart, t.transferDone))
+	kv("restore_ms", ms(t.restoreStart, t.restoreDone))
+	kv("switch_ms", ms(t.restoreDone, t.switchDone))
+	kv("garp_ms", ms(t.restoreStart, t.garpSent))
+	kv("checkpoint_size_bytes", t.checkpointSize)
+	kv("transfer_throughput_mbps", fmt.Sprintf("%.1f", t.transferMbps))
+	kv("precopy_start_ns", ns(t.precopyStart))
+	kv("precopy_ms", ms(t.precopyStart, t.precopyDone))
+	kv("lazy_pages_done_ns", ns(t.lazyDone))
+	kv("lazy_pages_ms", ms(t.restoreDone, t.lazyDone))
+	for _, f := range t.fields {
+		kv(f[0], f[1])
+	}
+	kv("time_to_ready_ms", ms(t.start, t.ready()))
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// ready is when the migrated server could take traffic: the switch update,
+// or the restore when there was none (no -controller-url or -switch-grpc)
+// or it failed.
+func (t *timing) ready() time.Time {
+	if t.switchDone.IsZero() {
+		return t.restoreDone
+	}
+	return t.switchDone
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Transfer methods (-transfer). rsync and tar report progress while they
+// run; scp is kept for nodes without rsync and reports none.
+//
+// tar streams the file over one ssh connection, `tar | dd | ssh tar -x`,
+// with dd counting the bytes. It skips rsync's checksum pass, which only
+// pays off when the target already has a similar file (precopy).
+//
+// shared writes the checkpoint to -shared-dir, an NFS (or other shared)
+// mount on both nodes, and restores from there: nothing is copied, and
+// the transfer phase is only the wait until the target sees the whole
+// file. Comparing it with the others separates the transfer's share of
+// the downtime from dump and restore.
+const (
+	transferRsync  = "rsync"
+	transferTar    = "tar"
+	transferSCP    = "scp"
+	transferShared = "shared"
+)
+
+// sharedPoll is how often a shared transfer checks the target's view.
+const sharedPoll = 20 * time.Millisecond
+
+// archiveDir is where the checkpoint archives go on both nodes.
+func archiveDir() string {
+	if *transferVia == transferShared {
+		return *sharedDir
+	}
+	return *checkpointDir
+}
+
+// progressRe matches the byte count at the start of a progress line from
+// rsync --info=progress2 ("  12,582,912  37%  120.00MB/s ...") or dd
+// status=progress ("12582912 bytes (13 MB, 12 MiB) copied, ...").
+var progressRe = regexp.MustCompile(`^\s*([\d,]+)\s+(?:bytes|\d+%)`)
+
+func parseProgress(line string) (int64, bool) {
+	m := progressRe.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(m[1], ",", ""), 10, 64)
+	return n, err == nil
+}
+
+// sent is what one sendFile moved.
+type sent struct {
+	bytes    int64
+	duration time.Duration
+}
+
+// mbps is the achieved throughput in megabits per second.
+func (s sent) mbps() float64 {
+	if s.duration <= 0 {
+		return 0
+	}
+	return float64(s.bytes) * 8 / 1e6 / s.duration.Seconds()
+}
+
+// sendFile copies path (size bytes) on the source to the same path on the
+// target over the direct link. While it runs, progress is logged and
+// published as transfer_progress every -progress-interval; label names
+// the file in both.
+func (m *migration) sendFile(ctx context.Context, path string, size int64, label string) (sent, error) {
+	if *transferVia == transferShared {
+		return m.awaitShared(ctx, path, size)
+	}
+	dest := fmt.Sprintf("%s:%s", m.targetDirect, path)
+	const sshCmd = "ssh -o BatchMode=yes -o StrictHostKeyChecking=no"
+	var script string
+	switch *transferVia {
+	case transferRsync:
+		script = fmt.Sprintf("rsync -a --inplace --info=progress2 -e '%s' %s %s", sshCmd, path, dest)
+	case transferTar:
+		dir, base := filepath.Dir(path), filepath.Base(path)
+		script = fmt.Sprintf("set -o pipefail; tar -C %s -cf - %s | dd bs=1M status=progress | %s %s 'tar -C %s -xf -'",
+			dir, base, sshCmd, m.targetDirect, dir)
+	case transferSCP:
+		script = fmt.Sprintf("scp -q -o BatchMode=yes -o StrictHostKeyChecking=no %s %s", path, dest)
+	}
+
+	cmd := m.src.Command(ctx, script)
+	pr, pw := io.Pipe()
+	var stderr bytes.Buffer
+	cmd.Stdout = pw
+	cmd.Stderr = io.MultiWriter(pw, &stderr)
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return sent{}, err
+	}
+	var done atomic.Int64
+	scanned := make(chan struct{})
+	go func() {
+		defer close(scanned)
+		sc := bufio.NewScanner(pr)
+		// Both tools redraw their progress line with \r.
+		sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+		for sc.Scan() {
+			if n, ok := parseProgress(sc.Text()); ok {
+				done.Store(n)
+			}
+		}
+		io.Copy(io.Discard, pr)
+	}()
+
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+	var tick <-chan time.Time
+	if *progressEvery > 0 && *transferVia != transferSCP {
+		t := time.NewTicker(*progressEvery)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case err := <-waitErr:
+			<-scanned
+			if err != nil {
+				return sent{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(lastLine(stderr.String())))
+			}
+			return sent{bytes: size, duration: time.Since(start)}, nil
+		case <-tick:
+			n := done.Load()
+			elapsed := time.Since(start)
+			rate := float64(n) * 8 / 1e6 / elapsed.Seconds()
+			pct := 0.0
+			if size > 0 {
+				pct = float64(n) / float64(size) * 100
+			}
+			log.Printf("Transfer %s: %d/%d bytes (%.0f%%), %.0f Mbit/s", label, n, size, pct, rate)
+			bus.Publish("transfer_progress", map[string]any{
+				"file": label, "bytes": n, "total_bytes": size, "elapsed_ms": elapsed.Milliseconds(), "throughput_mbps": rate,
+			})
+		}
+	}
+}
+
+// awaitShared waits until the target sees path on the shared mount with
+// its full size. NFS close-to-open consistency makes that immediate in
+// most setups; the wait covers attribute caching on the others.
+func (m *migration) awaitShared(ctx context.Context, path string, size int64) (sent, error) {
+	start := time.Now()
+	var last string
+	for {
+		out, err := m.dst.Run(ctx, "stat -c%s "+path+" 2>/dev/null || echo 0")
+		if err != nil {
+			return sent{}, err
+		}
+		if n, _ := strconv.ParseInt(out, 10, 64); n == size {
+			// Nothing crossed the direct link.
+			return sent{duration: time.Since(start)}, nil
+		}
+		last = out
+		select {
+		case <-ctx.Done():
+			return sent{}, fmt.Errorf("%s on the target has %s of %d bytes: %w", path, last, size, ctx.Err())
+		case <-time.After(sharedPoll):
+		}
+	}
+}
+
+func lastLine(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	if i := strings.LastIndexAny(s, "\r\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+// Command p4ctl reads and rewrites the load balancer's forward entries on
+// the Tofino2 over BF Runtime gRPC, bypassing the controller's HTTP API.
+//
+//	p4ctl -addr tofino:50052 read -ip 192.168.12.2
+//	p4ctl -addr tofino:50052 retarget -ip 192.168.12.2 -port 148 -mac 02:42:c0:a8:0c:02
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/p4rt"
+)
+
+var (
+	addr     = flag.String("addr", "127.0.0.1:50052", "BF Runtime gRPC address of bf_switchd")
+	deviceID = flag.Uint("device-id", 0, "Switch device ID")
+	clientID = flag.Uint("client-id", 7, "BF Runtime client ID; must differ from the controller's")
+	p4Name   = flag.String("p4-name", "", "P4 program name (empty = the first program on the switch)")
+	timeout  = flag.Duration("timeout", 5*time.Second, "Timeout for connecting and for each request")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] read|retarget [command flags]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+	ipStr := cmd.String("ip", "192.168.12.2", "Server IP whose entries to read or rewrite")
+	port := cmd.Uint("port", 0, "retarget: new switch port")
+	macStr := cmd.String("mac", "", "retarget: also rewrite the destination MAC (empty = set_egress_port only)")
+	verify := cmd.Bool("verify", true, "retarget: read the entries back afterwards")
+	cmd.Parse(flag.Args()[1:])
+
+	ip, err := netip.ParseAddr(*ipStr)
+	if err != nil {
+		log.Fatalf("-ip: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	c, err := p4rt.Dial(ctx, *addr, uint32(*deviceID), uint32(*clientID), *p4Name)
+	cancel()
+	if err != nil {
+		log.Fatalf("connect %s: %v", *addr, err)
+	}
+	defer c.Close()
+
+	switch flag.Arg(0) {
+	case "read":
+		printEntries(c, ip)
+	case "retarget":
+		if *port == 0 {
+			log.Fatal("retarget: -port is required")
+		}
+		var mac net.HardwareAddr
+		if *macStr != "" {
+			if mac, err = net.ParseMAC(*macStr); err != nil {
+				log.Fatalf("-mac: %v", err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		start := time.Now()
+		err := c.Retarget(ctx, ip, uint16(*port), mac)
+		cancel()
+		if err != nil {
+			log.Fatalf("retarget: %v", err)
+		}
+		log.Printf("Retargeted %s to port %d in %s", ip, *port, time.Since(start).Round(time.Microsecond))
+		if *verify {
+			printEntries(c, ip)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func printEntries(c *p4rt.Client, ip netip.Addr) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	entries, err := c.ReadForward(ctx, ip)
+	if err != nil {
+		log.Fatalf("read: %v", err)
+	}
+	if len(entries) == 0 {
+		log.Printf("No entries for %s", ip)
+	}
+	for _, e := range entries {
+		fmt.Println(e)
+	}
+}
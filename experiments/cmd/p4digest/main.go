@@ -0,0 +1,170 @@
+// Command p4digest listens for the load balancer's flow digests on the
+// Tofino2 and forwards them to the collector's event bus. The data plane
+// sends one for the first packet of each TCP flow and whenever a flow's
+// egress port changes, so the events show which client flows the switch
+// sent where before and after a retarget.
+//
+//	p4digest -addr tofino:50052 -event-bus localhost:50070 -output flows.jsonl
+//
+// It binds to the program with its own client ID next to the controller
+// and reconnects when bf_switchd restarts.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/p4rt"
+)
+
+var (
+	addr         = flag.String("addr", "127.0.0.1:50052", "BF Runtime gRPC address of bf_switchd")
+	deviceID     = flag.Uint("device-id", 0, "Switch device ID")
+	clientID     = flag.Uint("client-id", 8, "BF Runtime client ID; must differ from the controller's and p4ctl's")
+	p4Name       = flag.String("p4-name", "", "P4 program name (empty = the first program on the switch)")
+	timeout      = flag.Duration("timeout", 5*time.Second, "Timeout for connecting")
+	retry        = flag.Duration("retry", 2*time.Second, "Wait between reconnect attempts")
+	output       = flag.String("output", "", "Append one JSON line per digest to this file (default stdout)")
+	eventBusAddr = flag.String("event-bus", "", "Publish flow_seen events to the collector's event bus at this address (host:port)")
+)
+
+// flow is one digest in the JSON lines output. Src and Dst are as the
+// packet arrived; FwdSrc and FwdDst as it left, after node_selector or
+// client_snat rewrote them.
+type flow struct {
+	Type        string `json:"type"` // "flow"
+	RecvNs      int64  `json:"recv_unix_nano"`
+	Kind        string `json:"kind"` // "new", "moved" or "miss"
+	Src         string `json:"src"`
+	Dst         string `json:"dst"`
+	FwdSrc      string `json:"fwd_src"`
+	FwdDst      string `json:"fwd_dst"`
+	IngressPort uint64 `json:"ingress_port"`
+	EgressPort  uint64 `json:"egress_port"`
+}
+
+// Digest kinds, as FLOW_NEW / FLOW_MISS / FLOW_MOVED in the P4 program.
+var kinds = map[uint64]string{1: "new", 2: "miss", 3: "moved"}
+
+func toFlow(d p4rt.Digest) flow {
+	ip := func(name string) netip.Addr {
+		v := d.Uint(name)
+		return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+	}
+	kind, ok := kinds[d.Uint("kind")]
+	if !ok {
+		kind = "unknown"
+	}
+	return flow{
+		Type:        "flow",
+		RecvNs:      d.Received.UnixNano(),
+		Kind:        kind,
+		Src:         netip.AddrPortFrom(ip("src_addr"), uint16(d.Uint("src_port"))).String(),
+		Dst:         netip.AddrPortFrom(ip("dst_addr"), uint16(d.Uint("dst_port"))).String(),
+		FwdSrc:      ip("fwd_src_addr").String(),
+		FwdDst:      ip("fwd_dst_addr").String(),
+		IngressPort: d.Uint("ingress_port"),
+		EgressPort:  d.Uint("egress_port"),
+	}
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("-output: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+
+	var bus *eventbus.Client
+	if *eventBusAddr != "" {
+		var err error
+		if bus, err = eventbus.Dial(*eventBusAddr, "p4digest"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+		defer bus.Close(time.Second)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	var total int
+	for ctx.Err() == nil {
+		dctx, dcancel := context.WithTimeout(ctx, *timeout)
+		c, err := p4rt.DialDigests(dctx, *addr, uint32(*deviceID), uint32(*clientID), *p4Name)
+		dcancel()
+		if err != nil {
+			log.Printf("connect %s: %v", *addr, err)
+			select {
+			case <-sigCh:
+				cancel()
+			case <-time.After(*retry):
+			}
+			continue
+		}
+		log.Printf("Listening for digests from %s", *addr)
+		bus.Publish("p4digest_connected", map[string]any{"addr": *addr})
+
+		// RecvDigests only returns when the stream breaks, so a signal
+		// closes the client to get out of it.
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-sigCh:
+				cancel()
+				c.Close()
+			case <-done:
+			}
+		}()
+		for {
+			digests, err := c.RecvDigests()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("digest stream: %v", err)
+				}
+				break
+			}
+			for _, d := range digests {
+				if d.Name != p4rt.FlowDigest {
+					continue
+				}
+				f := toFlow(d)
+				total++
+				log.Printf("%s flow %s -> %s: port %d -> %d", f.Kind, f.Src, f.Dst, f.IngressPort, f.EgressPort)
+				enc.Encode(f)
+				bus.Publish("flow_seen", map[string]any{
+					"kind":         f.Kind,
+					"src":          f.Src,
+					"dst":          f.Dst,
+					"fwd_src":      f.FwdSrc,
+					"fwd_dst":      f.FwdDst,
+					"ingress_port": f.IngressPort,
+					"egress_port":  f.EgressPort,
+					"recv_ns":      f.RecvNs,
+				})
+			}
+		}
+		close(done)
+		if ctx.Err() == nil {
+			c.Close()
+			time.Sleep(*retry)
+		}
+	}
+	log.Printf("p4digest: %d flows", total)
+}
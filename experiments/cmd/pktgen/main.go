@@ -0,0 +1,138 @@
+// Command pktgen sends a constant-rate UDP flow to the stream server's
+// -udp-sink-addr. Every datagram carries a sequence number and its send
+// time, so the sink sees datapath downtime at the flow's packet interval
+// (1 ms at the default rate), independent of TCP retransmission timers and
+// the media stream. Run it from the loadgen's netns (or point -netns at
+// it) so the flow takes the same path through the switch.
+//
+// Every -interval it prints one JSON line with the datagrams sent so far.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/netns"
+)
+
+var (
+	target   = flag.String("target", "192.168.12.2:9000", "Server -udp-sink-addr (host:port)")
+	bindAddr = flag.String("bind", "", "Local address to send from, e.g. 192.168.12.1:0 (empty = any)")
+	nsSpec   = flag.String("netns", "", "Open the socket in this network namespace (PID, path or ip-netns name) instead of running under nsenter")
+	rate     = flag.Int("rate", 1000, "Datagrams per second")
+	size     = flag.Int("size", 64, "UDP payload bytes per datagram (at least the 24-byte header)")
+	duration = flag.Duration("duration", 0, "Stop after this long (0 = until interrupted)")
+	interval = flag.Duration("interval", time.Second, "Stats reporting interval (stdout)")
+)
+
+// Datagram header, see udpsink.go in cmd/server.
+const (
+	magic     = "P4PG"
+	headerLen = 24
+)
+
+type stats struct {
+	TimestampUnixMilli int64   `json:"timestamp_unix_milli"`
+	Flow               string  `json:"flow"`
+	Sent               uint64  `json:"sent"`
+	SendErrors         uint64  `json:"send_errors"`
+	RatePps            float64 `json:"rate_pps"`
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *rate <= 0 {
+		log.Fatal("-rate must be positive")
+	}
+	if *size < headerLen {
+		*size = headerLen
+	}
+	raddr, err := net.ResolveUDPAddr("udp", *target)
+	if err != nil {
+		log.Fatalf("-target: %v", err)
+	}
+	var laddr *net.UDPAddr
+	if *bindAddr != "" {
+		if laddr, err = net.ResolveUDPAddr("udp", *bindAddr); err != nil {
+			log.Fatalf("-bind: %v", err)
+		}
+	}
+	conn, err := netns.DialUDP(nsPath(), laddr, raddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() { <-sigCh; cancel() }()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	flow := rand.Uint32()
+	st := stats{Flow: fmt.Sprintf("%08x", flow)}
+	log.Printf("pktgen: flow %s %s -> %s, %d pps x %d B", st.Flow, conn.LocalAddr(), raddr, *rate, *size)
+
+	buf := make([]byte, *size)
+	copy(buf, magic)
+	binary.BigEndian.PutUint32(buf[4:8], flow)
+
+	// Pace against the start time rather than sleeping per datagram: the
+	// timer fires at most every millisecond, and each tick sends whatever
+	// the schedule says is due, so the long-run rate stays exact.
+	enc := json.NewEncoder(os.Stdout)
+	start := time.Now()
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	report := time.NewTicker(*interval)
+	defer report.Stop()
+	var seq, lastSent uint64
+	lastReport := start
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("pktgen: sent %d datagrams in %s (%d errors)", st.Sent, time.Since(start).Round(time.Millisecond), st.SendErrors)
+			return
+		case now := <-report.C:
+			st.TimestampUnixMilli = now.UnixMilli()
+			st.RatePps = float64(st.Sent-lastSent) / now.Sub(lastReport).Seconds()
+			lastSent, lastReport = st.Sent, now
+			enc.Encode(st)
+		case now := <-tick.C:
+			due := uint64(now.Sub(start).Seconds() * float64(*rate))
+			for ; seq < due; seq++ {
+				binary.BigEndian.PutUint64(buf[8:16], seq)
+				binary.BigEndian.PutUint64(buf[16:24], uint64(time.Now().UnixNano()))
+				if _, err := conn.Write(buf); err != nil {
+					// ECONNREFUSED from an ICMP unreachable while the
+					// server is gone; keep the schedule going.
+					st.SendErrors++
+					continue
+				}
+				st.Sent++
+			}
+		}
+	}
+}
+
+func nsPath() string {
+	if *nsSpec == "" {
+		return ""
+	}
+	return netns.Path(*nsSpec)
+}
@@ -0,0 +1,301 @@
+// Command preflight checks that the testbed can run a migration before an
+// experiment starts, and prints a pass/fail matrix of checks by host:
+//
+//	preflight -hosts lakewood=user@source-server,loveland=user@target-server -switch-grpc tofino:50052
+//
+// On every node, in one SSH session each: SSH works, passwordless sudo for
+// the container engine and criu, podman and CRIU at least -min-podman /
+// -min-criu (with -engine docker: Docker at least -min-docker, with
+// experimental features on), `criu check`, CONFIG_CHECKPOINT_RESTORE in the kernel config, and -min-free
+// available where the checkpoints go. On the switch: BF Runtime gRPC
+// answers with the load balancer's tables, and/or the controller's HTTP
+// API answers. The exit status is non-zero if any check fails.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/p4rt"
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+var (
+	hostList      = flag.String("hosts", "", "Comma-separated name=ssh-destination pairs (empty destination = this machine)")
+	sshOpts       = flag.String("ssh-opts", "-o BatchMode=yes -o StrictHostKeyChecking=no -o ConnectTimeout=5", "Options for ssh")
+	checkpointDir = flag.String("checkpoint-dir", "/tmp/checkpoints", "Where the orchestrator writes checkpoints on the nodes")
+	minFree       = flag.String("min-free", "2GB", "Least free space at -checkpoint-dir")
+	minPodman     = flag.String("min-podman", "4.0", "Oldest podman version accepted")
+	minCRIU       = flag.String("min-criu", "3.17", "Oldest CRIU version accepted")
+	engineName    = flag.String("engine", "podman", "Container engine on the nodes: podman or docker")
+	minDocker     = flag.String("min-docker", "20.10", "Oldest Docker version accepted (-engine docker)")
+	switchGRPC    = flag.String("switch-grpc", "", "BF Runtime gRPC address of the switch to check (empty = skip)")
+	controllerURL = flag.String("controller-url", "", "P4 controller base URL to check (empty = skip)")
+	timeout       = flag.Duration("timeout", 20*time.Second, "Timeout per host")
+	output        = flag.String("output", "", "Also write the results as CSV (host,check,status,value) to this file")
+)
+
+// nodeScript prints key=value facts about a node. The checkpoint dir may
+// not exist yet, so free space is that of its closest existing parent.
+const nodeScript = `echo "kernel=$(uname -r)"
+echo "engine=$(%[1]s --version 2>/dev/null | awk '{print $3}' | tr -d ,)"
+echo "criu=$(sudo -n criu --version 2>/dev/null | awk '/^Version/{print $2}')"
+for t in %[1]s criu; do p=$(command -v $t); if [ -n "$p" ] && sudo -n -l "$p" >/dev/null 2>&1; then echo "sudo_$t=yes"; else echo "sudo_$t=no"; fi; done
+echo "criu_check=$(sudo -n criu check 2>&1 | tail -n1)"
+cfg=/boot/config-$(uname -r)
+if [ -r "$cfg" ]; then v=$(grep '^CONFIG_CHECKPOINT_RESTORE=' "$cfg" | cut -d= -f2)
+elif [ -r /proc/config.gz ]; then v=$(zcat /proc/config.gz | grep '^CONFIG_CHECKPOINT_RESTORE=' | cut -d= -f2)
+else v=unknown; fi
+echo "checkpoint_restore=${v:-n}"
+d=%[2]s; while [ ! -d "$d" ]; do d=$(dirname "$d"); done
+echo "free_bytes=$(df -B1 --output=avail "$d" | tail -n1 | tr -d ' ')"`
+
+// dockerScript adds whether dockerd runs with experimental features, which
+// docker checkpoint needs.
+const dockerScript = `
+echo "experimental=$(sudo -n docker version -f '{{.Server.Experimental}}' 2>/dev/null)"`
+
+// result is one cell of the matrix.
+type result struct {
+	host, check string
+	ok          bool
+	value       string
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *hostList == "" && *switchGRPC == "" && *controllerURL == "" {
+		log.Fatal("-hosts, -switch-grpc or -controller-url is required")
+	}
+	if *engineName != "podman" && *engineName != "docker" {
+		log.Fatalf("-engine must be podman or docker, got %q", *engineName)
+	}
+	free, err := metricsmodel.ParseSize(*minFree)
+	if err != nil {
+		log.Fatalf("-min-free: %v", err)
+	}
+
+	var hosts, checks []string
+	var results []result
+	add := func(r result) {
+		results = append(results, r)
+		if !slices.Contains(checks, r.check) {
+			checks = append(checks, r.check)
+		}
+	}
+	if *hostList != "" {
+		type out struct {
+			name string
+			rs   []result
+		}
+		done := make(chan out)
+		for _, f := range strings.Split(*hostList, ",") {
+			name, dest, found := strings.Cut(strings.TrimSpace(f), "=")
+			if !found {
+				dest = name
+			}
+			hosts = append(hosts, name)
+			go func() {
+				done <- out{name, checkNode(name, dest, free)}
+			}()
+		}
+		byHost := map[string][]result{}
+		for range hosts {
+			o := <-done
+			byHost[o.name] = o.rs
+		}
+		for _, h := range hosts {
+			for _, r := range byHost[h] {
+				add(r)
+			}
+		}
+	}
+	if *switchGRPC != "" || *controllerURL != "" {
+		hosts = append(hosts, "switch")
+		for _, r := range checkSwitch() {
+			add(r)
+		}
+	}
+
+	cells := map[[2]string]result{}
+	passed := true
+	for _, r := range results {
+		cells[[2]string{r.host, r.check}] = r
+		passed = passed && r.ok
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "check\t%s\n", strings.Join(hosts, "\t"))
+	for _, c := range checks {
+		row := []string{c}
+		for _, h := range hosts {
+			r, ok := cells[[2]string{h, c}]
+			switch {
+			case !ok:
+				row = append(row, "-")
+			case r.ok:
+				row = append(row, "ok "+r.value)
+			default:
+				row = append(row, "FAIL "+r.value)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+
+	if *output != "" {
+		if err := writeCSV(*output, results); err != nil {
+			log.Printf("-output: %v", err)
+		}
+	}
+	if !passed {
+		log.Print("Preflight FAILED")
+		os.Exit(1)
+	}
+	log.Print("Preflight passed")
+}
+
+// checkNode runs nodeScript on one node and judges the facts it prints.
+func checkNode(name, dest string, minFree uint64) []result {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	h := sshmux.Host{Name: name, Addr: dest, Opts: strings.Fields(*sshOpts)}
+	script := fmt.Sprintf(nodeScript, *engineName, shellQuote(*checkpointDir))
+	if *engineName == "docker" {
+		script += dockerScript
+	}
+	out, err := h.Run(ctx, script)
+	if err != nil {
+		return []result{{name, "ssh", false, firstLine(err.Error())}}
+	}
+	facts := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			facts[k] = strings.TrimSpace(v)
+		}
+	}
+	via := "ssh"
+	if dest == "" {
+		via = "local"
+	}
+	rs := []result{{name, "ssh", true, via}}
+	rs = append(rs, result{name, "kernel", true, facts["kernel"]})
+	if *engineName == "docker" {
+		rs = append(rs, version(name, "docker", facts["engine"], *minDocker))
+		rs = append(rs, result{name, "docker experimental", facts["experimental"] == "true", facts["experimental"]})
+	} else {
+		rs = append(rs, version(name, "podman", facts["engine"], *minPodman))
+	}
+	rs = append(rs, version(name, "criu", facts["criu"], *minCRIU))
+	for _, t := range []string{*engineName, "criu"} {
+		rs = append(rs, result{name, "sudo " + t, facts["sudo_"+t] == "yes", facts["sudo_"+t]})
+	}
+	check := facts["criu_check"]
+	rs = append(rs, result{name, "criu check", strings.Contains(check, "Looks good"), check})
+	cr := facts["checkpoint_restore"]
+	// Without a readable config the feature is assumed; criu check covers it.
+	rs = append(rs, result{name, "CONFIG_CHECKPOINT_RESTORE", cr == "y" || cr == "unknown", cr})
+	avail, err := strconv.ParseUint(facts["free_bytes"], 10, 64)
+	rs = append(rs, result{name, "free " + *checkpointDir, err == nil && avail >= minFree, fmt.Sprintf("%.1fGB", float64(avail)/1e9)})
+	return rs
+}
+
+func version(host, check, got, min string) result {
+	if got == "" {
+		return result{host, check, false, "missing"}
+	}
+	return result{host, check, compareVersions(got, min) >= 0, got}
+}
+
+// compareVersions compares dotted versions numerically, ignoring any
+// suffix such as -rc1 or -dev.
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x, _ = strconv.Atoi(strings.TrimRight(strings.SplitN(pa[i], "-", 2)[0], "+~"))
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(strings.SplitN(pb[i], "-", 2)[0])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func checkSwitch() []result {
+	var rs []result
+	if *switchGRPC != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		c, err := p4rt.Dial(ctx, *switchGRPC, 0, 9, "")
+		cancel()
+		if err != nil {
+			rs = append(rs, result{"switch", "bfrt grpc", false, firstLine(err.Error())})
+		} else {
+			_, err := c.Info.Table(p4rt.ForwardTable)
+			c.Close()
+			if err != nil {
+				rs = append(rs, result{"switch", "bfrt grpc", false, "no load balancer program"})
+			} else {
+				rs = append(rs, result{"switch", "bfrt grpc", true, *switchGRPC})
+			}
+		}
+	}
+	if *controllerURL != "" {
+		client := &http.Client{Timeout: *timeout}
+		resp, err := client.Get(*controllerURL)
+		if err != nil {
+			rs = append(rs, result{"switch", "controller", false, firstLine(err.Error())})
+		} else {
+			resp.Body.Close()
+			// Any HTTP answer means the controller is up.
+			rs = append(rs, result{"switch", "controller", true, fmt.Sprintf("HTTP %d", resp.StatusCode)})
+		}
+	}
+	return rs
+}
+
+func writeCSV(path string, results []result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"host", "check", "status", "value"})
+	for _, r := range results {
+		status := "ok"
+		if !r.ok {
+			status = "fail"
+		}
+		w.Write([]string{r.host, r.check, status, r.value})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
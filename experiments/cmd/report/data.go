@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+)
+
+// num is a chart value; NaN marks a gap and is written as null.
+type num float64
+
+func (n num) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, float64(n), 'g', 6, 64), nil
+}
+
+type series struct {
+	Name string `json:"name"`
+	X    []num  `json:"x"`
+	Y    []num  `json:"y"`
+}
+
+type chart struct {
+	Title  string   `json:"title"`
+	YLabel string   `json:"ylabel"`
+	Series []series `json:"series"`
+}
+
+// run is everything the report shows for one run directory. X values are
+// seconds since the first collector row.
+type run struct {
+	Name       string      `json:"name"`
+	Start      time.Time   `json:"start"`
+	DurationS  float64     `json:"duration_s"`
+	Charts     []chart     `json:"charts"`
+	Migrations []float64   `json:"migrations"`
+	Phases     []phaseSpan `json:"phases"`
+	Timings    []timingRow `json:"-"`
+	TimingKeys []string    `json:"-"`
+	MeanMbps   float64     `json:"-"`
+}
+
+// phaseSpan is a migration phase between two of the collector's marker
+// rows.
+type phaseSpan struct {
+	Name  string  `json:"name"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type timingRow struct {
+	File    string
+	OffsetS float64
+	Values  map[string]string
+}
+
+// loadRun reads dir/metrics.csv, and dir/peers.jsonl (or loadgen.log)
+// and the migration_timing*.txt files when present.
+func loadRun(dir string) (*run, error) {
+	header, recs, err := readCSV(filepath.Join(dir, "metrics.csv"))
+	if err != nil {
+		return nil, err
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[h] = i
+	}
+	value := func(rec []string, c int) float64 {
+		if c >= len(rec) {
+			return math.NaN()
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(rec[c]), "%"), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return v
+	}
+	tc, ok := col["timestamp_unix_milli"]
+	if !ok {
+		return nil, fmt.Errorf("%s/metrics.csv: no timestamp_unix_milli column", dir)
+	}
+	// Marker rows are no samples; they become the phase bands.
+	var markers [][]string
+	if pc, ok := col["migration_phase"]; ok {
+		samples := recs[:0]
+		for _, rec := range recs {
+			if pc < len(rec) && rec[pc] != "" {
+				markers = append(markers, rec)
+			} else {
+				samples = append(samples, rec)
+			}
+		}
+		recs = samples
+	}
+	var ts []float64
+	for _, rec := range recs {
+		ts = append(ts, value(rec, tc))
+	}
+	if len(ts) == 0 || math.IsNaN(ts[0]) {
+		return nil, fmt.Errorf("%s/metrics.csv has no rows", dir)
+	}
+	t0 := ts[0]
+	xs := make([]num, len(ts))
+	for i, t := range ts {
+		xs[i] = num((t - t0) / 1000)
+	}
+	r := &run{
+		Name:      filepath.Base(filepath.Clean(dir)),
+		Start:     time.UnixMilli(int64(t0)),
+		DurationS: float64(xs[len(xs)-1]),
+	}
+	column := func(name, label string, f func(float64) float64) (series, bool) {
+		c, ok := col[name]
+		if !ok {
+			return series{}, false
+		}
+		s := series{Name: label, X: xs, Y: make([]num, len(recs))}
+		seen := false
+		for i, rec := range recs {
+			v := value(rec, c)
+			if f != nil {
+				v = f(v)
+			}
+			s.Y[i] = num(v)
+			seen = seen || !math.IsNaN(v)
+		}
+		return s, seen
+	}
+	add := func(title, ylabel string, ss ...series) {
+		if len(ss) > 0 {
+			r.Charts = append(r.Charts, chart{Title: title, YLabel: ylabel, Series: ss})
+		}
+	}
+
+	// Bitrate: the server's send rate from its byte counters and, when the
+	// loadgen log is there, what the peers actually received.
+	var rate []series
+	bytesCol := "wire_bytes_sent"
+	if _, ok := col[bytesCol]; !ok {
+		bytesCol = "bytes_sent"
+	}
+	if c, ok := col[bytesCol]; ok {
+		s := series{Name: "server send", X: xs, Y: make([]num, len(recs))}
+		var sum float64
+		var n int
+		s.Y[0] = num(math.NaN())
+		for i := 1; i < len(recs); i++ {
+			dt := (ts[i] - ts[i-1]) / 1000
+			db := value(recs[i], c) - value(recs[i-1], c)
+			if dt <= 0 || db < 0 || math.IsNaN(db) {
+				// Counter reset by a restarted server.
+				s.Y[i] = num(math.NaN())
+				continue
+			}
+			mbps := db * 8 / dt / 1e6
+			s.Y[i] = num(mbps)
+			sum += mbps
+			n++
+		}
+		if n > 0 {
+			r.MeanMbps = sum / float64(n)
+			rate = append(rate, s)
+		}
+	}
+	peers := filepath.Join(dir, "peers.jsonl")
+	if _, err := os.Stat(peers); err != nil {
+		peers = filepath.Join(dir, "loadgen.log")
+	}
+	if s, err := loadgenRate(peers, t0); err == nil && len(s.X) > 0 {
+		rate = append(rate, s)
+	}
+	add("Bitrate", "Mbit/s", rate...)
+
+	var pings []series
+	for _, h := range header {
+		for _, p := range []string{"ping_rtt_ms_", "ping_ms_"} {
+			if strings.HasPrefix(h, p) {
+				host := strings.ReplaceAll(strings.TrimPrefix(h, p), "_", ".")
+				// Negative values are lost probes.
+				if s, ok := column(h, host, func(v float64) float64 {
+					if v < 0 {
+						return math.NaN()
+					}
+					return v
+				}); ok {
+					pings = append(pings, s)
+				}
+			}
+		}
+	}
+	add("Ping RTT", "ms", pings...)
+
+	var rtt []series
+	for _, c := range []string{"ws_rtt_p50_ms", "ws_rtt_p95_ms", "ws_rtt_max_ms"} {
+		if s, ok := column(c, strings.TrimSuffix(strings.TrimPrefix(c, "ws_rtt_"), "_ms"), nil); ok {
+			rtt = append(rtt, s)
+		}
+	}
+	add("WebSocket RTT", "ms", rtt...)
+
+	var cpu, mem []series
+	for _, h := range header {
+		name := strings.TrimPrefix(h, "container_")
+		switch {
+		case h == "cpu_percent":
+			name = "server"
+		case strings.HasSuffix(h, "_cpu_pct"):
+			name = strings.TrimSuffix(name, "_cpu_pct")
+		case strings.HasSuffix(h, "_cpu"):
+			name = strings.TrimSuffix(name, "_cpu")
+		case strings.HasSuffix(h, "_mem_bytes"):
+			if s, ok := column(h, strings.TrimSuffix(name, "_mem_bytes"), func(v float64) float64 { return v / 1e6 }); ok {
+				mem = append(mem, s)
+			}
+			continue
+		default:
+			continue
+		}
+		if s, ok := column(h, name, nil); ok {
+			cpu = append(cpu, s)
+		}
+	}
+	add("CPU", "%", cpu...)
+	add("Container memory", "MB", mem...)
+
+	var clients []series
+	for _, c := range [][2]string{{"connected_clients", "server"}, {"lg_connected_clients", "loadgen"}} {
+		if s, ok := column(c[0], c[1], nil); ok {
+			clients = append(clients, s)
+		}
+	}
+	add("Connected clients", "clients", clients...)
+
+	if mc, ok := col["migration_event"]; ok {
+		for i, rec := range recs {
+			if value(rec, mc) == 1 {
+				r.Migrations = append(r.Migrations, float64(xs[i]))
+			}
+		}
+	}
+	r.addPhases(markers, col, t0)
+	if err := r.loadTimings(dir, t0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// addPhases pairs the marker rows into phase spans. The migration_started
+// markers, at the orchestrator's clock, replace the migration_event ones.
+func (r *run) addPhases(markers [][]string, col map[string]int, t0 float64) {
+	var started []float64
+	seen := map[string]float64{}
+	for _, rec := range markers {
+		ms, err := strconv.ParseFloat(rec[col["timestamp_unix_milli"]], 64)
+		if err != nil {
+			continue
+		}
+		x, phase := (ms-t0)/1000, rec[col["migration_phase"]]
+		if phase == "migration_started" {
+			started = append(started, x)
+			seen = map[string]float64{}
+		}
+		seen[phase] = x
+		for _, p := range metricsmodel.Phases {
+			if start, ok := seen[p.Start]; ok && p.End == phase {
+				r.Phases = append(r.Phases, phaseSpan{Name: p.Name, Start: start, End: x})
+			}
+		}
+	}
+	if len(started) > 0 {
+		r.Migrations = started
+	}
+}
+
+func readCSV(path string) ([]string, [][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var recs [][]string
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return header, recs, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// loadgenRate sums the per-peer bytes_per_second samples of the loadgen's
+// JSON lines into one receive rate per second.
+func loadgenRate(path string, t0 float64) (series, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return series{}, err
+	}
+	defer f.Close()
+	sums := map[int64]float64{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var s struct {
+			TimestampUnixMilli int64   `json:"timestamp_unix_milli"`
+			BytesPerSecond     float64 `json:"bytes_per_second"`
+		}
+		// peers.jsonl has the samples as peer_snapshot event fields.
+		var ev struct {
+			Type   string          `json:"type"`
+			Fields json.RawMessage `json:"fields"`
+		}
+		if json.Unmarshal(line, &ev) == nil && ev.Type == "peer_snapshot" {
+			line = ev.Fields
+		}
+		if json.Unmarshal(line, &s) != nil || s.TimestampUnixMilli == 0 {
+			continue
+		}
+		sums[s.TimestampUnixMilli/1000] += s.BytesPerSecond
+	}
+	secs := make([]int64, 0, len(sums))
+	for k := range sums {
+		secs = append(secs, k)
+	}
+	sort.Slice(secs, func(i, j int) bool { return secs[i] < secs[j] })
+	s := series{Name: "loadgen receive"}
+	for _, k := range secs {
+		s.X = append(s.X, num(float64(k*1000)-t0)/1000)
+		s.Y = append(s.Y, num(sums[k]*8/1e6))
+	}
+	return s, sc.Err()
+}
+
+// loadTimings reads the migration_timing*.txt files written by cr_hw.sh
+// or the orchestrator. Their start times replace the CSV's
+// migration_event markers, which only have the collector's resolution.
+func (r *run) loadTimings(dir string, t0 float64) error {
+	files, err := filepath.Glob(filepath.Join(dir, "migration_timing_*.txt"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(filepath.Join(dir, "migration_timing.txt")); err == nil {
+			files = []string{filepath.Join(dir, "migration_timing.txt")}
+		}
+	}
+	keys := map[string]bool{}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		row := timingRow{File: filepath.Base(path), OffsetS: math.NaN(), Values: map[string]string{}}
+		for _, line := range strings.Split(string(data), "\n") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+				row.Values[k] = v
+				if strings.HasSuffix(k, "_ms") {
+					keys[k] = true
+				}
+			}
+		}
+		if ns, err := strconv.ParseInt(row.Values["migration_start_ns"], 10, 64); err == nil {
+			row.OffsetS = (float64(ns/1e6) - t0) / 1000
+		}
+		r.Timings = append(r.Timings, row)
+	}
+	sort.Slice(r.Timings, func(i, j int) bool { return r.Timings[i].OffsetS < r.Timings[j].OffsetS })
+	for k := range keys {
+		r.TimingKeys = append(r.TimingKeys, k)
+	}
+	sort.Strings(r.TimingKeys)
+	if len(r.Timings) > 0 {
+		r.Migrations = r.Migrations[:0]
+		for _, t := range r.Timings {
+			if !math.IsNaN(t.OffsetS) {
+				r.Migrations = append(r.Migrations, t.OffsetS)
+			}
+		}
+	}
+	return nil
+}
+
+// timingStat returns the mean and max of key across the run's migrations.
+func (r *run) timingStat(key string) (mean, maxv float64, ok bool) {
+	var n int
+	for _, t := range r.Timings {
+		v, err := strconv.ParseFloat(t.Values[key], 64)
+		if err != nil {
+			continue
+		}
+		mean += v
+		maxv = max(maxv, v)
+		n++
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+	return mean / float64(n), maxv, true
+}
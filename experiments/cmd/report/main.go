@@ -0,0 +1,186 @@
+// Command report renders one or more run directories into a single
+// self-contained HTML file with interactive charts (bitrate, ping RTT,
+// WebSocket RTT, CPU, clients) and migration markers, plus the migration
+// timing table of each run. The file has no external dependencies, so it
+// can be shared instead of the raw CSVs.
+//
+//	report results/baseline_20250101_120000            # every iter_N inside
+//	report -output cmp.html results/a results/b
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	outputPath = flag.String("output", "", "HTML output (default <dir>/report.html for one argument, report.html otherwise)")
+	title      = flag.String("title", "", "Report title (default: the run names)")
+)
+
+//go:embed report.html
+var reportHTML string
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] run-dir...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var runs []*run
+	for _, arg := range flag.Args() {
+		for _, dir := range runDirs(arg) {
+			r, err := loadRun(dir)
+			if err != nil {
+				log.Printf("Skipping %s: %v", dir, err)
+				continue
+			}
+			if flag.NArg() > 1 && dir != arg {
+				// Keep names unique when comparing several runner outputs.
+				r.Name = filepath.Base(filepath.Clean(arg)) + "/" + r.Name
+			}
+			runs = append(runs, r)
+		}
+	}
+	if len(runs) == 0 {
+		log.Fatal("No run directory with a metrics.csv")
+	}
+
+	out := *outputPath
+	if out == "" {
+		out = "report.html"
+		if flag.NArg() == 1 {
+			out = filepath.Join(flag.Arg(0), "report.html")
+		}
+	}
+	if *title == "" {
+		*title = runs[0].Name
+		if len(runs) > 1 {
+			*title = fmt.Sprintf("%s and %d more runs", runs[0].Name, len(runs)-1)
+		}
+	}
+
+	data, err := json.Marshal(runs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmpl := template.Must(template.New("report").Funcs(template.FuncMap{
+		"ms":   formatMs,
+		"secs": func(s float64) string { return strconv.FormatFloat(s, 'f', 1, 64) },
+	}).Parse(reportHTML))
+	f, err := os.Create(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = tmpl.Execute(f, struct {
+		Title     string
+		Generated string
+		Runs      []*run
+		Summary   []summaryRow
+		Data      template.JS
+	}{*title, time.Now().Format(time.RFC3339), runs, summarize(runs), template.JS(data)})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", out, err)
+	}
+	log.Printf("Report of %d runs written to %s", len(runs), out)
+}
+
+// runDirs expands arg into run directories: arg itself if it holds a
+// metrics.csv, otherwise its subdirectories that do (a runner output with
+// one iter_N per iteration).
+func runDirs(arg string) []string {
+	if _, err := os.Stat(filepath.Join(arg, "metrics.csv")); err == nil {
+		return []string{arg}
+	}
+	matches, _ := filepath.Glob(filepath.Join(arg, "*", "metrics.csv"))
+	dirs := make([]string, 0, len(matches))
+	for _, m := range matches {
+		dirs = append(dirs, filepath.Dir(m))
+	}
+	sort.Slice(dirs, func(i, j int) bool { return naturalLess(dirs[i], dirs[j]) })
+	if len(dirs) == 0 {
+		log.Printf("%s: no metrics.csv", arg)
+	}
+	return dirs
+}
+
+// naturalLess orders iter_2 before iter_10.
+func naturalLess(a, b string) bool {
+	na, nb := trailingInt(a), trailingInt(b)
+	if na >= 0 && nb >= 0 && a[:len(a)-len(strconv.Itoa(na))] == b[:len(b)-len(strconv.Itoa(nb))] {
+		return na < nb
+	}
+	return a < b
+}
+
+func trailingInt(s string) int {
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+	n, err := strconv.Atoi(s[i:])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+type summaryRow struct {
+	Name       string
+	DurationS  float64
+	Migrations int
+	MeanMbps   float64
+	Ready      [2]float64 // mean, max time_to_ready_ms
+	Total      [2]float64 // mean, max total_ms
+}
+
+func summarize(runs []*run) []summaryRow {
+	rows := make([]summaryRow, len(runs))
+	for i, r := range runs {
+		rows[i] = summaryRow{Name: r.Name, DurationS: r.DurationS, Migrations: len(r.Migrations), MeanMbps: r.MeanMbps}
+		rows[i].Ready = [2]float64{math.NaN(), math.NaN()}
+		rows[i].Total = rows[i].Ready
+		if m, x, ok := r.timingStat("time_to_ready_ms"); ok {
+			rows[i].Ready = [2]float64{m, x}
+		}
+		if m, x, ok := r.timingStat("total_ms"); ok {
+			rows[i].Total = [2]float64{m, x}
+		}
+	}
+	return rows
+}
+
+func formatMs(v any) string {
+	switch v := v.(type) {
+	case float64:
+		if math.IsNaN(v) {
+			return "-"
+		}
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	}
+	return fmt.Sprint(v)
+}
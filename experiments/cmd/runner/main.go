@@ -0,0 +1,651 @@
+// Command runner executes an experiment scenario end to end: it runs the
+// setup commands, starts the loadgen on its node, tunnels the metrics
+// ports, starts the collector, runs the orchestrator on the migration
+// schedule, then stops everything and gathers the artifacts of each
+// iteration into one run directory.
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/sshmux"
+)
+
+var (
+	scenarioFile = flag.String("scenario", "", "Experiment definition (YAML, required)")
+	build        = flag.Bool("build", true, "Build the collector, orchestrator and loadgen binaries before the run")
+	tunnelLgPort = flag.Int("tunnel-loadgen-port", 19090, "Local port the loadgen's metrics are tunneled to")
+	tunnelSrPort = flag.Int("tunnel-metrics-port", 18081, "Local port the server's metrics are tunneled to")
+	eventBusPort = flag.Int("event-bus-port", 50070, "Port of the collector's event bus; the loadgen reaches it through the tunnel (0 = no event bus)")
+	preflight    = flag.Bool("preflight", true, "Check SSH, sudo, podman, CRIU, checkpoint space and the switch with cmd/preflight before the run")
+	clockCheck   = flag.Bool("clock-check", true, "Check clock offsets between the nodes before the run and record them during each iteration")
+	bundle       = flag.Bool("bundle", false, "Pack the run directory into <run-dir>.tar.gz with cmd/bundle when the run ends")
+)
+
+const (
+	remoteLoadgen = "/tmp/stream-client"
+	remoteGarp    = "/tmp/p4cf-garp" // orchestrator -garp-bin default
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *scenarioFile == "" {
+		log.Fatal("-scenario is required")
+	}
+	sc, err := loadScenario(*scenarioFile)
+	if err != nil {
+		log.Fatalf("-scenario: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() { <-sigCh; log.Println("Interrupted, stopping the current iteration"); cancel() }()
+
+	runDir := filepath.Join(sc.ResultsDir, fmt.Sprintf("%s_%s", sc.Name, time.Now().Format("20060102_150405")))
+	if err := os.MkdirAll(runDir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	if data, err := os.ReadFile(*scenarioFile); err == nil {
+		os.WriteFile(filepath.Join(runDir, "scenario.yaml"), data, 0o644)
+	}
+	logFile, err := os.Create(filepath.Join(runDir, "runner.log"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer logFile.Close()
+	log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+
+	r := &runner{sc: sc, sshOpts: strings.Fields(sc.SSHOpts)}
+	if *build {
+		if err := r.build(ctx); err != nil {
+			log.Fatalf("build: %v", err)
+		}
+	}
+	r.recordVersions(ctx, filepath.Join(runDir, "versions.txt"))
+
+	if *preflight {
+		log.Print("Preflight")
+		if err := runLocal(ctx, nil, "bin/preflight", append(r.preflightArgs(), "-output", filepath.Join(runDir, "preflight.csv"))...); err != nil {
+			log.Fatalf("preflight: %v (see preflight.csv; -preflight=false skips it)", err)
+		}
+	}
+	if *clockCheck {
+		log.Printf("Clock check (max offset %s)", time.Duration(sc.Clock.MaxOffset))
+		if err := runLocal(ctx, nil, "bin/clockcheck", append(r.clockArgs(), "-output", filepath.Join(runDir, "clock_preflight.csv"))...); err != nil {
+			log.Fatalf("clock check: %v (see clock_preflight.csv; -clock-check=false skips it)", err)
+		}
+	}
+
+	failed := 0
+	for i := 1; i <= sc.Iterations && ctx.Err() == nil; i++ {
+		dir := filepath.Join(runDir, fmt.Sprintf("iter_%d", i))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("===== Iteration %d/%d -> %s =====", i, sc.Iterations, dir)
+		if err := r.iteration(ctx, dir); err != nil {
+			failed++
+			log.Printf("Iteration %d failed: %v", i, err)
+			os.WriteFile(filepath.Join(dir, "error.txt"), []byte(err.Error()+"\n"), 0o644)
+		}
+	}
+	log.Printf("Run done: %d/%d iterations ok, results in %s", sc.Iterations-failed, sc.Iterations, runDir)
+	if *bundle {
+		if err := runLocal(context.Background(), nil, "bin/bundle", runDir); err != nil {
+			log.Printf("bundle: %v", err)
+		}
+	}
+	if failed > 0 || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+type runner struct {
+	sc      *scenario
+	sshOpts []string
+}
+
+func (r *runner) ssh(ctx context.Context, n node, script string) error {
+	_, err := sshmux.Host{Name: n.SSH, Addr: n.SSH, Opts: r.sshOpts}.Run(ctx, script)
+	return err
+}
+
+// versionScript prints what on a node decides how a migration behaves.
+const versionScript = `echo "kernel $(uname -r)"; echo "podman $(podman --version 2>/dev/null | awk '{print $NF}')"; echo "criu $(sudo -n criu --version 2>/dev/null | awk '/^Version/{print $2}')"; echo "crun $(crun --version 2>/dev/null | awk 'NR==1{print $NF}')"`
+
+// recordVersions writes the experiments commit, the local toolchain, the
+// checksums of the binaries in bin/ and each node's kernel, podman, CRIU
+// and crun versions to path, for cmd/bundle and later comparisons. It is
+// best effort: what cannot be found is left empty.
+func (r *runner) recordVersions(ctx context.Context, path string) {
+	var b strings.Builder
+	out := func(name string, args ...string) string {
+		o, _ := exec.CommandContext(ctx, name, args...).Output()
+		return strings.TrimSpace(string(o))
+	}
+	fmt.Fprintf(&b, "git_commit=%s\n", out("git", "rev-parse", "HEAD"))
+	fmt.Fprintf(&b, "git_dirty_files=%s\n", out("bash", "-c", "git status --porcelain | wc -l"))
+	fmt.Fprintf(&b, "go=%s\n", out("go", "env", "GOVERSION"))
+	for _, line := range strings.Split(out("bash", "-c", "sha256sum bin/* 2>/dev/null"), "\n") {
+		if sum, file, ok := strings.Cut(line, "  "); ok {
+			fmt.Fprintf(&b, "sha256_%s=%s\n", filepath.Base(file), sum)
+		}
+	}
+	names := make([]string, 0, len(r.sc.Nodes))
+	for name := range r.sc.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := r.sc.Nodes[name]
+		o, err := sshmux.Host{Name: n.SSH, Addr: n.SSH, Opts: r.sshOpts}.Run(ctx, versionScript)
+		if err != nil {
+			log.Printf("versions: %v", err)
+		}
+		for _, line := range strings.Split(o, "\n") {
+			if k, v, ok := strings.Cut(line, " "); ok {
+				fmt.Fprintf(&b, "%s_%s=%s\n", name, k, v)
+			}
+		}
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		log.Printf("versions: %v", err)
+	}
+}
+
+func (r *runner) build(ctx context.Context) error {
+	for _, b := range []struct{ out, pkg string }{
+		{"bin/stream-collector", "./cmd/collector/"},
+		{"bin/orchestrator", "./cmd/orchestrator/"},
+		{"bin/clockcheck", "./cmd/clockcheck/"},
+		{"bin/preflight", "./cmd/preflight/"},
+		{"bin/chaos", "./cmd/chaos/"},
+		{"bin/bundle", "./cmd/bundle/"},
+	} {
+		if err := runLocal(ctx, nil, "go", "build", "-o", b.out, b.pkg); err != nil {
+			return err
+		}
+	}
+	// The loadgen runs on a lab node, garp on whichever node a migration
+	// targets.
+	env := []string{"CGO_ENABLED=0", "GOOS=linux", "GOARCH=amd64"}
+	if err := runLocal(ctx, env, "go", "build", "-o", "bin/stream-client-linux", "./cmd/loadgen/"); err != nil {
+		return err
+	}
+	if err := runLocal(ctx, env, "go", "build", "-o", "bin/garp-linux", "./cmd/garp/"); err != nil {
+		return err
+	}
+	lg := r.sc.Nodes[r.sc.Loadgen.Node]
+	args := append(append([]string{}, r.sshOpts...), "bin/stream-client-linux", lg.SSH+":"+remoteLoadgen)
+	if err := runLocal(ctx, nil, "scp", args...); err != nil {
+		return err
+	}
+	for _, n := range r.sc.Nodes {
+		args := append(append([]string{}, r.sshOpts...), "bin/garp-linux", n.SSH+":"+remoteGarp)
+		if err := runLocal(ctx, nil, "scp", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runLocal(ctx context.Context, env []string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = log.Writer(), log.Writer()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// iteration runs one measurement: setup, loadgen, tunnel, collector, the
+// migration schedule, then teardown. Processes it started are stopped on
+// every return path.
+func (r *runner) iteration(ctx context.Context, dir string) error {
+	sc := r.sc
+	for _, c := range sc.Setup {
+		if err := runLocal(ctx, nil, "bash", "-c", c); err != nil {
+			return fmt.Errorf("setup: %w", err)
+		}
+	}
+	defer func() {
+		for _, c := range sc.Teardown {
+			if terr := runLocal(context.Background(), nil, "bash", "-c", c); terr != nil {
+				log.Printf("teardown: %v", terr)
+			}
+		}
+	}()
+
+	lgNode := sc.Nodes[sc.Loadgen.Node]
+	if err := r.startLoadgen(ctx, lgNode); err != nil {
+		return err
+	}
+	defer func() {
+		// The loadgen writes its histograms on the way out.
+		r.ssh(context.Background(), lgNode, "sudo pkill -f '[s]tream-client' 2>/dev/null; "+
+			"timeout 5 sh -c 'while pgrep -f [s]tream-client >/dev/null; do sleep 0.1; done' || true")
+		for remote, local := range map[string]string{"/tmp/loadgen.log": "loadgen.log", remoteHistograms: "latency_histograms.csv"} {
+			args := append(append([]string{}, r.sshOpts...), lgNode.SSH+":"+remote, filepath.Join(dir, local))
+			runLocal(context.Background(), nil, "scp", args...)
+		}
+	}()
+
+	tunnel, err := r.startTunnel(lgNode)
+	if err != nil {
+		return err
+	}
+	defer stop(tunnel)
+
+	if *clockCheck {
+		clock, err := r.startClockRecorder(dir)
+		if err != nil {
+			return err
+		}
+		defer stop(clock)
+	}
+
+	if sc.Capture.Node != "" {
+		capNode := sc.Nodes[sc.Capture.Node]
+		if err := r.startCapture(ctx, capNode); err != nil {
+			return err
+		}
+		defer r.stopCapture(capNode, filepath.Join(dir, "capture.pcap"))
+	}
+
+	flagPath := filepath.Join(dir, "migration_event")
+	collector, err := r.startCollector(dir, flagPath)
+	if err != nil {
+		return err
+	}
+	// Stop the collector before the loadgen so its last row is live data.
+	defer stop(collector)
+
+	if len(sc.Faults) > 0 {
+		chaos, err := r.startChaos(dir)
+		if err != nil {
+			return err
+		}
+		defer stop(chaos)
+	}
+
+	log.Printf("Warm-up %s", time.Duration(sc.Migration.Warmup))
+	if err := sleep(ctx, time.Duration(sc.Migration.Warmup)); err != nil {
+		return err
+	}
+	from, to := sc.Migration.From, sc.Migration.To
+	for i := 1; i <= sc.Migration.Count; i++ {
+		log.Printf("Migration %d/%d: %s -> %s", i, sc.Migration.Count, from, to)
+		direction := "forward"
+		if i%2 == 0 {
+			direction = "back"
+		}
+		os.WriteFile(flagPath, fmt.Appendf(nil, "migration=%d\ndirection=%s\n", i, direction), 0o644)
+		timing := filepath.Join(dir, fmt.Sprintf("migration_timing_%d.txt", i))
+		if err := r.migrate(ctx, from, to, timing, i, direction); err != nil {
+			return fmt.Errorf("migration %d: %w", i, err)
+		}
+		wait := time.Duration(sc.Migration.Interval)
+		if i == sc.Migration.Count {
+			wait = time.Duration(sc.Migration.Cooldown)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+		from, to = to, from
+	}
+	return nil
+}
+
+func (r *runner) startLoadgen(ctx context.Context, n node) error {
+	sc := r.sc
+	args := []string{
+		"-server", fmt.Sprintf("http://%s:%d", sc.Server.IP, sc.Server.SignalingPort),
+		"-connections", strconv.Itoa(sc.Loadgen.Connections),
+		"-metrics-port", strconv.Itoa(sc.Loadgen.MetricsPort),
+		"-histograms", remoteHistograms,
+	}
+	if *eventBusPort != 0 {
+		// The collector writes the snapshots to peers.jsonl in the run
+		// directory; /tmp/loadgen.log keeps only the log.
+		args = append(args, "-event-bus", fmt.Sprintf("localhost:%d", *eventBusPort), "-push-snapshots", "-stdout=false")
+	}
+	args = append(args, sc.Loadgen.Args...)
+	for i, a := range args {
+		args[i] = shellQuote(a)
+	}
+	script := fmt.Sprintf("sudo pkill -f '[s]tream-client' 2>/dev/null; nohup %s %s > /tmp/loadgen.log 2>&1 &",
+		remoteLoadgen, strings.Join(args, " "))
+	if err := r.ssh(ctx, n, script); err != nil {
+		return fmt.Errorf("start loadgen: %w", err)
+	}
+	if err := sleep(ctx, 2*time.Second); err != nil {
+		return err
+	}
+	if err := r.ssh(ctx, n, "pgrep -f stream-client >/dev/null"); err != nil {
+		return fmt.Errorf("loadgen did not start on %s (see /tmp/loadgen.log there)", n.SSH)
+	}
+	return nil
+}
+
+const remoteCapture = "/tmp/capture.pcap"
+
+// remoteHistograms is the loadgen's -histograms file, fetched into the
+// run directory as latency_histograms.csv.
+const remoteHistograms = "/tmp/loadgen_histograms.csv"
+
+// startCapture runs tcpdump on n for the server's traffic. The snap
+// length keeps the headers (and the start of the payload), which is all
+// `analyze pcap` needs.
+func (r *runner) startCapture(ctx context.Context, n node) error {
+	c := r.sc.Capture
+	script := fmt.Sprintf("sudo pkill -f '[t]cpdump -i %[1]s' 2>/dev/null; sudo rm -f %[4]s; nohup sudo tcpdump -i %[1]s -s %[2]d -w %[4]s host %[3]s > /tmp/tcpdump.log 2>&1 &",
+		c.Interface, c.Snaplen, r.sc.Server.IP, remoteCapture)
+	if err := r.ssh(ctx, n, script); err != nil {
+		return fmt.Errorf("start capture: %w", err)
+	}
+	return sleep(ctx, time.Second)
+}
+
+// stopCapture stops tcpdump so it flushes and copies the capture to path.
+func (r *runner) stopCapture(n node, path string) {
+	ctx := context.Background()
+	r.ssh(ctx, n, fmt.Sprintf("sudo pkill -INT -f '[t]cpdump -i %s'; sleep 1; sudo chmod a+r %s", r.sc.Capture.Interface, remoteCapture))
+	args := append(append([]string{}, r.sshOpts...), n.SSH+":"+remoteCapture, path)
+	if err := runLocal(ctx, nil, "scp", args...); err != nil {
+		log.Printf("capture: %v", err)
+	}
+}
+
+// startTunnel forwards the loadgen's and the server's metrics ports
+// through the loadgen node, which sits on the switch subnet; only metrics
+// use the tunnel, the data path stays loadgen -> switch -> server.
+func (r *runner) startTunnel(n node) (*proc, error) {
+	sc := r.sc
+	args := append([]string{"-N",
+		"-L", fmt.Sprintf("%d:localhost:%d", *tunnelLgPort, sc.Loadgen.MetricsPort),
+		"-L", fmt.Sprintf("%d:%s:%d", *tunnelSrPort, sc.Server.IP, sc.Server.MetricsPort),
+		"-o", "ExitOnForwardFailure=yes", "-o", "ServerAliveInterval=10", "-o", "ServerAliveCountMax=6",
+	}, r.sshOpts...)
+	if *eventBusPort != 0 {
+		// The loadgen publishes to the collector's event bus on its own
+		// localhost.
+		args = append(args, "-R", fmt.Sprintf("%d:localhost:%d", *eventBusPort, *eventBusPort))
+	}
+	p, err := start(exec.Command("ssh", append(args, n.SSH)...), nil)
+	if err != nil {
+		return nil, fmt.Errorf("metrics tunnel: %w", err)
+	}
+	select {
+	case <-p.done:
+		return nil, errors.New("metrics tunnel exited immediately")
+	case <-time.After(2 * time.Second):
+	}
+	return p, nil
+}
+
+func (r *runner) startCollector(dir, flagPath string) (*proc, error) {
+	args := []string{
+		"-server-metrics-url", fmt.Sprintf("http://localhost:%d", *tunnelSrPort),
+		"-loadgen-url", fmt.Sprintf("http://localhost:%d", *tunnelLgPort),
+		"-migration-flag", flagPath,
+		"-output", filepath.Join(dir, "metrics.csv"),
+		"-interval", time.Duration(r.sc.Collector.Interval).String(),
+	}
+	if r.sc.Collector.BurstWindow > 0 {
+		args = append(args, "-burst-window", time.Duration(r.sc.Collector.BurstWindow).String())
+		if r.sc.Collector.BurstInterval > 0 {
+			args = append(args, "-burst-interval", time.Duration(r.sc.Collector.BurstInterval).String())
+		}
+	}
+	if len(r.sc.Collector.Ping) > 0 {
+		args = append(args,
+			"-ping", strings.Join(r.sc.Collector.Ping, ","),
+			"-loadgen-ssh", r.sc.Nodes[r.sc.Loadgen.Node].SSH,
+			"-ssh-opts", r.sc.SSHOpts)
+	}
+	if r.sc.Collector.RemoteWrite != "" {
+		args = append(args,
+			"-remote-write", r.sc.Collector.RemoteWrite,
+			"-remote-write-labels", fmt.Sprintf("scenario=%s,run=%s,iteration=%s",
+				r.sc.Name, filepath.Base(filepath.Dir(dir)), filepath.Base(dir)))
+	}
+	if *eventBusPort != 0 {
+		args = append(args,
+			"-event-bus-listen", fmt.Sprintf("localhost:%d", *eventBusPort),
+			"-events", filepath.Join(dir, "events.jsonl"))
+	}
+	if r.sc.Switch.GRPC != "" {
+		var ports []string
+		for name, n := range r.sc.Nodes {
+			if n.SwPort != 0 {
+				ports = append(ports, fmt.Sprintf("%s=%d", name, n.SwPort))
+			}
+		}
+		sort.Strings(ports)
+		args = append(args,
+			"-switch-grpc", r.sc.Switch.GRPC,
+			"-switch-server-ip", r.sc.Server.IP,
+			"-switch-ports", strings.Join(ports, ","))
+	}
+	args = append(args, r.sc.Collector.Args...)
+	logf, err := os.Create(filepath.Join(dir, "collector.log"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("bin/stream-collector", args...)
+	cmd.Stdout, cmd.Stderr = logf, logf
+	p, err := start(cmd, logf)
+	if err != nil {
+		return nil, fmt.Errorf("start collector: %w", err)
+	}
+	return p, nil
+}
+
+// startChaos schedules the scenario's faults with cmd/chaos, from now.
+func (r *runner) startChaos(dir string) (*proc, error) {
+	sc := r.sc
+	var hosts, links []string
+	for name, n := range sc.Nodes {
+		hosts = append(hosts, name+"="+n.SSH)
+		if n.DirectIP != "" {
+			links = append(links, name+"="+n.DirectIP)
+		}
+	}
+	sort.Strings(hosts)
+	sort.Strings(links)
+	args := []string{
+		"-hosts", strings.Join(hosts, ","),
+		"-links", strings.Join(links, ","),
+		"-faults", strings.Join(sc.Faults, ","),
+		"-container", sc.Server.Container,
+		"-engine", sc.Server.Engine,
+		"-ssh-opts", sc.SSHOpts,
+	}
+	if *eventBusPort != 0 {
+		args = append(args, "-event-bus", fmt.Sprintf("localhost:%d", *eventBusPort))
+	}
+	logf, err := os.Create(filepath.Join(dir, "chaos.log"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("bin/chaos", args...)
+	cmd.Stdout, cmd.Stderr = logf, logf
+	p, err := start(cmd, logf)
+	if err != nil {
+		return nil, fmt.Errorf("start chaos: %w", err)
+	}
+	return p, nil
+}
+
+// preflightArgs are the cmd/preflight flags for every node and the switch.
+func (r *runner) preflightArgs() []string {
+	sc := r.sc
+	var hosts []string
+	for name, n := range sc.Nodes {
+		hosts = append(hosts, name+"="+n.SSH)
+	}
+	sort.Strings(hosts)
+	return []string{
+		"-hosts", strings.Join(hosts, ","),
+		"-ssh-opts", sc.SSHOpts,
+		"-engine", sc.Server.Engine,
+		"-checkpoint-dir", cmp.Or(sc.Migration.SharedDir, sc.Migration.CheckpointDir),
+		"-switch-grpc", sc.Switch.GRPC,
+		"-controller-url", sc.Switch.ControllerURL,
+	}
+}
+
+// clockArgs are the cmd/clockcheck flags for every node and, if
+// configured, the switch CPU.
+func (r *runner) clockArgs() []string {
+	sc := r.sc
+	var hosts []string
+	for name, n := range sc.Nodes {
+		hosts = append(hosts, name+"="+n.SSH)
+	}
+	sort.Strings(hosts)
+	if sc.Switch.SSH != "" {
+		hosts = append(hosts, "switch="+sc.Switch.SSH)
+	}
+	return []string{
+		"-hosts", strings.Join(hosts, ","),
+		"-ssh-opts", sc.SSHOpts,
+		"-max-offset", time.Duration(sc.Clock.MaxOffset).String(),
+	}
+}
+
+// startClockRecorder samples the clock offsets into clock_offsets.csv
+// for the length of the iteration.
+func (r *runner) startClockRecorder(dir string) (*proc, error) {
+	args := append(r.clockArgs(),
+		"-interval", time.Duration(r.sc.Clock.Interval).String(),
+		"-output", filepath.Join(dir, "clock_offsets.csv"))
+	logf, err := os.Create(filepath.Join(dir, "clockcheck.log"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("bin/clockcheck", args...)
+	cmd.Stdout, cmd.Stderr = logf, logf
+	p, err := start(cmd, logf)
+	if err != nil {
+		return nil, fmt.Errorf("start clockcheck: %w", err)
+	}
+	return p, nil
+}
+
+func (r *runner) migrate(ctx context.Context, from, to, timingFile string, number int, direction string) error {
+	sc := r.sc
+	src, dst := sc.Nodes[from], sc.Nodes[to]
+	args := []string{
+		"-source", src.SSH,
+		"-target", dst.SSH,
+		"-container", sc.Server.Container,
+		"-engine", sc.Server.Engine,
+		"-checkpoint-dir", sc.Migration.CheckpointDir,
+		"-server-ip", sc.Server.IP,
+		"-server-mac", sc.Server.MAC,
+		"-ssh-opts", sc.SSHOpts,
+		"-migration-flag", "",
+		"-timing-file", timingFile,
+		"-number", strconv.Itoa(number),
+		"-direction", direction,
+	}
+	if *eventBusPort != 0 {
+		args = append(args, "-event-bus", fmt.Sprintf("localhost:%d", *eventBusPort))
+	}
+	if sc.Migration.Mode != "" {
+		args = append(args, "-mode", sc.Migration.Mode)
+	}
+	if sc.Migration.PreDumps != 0 {
+		args = append(args, "-pre-dumps", strconv.Itoa(sc.Migration.PreDumps))
+	}
+	if sc.Migration.SharedDir != "" {
+		args = append(args, "-transfer", "shared", "-shared-dir", sc.Migration.SharedDir)
+	}
+	if dst.DirectIP != "" {
+		args = append(args, "-target-direct", dst.DirectIP)
+	}
+	if dst.NIC != "" {
+		args = append(args, "-target-nic", dst.NIC)
+	}
+	if dst.SwPort != 0 {
+		args = append(args, "-target-sw-port", strconv.Itoa(dst.SwPort))
+	}
+	switch {
+	case sc.Switch.GRPC != "":
+		args = append(args, "-switch-grpc", sc.Switch.GRPC)
+	case sc.Switch.ControllerURL != "":
+		args = append(args, "-controller-url", sc.Switch.ControllerURL)
+	}
+	args = append(args, sc.Migration.Args...)
+	return runLocal(ctx, nil, "bin/orchestrator", args...)
+}
+
+// proc is a background process started by the runner. done is closed
+// once it has exited.
+type proc struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// start runs cmd in the background and closes out (if any) when it exits.
+func start(cmd *exec.Cmd, out io.Closer) (*proc, error) {
+	if err := cmd.Start(); err != nil {
+		if out != nil {
+			out.Close()
+		}
+		return nil, err
+	}
+	p := &proc{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		if out != nil {
+			out.Close()
+		}
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// stop sends SIGTERM and waits, so the collector flushes its last row.
+func stop(p *proc) {
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scenario is one experiment definition. Example (scenarios/baseline.yaml):
+//
+//	name: baseline
+//	iterations: 3
+//	nodes:
+//	  lakewood: {ssh: user@source-server, direct_ip: 192.168.10.2, nic: enp101s0np1, sw_port: 140}
+//	  loveland: {ssh: user@target-server, direct_ip: 192.168.10.3, nic: enp101s0np1, sw_port: 148}
+//	switch: {controller_url: http://tofino-switch:5000, ssh: root@tofino-switch}
+//	server: {container: stream-server, ip: 192.168.12.2, mac: "02:42:c0:a8:0c:02"}
+//	loadgen: {node: lakewood, connections: 4}
+//	migration: {from: lakewood, to: loveland, count: 2, warmup: 15s, interval: 30s, cooldown: 30s, mode: precopy}
+//	capture: {node: lakewood, interface: enp101s0np1}
+//	setup: [./clean_hw.sh, ./build_hw.sh]
+//
+// With count > 1 the container migrates back and forth between from and
+// to. Durations use Go syntax.
+type scenario struct {
+	Name       string          `yaml:"name"`
+	Iterations int             `yaml:"iterations"`
+	ResultsDir string          `yaml:"results_dir"`
+	SSHOpts    string          `yaml:"ssh_opts"`
+	Nodes      map[string]node `yaml:"nodes"`
+	Switch     struct {
+		ControllerURL string `yaml:"controller_url"`
+		GRPC          string `yaml:"grpc"`
+		// SSH reaches the switch CPU for the clock check, if set.
+		SSH string `yaml:"ssh"`
+	} `yaml:"switch"`
+	Server struct {
+		Container string `yaml:"container"`
+		// Engine is the container engine on the nodes, podman (default)
+		// or docker. Docker only migrates in mode stop.
+		Engine        string `yaml:"engine"`
+		IP            string `yaml:"ip"`
+		MAC           string `yaml:"mac"`
+		SignalingPort int    `yaml:"signaling_port"`
+		MetricsPort   int    `yaml:"metrics_port"`
+	} `yaml:"server"`
+	Loadgen struct {
+		Node        string   `yaml:"node"`
+		Connections int      `yaml:"connections"`
+		MetricsPort int      `yaml:"metrics_port"`
+		Args        []string `yaml:"args"`
+	} `yaml:"loadgen"`
+	Collector struct {
+		Interval duration `yaml:"interval"`
+		// BurstWindow, if set, is how long the collector samples every
+		// BurstInterval (default 100ms) from each migration's start.
+		BurstWindow   duration `yaml:"burst_window"`
+		BurstInterval duration `yaml:"burst_interval"`
+		// RemoteWrite is a Prometheus remote-write URL the collector
+		// pushes its samples to, labelled with the scenario, run and
+		// iteration.
+		RemoteWrite string `yaml:"remote_write"`
+		// Ping lists IPs the collector pings from the loadgen's node,
+		// the clients' side of the switch.
+		Ping []string `yaml:"ping"`
+		Args []string `yaml:"args"`
+	} `yaml:"collector"`
+	// Clock is the cmd/clockcheck preflight (the run aborts when the
+	// offset between hosts exceeds MaxOffset) and its recording interval
+	// during each iteration.
+	Clock struct {
+		MaxOffset duration `yaml:"max_offset"`
+		Interval  duration `yaml:"interval"`
+	} `yaml:"clock"`
+	Migration struct {
+		From          string   `yaml:"from"`
+		To            string   `yaml:"to"`
+		Count         int      `yaml:"count"`
+		Warmup        duration `yaml:"warmup"`
+		Interval      duration `yaml:"interval"`
+		Cooldown      duration `yaml:"cooldown"`
+		CheckpointDir string   `yaml:"checkpoint_dir"`
+		// Mode is the orchestrator's -mode: stop (default), precopy or
+		// lazy; PreDumps its -pre-dumps for precopy.
+		Mode     string `yaml:"mode"`
+		PreDumps int    `yaml:"pre_dumps"`
+		// SharedDir, if set, is an NFS mount on every node: the
+		// orchestrator checkpoints into it and restores from it without
+		// a transfer (-transfer shared).
+		SharedDir string   `yaml:"shared_dir"`
+		Args      []string `yaml:"args"`
+	} `yaml:"migration"`
+	// Capture runs tcpdump for the server's traffic on Interface of Node
+	// during each iteration, into capture.pcap for `analyze pcap`.
+	Capture struct {
+		Node      string `yaml:"node"`
+		Interface string `yaml:"interface"`
+		Snaplen   int    `yaml:"snaplen"`
+	} `yaml:"capture"`
+	// Faults are cmd/chaos faults, kind:node[:target]@at[/duration], with
+	// at counted from the start of the warm-up.
+	Faults []string `yaml:"faults"`
+	// Setup and Teardown run locally with bash before and after every
+	// iteration, from the experiments directory.
+	Setup    []string `yaml:"setup"`
+	Teardown []string `yaml:"teardown"`
+}
+
+// node is one server host. DirectIP is its address on the
+// server-to-server link, NIC the switch-facing interface the macvlan sits
+// on, SwPort the switch port behind that NIC.
+type node struct {
+	SSH      string `yaml:"ssh"`
+	DirectIP string `yaml:"direct_ip"`
+	NIC      string `yaml:"nic"`
+	SwPort   int    `yaml:"sw_port"`
+}
+
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(v *yaml.Node) error {
+	pd, err := time.ParseDuration(v.Value)
+	if err != nil {
+		return err
+	}
+	*d = duration(pd)
+	return nil
+}
+
+func loadScenario(path string) (*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc := &scenario{}
+	if err := yaml.Unmarshal(data, sc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	sc.setDefaults()
+	return sc, sc.validate()
+}
+
+// setDefaults fills in the values config.env and config_hw.env use.
+func (sc *scenario) setDefaults() {
+	if sc.Name == "" {
+		sc.Name = "run"
+	}
+	if sc.Iterations == 0 {
+		sc.Iterations = 1
+	}
+	if sc.ResultsDir == "" {
+		sc.ResultsDir = "results"
+	}
+	if sc.SSHOpts == "" {
+		sc.SSHOpts = "-o BatchMode=yes -o StrictHostKeyChecking=no -o ConnectTimeout=10"
+	}
+	if sc.Server.Container == "" {
+		sc.Server.Container = "stream-server"
+	}
+	if sc.Server.Engine == "" {
+		sc.Server.Engine = "podman"
+	}
+	if sc.Server.IP == "" {
+		sc.Server.IP = "192.168.12.2"
+	}
+	if sc.Server.MAC == "" {
+		sc.Server.MAC = "02:42:c0:a8:0c:02"
+	}
+	if sc.Server.SignalingPort == 0 {
+		sc.Server.SignalingPort = 8080
+	}
+	if sc.Server.MetricsPort == 0 {
+		sc.Server.MetricsPort = 8081
+	}
+	if sc.Loadgen.Connections == 0 {
+		sc.Loadgen.Connections = 4
+	}
+	if sc.Loadgen.MetricsPort == 0 {
+		sc.Loadgen.MetricsPort = 9090
+	}
+	if sc.Collector.Interval == 0 {
+		sc.Collector.Interval = duration(time.Second)
+	}
+	if sc.Clock.MaxOffset == 0 {
+		sc.Clock.MaxOffset = duration(time.Millisecond)
+	}
+	if sc.Clock.Interval == 0 {
+		sc.Clock.Interval = duration(5 * time.Second)
+	}
+	if sc.Migration.Count == 0 {
+		sc.Migration.Count = 1
+	}
+	if sc.Migration.Warmup == 0 {
+		sc.Migration.Warmup = duration(15 * time.Second)
+	}
+	if sc.Migration.Interval == 0 {
+		sc.Migration.Interval = duration(30 * time.Second)
+	}
+	if sc.Migration.Cooldown == 0 {
+		sc.Migration.Cooldown = duration(30 * time.Second)
+	}
+	if sc.Capture.Snaplen == 0 {
+		sc.Capture.Snaplen = 128
+	}
+	if sc.Migration.CheckpointDir == "" {
+		sc.Migration.CheckpointDir = "/tmp/checkpoints"
+	}
+}
+
+func (sc *scenario) validate() error {
+	for _, name := range []string{sc.Loadgen.Node, sc.Migration.From, sc.Migration.To} {
+		if name == "" {
+			return fmt.Errorf("loadgen.node, migration.from and migration.to are required")
+		}
+		n, ok := sc.Nodes[name]
+		if !ok {
+			return fmt.Errorf("node %q is not defined under nodes", name)
+		}
+		if n.SSH == "" {
+			return fmt.Errorf("node %q has no ssh destination", name)
+		}
+	}
+	if sc.Migration.From == sc.Migration.To {
+		return fmt.Errorf("migration.from and migration.to are both %q", sc.Migration.From)
+	}
+	switch sc.Server.Engine {
+	case "podman":
+	case "docker":
+		if sc.Migration.Mode != "" && sc.Migration.Mode != "stop" {
+			return fmt.Errorf("server.engine docker supports only migration.mode stop")
+		}
+	default:
+		return fmt.Errorf("server.engine must be podman or docker, got %q", sc.Server.Engine)
+	}
+	if sc.Capture.Node != "" {
+		if _, ok := sc.Nodes[sc.Capture.Node]; !ok {
+			return fmt.Errorf("capture node %q is not defined under nodes", sc.Capture.Node)
+		}
+		if sc.Capture.Interface == "" {
+			return fmt.Errorf("capture.interface is required with capture.node")
+		}
+	}
+	for _, f := range sc.Faults {
+		what, _, _ := strings.Cut(f, "@")
+		parts := strings.Split(what, ":")
+		if len(parts) < 2 {
+			return fmt.Errorf("fault %q: want kind:node[:target]@at[/duration]", f)
+		}
+		if _, ok := sc.Nodes[parts[1]]; !ok {
+			return fmt.Errorf("fault %q: node %q is not defined under nodes", f, parts[1])
+		}
+	}
+	if sc.Iterations < 1 || sc.Migration.Count < 1 {
+		return fmt.Errorf("iterations and migration.count must be >= 1")
+	}
+	return nil
+}
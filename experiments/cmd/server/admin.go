@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// handleDisconnect serves POST /admin/disconnect?peer=ID[,ID...], which
+// closes the given peers (peer=all for every one) so reconnection paths can
+// be exercised from the server side. mode=close (the default) sends a
+// WebSocket close frame first, as a restarting server would; mode=reset
+// drops the TCP connection with an RST, as a crashed one would. Sessions
+// are kept, so the peers can resume with their tokens. It needs the
+// -auth-token bearer token and is refused (403) when none is set, so the
+// metrics port alone never lets anyone kick peers.
+func (s *server) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = "close"
+	}
+	if mode != "close" && mode != "reset" {
+		http.Error(w, "mode must be close or reset", http.StatusBadRequest)
+		return
+	}
+	all := q.Get("peer") == "all"
+	want := map[uint64]bool{}
+	if !all {
+		for _, v := range strings.Split(q.Get("peer"), ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				http.Error(w, "peer must be comma-separated peer IDs or all", http.StatusBadRequest)
+				return
+			}
+			want[id] = true
+		}
+	}
+
+	var victims []*client
+	s.mu.RLock()
+	for id, c := range s.clients {
+		if all || want[id] {
+			victims = append(victims, c)
+			delete(want, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	closed := make([]uint64, 0, len(victims))
+	for _, c := range victims {
+		if mode == "reset" {
+			if tc, ok := c.conn.UnderlyingConn().(*net.TCPConn); ok {
+				tc.SetLinger(0)
+			}
+		} else {
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseServiceRestart, "disconnected by admin"), time.Now().Add(time.Second))
+		}
+		c.conn.Close()
+		s.peersKicked.Add(1)
+		s.events.peerEvent("peer_kicked", c.id, map[string]any{"mode": mode})
+		closed = append(closed, c.id)
+	}
+	missing := make([]uint64, 0, len(want))
+	for id := range want {
+		missing = append(missing, id)
+	}
+	log.Printf("Admin disconnect (%s): %d peers closed, %d not connected", mode, len(closed), len(missing))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"mode": mode, "disconnected": closed, "missing": missing})
+}
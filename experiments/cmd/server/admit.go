@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// admitLimiter paces new /ws sessions (-accept-rate). After a restore every
+// loadgen peer redials at once; admitting them all in the same instant
+// competes with the restored process for CPU and stretches the very
+// downtime being measured. Requests wait for a slot instead, in arrival
+// order, and are refused once the wait would exceed maxWait.
+type admitLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // between admissions
+	burst    time.Duration // how far next may lag behind now
+	maxWait  time.Duration
+	next     time.Time
+}
+
+func newAdmitLimiter(rate float64, burst int, maxWait time.Duration) *admitLimiter {
+	interval := time.Duration(float64(time.Second) / rate)
+	return &admitLimiter{
+		interval: interval,
+		burst:    time.Duration(max(burst, 1)) * interval,
+		maxWait:  maxWait,
+	}
+}
+
+// reserve returns the wait before the caller may proceed, or ok=false if it
+// would be longer than maxWait (nothing is reserved then).
+func (l *admitLimiter) reserve(now time.Time) (wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot := l.next
+	if earliest := now.Add(-l.burst + l.interval); slot.Before(earliest) {
+		slot = earliest
+	}
+	wait = max(slot.Sub(now), 0)
+	if wait > l.maxWait {
+		return wait, false
+	}
+	l.next = slot.Add(l.interval)
+	return wait, true
+}
+
+// admit blocks r until the limiter has a slot for it. It writes a 503 and
+// returns false if the queue is too long or the client gives up waiting.
+func (s *server) admit(w http.ResponseWriter, r *http.Request) bool {
+	if s.admits == nil {
+		return true
+	}
+	wait, ok := s.admits.reserve(time.Now())
+	if !ok {
+		s.setupErrors.throttled.Add(1)
+		refuseRetry(w, "too many new sessions, retry later", wait)
+		return false
+	}
+	if wait == 0 {
+		return true
+	}
+	s.admitsQueued.Add(1)
+	defer s.admitsQueued.Add(-1)
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		s.setupErrors.throttled.Add(1)
+		return false
+	}
+}
+
+// refuseRetry answers 503 with a Retry-After of wait, in whole seconds as
+// the header allows.
+func refuseRetry(w http.ResponseWriter, msg string, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireToken wraps h with bearer-token auth when -auth-token is set.
+// The token is accepted from "Authorization: Bearer <token>" or, for
+// browser WebSocket clients that cannot set headers, a ?token= parameter.
+// /health and /metrics stay open so the collector needs no credentials.
+func (s *server) requireToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *authToken == "" {
+			h(w, r)
+			return
+		}
+		got := r.URL.Query().Get("token")
+		if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			got = v
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(*authToken)) != 1 {
+			s.setupErrors.unauthorized.Add(1)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requireAdminToken is requireToken for endpoints that must never be open,
+// such as POST /admin/disconnect: without -auth-token they answer 403.
+func (s *server) requireAdminToken(h http.HandlerFunc) http.HandlerFunc {
+	auth := s.requireToken(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *authToken == "" {
+			http.Error(w, "forbidden: this endpoint needs the server to run with -auth-token", http.StatusForbidden)
+			return
+		}
+		auth(w, r)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Receiver-report driven rate adaptation (-adaptive). The loadgen sends
+// {"type":"rr","bytes_received":N,"ts":T} every -feedback-interval; the
+// server compares what arrived with what it has written. Bytes written but
+// not yet reported are queued in socket buffers or on the path, so a growing
+// backlog means the sender outruns the bottleneck, which is the same signal
+// TWCC gives a WebRTC sender.
+const (
+	// ccQueueTarget is how much in-flight data (as time at the delivered
+	// rate) is tolerated before backing off.
+	ccQueueTarget = 100 * time.Millisecond
+	ccBackoff     = 0.85
+	ccIncrease    = 1.05
+	// ccMinScale is the lowest fraction of the nominal frame size used.
+	ccMinScale = 0.05
+	// ccHalfRateScale is the scale below which the frame rate is halved
+	// as well, like an encoder dropping frames at low bandwidth.
+	ccHalfRateScale = 0.25
+)
+
+// bwEstimator is one peer's estimate. observe runs on the reader
+// goroutine; scale is read by the writer, hence the atomic.
+type bwEstimator struct {
+	nominalBits atomic.Uint64 // float64 bits: bits/s the source produces at scale 1
+	lastRecv    uint64
+	lastTs      int64
+	overQueue   int           // consecutive reports with backlog above target
+	estimate    atomic.Uint64 // delivered bits/s
+	scaleBits   atomic.Uint64 // float64 bits of the frame size scale
+}
+
+func newBWEstimator(nominalBps int) *bwEstimator {
+	e := &bwEstimator{}
+	e.setNominal(nominalBps)
+	e.scaleBits.Store(math.Float64bits(1))
+	return e
+}
+
+// setNominal changes the bitrate at scale 1 after a runtime config change.
+func (e *bwEstimator) setNominal(bps int) { e.nominalBits.Store(math.Float64bits(float64(bps))) }
+
+func (e *bwEstimator) nominal() float64 { return math.Float64frombits(e.nominalBits.Load()) }
+
+func (e *bwEstimator) scale() float64 { return math.Float64frombits(e.scaleBits.Load()) }
+
+// observe handles one receiver report: bytesRecv is the peer's running
+// byte count at its clock ts (UnixNano), sent the server's running count.
+func (e *bwEstimator) observe(bytesRecv uint64, ts int64, sent uint64) {
+	defer func() { e.lastRecv, e.lastTs = bytesRecv, ts }()
+	if e.lastTs == 0 || ts <= e.lastTs || bytesRecv < e.lastRecv {
+		return
+	}
+	dt := time.Duration(ts - e.lastTs).Seconds()
+	delivered := float64(bytesRecv-e.lastRecv) * 8 / dt
+	e.estimate.Store(uint64(delivered))
+
+	scale := e.scale()
+	backlog := float64(sent-min(sent, bytesRecv)) * 8
+	if backlog > delivered*ccQueueTarget.Seconds() {
+		e.overQueue++
+	} else {
+		e.overQueue = 0
+	}
+	// One report over target can just be a keyframe in flight; a second
+	// in a row means the queue is really building.
+	switch {
+	case e.overQueue >= 2 && delivered > 0:
+		scale = min(scale, ccBackoff*delivered/e.nominal())
+	case e.overQueue == 0:
+		scale *= ccIncrease
+	}
+	e.scaleBits.Store(math.Float64bits(max(ccMinScale, min(1, scale))))
+}
+
+// targetBps is the bitrate the source is currently asked to produce.
+func (e *bwEstimator) targetBps() float64 { return e.nominal() * e.scale() }
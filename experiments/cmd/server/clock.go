@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CRIU restores the monotonic readings held in time.Time values as they
+// were, but CLOCK_MONOTONIC on the restore host is only continuous with the
+// old one when the container runs in a time namespace; otherwise it is
+// unrelated and durations spanning the restore come out hours off or
+// negative. The wall clock agrees on both hosts (NTP), so durations that
+// may span a checkpoint use wallSub, and clockWatch uses the two clocks'
+// disagreement to notice a restore.
+
+// wallSub is a.Sub(b) on the wall clock, ignoring monotonic readings.
+func wallSub(a, b time.Time) time.Duration { return a.Round(0).Sub(b.Round(0)) }
+
+// clockWatchPeriod is how often clockWatch compares the clocks, and
+// clockJump how far a clock must be off the schedule (or the two clocks
+// apart) to count as a jump rather than scheduling noise.
+const (
+	clockWatchPeriod = 100 * time.Millisecond
+	clockJump        = time.Second
+)
+
+// clockWatch tracks freezes of the process: checkpoint/restore, or a
+// SIGSTOP. uptime_seconds is wall time since start and includes them;
+// active_seconds leaves them out. Only a restore makes both clocks jump
+// and disagree with each other: after a SIGSTOP they are late by the same
+// amount, and an NTP step moves the wall clock alone, so neither counts as
+// a restore. It also polls -restore-marker, which tells it about a
+// restore for certain, clock jump or not.
+type clockWatch struct {
+	mu         sync.Mutex
+	frozen     time.Duration
+	restoredAt time.Time // wall clock, zero before the first restore
+	restores   uint64
+}
+
+func (w *clockWatch) run(s *server) {
+	t := time.NewTicker(clockWatchPeriod)
+	defer t.Stop()
+	last := time.Now()
+	for now := range t.C {
+		wall, mono := wallSub(now, last), now.Sub(last)
+		last = now
+		if *restoreMarker != "" {
+			if err := os.Remove(*restoreMarker); err == nil {
+				w.restored(s, "marker", now, nil)
+			}
+		}
+		wallOff := (wall - clockWatchPeriod).Abs() >= clockJump
+		monoOff := (mono - clockWatchPeriod).Abs() >= clockJump
+		if !wallOff && !monoOff {
+			continue
+		}
+		if !wallOff || !monoOff {
+			log.Printf("Clock step: %s wall, %s monotonic since the last tick (one clock only, not a freeze)",
+				wall.Round(time.Millisecond), mono.Round(time.Millisecond))
+			continue
+		}
+		w.mu.Lock()
+		w.frozen += max(wall-clockWatchPeriod, 0)
+		w.mu.Unlock()
+		if (wall - mono).Abs() < clockJump {
+			log.Printf("Paused: %s since the last tick on both clocks (stopped, not restored)", wall.Round(time.Millisecond))
+			continue
+		}
+		log.Printf("Clock jump: %s wall, %s monotonic since the last tick (restored)",
+			wall.Round(time.Millisecond), mono.Round(time.Millisecond))
+		w.restored(s, "clock", now, map[string]any{
+			"wall_ms": wall.Milliseconds(), "monotonic_ms": mono.Milliseconds(),
+		})
+	}
+}
+
+// restoreMerge is how close a clock jump and the -restore-marker must be
+// to count as one restore; the orchestrator creates the marker a few
+// hundred ms after CRIU resumes the process.
+const restoreMerge = 5 * time.Second
+
+// restored records a restore seen by source ("clock" or "marker") at now.
+// The first sighting counts it, publishes a restored event, restarts the
+// producers and tells peers that asked with ?notify=1; a second sighting
+// of the same restore is only logged.
+func (w *clockWatch) restored(s *server, source string, now time.Time, fields map[string]any) {
+	now = now.Round(0)
+	w.mu.Lock()
+	if !w.restoredAt.IsZero() && wallSub(now, w.restoredAt) < restoreMerge {
+		at := w.restoredAt
+		w.mu.Unlock()
+		log.Printf("Restore also seen by %s, %s after the first sighting", source, wallSub(now, at).Round(time.Millisecond))
+		return
+	}
+	w.restoredAt = now
+	w.restores++
+	w.mu.Unlock()
+
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	fields["source"] = source
+	fields["restored_at_ns"] = now.UnixNano()
+	log.Printf("Restored (%s)", source)
+	s.events.emit("restored", nil, fields)
+	s.restartProducers("restore")
+	s.notifyPeers(noticeMsg{Type: "restored", Ts: now.UnixNano(), Source: source})
+}
+
+// clockStats are the clockWatch figures for /metrics, in seconds.
+// SinceRestore is nil until a restore is seen.
+type clockStats struct {
+	UptimeSeconds float64
+	ActiveSeconds float64
+	SinceRestore  *float64
+	Restores      uint64
+}
+
+func (w *clockWatch) stats(start time.Time) clockStats {
+	now := time.Now()
+	up := wallSub(now, start)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := clockStats{
+		UptimeSeconds: up.Seconds(),
+		ActiveSeconds: max(up-w.frozen, 0).Seconds(),
+		Restores:      w.restores,
+	}
+	if !w.restoredAt.IsZero() {
+		since := wallSub(now, w.restoredAt).Seconds()
+		st.SinceRestore = &since
+	}
+	return st
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamConfig is the shape of the synthetic stream. It starts from -fps,
+// -target-bitrate / -frame-size, -gop and -profile and is replaced by POST
+// /config.
+// Writers compare the pointer before each frame and reconfigure their
+// source when it changed, so a change needs no restart and leaves the
+// process (and what CRIU checkpoints) otherwise untouched.
+type streamConfig struct {
+	FPS           int `json:"fps"`
+	TargetBitrate int `json:"target_bitrate"` // bits/s; 0 = -frame-size padding
+	GOP           int `json:"gop"`
+	// Profile is the traffic profile as given, see trafficProfile.
+	Profile string `json:"profile"`
+	padding int    // per full-layer frame, derived from the above
+	profile *trafficProfile
+}
+
+var streamCfg atomic.Pointer[streamConfig]
+
+// configMu serialises POST /config so concurrent updates don't lose fields.
+var configMu sync.Mutex
+
+func newStreamConfig(fps, bitrate, gop int, profile string, start time.Time) (*streamConfig, error) {
+	p, err := parseProfile(profile, start)
+	if err != nil {
+		return nil, err
+	}
+	c := &streamConfig{FPS: fps, TargetBitrate: bitrate, GOP: gop, Profile: profile, padding: *frameSize, profile: p}
+	if bitrate > 0 {
+		c.padding = paddingForBitrate(bitrate, fps)
+	}
+	return c, nil
+}
+
+// paddingFor returns the padding for a peer with per-peer bitrate, which
+// overrides the configured one.
+func (c *streamConfig) paddingFor(bitrate int) int {
+	if bitrate > 0 {
+		return paddingForBitrate(bitrate, c.FPS)
+	}
+	return c.padding
+}
+
+// configUpdate is the body of POST /config. Every field is optional;
+// fields left out keep their current value. Example:
+//
+//	{"fps": 60, "target_bitrate": 2000000, "gop": 60, "profile": "ramp:period=30s"}
+//
+// A profile restarts from the update even when unchanged.
+type configUpdate struct {
+	FPS           *int    `json:"fps"`
+	TargetBitrate *int    `json:"target_bitrate"`
+	GOP           *int    `json:"gop"`
+	Profile       *string `json:"profile"`
+}
+
+func (u configUpdate) apply(cur *streamConfig) (*streamConfig, error) {
+	fps, bitrate, gop := cur.FPS, cur.TargetBitrate, cur.GOP
+	profile, start := cur.Profile, cur.profile.start
+	if u.Profile != nil {
+		profile, start = *u.Profile, time.Now()
+	}
+	if u.FPS != nil {
+		fps = *u.FPS
+	}
+	if u.TargetBitrate != nil {
+		bitrate = *u.TargetBitrate
+	}
+	if u.GOP != nil {
+		gop = *u.GOP
+	}
+	switch {
+	case fps < 1 || fps > 1000:
+		return nil, fmt.Errorf("fps must be 1-1000, got %d", fps)
+	case bitrate < 0:
+		return nil, fmt.Errorf("target_bitrate must be >= 0, got %d", bitrate)
+	case gop < 1:
+		return nil, fmt.Errorf("gop must be >= 1, got %d", gop)
+	}
+	c, err := newStreamConfig(fps, bitrate, gop, profile, start)
+	if err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	return c, nil
+}
+
+// handleConfig serves GET /config (the current stream shape) and POST
+// /config (change it for all connected and future peers).
+func (s *server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if video != nil {
+			http.Error(w, "runtime config only applies to the synthetic stream, not -video-file", http.StatusConflict)
+			return
+		}
+		var u configUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		configMu.Lock()
+		prev := streamCfg.Load()
+		next, err := u.apply(prev)
+		if err != nil {
+			configMu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		streamCfg.Store(next)
+		configMu.Unlock()
+		log.Printf("Config: fps %d -> %d, target_bitrate %d -> %d, gop %d -> %d, profile %s -> %s",
+			prev.FPS, next.FPS, prev.TargetBitrate, next.TargetBitrate, prev.GOP, next.GOP, prev.Profile, next.Profile)
+		s.events.emit("config_changed", nil, map[string]any{
+			"fps": next.FPS, "target_bitrate": next.TargetBitrate, "gop": next.GOP, "profile": next.Profile,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(streamCfg.Load())
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// registerDebug adds net/http/pprof and GET /debug/runtime to mux. They sit
+// on the metrics listener so a restored server can be profiled with
+// `go tool pprof http://<host>:8081/debug/pprof/profile` instead of
+// attaching a debugger to the CRIU-restored process.
+func registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/runtime", handleRuntime)
+}
+
+// gcPauseHistory is how many recent GC pauses /debug/runtime lists.
+const gcPauseHistory = 16
+
+type runtimeStats struct {
+	Goroutines     int        `json:"goroutines"`
+	GOMAXPROCS     int        `json:"gomaxprocs"`
+	HeapAllocBytes uint64     `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64     `json:"heap_inuse_bytes"`
+	HeapObjects    uint64     `json:"heap_objects"`
+	SysBytes       uint64     `json:"sys_bytes"`
+	NumGC          uint32     `json:"num_gc"`
+	LastGC         *time.Time `json:"last_gc,omitempty"`
+	PauseTotalMs   float64    `json:"gc_pause_total_ms"`
+	// RecentPausesMs are the latest GC pauses, newest first.
+	RecentPausesMs []float64 `json:"gc_recent_pauses_ms"`
+	GCCPUFraction  float64   `json:"gc_cpu_fraction"`
+}
+
+func handleRuntime(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	st := runtimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		HeapAllocBytes: m.HeapAlloc,
+		HeapInuseBytes: m.HeapInuse,
+		HeapObjects:    m.HeapObjects,
+		SysBytes:       m.Sys,
+		NumGC:          m.NumGC,
+		PauseTotalMs:   float64(m.PauseTotalNs) / 1e6,
+		RecentPausesMs: []float64{},
+		GCCPUFraction:  m.GCCPUFraction,
+	}
+	if m.LastGC != 0 {
+		t := time.Unix(0, int64(m.LastGC))
+		st.LastGC = &t
+	}
+	// PauseNs is a circular buffer; the latest pause is at (NumGC+255)%256.
+	for i := uint32(0); i < min(m.NumGC, gcPauseHistory); i++ {
+		idx := (m.NumGC - 1 - i) % uint32(len(m.PauseNs))
+		st.RecentPausesMs = append(st.RecentPausesMs, float64(m.PauseNs[idx])/1e6)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
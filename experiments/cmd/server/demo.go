@@ -0,0 +1,44 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// demoPage joins the stream from a browser (GET /), for eyeballing
+// migration glitches next to the loadgen's numbers.
+//
+//go:embed demo.html
+var demoPage []byte
+
+func handleDemo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(demoPage)
+}
+
+// allowOrigin reports whether a browser page from origin may use the
+// server (-cors-origin).
+func allowOrigin(origin string) bool {
+	return *corsOrigin == "*" || origin == "" || origin == *corsOrigin
+}
+
+// cors adds CORS headers for browser clients served from another origin
+// and answers preflight requests.
+func cors(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && allowOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", *corsOrigin)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Server-Instance, "+sessionHeader+", "+sessionResumedHeader)
+			if *corsOrigin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, r)
+	}
+}
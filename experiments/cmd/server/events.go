@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
+)
+
+// event is one server-side occurrence, written as a JSONL line to
+// -event-log and served by GET /events.
+type event struct {
+	Seq  uint64 `json:"seq"`
+	Time string `json:"time"`
+	// TsMs is the wall clock in Unix milliseconds, the collector's
+	// timestamp_unix_milli, for aligning events with its rows.
+	TsMs   int64          `json:"ts_unix_milli"`
+	Type   string         `json:"type"`
+	Peer   *uint64        `json:"peer,omitempty"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+// eventRingSize bounds how many events GET /events can return.
+const eventRingSize = 10000
+
+// eventLog keeps recent events in memory and appends every event to an
+// optional file, an optional event bus and an optional webhook.
+type eventLog struct {
+	mu   sync.Mutex
+	seq  uint64
+	ring []event // circular once full: ring[head] is the oldest event
+	head int
+	file *os.File
+	bus  *eventbus.Client
+	hook *webhook
+}
+
+func newEventLog(path string) (*eventLog, error) {
+	l := &eventLog{}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		l.file = f
+	}
+	return l, nil
+}
+
+// emit records an event. peer is nil for server-wide events.
+func (l *eventLog) emit(typ string, peer *uint64, fields map[string]any) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	ev := event{
+		Seq:    l.seq,
+		Time:   now.Format(time.RFC3339Nano),
+		TsMs:   now.UnixMilli(),
+		Type:   typ,
+		Peer:   peer,
+		Fields: fields,
+	}
+	if len(l.ring) < eventRingSize {
+		l.ring = append(l.ring, ev)
+	} else {
+		l.ring[l.head] = ev
+		l.head = (l.head + 1) % eventRingSize
+	}
+	if l.file != nil {
+		data, _ := json.Marshal(ev)
+		if _, err := l.file.Write(append(data, '\n')); err != nil {
+			log.Printf("event log: %v", err)
+		}
+	}
+	l.hook.send(ev)
+	if l.bus != nil {
+		bf := map[string]any{"seq": ev.Seq}
+		for k, v := range fields {
+			bf[k] = v
+		}
+		if peer != nil {
+			bf["peer"] = *peer
+		}
+		l.bus.Publish(typ, bf)
+	}
+}
+
+// peerEvent records an event about one client.
+func (l *eventLog) peerEvent(typ string, id uint64, fields map[string]any) {
+	l.emit(typ, &id, fields)
+}
+
+// since returns the buffered events newer than tsMs (Unix milliseconds).
+func (l *eventLog) since(tsMs int64) []event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := []event{}
+	for _, part := range [][]event{l.ring[l.head:], l.ring[:l.head]} {
+		for _, ev := range part {
+			if ev.TsMs > tsMs {
+				out = append(out, ev)
+			}
+		}
+	}
+	return out
+}
+
+// handleEvents serves GET /events?since=<unix ms>; without since it
+// returns everything still buffered.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "since must be Unix milliseconds", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.events.since(since))
+}
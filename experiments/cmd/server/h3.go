@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns the optional QUIC signaling listener (-h3-addr).
+// It serves the signaling handlers over HTTP/3 so the loadgen can compare
+// how quickly a QUIC path to the server recovers after a move with the TCP
+// one. /ws itself stays on TCP: gorilla has no WebSocket-over-HTTP/3.
+func newHTTP3Server(addr string, h http.Handler) (*http3.Server, error) {
+	cert, err := loadOrGenerateCert(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	return &http3.Server{
+		Addr:      addr,
+		Handler:   h,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		// A checkpoint freezes the process for seconds; a short idle
+		// timeout would drop every QUIC connection across each one.
+		QUICConfig: &quic.Config{MaxIdleTimeout: 30 * time.Second},
+	}, nil
+}
+
+// loadOrGenerateCert loads -tls-cert/-tls-key, or makes a throwaway
+// self-signed certificate when neither is set.
+func loadOrGenerateCert(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stream-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -1,40 +1,140 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/eventbus"
 )
 
 var (
-	listenAddr  = flag.String("signaling-addr", ":8080", "HTTP address for WebSocket + health")
-	metricsAddr = flag.String("metrics-addr", ":8081", "HTTP address for metrics")
-	dataFPS     = flag.Int("fps", 30, "Data frames per second sent to each client")
+	listenAddr    = flag.String("signaling-addr", ":8080", "HTTP address for WebSocket + health")
+	metricsAddr   = flag.String("metrics-addr", ":8081", "HTTP address for metrics")
+	dataFPS       = flag.Int("fps", 30, "Data frames per second sent to each client")
+	frameSize     = flag.Int("frame-size", 512, "Padding bytes per synthetic frame")
+	targetBps     = flag.Int("target-bitrate", 0, "Per-client synthetic bitrate in bits/s; overrides -frame-size (0 = use -frame-size)")
+	resolution    = flag.String("resolution", "640x480", "Frame size the synthetic stream's full simulcast layer advertises (h and q halve it per step) in each frame's width and height")
+	profileSpec   = flag.String("profile", "constant", "Traffic profile of the synthetic stream, a factor on every peer's frame size over time: constant, square or ramp, as kind[:option=value...] with options low, high (factors up to 4) and period, e.g. square:low=0.5:high=1.5:period=10s (POST /config changes it)")
+	gopLength     = flag.Int("gop", 30, "Synthetic frames per GOP: one keyframe followed by gop-1 delta frames (1 = keyframes only)")
+	keyRatio      = flag.Float64("keyframe-ratio", 8, "Size of a synthetic keyframe relative to a delta frame")
+	audioBps      = flag.Int("audio-bitrate", 32000, "Bitrate of the synthetic Opus-shaped audio stream for clients that request it (0 = never send audio)")
+	stateFile     = flag.String("state-file", "", "Persist session state (counters, peers, start time) here and restore it on startup")
+	drainTimeout  = flag.Duration("drain-timeout", 2*time.Second, "On SIGTERM, how long to wait for peers to close before force-closing them")
+	finalStats    = flag.String("final-stats", "", "On SIGTERM, write the final /metrics JSON to this file")
+	stateEvery    = flag.Duration("state-interval", time.Second, "How often to write -state-file")
+	recordFile    = flag.String("record", "", "Append a CSV row of the server's own metrics to this file every -record-interval")
+	recordEvery   = flag.Duration("record-interval", time.Second, "How often to append to -record")
+	maxPeers      = flag.Int("max-peers", 0, "Refuse new peers with 503 while this many are connected (0 = no limit)")
+	acceptRate    = flag.Float64("accept-rate", 0, "Admit at most this many new /ws sessions per second, queueing the rest (0 = unlimited)")
+	acceptBurst   = flag.Int("accept-burst", 10, "Sessions -accept-rate admits back to back before pacing")
+	acceptWait    = flag.Duration("accept-wait", 2*time.Second, "Longest a /ws request queues for -accept-rate before 503 + Retry-After")
+	idleTimeout   = flag.Duration("peer-idle-timeout", 0, "Close peers that send nothing (pings, pongs) for this long (0 = never)")
+	sessionTTL    = flag.Duration("session-ttl", 5*time.Minute, "How long a disconnected peer's session token stays resumable (?session=)")
+	eventLogPath  = flag.String("event-log", "", "Append server events (peers, quiesce, keyframe requests, stalls) to this JSONL file; GET /events serves recent ones either way")
+	eventBusAddr  = flag.String("event-bus", "", "Also publish server events to the collector's event bus at this address (host:port)")
+	webhookURL    = flag.String("webhook-url", "", "Also POST server events as JSON (the objects GET /events returns) to this URL")
+	webhookEvents = flag.String("webhook-events", "peer_connected,peer_disconnected,restored,keyframe_storm", "Comma-separated event types to POST to -webhook-url (empty = all)")
+	kfStorm       = flag.Int("keyframe-storm", 10, "Emit a keyframe_storm event when this many PLI/FIR arrive from all peers within a second (0 = never)")
+	adaptive      = flag.Bool("adaptive", false, "Adapt each peer's synthetic frame size and rate to receiver reports (loadgen -feedback-interval)")
+	peerRateLimit = flag.Int("peer-rate-limit", 0, "Cap each peer's send rate at this many bits/s with a token bucket; ?max_rate= overrides per peer (0 = unlimited)")
+	authToken     = flag.String("auth-token", "", "Require this bearer token on /ws and control endpoints (default $STREAM_AUTH_TOKEN; empty = no auth, and POST /admin/disconnect is refused)")
+	ipFamily      = flag.String("ip-family", "", "Listen on IPv4 (4) or IPv6 (6) only; empty = dual-stack")
+	h3Addr        = flag.String("h3-addr", "", "Also serve the signaling handlers over HTTP/3 (QUIC) on this UDP address, e.g. :8443 (empty = off)")
+	tlsCert       = flag.String("tls-cert", "", "TLS certificate for -h3-addr (default: generate a self-signed one)")
+	tlsKey        = flag.String("tls-key", "", "TLS key for -h3-addr")
+	corsOrigin    = flag.String("cors-origin", "*", "Origin allowed to use /ws and /health from a browser (* = any)")
+	videoFile     = flag.String("video-file", "", "Stream this pre-encoded file on loop (binary frames) instead of synthetic JSON frames")
+	udpSinkAddr   = flag.String("udp-sink-addr", "", "Count cmd/pktgen's UDP flow on this address, e.g. :9000, and report it as udp_sink in /metrics (empty = off)")
+	udpGapEvent   = flag.Duration("udp-gap-event", 20*time.Millisecond, "Log a udp_gap event when -udp-sink-addr sees no datagram for this long (0 = never)")
+	videoCodec    = flag.String("codec", "", "Codec of -video-file: vp8 or vp9 (IVF), h264 (Annex B, paced at -fps); empty = detect from IVF header")
+	transcriptDir = flag.String("transcript-dir", "", "Write each peer's signaling (handshake, control messages in and out) with timestamps to peer-<id>.jsonl in this directory (empty = off)")
+	producerStall = flag.Duration("producer-stall", 250*time.Millisecond, "Count a producer stall (producer_stalls) when a write to a peer blocks this long or writes start failing (0 = only failures)")
+	restoreMarker = flag.String("restore-marker", "", "Announce a restore (restored event, ?notify=1 peers) when this file appears; the orchestrator's -restore-marker creates it in the restored container, and the server removes it")
+	quiesceRetry  = flag.Duration("quiesce-retry-after", 0, "While quiesced (SIGUSR2 before checkpoint), refuse new /ws sessions with 503 and this Retry-After, and send ?notify=1 peers a checkpoint notice (0 = accept them as usual)")
+	reofferResume = flag.Bool("reoffer-on-resume", false, "When SIGUSR2 ends a quiesce (after restore), push a reoffer to every peer that accepts them so it redials at once; POST /reoffer does the same on demand")
 )
 
+// video is the loaded -video-file, shared read-only by all clients.
+var video *videoClip
+
+// newFrameSource returns the stream for one client. A per-peer bitrate
+// (?bitrate= on the WebSocket URL) overrides the synthetic frame size.
+func newFrameSource(bitrate int, cfg *streamConfig) frameSource {
+	if video != nil {
+		return &clipSource{file: video}
+	}
+	return newSyntheticSource(cfg.FPS, cfg.paddingFor(bitrate), cfg.GOP, *keyRatio)
+}
+
+// nominalBitrate is the bitrate a peer's synthetic source produces before
+// rate adaptation.
+func nominalBitrate(bitrate int, cfg *streamConfig) int {
+	switch {
+	case bitrate > 0:
+		return bitrate
+	case cfg.TargetBitrate > 0:
+		return cfg.TargetBitrate
+	}
+	envelope, _ := json.Marshal(dataMsg{Seq: 1 << 20, Ts: time.Now().UnixNano(), RID: "f", Width: frameWidth, Height: frameHeight})
+	return (cfg.padding + len(envelope)) * 8 * cfg.FPS
+}
+
 // quiesced is toggled by SIGUSR2. When true, the writer goroutines skip
 // sending data frames, letting the kernel TCP send queue drain before a
 // CRIU checkpoint. After restore, cr_hw.sh sends SIGUSR2 again to resume.
 var quiesced atomic.Bool
 
+// quiescePoll is how often a quiesced writer re-checks the flag.
+const quiescePoll = 10 * time.Millisecond
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: func(r *http.Request) bool { return allowOrigin(r.Header.Get("Origin")) },
 }
 
+// clientMsg is an echo request, or a control message when Type is set.
+// "pli" / "fir" ask for a keyframe, like their RTCP namesakes; "layer"
+// selects a simulcast layer (rid) and/or maximum temporal layer (tid);
+// "rr" is a receiver report for -adaptive.
 type clientMsg struct {
-	Seq int   `json:"seq"`
-	Ts  int64 `json:"ts"`
+	Type string `json:"type,omitempty"`
+	Seq  int    `json:"seq"`
+	Ts   int64  `json:"ts"`
+	RID  string `json:"rid,omitempty"`
+	TID  *int   `json:"tid,omitempty"`
+	// BytesReceived is the peer's running byte count in an "rr" receiver
+	// report, taken at Ts.
+	BytesReceived uint64 `json:"bytes_received,omitempty"`
+	// The rest of an "rr", see rrStats: running frame counts, and the
+	// newest frame's ts with the ns since it arrived.
+	FramesReceived uint64 `json:"frames_received,omitempty"`
+	FramesLost     uint64 `json:"frames_lost,omitempty"`
+	LSR            int64  `json:"lsr,omitempty"`
+	DLSR           int64  `json:"dlsr,omitempty"`
+}
+
+// layerSel is a requested simulcast layer; tid -1 keeps the current one.
+type layerSel struct {
+	rid string
+	tid int
 }
 
 type echoMsg struct {
@@ -46,45 +146,175 @@ type echoMsg struct {
 type dataMsg struct {
 	Seq     int    `json:"seq"`
 	Ts      int64  `json:"ts"`
+	Key     bool   `json:"key"`
+	RID     string `json:"rid,omitempty"` // simulcast layer
+	TID     int    `json:"tid"`           // temporal layer
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
 	Size    int    `json:"size"`
 	Padding string `json:"padding,omitempty"`
 }
 
 type server struct {
+	// instanceID is generated once per process. It lives in memory, so a
+	// CRIU-restored server reports the same ID while a restarted one does not.
+	instanceID   string
 	mu           sync.RWMutex
-	clients      map[uint64]*websocket.Conn
+	clients      map[uint64]*client
+	sessions     map[string]*session // by token, guarded by mu
 	nextClientID uint64
 	startTime    time.Time
 	totalClients atomic.Int64
 	bytesSent    atomic.Uint64
 	bytesRecv    atomic.Uint64
-	cpu          *cpuTracker
+	// keyframeRequests counts PLI/FIR control messages from clients.
+	keyframeRequests atomic.Int64
+	kfStorm          keyframeStorm
+	videoFramesSent  atomic.Uint64
+	audioFramesSent  atomic.Uint64
+	setupErrors      setupErrors
+	writeErrors      atomic.Uint64
+	producerStalls   atomic.Uint64 // see -producer-stall
+	peersReaped      atomic.Uint64
+	peersKicked      atomic.Uint64 // by POST /admin/disconnect
+	sessionsResumed  atomic.Uint64
+	// wireSentClosed / wireRetransClosed hold the kernel byte counters of
+	// peers that already left, so the totals stay monotonic.
+	wireSentClosed    atomic.Uint64
+	wireRetransClosed atomic.Uint64
+	// admits paces new sessions (nil without -accept-rate); admitsQueued
+	// is how many requests are waiting for it.
+	admits            *admitLimiter
+	admitsQueued      atomic.Int64
+	setupLatency      *histogram
+	firstFrameLatency *histogram
+	cpu               *cpuTracker
+	events            *eventLog
+	udpSink           *udpSink // nil without -udp-sink-addr
+	clock             clockWatch
+	// draining is set once shutdown starts: /ws refuses new peers with 503
+	// and /health reports "draining".
+	draining atomic.Bool
 }
 
 func newServer() *server {
+	idBuf := make([]byte, 8)
+	if _, err := rand.Read(idBuf); err != nil {
+		log.Fatalf("generate instance ID: %v", err)
+	}
 	return &server{
-		clients:   make(map[uint64]*websocket.Conn),
-		startTime: time.Now(),
-		cpu:       newCPUTracker(),
+		instanceID:        hex.EncodeToString(idBuf),
+		clients:           make(map[uint64]*client),
+		sessions:          make(map[string]*session),
+		startTime:         time.Now(),
+		cpu:               newCPUTracker(),
+		setupLatency:      newLatencyHistogram(),
+		firstFrameLatency: newLatencyHistogram(),
 	}
 }
 
-func (s *server) addClient(conn *websocket.Conn) uint64 {
+// client is one connected peer. Each client has its own writer goroutine
+// and frameSource, so rate and byte accounting are per peer.
+type client struct {
+	id        uint64
+	conn      *websocket.Conn
+	createdAt time.Time
+	bitrate   int // synthetic bits/s for this peer (0 = server default)
+	maxRate   int // send rate cap in bits/s (0 = unlimited)
+	bytesSent atomic.Uint64
+	bytesRecv atomic.Uint64
+	// rttNs is the latest WebSocket ping/pong round trip; rr has the one
+	// from receiver reports.
+	rttNs atomic.Int64
+	rr    rrStats
+	// stalled is set while writes to the peer are failing.
+	stalled atomic.Bool
+	// bwe is the receiver-report bandwidth estimator (nil without -adaptive).
+	bwe *bwEstimator
+	// lastSeen is the UnixNano time of the last message or pong from the
+	// peer, for the idle reaper.
+	lastSeen atomic.Int64
+	sess     *session
+	// sentBase is bytesSent carried over from the session when the
+	// connection started; receiver reports count from this connection only.
+	sentBase uint64
+	// reoffer queues a reoffer for the writer; nil unless the peer
+	// connected with ?reoffer=1.
+	reoffer chan struct{}
+	// notify queues noticeMsg JSON for the writer; nil unless the peer
+	// connected with ?notify=1.
+	notify chan []byte
+}
+
+func (c *client) touch(now time.Time) { c.lastSeen.Store(now.UnixNano()) }
+
+// rttPingInterval is how often the writer sends a WebSocket ping carrying
+// its send time; the pong handler turns the echo into client.rttNs.
+const rttPingInterval = time.Second
+
+// addClient registers c under sess, which the caller claimed before the
+// upgrade. A resumed session keeps its peer ID and is not a new client.
+// Other goroutines read c's fields once it is in s.clients, so the caller
+// fills them in first.
+func (s *server) addClient(c *client, sess *session, resumed bool) {
+	c.createdAt = time.Now()
+	c.touch(c.createdAt)
 	s.mu.Lock()
-	id := s.nextClientID
-	s.nextClientID++
-	s.clients[id] = conn
+	sess.attach(c)
+	s.clients[c.id] = c
+	if resumed {
+		sess.resumes++
+	}
 	s.mu.Unlock()
-	s.totalClients.Add(1)
-	return id
+	if resumed {
+		s.sessionsResumed.Add(1)
+	} else {
+		s.totalClients.Add(1)
+	}
 }
 
-func (s *server) removeClient(id uint64) {
+func (s *server) removeClient(c *client) {
 	s.mu.Lock()
-	delete(s.clients, id)
+	if s.clients[c.id] == c {
+		delete(s.clients, c.id)
+	}
+	c.sess.detach(c, time.Now())
 	s.mu.Unlock()
 }
 
+// retireWire folds a departing peer's kernel counters into the totals.
+func (s *server) retireWire(c *client) {
+	if st, err := readTCPInfo(c.conn.UnderlyingConn()); err == nil {
+		s.wireSentClosed.Add(st.bytesSent)
+		s.wireRetransClosed.Add(st.bytesRetrans)
+	}
+}
+
+// wireTotals returns bytes sent and retransmitted on the wire, from
+// TCP_INFO, across all peers past and present.
+func (s *server) wireTotals() (sent, retrans uint64) {
+	sent, retrans = s.wireSentClosed.Load(), s.wireRetransClosed.Load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, c := range s.clients {
+		if st, err := readTCPInfo(c.conn.UnderlyingConn()); err == nil {
+			sent += st.bytesSent
+			retrans += st.bytesRetrans
+		}
+	}
+	return sent, retrans
+}
+
+// listen opens addr for -ip-family. Plain "tcp" on a wildcard address is a
+// dual-stack socket, so IPv6 clients reach the default ":8080" too.
+func listen(addr string) (net.Listener, error) {
+	network := "tcp"
+	if *ipFamily != "" {
+		network += *ipFamily
+	}
+	return net.Listen(network, addr)
+}
+
 func (s *server) connectedCount() int {
 	s.mu.RLock()
 	n := len(s.clients)
@@ -93,82 +323,280 @@ func (s *server) connectedCount() int {
 }
 
 func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	start := time.Now()
+	if s.draining.Load() {
+		s.setupErrors.draining.Add(1)
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if *quiesceRetry > 0 && quiesced.Load() {
+		s.setupErrors.quiesced.Add(1)
+		refuseRetry(w, "server is quiesced for a checkpoint, retry later", *quiesceRetry)
+		return
+	}
+	if !s.admit(w, r) {
+		return
+	}
+	if *maxPeers > 0 && s.connectedCount() >= *maxPeers {
+		s.setupErrors.rejected.Add(1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many peers", http.StatusServiceUnavailable)
+		return
+	}
+	s.mu.Lock()
+	sess, resumed := s.claimSession(r.URL.Query().Get("session"), start)
+	s.mu.Unlock()
+	hdr := http.Header{}
+	hdr.Set("X-Server-Instance", s.instanceID)
+	hdr.Set(sessionHeader, sess.token)
+	if resumed {
+		hdr.Set(sessionResumedHeader, "1")
+	}
+	conn, err := upgrader.Upgrade(w, r, hdr)
 	if err != nil {
+		s.setupErrors.upgrade.Add(1)
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
+	s.setupLatency.observe(time.Since(start))
 
-	clientID := s.addClient(conn)
-	log.Printf("[client-%d] connected", clientID)
+	var bitrate int
+	if v := r.URL.Query().Get("bitrate"); v != "" {
+		bitrate, _ = strconv.Atoi(v)
+	}
+	maxRate := *peerRateLimit
+	if v := r.URL.Query().Get("max_rate"); v != "" {
+		maxRate, _ = strconv.Atoi(v)
+	}
+	cl := &client{conn: conn, bitrate: bitrate, maxRate: maxRate}
+	if r.URL.Query().Get("reoffer") == "1" {
+		cl.reoffer = make(chan struct{}, 1)
+	}
+	if r.URL.Query().Get("notify") == "1" {
+		cl.notify = make(chan []byte, 4)
+	}
+	if *adaptive && video == nil {
+		cl.bwe = newBWEstimator(nominalBitrate(bitrate, streamCfg.Load()))
+	}
+	s.addClient(cl, sess, resumed)
+	clientID := cl.id
+	// Clients opt into the audio stream with ?audio=1, the WebSocket
+	// counterpart of offering an audio m-line.
+	wantAudio := *audioBps > 0 && r.URL.Query().Get("audio") == "1"
+	tr := openTranscript(*transcriptDir, clientID)
+	defer tr.close()
+	tr.record("in", "handshake", map[string]any{
+		"url": r.URL.String(), "local": conn.LocalAddr().String(), "remote": conn.RemoteAddr().String(),
+		"user_agent": r.UserAgent(),
+	})
+	tr.record("out", "handshake", map[string]any{
+		"status": http.StatusSwitchingProtocols, "instance": s.instanceID, "session": sess.token,
+		"resumed": resumed, "audio": wantAudio, "bitrate": bitrate, "max_rate": maxRate,
+	})
+	log.Printf("[client-%d] connected local=%s remote=%s audio=%t resumed=%t", clientID, conn.LocalAddr(), conn.RemoteAddr(), wantAudio, resumed)
+	s.events.peerEvent("peer_connected", clientID, map[string]any{
+		"local": conn.LocalAddr().String(), "remote": conn.RemoteAddr().String(),
+		"audio": wantAudio, "bitrate": bitrate, "setup_ms": float64(time.Since(start)) / 1e6,
+		"resumed": resumed,
+	})
 
 	// Echoes go through a channel so the reader never blocks on writes
 	// (avoids deadlock when the TCP send buffer fills post-migration).
 	echoCh := make(chan []byte, 64)
 
+	// A keyframe request preempts the pacing timer so the keyframe goes
+	// out immediately; repeated requests before it is sent coalesce.
+	keyReq := make(chan struct{}, 1)
+
+	// Layer switches are applied by the writer, which owns the source.
+	// The initial layer comes from ?rid= and ?tid= on the URL.
+	layerReq := make(chan layerSel, 4)
+	if q := r.URL.Query(); q.Get("rid") != "" || q.Get("tid") != "" {
+		sel := layerSel{rid: q.Get("rid"), tid: -1}
+		if v := q.Get("tid"); v != "" {
+			sel.tid, _ = strconv.Atoi(v)
+		}
+		layerReq <- sel
+	}
+
 	done := make(chan struct{})
 
 	// gorilla/websocket requires serialised writes
 	var writeMu sync.Mutex
-	writeMsg := func(data []byte) error {
+	writeMsg := func(msgType int, data []byte) error {
 		writeMu.Lock()
 		defer writeMu.Unlock()
 		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-		return conn.WriteMessage(websocket.TextMessage, data)
+		return conn.WriteMessage(msgType, data)
 	}
 
 	// Writer: periodic data frames + echo responses.
 	// Tolerates transient write failures so a brief CRIU migration outage
 	// doesn't kill the goroutine.
 	go func() {
-		frameDuration := time.Second / time.Duration(*dataFPS)
-		ticker := time.NewTicker(frameDuration)
-		defer ticker.Stop()
+		// Frames are paced against a deadline rather than a ticker because
+		// file frames have individual durations.
+		cfg := streamCfg.Load()
+		src := newFrameSource(cl.bitrate, cfg)
+		vp := newPacer()
+		defer vp.timer.Stop()
+
+		// The audio stream has its own sequence space and pacer; a nil
+		// channel disables its select case.
+		var audio *audioSource
+		var audioC <-chan time.Time
+		var ap *pacer
+		if wantAudio {
+			audio = newAudioSource(*audioBps)
+			ap = newPacer()
+			defer ap.timer.Stop()
+			audioC = ap.timer.C
+		}
+		audioSeq := 0
+
+		var limit *tokenBucket
+		if cl.maxRate > 0 {
+			limit = newTokenBucket(cl.maxRate)
+		}
+		// A video frame held back by the limiter is kept until it fits.
+		var pendType int
+		var pending []byte
+		var pendWait time.Duration
+
+		rttTick := time.NewTicker(rttPingInterval)
+		defer rttTick.Stop()
 
 		const consecutiveErrLimit = 30
 		writeErrs := 0
 
-		tryWrite := func(data []byte) bool {
-			if err := writeMsg(data); err != nil {
+		tryWrite := func(msgType int, data []byte) bool {
+			t0 := time.Now()
+			err := writeMsg(msgType, data)
+			blocked := time.Since(t0)
+			if (*producerStall > 0 && blocked >= *producerStall) || (err != nil && writeErrs == 0) {
+				s.producerStalls.Add(1)
+				s.events.peerEvent("producer_stalled", clientID, map[string]any{
+					"blocked_ms": float64(blocked) / 1e6, "failed": err != nil,
+				})
+				if err == nil {
+					log.Printf("[client-%d] write blocked for %s", clientID, blocked.Round(time.Millisecond))
+				}
+			}
+			if err != nil {
 				writeErrs++
+				s.writeErrors.Add(1)
+				cl.stalled.Store(true)
+				if writeErrs == 1 {
+					s.events.peerEvent("write_stalled", clientID, map[string]any{"error": err.Error()})
+				}
 				if writeErrs == 1 || writeErrs%10 == 0 {
 					log.Printf("[client-%d] write error (%d consecutive): %v",
 						clientID, writeErrs, err)
 				}
 				if writeErrs >= consecutiveErrLimit {
-					log.Printf("[client-%d] giving up after %d consecutive write errors",
+					log.Printf("[client-%d] giving up after %d consecutive write errors, closing",
 						clientID, writeErrs)
+					// gorilla keeps the first write error for good, so this
+					// connection will not carry frames again. Closing it ends
+					// the read loop as well, and the peer can resume its
+					// session with a fresh writer instead of idling here.
+					conn.Close()
 					return false
 				}
 				return true
 			}
 			if writeErrs > 0 {
 				log.Printf("[client-%d] write recovered after %d errors", clientID, writeErrs)
+				s.events.peerEvent("write_recovered", clientID, map[string]any{"errors": writeErrs})
 			}
 			writeErrs = 0
-			s.bytesSent.Add(uint64(len(data)))
+			cl.stalled.Store(false)
+			if limit != nil {
+				limit.debit(len(data), time.Now())
+			}
+			// Pings are control frames the peer never counts, so leaving
+			// them out keeps bytes_sent comparable with its bytes_received.
+			if msgType != websocket.PingMessage {
+				s.bytesSent.Add(uint64(len(data)))
+				cl.bytesSent.Add(uint64(len(data)))
+			}
 			return true
 		}
 
 		seq := 0
-		paddingBuf := make([]byte, 512)
-		for i := range paddingBuf {
-			paddingBuf[i] = 'x'
-		}
-		paddingStr := string(paddingBuf)
-
 		for {
 			select {
 			case <-done:
 				return
 
 			case echoData := <-echoCh:
-				if !tryWrite(echoData) {
+				if !tryWrite(websocket.TextMessage, echoData) {
 					return
 				}
 
-			case <-ticker.C:
+			case <-*producerKick.Load():
+				vp.hold(0)
+				if ap != nil {
+					ap.hold(0)
+				}
+				src.forceKeyframe()
+				pending = nil
+
+			case data := <-cl.notify:
+				if !tryWrite(websocket.TextMessage, data) {
+					return
+				}
+				tr.record("out", "notice", map[string]any{"msg": json.RawMessage(data)})
+
+			case <-cl.reoffer:
+				data, _ := json.Marshal(reofferMsg{Type: "reoffer", Ts: time.Now().UnixNano()})
+				if !tryWrite(websocket.TextMessage, data) {
+					return
+				}
+				tr.record("out", "reoffer", map[string]any{"msg": json.RawMessage(data)})
+				s.events.peerEvent("reoffer_sent", clientID, nil)
+
+			case <-audioC:
 				if quiesced.Load() {
+					ap.hold(quiescePoll)
+					continue
+				}
+				msgType, frame, wait := audio.next(audioSeq)
+				if !tryWrite(msgType, frame) {
+					return
+				}
+				s.audioFramesSent.Add(1)
+				audioSeq++
+				ap.advance(wait)
+
+			case <-rttTick.C:
+				if quiesced.Load() {
+					continue
+				}
+				ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+				if !tryWrite(websocket.PingMessage, []byte(ts)) {
+					return
+				}
+
+			case sel := <-layerReq:
+				if !src.selectLayer(sel.rid, sel.tid) {
+					log.Printf("[client-%d] layer rid=%q tid=%d not available", clientID, sel.rid, sel.tid)
+					continue
+				}
+				log.Printf("[client-%d] layer rid=%q tid=%d", clientID, sel.rid, sel.tid)
+				s.events.peerEvent("layer_changed", clientID, map[string]any{"rid": sel.rid, "tid": sel.tid})
+				pending = nil
+
+			case <-keyReq:
+				src.forceKeyframe()
+				pending = nil // replace a held-back delta with the keyframe
+				if !quiesced.Load() {
+					vp.hold(0)
+				}
+
+			case <-vp.timer.C:
+				if quiesced.Load() {
+					vp.hold(quiescePoll)
 					continue
 				}
 
@@ -176,7 +604,7 @@ func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
 				for {
 					select {
 					case echoData := <-echoCh:
-						if !tryWrite(echoData) {
+						if !tryWrite(websocket.TextMessage, echoData) {
 							return
 						}
 					default:
@@ -184,36 +612,102 @@ func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
 					}
 				}
 
-				msg := dataMsg{
-					Seq:     seq,
-					Ts:      time.Now().UnixNano(),
-					Size:    512,
-					Padding: paddingStr,
+				if pending == nil {
+					if next := streamCfg.Load(); next != cfg {
+						cfg = next
+						src.configure(cfg.FPS, cfg.paddingFor(cl.bitrate), cfg.GOP)
+						if cl.bwe != nil {
+							cl.bwe.setNominal(nominalBitrate(cl.bitrate, cfg))
+						}
+					}
+					scale := cfg.profile.factor(time.Now())
+					if cl.bwe != nil {
+						scale *= cl.bwe.scale()
+					}
+					src.setScale(scale)
+					pendType, pending, pendWait = src.next(seq)
+				}
+				if limit != nil {
+					if d := limit.delay(len(pending), time.Now()); d > 0 {
+						vp.hold(d)
+						continue
+					}
 				}
-				data, _ := json.Marshal(msg)
-				if !tryWrite(data) {
+				if !tryWrite(pendType, pending) {
 					return
 				}
+				pending = nil
+				if seq == 0 {
+					s.firstFrameLatency.observe(time.Since(start))
+				}
+				s.videoFramesSent.Add(1)
 				seq++
+				vp.advance(pendWait)
 			}
 		}
 	}()
 
+	conn.SetPongHandler(func(appData string) error {
+		cl.touch(time.Now())
+		if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			cl.rttNs.Store(time.Now().UnixNano() - sent)
+		}
+		return nil
+	})
+
 	for {
 		_, raw, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
 		s.bytesRecv.Add(uint64(len(raw)))
+		cl.bytesRecv.Add(uint64(len(raw)))
+		cl.touch(time.Now())
 
-		// When quiesced, keep reading but skip echo writes so the
-		// TCP send buffer can drain before checkpoint.
-		if quiesced.Load() {
+		var cm clientMsg
+		if err := json.Unmarshal(raw, &cm); err != nil {
 			continue
 		}
+		if cm.Type != "" {
+			tr.record("in", cm.Type, map[string]any{"msg": json.RawMessage(raw)})
+		}
 
-		var cm clientMsg
-		if err := json.Unmarshal(raw, &cm); err != nil {
+		if cm.Type == "pli" || cm.Type == "fir" {
+			s.keyframeRequests.Add(1)
+			s.events.peerEvent("keyframe_request", clientID, map[string]any{"kind": cm.Type})
+			if n := s.kfStorm.observe(time.Now(), *kfStorm); n > 0 {
+				s.events.emit("keyframe_storm", nil, map[string]any{"requests": n, "window_ms": keyframeStormWindow.Milliseconds(), "peers": s.connectedCount()})
+			}
+			select {
+			case keyReq <- struct{}{}:
+			default:
+			}
+			continue
+		}
+
+		if cm.Type == "rr" {
+			cl.rr.observe(cm, time.Now())
+			if cl.bwe != nil {
+				cl.bwe.observe(cm.BytesReceived, cm.Ts, cl.bytesSent.Load()-cl.sentBase)
+			}
+			continue
+		}
+
+		if cm.Type == "layer" {
+			sel := layerSel{rid: cm.RID, tid: -1}
+			if cm.TID != nil {
+				sel.tid = *cm.TID
+			}
+			select {
+			case layerReq <- sel:
+			default:
+			}
+			continue
+		}
+
+		// When quiesced, keep reading but skip echo writes so the
+		// TCP send buffer can drain before checkpoint.
+		if quiesced.Load() {
 			continue
 		}
 
@@ -231,17 +725,24 @@ func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	close(done)
+	tr.record("", "closed", map[string]any{"duration_s": time.Since(cl.createdAt).Seconds()})
+	s.retireWire(cl)
 	conn.Close()
-	s.removeClient(clientID)
-	log.Printf("[client-%d] disconnected", clientID)
+	s.removeClient(cl)
+	log.Printf("[client-%d] disconnected after %s, sent %d B, received %d B",
+		clientID, time.Since(cl.createdAt).Round(time.Millisecond), cl.bytesSent.Load(), cl.bytesRecv.Load())
+	s.events.peerEvent("peer_disconnected", clientID, map[string]any{
+		"duration_s": time.Since(cl.createdAt).Seconds(),
+		"bytes_sent": cl.bytesSent.Load(), "bytes_received": cl.bytesRecv.Load(),
+	})
 }
 
 type cpuTracker struct {
-	mu          sync.Mutex
-	lastUser    uint64
-	lastSystem  uint64
-	lastWall    time.Time
-	cpuPercent  float64
+	mu         sync.Mutex
+	lastUser   uint64
+	lastSystem uint64
+	lastWall   time.Time
+	cpuPercent float64
 }
 
 func newCPUTracker() *cpuTracker {
@@ -309,6 +810,7 @@ func (ct *cpuTracker) sample() float64 {
 }
 
 type metricsResponse struct {
+	TimestampNs      int64   `json:"timestamp_unix_nano"` // when the snapshot was taken
 	ConnectedClients int     `json:"connected_clients"`
 	TotalClients     int64   `json:"total_clients"`
 	UptimeSeconds    float64 `json:"uptime_seconds"`
@@ -316,31 +818,256 @@ type metricsResponse struct {
 	BytesReceived    uint64  `json:"bytes_received"`
 	CPUPercent       float64 `json:"cpu_percent"`
 	MemoryMB         float64 `json:"memory_mb"`
+	ServerInstance   string  `json:"server_instance"`
+	KeyframeRequests int64   `json:"keyframe_requests"`
+	PeersReaped      uint64  `json:"peers_reaped"`
+	PeersKicked      uint64  `json:"peers_kicked"`
+	ProducerStalls   uint64  `json:"producer_stalls"`
+	SessionsResumed  uint64  `json:"sessions_resumed"`
+	PeersRejected    uint64  `json:"peers_rejected"`
+	SessionsQueued   int64   `json:"sessions_queued"`
+	// Session setup: request to upgrade, request to first video frame,
+	// and refused or failed setups by reason.
+	SessionSetup  latencySummary    `json:"session_setup"`
+	FirstFrame    latencySummary    `json:"first_frame"`
+	SessionErrors map[string]uint64 `json:"session_errors"`
+	// PeerBytesSent attributes bytes_sent to each connected client ID.
+	PeerBytesSent map[string]uint64 `json:"peer_bytes_sent"`
+	// WireBytesSent counts TCP payload that left the sockets (framing,
+	// pings and retransmissions included); bytes_sent only counts
+	// WebSocket message payloads.
+	WireBytesSent    uint64 `json:"wire_bytes_sent"`
+	WireBytesRetrans uint64 `json:"wire_bytes_retrans"`
+	// PeerRR is each peer's receiver-report RTT and loss, for peers that
+	// send reports.
+	PeerRR map[string]rrInfo `json:"peer_rr,omitempty"`
+	// ProfileFactor is the -profile frame size factor now.
+	ProfileFactor float64 `json:"profile_factor"`
+	// PeerTargetBps is each peer's adapted bitrate (-adaptive only).
+	PeerTargetBps map[string]float64 `json:"peer_target_bps,omitempty"`
+	// UDPSink counts cmd/pktgen's flow (-udp-sink-addr only).
+	UDPSink *udpSinkStats `json:"udp_sink,omitempty"`
+	// Webhook counts undelivered -webhook-url events.
+	Webhook *webhookStats `json:"webhook,omitempty"`
+	// Freezes and restores seen by clockWatch, see clockStats.
+	ActiveSeconds    float64  `json:"active_seconds"`
+	TimeSinceRestore *float64 `json:"time_since_restore_seconds,omitempty"`
+	RestoresDetected uint64   `json:"restores_detected"`
+}
+
+func (s *server) peerTargetBps() map[string]float64 {
+	if !*adaptive {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]float64, len(s.clients))
+	for id, c := range s.clients {
+		if c.bwe != nil {
+			out[strconv.FormatUint(id, 10)] = c.bwe.targetBps()
+		}
+	}
+	return out
+}
+
+func (s *server) peerBytesSent() map[string]uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]uint64, len(s.clients))
+	for id, c := range s.clients {
+		out[strconv.FormatUint(id, 10)] = c.bytesSent.Load()
+	}
+	return out
+}
+
+// peerInfo is one entry of GET /peers.
+type peerInfo struct {
+	ID            uint64  `json:"id"`
+	State         string  `json:"state"` // connected, stalled or quiesced
+	LocalAddr     string  `json:"local_addr"`
+	RemoteAddr    string  `json:"remote_addr"`
+	BytesSent     uint64  `json:"bytes_sent"`
+	BytesReceived uint64  `json:"bytes_received"`
+	RttMs         float64 `json:"rtt_ms"`
+	AgeSeconds    float64 `json:"age_seconds"`
+	// SessionResumes counts reconnects that presented this peer's token.
+	SessionResumes int `json:"session_resumes"`
+	Bitrate        int `json:"bitrate,omitempty"`
+	MaxRate        int `json:"max_rate,omitempty"`
+	// BWEBps and TargetBps are set with -adaptive: the delivered rate from
+	// receiver reports and the rate the source is adapted to.
+	BWEBps    float64 `json:"bwe_bps,omitempty"`
+	TargetBps float64 `json:"target_bps,omitempty"`
+	// Kernel TCP_INFO counters for the peer's socket.
+	WireBytesSent    uint64  `json:"wire_bytes_sent"`
+	WireBytesRetrans uint64  `json:"wire_bytes_retrans"`
+	TCPRetransmits   uint32  `json:"tcp_retransmits"`
+	TCPNotSent       uint32  `json:"tcp_notsent_bytes"`
+	TCPRttMs         float64 `json:"tcp_rtt_ms"`
+	// RR has the peer's receiver-report RTT and loss, once it sent any.
+	RR *rrInfo `json:"rr,omitempty"`
+}
+
+func (s *server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	s.mu.RLock()
+	peers := make([]peerInfo, 0, len(s.clients))
+	for _, c := range s.clients {
+		state := "connected"
+		switch {
+		case quiesced.Load():
+			state = "quiesced"
+		case c.stalled.Load():
+			state = "stalled"
+		}
+		var bwe, target float64
+		if c.bwe != nil {
+			bwe, target = float64(c.bwe.estimate.Load()), c.bwe.targetBps()
+		}
+		st, _ := readTCPInfo(c.conn.UnderlyingConn())
+		peers = append(peers, peerInfo{
+			ID:               c.id,
+			State:            state,
+			LocalAddr:        c.conn.LocalAddr().String(),
+			RemoteAddr:       c.conn.RemoteAddr().String(),
+			BytesSent:        c.bytesSent.Load(),
+			BytesReceived:    c.bytesRecv.Load(),
+			RttMs:            float64(c.rttNs.Load()) / 1e6,
+			AgeSeconds:       now.Sub(c.createdAt).Seconds(),
+			SessionResumes:   c.sess.resumes,
+			Bitrate:          c.bitrate,
+			MaxRate:          c.maxRate,
+			BWEBps:           bwe,
+			TargetBps:        target,
+			WireBytesSent:    st.bytesSent,
+			WireBytesRetrans: st.bytesRetrans,
+			TCPRetransmits:   st.totalRetrans,
+			TCPNotSent:       st.notSent,
+			TCPRttMs:         float64(st.rtt) / 1e6,
+			RR:               c.rr.info(),
+		})
+	}
+	s.mu.RUnlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
 }
 
 func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if wantsPrometheus(r) {
+		s.writePrometheus(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.metricsSnapshot())
+}
+
+func (s *server) metricsSnapshot() metricsResponse {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(metricsResponse{
+	wireSent, wireRetrans := s.wireTotals()
+	clk := s.clock.stats(s.startTime)
+	return metricsResponse{
+		TimestampNs:      time.Now().UnixNano(),
 		ConnectedClients: s.connectedCount(),
 		TotalClients:     s.totalClients.Load(),
-		UptimeSeconds:    time.Since(s.startTime).Seconds(),
+		UptimeSeconds:    clk.UptimeSeconds,
 		BytesSent:        s.bytesSent.Load(),
 		BytesReceived:    s.bytesRecv.Load(),
 		CPUPercent:       s.cpu.sample(),
 		MemoryMB:         float64(m.Sys) / 1024 / 1024,
-	})
+		ServerInstance:   s.instanceID,
+		KeyframeRequests: s.keyframeRequests.Load(),
+		PeersReaped:      s.peersReaped.Load(),
+		PeersKicked:      s.peersKicked.Load(),
+		ProducerStalls:   s.producerStalls.Load(),
+		SessionsResumed:  s.sessionsResumed.Load(),
+		PeersRejected:    s.setupErrors.rejected.Load(),
+		SessionsQueued:   s.admitsQueued.Load(),
+		SessionSetup:     s.setupLatency.summary(),
+		FirstFrame:       s.firstFrameLatency.summary(),
+		SessionErrors:    s.setupErrors.byReason(),
+		PeerBytesSent:    s.peerBytesSent(),
+		WireBytesSent:    wireSent,
+		WireBytesRetrans: wireRetrans,
+		PeerRR:           s.peerRR(),
+		ProfileFactor:    streamCfg.Load().profile.factor(time.Now()),
+		PeerTargetBps:    s.peerTargetBps(),
+		UDPSink:          s.udpSink.snapshot(),
+		Webhook:          s.events.hook.snapshot(),
+		ActiveSeconds:    clk.ActiveSeconds,
+		TimeSinceRestore: clk.SinceRestore,
+		RestoresDetected: clk.Restores,
+	}
 }
 
 func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if s.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"status":"draining"}`)
+		return
+	}
 	fmt.Fprint(w, `{"status":"ok"}`)
 }
 
 func main() {
 	flag.Parse()
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	if *authToken == "" {
+		*authToken = os.Getenv("STREAM_AUTH_TOKEN")
+	}
+
+	if *videoFile != "" {
+		v, err := loadClip(*videoFile, *videoCodec, *dataFPS)
+		if err != nil {
+			log.Fatalf("-video-file: %v", err)
+		}
+		video = v
+		log.Printf("Streaming %s: %s %dx%d, %d frames", *videoFile, v.codec, v.width, v.height, len(v.frames))
+	}
+
+	if *ipFamily != "" && *ipFamily != "4" && *ipFamily != "6" {
+		log.Fatalf("-ip-family must be 4, 6 or empty, got %q", *ipFamily)
+	}
+	if *keyRatio < 1 {
+		log.Fatalf("-keyframe-ratio must be >= 1, got %g", *keyRatio)
+	}
+	if *dataFPS < 1 || *gopLength < 1 {
+		log.Fatalf("-fps and -gop must be >= 1")
+	}
+	w, h, err := parseResolution(*resolution)
+	if err != nil {
+		log.Fatalf("-resolution: %v", err)
+	}
+	frameWidth, frameHeight = w, h
+	cfg, err := newStreamConfig(*dataFPS, *targetBps, *gopLength, *profileSpec, time.Now())
+	if err != nil {
+		log.Fatalf("-profile: %v", err)
+	}
+	streamCfg.Store(cfg)
+	if *targetBps > 0 {
+		log.Printf("Synthetic frames: %d B padding for %d bit/s at %d fps", cfg.padding, *targetBps, *dataFPS)
+	}
+
+	s := newServer()
+	evlog, err := newEventLog(*eventLogPath)
+	if err != nil {
+		log.Fatalf("-event-log: %v", err)
+	}
+	if *eventBusAddr != "" {
+		if evlog.bus, err = eventbus.Dial(*eventBusAddr, "server"); err != nil {
+			log.Fatalf("-event-bus: %v", err)
+		}
+	}
+	if *webhookURL != "" {
+		if _, err := url.ParseRequestURI(*webhookURL); err != nil {
+			log.Fatalf("-webhook-url: %v", err)
+		}
+		evlog.hook = newWebhook(*webhookURL, *webhookEvents)
+	}
+	s.events = evlog
+	go s.clock.run(s)
 
 	// SIGUSR2 toggles quiesce mode for pre-checkpoint send-queue drain
 	sigCh := make(chan os.Signal, 1)
@@ -351,28 +1078,128 @@ func main() {
 			quiesced.Store(!prev)
 			if !prev {
 				log.Println("SIGUSR2: quiesced — data frames paused (send queue draining)")
+				s.events.emit("quiesced", nil, nil)
+				if *quiesceRetry > 0 {
+					s.notifyPeers(noticeMsg{Type: "checkpoint", Ts: time.Now().UnixNano(), RetryAfterMs: quiesceRetry.Milliseconds()})
+				}
 			} else {
 				log.Println("SIGUSR2: resumed — data frames active")
+				s.events.emit("resumed", nil, nil)
+				s.restartProducers("resume")
+				if *reofferResume {
+					s.pushReoffers("resume")
+				}
 			}
 		}
 	}()
 
-	s := newServer()
+	if *transcriptDir != "" {
+		if err := os.MkdirAll(*transcriptDir, 0o755); err != nil {
+			log.Fatalf("-transcript-dir: %v", err)
+		}
+	}
+
+	if *stateFile != "" {
+		if err := s.loadState(*stateFile); err != nil {
+			log.Fatalf("-state-file: %v", err)
+		}
+		go s.persistState(*stateFile, *stateEvery)
+	}
+
+	if *recordFile != "" {
+		if err := s.recordStats(*recordFile, *recordEvery); err != nil {
+			log.Fatalf("-record: %v", err)
+		}
+	}
+
+	if *idleTimeout > 0 {
+		go s.reapIdlePeers(*idleTimeout)
+	}
+	if *acceptRate > 0 {
+		s.admits = newAdmitLimiter(*acceptRate, *acceptBurst, *acceptWait)
+	}
 
 	sigMux := http.NewServeMux()
-	sigMux.HandleFunc("/ws", s.handleWS)
-	sigMux.HandleFunc("/health", s.handleHealth)
+	sigMux.HandleFunc("/ws", cors(s.requireToken(s.handleWS)))
+	sigMux.HandleFunc("/health", cors(s.handleHealth))
+	sigMux.HandleFunc("GET /{$}", handleDemo)
 
 	metMux := http.NewServeMux()
 	metMux.HandleFunc("/metrics", s.handleMetrics)
+	metMux.HandleFunc("GET /peers", s.handlePeers)
+	metMux.HandleFunc("GET /events", s.handleEvents)
+	metMux.HandleFunc("GET /config", s.handleConfig)
+	metMux.HandleFunc("POST /config", s.requireToken(s.handleConfig))
+	metMux.HandleFunc("POST /reoffer", s.requireToken(s.handleReoffer))
+	metMux.HandleFunc("POST /admin/disconnect", s.requireAdminToken(s.handleDisconnect))
 	metMux.HandleFunc("/health", s.handleHealth)
+	registerDebug(metMux)
 
-	log.Printf("Stream server starting — ws=%s  metrics=%s  fps=%d",
-		*listenAddr, *metricsAddr, *dataFPS)
+	log.Printf("Stream server starting — ws=%s  metrics=%s  fps=%d  instance=%s  auth=%t",
+		*listenAddr, *metricsAddr, *dataFPS, s.instanceID, *authToken != "")
+	s.events.emit("server_started", nil, map[string]any{"instance": s.instanceID})
 
-	go func() {
-		log.Fatal(http.ListenAndServe(*metricsAddr, metMux))
-	}()
+	sigSrv := &http.Server{Addr: *listenAddr, Handler: sigMux}
+	metSrv := &http.Server{Addr: *metricsAddr, Handler: metMux}
+	activated, err := activatedListeners()
+	if err != nil {
+		log.Fatalf("socket activation: %v", err)
+	}
+	for i, srv := range []*http.Server{sigSrv, metSrv} {
+		var ln net.Listener
+		if i < len(activated) {
+			ln = activated[i]
+			log.Printf("Using socket-activated listener %s for %s", ln.Addr(), srv.Addr)
+		} else if ln, err = listen(srv.Addr); err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := srv.Serve(ln); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
 
-	log.Fatal(http.ListenAndServe(*listenAddr, sigMux))
+	if *udpSinkAddr != "" {
+		network := "udp"
+		if *ipFamily != "" {
+			network += *ipFamily
+		}
+		pc, err := net.ListenPacket(network, *udpSinkAddr)
+		if err != nil {
+			log.Fatalf("-udp-sink-addr: %v", err)
+		}
+		s.udpSink = &udpSink{flows: make(map[uint32]*udpFlow)}
+		go s.serveUDPSink(pc)
+		log.Printf("UDP sink on %s", pc.LocalAddr())
+	}
+
+	var h3Srv *http3.Server
+	if *h3Addr != "" {
+		h3Srv, err = newHTTP3Server(*h3Addr, sigMux)
+		if err != nil {
+			log.Fatalf("-h3-addr: %v", err)
+		}
+		go func() {
+			if err := h3Srv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatalf("HTTP/3: %v", err)
+			}
+		}()
+		log.Printf("HTTP/3 signaling on udp %s", *h3Addr)
+	}
+
+	// Both listeners are bound, so /ws and /health accept from here on.
+	sdNotify(fmt.Sprintf("READY=1\nSTATUS=serving, instance %s", s.instanceID))
+	go sdWatchdog()
+
+	// SIGTERM/SIGINT drain peers and exit cleanly instead of dropping
+	// every connection mid-frame.
+	termCh := make(chan os.Signal, 1)
+	signal.Notify(termCh, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-termCh
+	if h3Srv != nil {
+		h3Srv.Close()
+	}
+	s.shutdown(sig, sigSrv, metSrv)
+	log.Println("Shutdown complete")
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// producerKick is closed and replaced by restartProducers. Each writer
+// selects on the current channel and re-arms its pacers when it closes.
+var producerKick atomic.Pointer[chan struct{}]
+
+func init() {
+	kick := make(chan struct{})
+	producerKick.Store(&kick)
+}
+
+// restartProducers re-arms every writer's pacing timers from now. After a
+// CRIU restore the timers armed before the checkpoint can be far off: the
+// monotonic clock of the new host may be behind the old one, and a frame
+// due in 30 ms would then wait for the difference. Writers restarted this
+// way also force a keyframe, as the peer has just been through a freeze.
+func (s *server) restartProducers(reason string) {
+	kick := make(chan struct{})
+	close(*producerKick.Swap(&kick))
+	log.Printf("Producers restarted (%s)", reason)
+	s.events.emit("producers_restarted", nil, map[string]any{"reason": reason, "peers": s.connectedCount()})
+}
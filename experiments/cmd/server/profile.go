@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trafficProfile varies the offered load of the synthetic stream over
+// time, as a factor on every peer's frame size, to see how the switch
+// redirection and the -adaptive congestion control behave at changing
+// rates:
+//
+//	constant   factor 1, the plain -target-bitrate / -frame-size stream
+//	square     high for the first half of each period, low for the second
+//	ramp       low to high linearly over one period, then high
+//
+// It runs from when it was set (start, or the POST /config that set it),
+// on the wall clock, so a freeze advances it like any other time.
+type trafficProfile struct {
+	kind      string
+	low, high float64
+	period    time.Duration
+	start     time.Time
+}
+
+// maxProfileFactor bounds low and high, so the synthetic source can keep
+// one padding buffer large enough for any frame.
+const maxProfileFactor = 4
+
+// profileKinds are the -profile kinds, with their defaults.
+var profileKinds = map[string]trafficProfile{
+	"constant": {kind: "constant", low: 1, high: 1},
+	"square":   {kind: "square", low: 0.5, high: 1, period: 10 * time.Second},
+	"ramp":     {kind: "ramp", low: 0.1, high: 1, period: time.Minute},
+}
+
+// parseProfile parses kind[:option=value...] with options low, high
+// (factors, at most maxProfileFactor) and period (a duration), e.g.
+// "square:low=0.25:period=4s" or "ramp:high=2:period=30s". constant takes
+// none.
+func parseProfile(s string, start time.Time) (*trafficProfile, error) {
+	opts := strings.Split(strings.TrimSpace(s), ":")
+	p, ok := profileKinds[opts[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q (want constant, square or ramp)", opts[0])
+	}
+	if p.kind == "constant" && len(opts) > 1 {
+		return nil, fmt.Errorf("constant takes no options")
+	}
+	for _, o := range opts[1:] {
+		k, v, _ := strings.Cut(o, "=")
+		var err error
+		switch k {
+		case "low":
+			p.low, err = strconv.ParseFloat(v, 64)
+		case "high":
+			p.high, err = strconv.ParseFloat(v, 64)
+		case "period":
+			p.period, err = time.ParseDuration(v)
+		default:
+			err = fmt.Errorf("unknown option (want low, high or period)")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	switch {
+	case p.low < 0 || p.high <= 0:
+		return nil, fmt.Errorf("want low >= 0 and high > 0, got %g and %g", p.low, p.high)
+	case p.low > maxProfileFactor || p.high > maxProfileFactor:
+		return nil, fmt.Errorf("low and high must be at most %d, got %g and %g", maxProfileFactor, p.low, p.high)
+	case p.kind != "constant" && p.period <= 0:
+		return nil, fmt.Errorf("period must be > 0, got %s", p.period)
+	}
+	p.start = start
+	return &p, nil
+}
+
+// factor is the profile's frame size factor at now.
+func (p *trafficProfile) factor(now time.Time) float64 {
+	if p == nil {
+		return 1
+	}
+	t := max(wallSub(now, p.start), 0)
+	switch p.kind {
+	case "square":
+		if t%p.period < p.period/2 {
+			return p.high
+		}
+		return p.low
+	case "ramp":
+		if t >= p.period {
+			return p.high
+		}
+		return p.low + (p.high-p.low)*float64(t)/float64(p.period)
+	}
+	return 1
+}
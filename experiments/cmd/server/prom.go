@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// histogram is a minimal Prometheus-style cumulative histogram. The repo
+// avoids client_golang to keep the server dependency-free; the exposition
+// format is simple enough to write by hand.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64 // upper bounds in seconds, ascending
+	buckets []uint64  // buckets[i] counts observations <= bounds[i]
+	count   uint64
+	sum     float64
+	max     float64
+	last    float64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+// newLatencyHistogram covers sub-millisecond local setups up to the
+// multi-second ones seen right after a restore.
+func newLatencyHistogram() *histogram {
+	return newHistogram(0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05,
+		0.1, 0.25, 0.5, 1, 2.5, 5)
+}
+
+// setupErrors counts WebSocket sessions that failed before streaming.
+type setupErrors struct {
+	unauthorized atomic.Uint64 // bad or missing -auth-token
+	draining     atomic.Uint64 // refused during shutdown
+	quiesced     atomic.Uint64 // refused by -quiesce-retry-after
+	rejected     atomic.Uint64 // refused by -max-peers
+	throttled    atomic.Uint64 // refused or abandoned in the -accept-rate queue
+	upgrade      atomic.Uint64 // WebSocket handshake failed
+}
+
+func (e *setupErrors) byReason() map[string]uint64 {
+	return map[string]uint64{
+		"unauthorized": e.unauthorized.Load(),
+		"draining":     e.draining.Load(),
+		"quiesced":     e.quiesced.Load(),
+		"rejected":     e.rejected.Load(),
+		"throttled":    e.throttled.Load(),
+		"upgrade":      e.upgrade.Load(),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+	h.max = max(h.max, v)
+	h.last = v
+}
+
+// latencySummary is a histogram's JSON form in /metrics.
+type latencySummary struct {
+	Count  uint64  `json:"count"`
+	AvgMs  float64 `json:"avg_ms"`
+	MaxMs  float64 `json:"max_ms"`
+	LastMs float64 `json:"last_ms"`
+}
+
+func (h *histogram) summary() latencySummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ls := latencySummary{Count: h.count, MaxMs: h.max * 1e3, LastMs: h.last * 1e3}
+	if h.count > 0 {
+		ls.AvgMs = h.sum / float64(h.count) * 1e3
+	}
+	return ls
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+// wantsPrometheus reports whether a /metrics request asked for the text
+// exposition format, either explicitly (?format=prometheus) or through the
+// Accept header a Prometheus scraper sends. Plain curl and the collector
+// keep getting JSON.
+func wantsPrometheus(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "prometheus"
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text")
+}
+
+// clockTicks is USER_HZ, the unit of utime/stime in /proc/self/stat.
+const clockTicks = 100.0
+
+func writeMetric(w io.Writer, name, typ, help string, v any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, v)
+}
+
+func (s *server) writePrometheus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	user, sys := readProcCPU()
+	quiescedVal := 0
+	if quiesced.Load() {
+		quiescedVal = 1
+	}
+
+	fmt.Fprintf(w, "# HELP stream_server_info Server instance; changes on restart, not on CRIU restore.\n# TYPE stream_server_info gauge\n")
+	fmt.Fprintf(w, "stream_server_info{instance_id=%q} 1\n", s.instanceID)
+	writeMetric(w, "stream_peers_connected", "gauge", "Currently connected peers.", s.connectedCount())
+	writeMetric(w, "stream_peers_total", "counter", "Peers accepted since start.", s.totalClients.Load())
+	writeMetric(w, "stream_bytes_sent_total", "counter", "WebSocket payload bytes sent.", s.bytesSent.Load())
+	writeMetric(w, "stream_bytes_received_total", "counter", "WebSocket payload bytes received.", s.bytesRecv.Load())
+	wireSent, wireRetrans := s.wireTotals()
+	writeMetric(w, "stream_wire_bytes_sent_total", "counter", "TCP payload bytes sent per TCP_INFO, retransmissions included.", wireSent)
+	writeMetric(w, "stream_wire_bytes_retrans_total", "counter", "TCP payload bytes retransmitted per TCP_INFO.", wireRetrans)
+	writeMetric(w, "stream_video_frames_sent_total", "counter", "Video frames sent to all peers.", s.videoFramesSent.Load())
+	writeMetric(w, "stream_audio_frames_sent_total", "counter", "Audio frames sent to all peers.", s.audioFramesSent.Load())
+	writeMetric(w, "stream_keyframe_requests_total", "counter", "PLI/FIR requests received.", s.keyframeRequests.Load())
+	writeMetric(w, "stream_peers_reaped_total", "counter", "Idle peers closed by -peer-idle-timeout.", s.peersReaped.Load())
+	writeMetric(w, "stream_peers_kicked_total", "counter", "Peers closed by POST /admin/disconnect.", s.peersKicked.Load())
+	writeMetric(w, "stream_sessions_resumed_total", "counter", "Reconnects that resumed an existing session token.", s.sessionsResumed.Load())
+	writeMetric(w, "stream_peers_rejected_total", "counter", "Peers refused by -max-peers.", s.setupErrors.rejected.Load())
+	fmt.Fprintf(w, "# HELP stream_session_errors_total Sessions refused or failed during setup, by reason.\n# TYPE stream_session_errors_total counter\n")
+	for reason, n := range s.setupErrors.byReason() {
+		fmt.Fprintf(w, "stream_session_errors_total{reason=%q} %d\n", reason, n)
+	}
+	writeMetric(w, "stream_sessions_queued", "gauge", "/ws requests waiting for -accept-rate.", s.admitsQueued.Load())
+	writeMetric(w, "stream_write_errors_total", "counter", "Failed writes to peers.", s.writeErrors.Load())
+	writeMetric(w, "stream_producer_stalls_total", "counter", "Peer writers that blocked for -producer-stall or started failing.", s.producerStalls.Load())
+	writePeerRR(w, s.peerRR())
+	writeMetric(w, "stream_profile_factor", "gauge", "Frame size factor of the -profile traffic profile now.", streamCfg.Load().profile.factor(time.Now()))
+	writeMetric(w, "stream_quiesced", "gauge", "1 while data frames are paused for a checkpoint.", quiescedVal)
+	s.setupLatency.write(w, "stream_session_setup_seconds", "Time from WebSocket request to upgraded session.")
+	s.firstFrameLatency.write(w, "stream_first_frame_seconds", "Time from WebSocket request to the first video frame written.")
+	writeMetric(w, "process_cpu_seconds_total", "counter", "User and system CPU time.", float64(user+sys)/clockTicks)
+	writeMetric(w, "process_start_time_seconds", "gauge", "Start time (restored from -state-file if set).", float64(s.startTime.UnixNano())/1e9)
+	clk := s.clock.stats(s.startTime)
+	writeMetric(w, "stream_active_seconds", "gauge", "Wall time since start without detected freezes (checkpoint/restore).", clk.ActiveSeconds)
+	writeMetric(w, "stream_restores_detected_total", "counter", "Restores seen as a jump of both the wall and the monotonic clock that leaves them apart, or via -restore-marker.", clk.Restores)
+	if clk.SinceRestore != nil {
+		writeMetric(w, "stream_time_since_restore_seconds", "gauge", "Wall time since the last detected restore.", *clk.SinceRestore)
+	}
+	writeMetric(w, "go_memstats_sys_bytes", "gauge", "Bytes obtained from the OS.", m.Sys)
+	writeMetric(w, "go_goroutines", "gauge", "Number of goroutines.", runtime.NumGoroutine())
+}
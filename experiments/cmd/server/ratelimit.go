@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// tokenBucket caps one peer's send rate. Every write debits it, but only
+// video frames wait for tokens; audio and echoes never do, since they are
+// small and delaying them would distort RTT and audio cadence. Like the
+// pacer it is owned by the peer's writer goroutine and needs no locking.
+type tokenBucket struct {
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket allows bps bits/s with a burst of 100 ms worth of bytes.
+func newTokenBucket(bps int) *tokenBucket {
+	rate := float64(bps) / 8
+	return &tokenBucket{rate: rate, burst: rate / 10, tokens: rate / 10, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// delay returns how long an n-byte frame must wait for tokens (0 = send
+// now). A frame larger than the burst goes out once the bucket is full and
+// leaves it in debt, so big keyframes still pass.
+func (b *tokenBucket) delay(n int, now time.Time) time.Duration {
+	b.refill(now)
+	need := min(float64(n), b.burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// debit records n bytes sent.
+func (b *tokenBucket) debit(n int, now time.Time) {
+	b.refill(now)
+	b.tokens -= float64(n)
+}
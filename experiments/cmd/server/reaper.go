@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// reapIdlePeers closes peers that sent nothing (no echo request, control
+// message or pong) for longer than timeout. Those are connections whose
+// client is gone without a FIN, e.g. after a loadgen reconnect storm, and
+// would otherwise hold a writer goroutine until 30 write errors pile up.
+//
+// Peers are left alone while quiesced, and a sweep that runs much later
+// than scheduled is skipped: that means the process was frozen (CRIU
+// checkpoint/restore), and every peer would look idle for the freeze. The
+// wall clock catches a freeze that the monotonic clock hides, see wallSub.
+func (s *server) reapIdlePeers(timeout time.Duration) {
+	period := max(timeout/4, 100*time.Millisecond)
+	t := time.NewTicker(period)
+	defer t.Stop()
+	last := time.Now()
+	for now := range t.C {
+		late := wallSub(now, last) > 2*period || now.Sub(last) > 2*period
+		last = now
+		if late {
+			s.mu.RLock()
+			for _, c := range s.clients {
+				c.touch(now)
+			}
+			s.mu.RUnlock()
+			continue
+		}
+		if quiesced.Load() {
+			continue
+		}
+		s.mu.RLock()
+		for _, c := range s.clients {
+			if idle := now.Sub(time.Unix(0, c.lastSeen.Load())); idle > timeout {
+				log.Printf("[client-%d] idle for %s, closing", c.id, idle.Round(time.Millisecond))
+				s.peersReaped.Add(1)
+				s.events.peerEvent("peer_reaped", c.id, map[string]any{"idle_ms": idle.Milliseconds()})
+				c.conn.Close()
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
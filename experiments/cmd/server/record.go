@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// recordHeader matches the server columns of the collector CSV so both
+// files can be joined on timestamp_unix_milli.
+var recordHeader = []string{
+	"timestamp", "timestamp_unix_milli", "uptime_s",
+	"connected_clients", "total_clients", "bytes_sent", "bytes_received", "wire_bytes_sent",
+	"session_setups", "session_setup_last_ms", "sessions_resumed", "sessions_queued",
+	"peers_rejected", "keyframe_requests",
+	"cpu_percent", "memory_mb",
+	"server_instance",
+}
+
+// recordStats appends one row of the server's own metrics to path every
+// interval. The file is opened in append mode and flushed per row, so a
+// run that spans a checkpoint/restore keeps one continuous file even when
+// the collector cannot reach the container.
+func (s *server) recordStats(path string, interval time.Duration) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w := csv.NewWriter(f)
+	if fi.Size() == 0 {
+		w.Write(recordHeader)
+		w.Flush()
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for now := range t.C {
+			m := s.metricsSnapshot()
+			w.Write([]string{
+				now.Format(time.RFC3339Nano),
+				strconv.FormatInt(now.UnixMilli(), 10),
+				fmt.Sprintf("%.1f", m.UptimeSeconds),
+				strconv.Itoa(m.ConnectedClients),
+				strconv.FormatInt(m.TotalClients, 10),
+				strconv.FormatUint(m.BytesSent, 10),
+				strconv.FormatUint(m.BytesReceived, 10),
+				strconv.FormatUint(m.WireBytesSent, 10),
+				strconv.FormatUint(m.SessionSetup.Count, 10),
+				fmt.Sprintf("%.3f", m.SessionSetup.LastMs),
+				strconv.FormatUint(m.SessionsResumed, 10),
+				strconv.FormatInt(m.SessionsQueued, 10),
+				strconv.FormatUint(m.PeersRejected, 10),
+				strconv.FormatInt(m.KeyframeRequests, 10),
+				fmt.Sprintf("%.2f", m.CPUPercent),
+				fmt.Sprintf("%.2f", m.MemoryMB),
+				m.ServerInstance,
+			})
+			w.Flush()
+			if err := w.Error(); err != nil {
+				log.Printf("-record: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// reofferMsg asks a peer to redial with its session token, the WebSocket
+// counterpart of a server-initiated re-offer with an ICE restart. Only
+// peers that connected with ?reoffer=1 get it; others would take its ts
+// for a data frame. Ts is the server's send time.
+type reofferMsg struct {
+	Type string `json:"type"`
+	Ts   int64  `json:"ts"`
+}
+
+// noticeMsg is a server announcement to peers that connected with
+// ?notify=1, e.g. {"type":"restored","ts":...,"source":"marker"}, or
+// checkpoint with the Retry-After new sessions get until the restore. Ts
+// is the server's time of the event.
+type noticeMsg struct {
+	Type         string `json:"type"`
+	Ts           int64  `json:"ts"`
+	Source       string `json:"source,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+}
+
+// notifyPeers queues msg for every peer that asked for notices. A peer
+// whose queue is full misses it.
+func (s *server) notifyPeers(msg noticeMsg) {
+	data, _ := json.Marshal(msg)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, c := range s.clients {
+		if c.notify == nil {
+			continue
+		}
+		select {
+		case c.notify <- data:
+		default:
+		}
+	}
+}
+
+// pushReoffers queues a reoffer on every peer that accepts them and returns
+// how many did. trigger ("resume" or "api") goes into the event.
+func (s *server) pushReoffers(trigger string) int {
+	n := 0
+	s.mu.RLock()
+	for _, c := range s.clients {
+		if c.reoffer == nil {
+			continue
+		}
+		select {
+		case c.reoffer <- struct{}{}:
+			n++
+		default:
+		}
+	}
+	s.mu.RUnlock()
+	log.Printf("Reoffer pushed to %d peers (%s)", n, trigger)
+	s.events.emit("reoffer_pushed", nil, map[string]any{"trigger": trigger, "peers": n})
+	return n
+}
+
+// handleReoffer serves POST /reoffer, which pushes a reoffer to every peer
+// now, e.g. from the orchestrator once the restored server is reachable.
+func (s *server) handleReoffer(w http.ResponseWriter, r *http.Request) {
+	n := s.pushReoffers("api")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"peers": n, "ts": time.Now().UnixNano()})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rrStats is a peer's view of its stream from its receiver reports
+// (loadgen -feedback-interval), computed as from an RTCP report block: the
+// round trip is now - LSR - DLSR, and the fraction lost is the frames lost
+// over those expected since the previous report. Unlike rttNs (WebSocket
+// ping/pong) the round trip includes the data queued ahead of the frame,
+// so next to the peer's own figures it shows where a migration's
+// degradation is seen first.
+type rrStats struct {
+	mu           sync.Mutex
+	reports      uint64
+	rtt          time.Duration
+	fractionLost float64
+	lost         uint64 // the peer's running count
+	lastRecv     uint64
+	lastLost     uint64
+}
+
+// rrInfo is rrStats in /peers and /metrics.
+type rrInfo struct {
+	Reports      uint64  `json:"reports"`
+	RttMs        float64 `json:"rtt_ms"`
+	FractionLost float64 `json:"fraction_lost"`
+	FramesLost   uint64  `json:"frames_lost"`
+}
+
+// observe handles one "rr" received at now. Reports from a peer without the
+// frame fields (older loadgen) are ignored.
+func (r *rrStats) observe(cm clientMsg, now time.Time) {
+	if cm.FramesReceived == 0 && cm.FramesLost == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cm.LSR > 0 {
+		if rtt := time.Duration(now.UnixNano() - cm.LSR - cm.DLSR); rtt >= 0 {
+			r.rtt = rtt
+		}
+	}
+	if r.reports > 0 && cm.FramesReceived >= r.lastRecv && cm.FramesLost >= r.lastLost {
+		recv, lost := cm.FramesReceived-r.lastRecv, cm.FramesLost-r.lastLost
+		r.fractionLost = 0
+		if recv+lost > 0 {
+			r.fractionLost = float64(lost) / float64(recv+lost)
+		}
+	}
+	r.reports++
+	r.lost = cm.FramesLost
+	r.lastRecv, r.lastLost = cm.FramesReceived, cm.FramesLost
+}
+
+// info returns nil before the first report.
+func (r *rrStats) info() *rrInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reports == 0 {
+		return nil
+	}
+	return &rrInfo{
+		Reports:      r.reports,
+		RttMs:        float64(r.rtt) / 1e6,
+		FractionLost: r.fractionLost,
+		FramesLost:   r.lost,
+	}
+}
+
+// peerRR returns the receiver-report figures of each peer that sent any.
+func (s *server) peerRR() map[string]rrInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out map[string]rrInfo
+	for id, c := range s.clients {
+		if in := c.rr.info(); in != nil {
+			if out == nil {
+				out = map[string]rrInfo{}
+			}
+			out[strconv.FormatUint(id, 10)] = *in
+		}
+	}
+	return out
+}
+
+func writePeerRR(w io.Writer, peers map[string]rrInfo) {
+	ids := make([]string, 0, len(peers))
+	for id := range peers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Fprintf(w, "# HELP stream_peer_rr_rtt_seconds Round trip from the peer's last receiver report (LSR/DLSR).\n# TYPE stream_peer_rr_rtt_seconds gauge\n")
+	for _, id := range ids {
+		fmt.Fprintf(w, "stream_peer_rr_rtt_seconds{peer=%q} %g\n", id, peers[id].RttMs/1000)
+	}
+	fmt.Fprintf(w, "# HELP stream_peer_fraction_lost Frames lost over frames expected between the peer's last two receiver reports.\n# TYPE stream_peer_fraction_lost gauge\n")
+	for _, id := range ids {
+		fmt.Fprintf(w, "stream_peer_fraction_lost{peer=%q} %g\n", id, peers[id].FractionLost)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// sessionHeader carries the session token in the handshake response. A
+// client that redials with ?session=<token> gets the same peer ID and its
+// byte counters back, and sessionResumedHeader is set to "1".
+const (
+	sessionHeader        = "X-Session-Token"
+	sessionResumedHeader = "X-Session-Resumed"
+)
+
+// session is a logical peer. It outlives its WebSocket connection for
+// -session-ttl, so a peer that reconnects after a migration is counted as
+// the same session rather than as a drop plus a new client.
+type session struct {
+	token     string
+	id        uint64
+	createdAt time.Time
+	resumes   int
+	// owner is the connected client, nil between connections. bytesSent
+	// and bytesRecv hold the counters while there is no owner.
+	owner     *client
+	bytesSent uint64
+	bytesRecv uint64
+	closedAt  time.Time
+}
+
+func newSessionToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// claimSession returns the session for token, or a new one if the token is
+// empty, unknown or expired. Callers must hold s.mu.
+func (s *server) claimSession(token string, now time.Time) (sess *session, resumed bool) {
+	for t, old := range s.sessions {
+		if old.owner == nil && wallSub(now, old.closedAt) > *sessionTTL {
+			delete(s.sessions, t)
+		}
+	}
+	if sess, ok := s.sessions[token]; ok && token != "" {
+		return sess, true
+	}
+	sess = &session{token: newSessionToken(), id: s.nextClientID, createdAt: now}
+	s.nextClientID++
+	s.sessions[sess.token] = sess
+	return sess, false
+}
+
+// attach makes c the session's connection. If an older connection still
+// holds the session (the server has not noticed it is dead yet) it is
+// closed and its counters are taken over. Callers must hold s.mu.
+func (sess *session) attach(c *client) {
+	if old := sess.owner; old != nil {
+		sess.bytesSent, sess.bytesRecv = old.bytesSent.Load(), old.bytesRecv.Load()
+		old.conn.Close()
+	}
+	c.id = sess.id
+	c.sess = sess
+	c.sentBase = sess.bytesSent
+	c.bytesSent.Store(sess.bytesSent)
+	c.bytesRecv.Store(sess.bytesRecv)
+	sess.owner = c
+}
+
+// detach saves c's counters into its session, unless a newer connection
+// has taken the session over. Callers must hold s.mu.
+func (sess *session) detach(c *client, now time.Time) {
+	if sess.owner != c {
+		return
+	}
+	sess.owner = nil
+	sess.bytesSent, sess.bytesRecv = c.bytesSent.Load(), c.bytesRecv.Load()
+	sess.closedAt = now
+}
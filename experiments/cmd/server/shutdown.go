@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closePeers sends every peer a Going Away close frame and waits up to
+// timeout for their read loops to finish, then force-closes the rest.
+// A peer that sees 1001 knows the server left on purpose, which lets the
+// loadgen tell a planned stop apart from a migration blackout.
+func (s *server) closePeers(timeout time.Duration) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(timeout)
+	s.mu.RLock()
+	for _, c := range s.clients {
+		c.conn.WriteControl(websocket.CloseMessage, msg, deadline)
+	}
+	s.mu.RUnlock()
+
+	for s.connectedCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(quiescePoll)
+	}
+	s.mu.RLock()
+	remaining := len(s.clients)
+	for _, c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.RUnlock()
+	if remaining > 0 {
+		log.Printf("Drain timeout: force-closed %d peers", remaining)
+	}
+}
+
+// writeFinalStats dumps the /metrics JSON to path.
+func (s *server) writeFinalStats(path string) error {
+	data, err := json.MarshalIndent(s.metricsSnapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// shutdown drains and stops the server: refuse new peers, close existing
+// ones cleanly, stop the listeners, then persist state and final stats.
+func (s *server) shutdown(sig os.Signal, srvs ...*http.Server) {
+	log.Printf("%s: draining %d peers (timeout %s)", sig, s.connectedCount(), *drainTimeout)
+	s.draining.Store(true)
+	sdNotify("STOPPING=1")
+	s.events.emit("draining", nil, map[string]any{"signal": sig.String(), "peers": s.connectedCount()})
+	s.closePeers(*drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, srv := range srvs {
+		srv.Shutdown(ctx)
+	}
+
+	if *stateFile != "" {
+		if err := s.saveState(*stateFile); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+	if *finalStats != "" {
+		if err := s.writeFinalStats(*finalStats); err != nil {
+			log.Printf("write final stats: %v", err)
+		} else {
+			log.Printf("Final stats written to %s", *finalStats)
+		}
+	}
+	s.events.bus.Close(time.Second)
+	s.events.hook.close(time.Second)
+}
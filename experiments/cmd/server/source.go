@@ -0,0 +1,532 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// frameSource produces one client's data stream. next is called at send
+// time and returns the message for frame seq plus that frame's duration,
+// i.e. how long to wait before sending the following one.
+// Each client gets its own source so per-client state (file position)
+// never needs locking.
+type frameSource interface {
+	next(seq int) (msgType int, data []byte, wait time.Duration)
+	// forceKeyframe makes the next frame a keyframe (PLI/FIR handling).
+	forceKeyframe()
+	// selectLayer picks a simulcast layer (rid, or "" to keep it) and a
+	// maximum temporal ID (-1 to keep it). It reports false if the source
+	// has no such layer.
+	selectLayer(rid string, tid int) bool
+	// setScale scales the frame size for rate adaptation (1 = nominal).
+	setScale(scale float64)
+	// configure applies a runtime stream config (POST /config).
+	configure(fps, padding, gop int)
+}
+
+// syntheticSource is the original stream: a JSON dataMsg every 1/fps
+// seconds. It mimics an encoder's GOP: a keyframe every gop frames (or on
+// forceKeyframe) and smaller delta frames in between, sized so the average
+// still matches the configured padding. Large frames need no manual
+// splitting: gorilla fragments messages above its write buffer size and TCP
+// segments them.
+//
+// It also mimics simulcast with temporal scalability: each spatial layer
+// (rid) is an independent encoding at a fraction of the bitrate, and frames
+// carry an L1T3 temporal ID so dropping the upper temporal layers halves or
+// quarters the frame rate without breaking decoding.
+type syntheticSource struct {
+	frameDuration time.Duration
+	gop           int
+	keyRatio      float64
+	layers        [len(simulcastRIDs)]layerPadding
+	padding       string // room for the largest frame at maxProfileFactor
+	spatial       int    // index into simulcastRIDs
+	maxTemporal   int
+	scale         float64 // rate adaptation, see cc.go
+	sinceKey      int
+	forceKey      bool
+}
+
+// layerPadding is a layer's padding bytes per frame at scale 1.
+type layerPadding struct{ key, delta int }
+
+// simulcastRIDs names the spatial layers, highest first, with the share of
+// the full-layer bitrate each one carries (half resolution per step).
+var (
+	simulcastRIDs   = [...]string{"f", "h", "q"}
+	simulcastScales = [...]float64{1, 0.25, 0.0625}
+)
+
+// frameWidth and frameHeight are the full layer's -resolution. Each lower
+// spatial layer halves both, and frames carry their layer's size like a
+// VP8 keyframe header does, so a receiver can tell a layer switch from
+// the frame size alone.
+var frameWidth, frameHeight int
+
+// parseResolution parses WIDTHxHEIGHT. Both must leave at least 1 pixel in
+// the lowest simulcast layer.
+func parseResolution(s string) (w, h int, err error) {
+	ws, hs, ok := strings.Cut(s, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("want WIDTHxHEIGHT, got %q", s)
+	}
+	if w, err = strconv.Atoi(ws); err != nil {
+		return 0, 0, fmt.Errorf("width: %w", err)
+	}
+	if h, err = strconv.Atoi(hs); err != nil {
+		return 0, 0, fmt.Errorf("height: %w", err)
+	}
+	if least := 1 << (len(simulcastRIDs) - 1); w < least || h < least {
+		return 0, 0, fmt.Errorf("%dx%d is below %dx%d", w, h, least, least)
+	}
+	return w, h, nil
+}
+
+// maxTemporalLayer is the highest temporal ID (T2 in L1T3).
+const maxTemporalLayer = 2
+
+// ridIndex returns the spatial layer index for rid, or -1.
+func ridIndex(rid string) int {
+	for i, r := range simulcastRIDs {
+		if r == rid {
+			return i
+		}
+	}
+	return -1
+}
+
+// temporalID assigns L1T3 temporal IDs by position in the GOP:
+// T0 every 4th frame, T1 halfway between, T2 on the rest.
+func temporalID(pos int) int {
+	switch {
+	case pos%4 == 0:
+		return 0
+	case pos%2 == 0:
+		return 1
+	}
+	return 2
+}
+
+// newSyntheticSource averages padding bytes per full-layer frame over a GOP
+// of gop frames in which the keyframe is keyRatio times the size of a delta
+// frame.
+func newSyntheticSource(fps, padding, gop int, keyRatio float64) *syntheticSource {
+	s := &syntheticSource{
+		keyRatio:    keyRatio,
+		maxTemporal: maxTemporalLayer,
+		scale:       1,
+	}
+	s.configure(fps, padding, gop)
+	return s
+}
+
+// configure changes frame rate, padding and GOP length. The next frame
+// starts a new GOP with a keyframe, as after an encoder reconfiguration;
+// the selected layers and the adaptation scale are kept.
+func (s *syntheticSource) configure(fps, padding, gop int) {
+	gop = max(gop, 1)
+	s.frameDuration = time.Second / time.Duration(fps)
+	s.gop = gop
+	for i, scale := range simulcastScales {
+		p := int(float64(padding) * scale)
+		delta := float64(p*gop) / (s.keyRatio + float64(gop-1))
+		key := p*gop - int(delta)*(gop-1)
+		s.layers[i] = layerPadding{key: key, delta: int(delta)}
+	}
+	s.padding = strings.Repeat("x", int(float64(s.layers[0].key)*maxProfileFactor))
+	s.forceKey = true
+}
+
+func (s *syntheticSource) forceKeyframe() { s.forceKey = true }
+
+func (s *syntheticSource) setScale(scale float64) { s.scale = scale }
+
+// selectLayer switches to spatial layer rid (empty keeps the current one)
+// and caps the temporal ID at tid. A spatial switch is a different
+// encoding, so it starts with a keyframe; a temporal switch needs none.
+func (s *syntheticSource) selectLayer(rid string, tid int) bool {
+	if rid != "" {
+		i := ridIndex(rid)
+		if i < 0 {
+			return false
+		}
+		if i != s.spatial {
+			s.spatial = i
+			s.forceKey = true
+		}
+	}
+	if tid >= 0 {
+		s.maxTemporal = min(tid, maxTemporalLayer)
+	}
+	return true
+}
+
+// paddingForBitrate returns the padding that makes each synthetic frame,
+// JSON envelope included, carry bps/8/fps bytes on average.
+func paddingForBitrate(bps, fps int) int {
+	envelope, _ := json.Marshal(dataMsg{Seq: 1 << 20, Ts: time.Now().UnixNano(), RID: "f", Width: frameWidth, Height: frameHeight, Size: bps / 8 / fps})
+	n := bps/8/fps - len(envelope) - len(`,"padding":""`)
+	return max(n, 0)
+}
+
+// next skips frames above the selected temporal layer, folding their
+// duration into the wait, so seq stays contiguous over the frames sent.
+func (s *syntheticSource) next(seq int) (int, []byte, time.Duration) {
+	// At low rates the adaptation halves the frame rate (drops T2) and
+	// doubles the frame size, keeping the bitrate at the target.
+	maxTemporal, scale := s.maxTemporal, s.scale
+	if scale < ccHalfRateScale {
+		maxTemporal, scale = min(maxTemporal, 1), scale*2
+	}
+	wait := time.Duration(0)
+	for !s.forceKey && s.sinceKey%s.gop != 0 && temporalID(s.sinceKey) > maxTemporal {
+		s.sinceKey++
+		wait += s.frameDuration
+	}
+	key := s.forceKey || s.sinceKey%s.gop == 0
+	if key {
+		s.forceKey, s.sinceKey = false, 0
+	}
+	tid := temporalID(s.sinceKey)
+	s.sinceKey++
+	layer := s.layers[s.spatial]
+	n := layer.delta
+	if key {
+		n = layer.key
+	}
+	// The profile can raise the frame size above nominal, up to
+	// maxProfileFactor, which s.padding has room for.
+	padding := s.padding[:int(float64(n)*min(scale, maxProfileFactor))]
+	msg := dataMsg{
+		Seq:     seq,
+		Ts:      time.Now().UnixNano(),
+		Key:     key,
+		RID:     simulcastRIDs[s.spatial],
+		TID:     tid,
+		Width:   frameWidth >> s.spatial,
+		Height:  frameHeight >> s.spatial,
+		Size:    len(padding),
+		Padding: padding,
+	}
+	data, _ := json.Marshal(msg)
+	return websocket.TextMessage, data, wait + s.frameDuration
+}
+
+// Binary video frames are sent as one WebSocket message each:
+//
+//	offset 0  uint64  seq (big endian)
+//	offset 8  int64   server send time, UnixNano
+//	offset 16 uint8   flags (bit 0: keyframe, bit 1: audio)
+//	offset 17 ...     encoded frame
+const (
+	videoHeaderLen = 17
+	flagKeyframe   = 1 << 0
+	flagAudio      = 1 << 1
+)
+
+type clipFrame struct {
+	data     []byte
+	duration time.Duration
+	key      bool
+}
+
+// videoClip is a pre-encoded stream loaded fully into memory at startup, so
+// no file I/O happens on the send path (or across a checkpoint).
+type videoClip struct {
+	codec         string // vp8, vp9 or h264
+	width, height int    // 0 when the container doesn't say (Annex B)
+	frames        []clipFrame
+}
+
+// loadClip loads path for the given -codec. An empty codec means IVF with
+// the codec taken from its fourcc; vp8/vp9 require a matching IVF fourcc;
+// h264 reads an Annex B elementary stream paced at fps.
+func loadClip(path, codec string, fps int) (*videoClip, error) {
+	switch codec {
+	case "h264":
+		return loadAnnexB(path, fps)
+	case "", "vp8", "vp9":
+		clip, err := loadIVF(path)
+		if err != nil {
+			return nil, err
+		}
+		if codec != "" && clip.codec != codec {
+			return nil, fmt.Errorf("%s: -codec %s but file contains %s", path, codec, clip.codec)
+		}
+		return clip, nil
+	}
+	return nil, fmt.Errorf("unsupported codec %q (want vp8, vp9 or h264)", codec)
+}
+
+// loadIVF parses an IVF file (32-byte file header, then 12-byte frame
+// headers of size+pts). Frame durations come from pts deltas scaled by the
+// header's timebase; the last frame reuses the previous duration.
+func loadIVF(path string) (*videoClip, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 32 || string(raw[:4]) != "DKIF" {
+		return nil, fmt.Errorf("%s: not an IVF file", path)
+	}
+	hdrLen := int(binary.LittleEndian.Uint16(raw[6:8]))
+	fourcc := string(raw[8:12])
+	f := &videoClip{
+		width:  int(binary.LittleEndian.Uint16(raw[12:14])),
+		height: int(binary.LittleEndian.Uint16(raw[14:16])),
+	}
+	switch fourcc {
+	case "VP80":
+		f.codec = "vp8"
+	case "VP90":
+		f.codec = "vp9"
+	default:
+		return nil, fmt.Errorf("%s: unsupported IVF fourcc %q", path, fourcc)
+	}
+	den := binary.LittleEndian.Uint32(raw[16:20]) // timebase denominator (rate)
+	num := binary.LittleEndian.Uint32(raw[20:24]) // timebase numerator (scale)
+	if den == 0 || num == 0 {
+		return nil, fmt.Errorf("%s: invalid timebase %d/%d", path, num, den)
+	}
+	tick := time.Duration(float64(time.Second) * float64(num) / float64(den))
+
+	var pts []uint64
+	off := hdrLen
+	for off+12 <= len(raw) {
+		size := int(binary.LittleEndian.Uint32(raw[off : off+4]))
+		p := binary.LittleEndian.Uint64(raw[off+4 : off+12])
+		off += 12
+		if off+size > len(raw) {
+			return nil, fmt.Errorf("%s: truncated frame %d", path, len(f.frames))
+		}
+		data := raw[off : off+size]
+		off += size
+		f.frames = append(f.frames, clipFrame{data: data, key: isKeyframe(f.codec, data)})
+		pts = append(pts, p)
+	}
+	if len(f.frames) == 0 {
+		return nil, fmt.Errorf("%s: no frames", path)
+	}
+	for i := range f.frames {
+		switch {
+		case i+1 < len(f.frames) && pts[i+1] > pts[i]:
+			f.frames[i].duration = time.Duration(pts[i+1]-pts[i]) * tick
+		case i > 0:
+			f.frames[i].duration = f.frames[i-1].duration
+		default:
+			f.frames[i].duration = tick
+		}
+	}
+	return f, nil
+}
+
+// isKeyframe inspects the start of a VP8 or VP9 frame.
+func isKeyframe(codec string, data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	switch codec {
+	case "vp8":
+		// Frame tag bit 0: 0 = key frame.
+		return data[0]&0x01 == 0
+	case "vp9":
+		// frame_marker(2) profile_low(1) profile_high(1) [reserved(1) if
+		// profile 3] show_existing_frame(1) frame_type(1, 0 = key).
+		b := data[0]
+		bit := 4
+		if (b>>5)&1 == 1 && (b>>4)&1 == 1 {
+			bit = 3
+		}
+		if (b>>bit)&1 == 1 {
+			return false
+		}
+		return (b>>(bit-1))&1 == 0
+	}
+	return false
+}
+
+// clipSource loops over a videoClip, starting each client at frame 0.
+type clipSource struct {
+	file *videoClip
+	pos  int
+}
+
+// forceKeyframe skips ahead to the clip's next keyframe, so a decoder that
+// lost frames can resync without waiting for the loop to reach one.
+func (s *clipSource) forceKeyframe() {
+	n := len(s.file.frames)
+	for i := 0; i < n; i++ {
+		if j := (s.pos + i) % n; s.file.frames[j].key {
+			s.pos = j
+			return
+		}
+	}
+}
+
+// selectLayer fails: a pre-encoded clip has a single encoding.
+func (s *clipSource) selectLayer(string, int) bool { return false }
+
+// setScale is a no-op: clip frames can't be resized without re-encoding.
+func (s *clipSource) setScale(float64) {}
+
+func (s *clipSource) configure(int, int, int) {}
+
+func (s *clipSource) next(seq int) (int, []byte, time.Duration) {
+	fr := s.file.frames[s.pos]
+	s.pos = (s.pos + 1) % len(s.file.frames)
+	return websocket.BinaryMessage, encodeVideoFrame(seq, fr.data, fr.key), fr.duration
+}
+
+// loadAnnexB splits an H.264 Annex B stream into access units. A new unit
+// starts at an access unit delimiter, SPS, PPS or SEI following a slice,
+// or at a slice whose first_mb_in_slice is 0. Annex B carries no timing, so
+// every unit lasts 1/fps.
+func loadAnnexB(path string, fps int) (*videoClip, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	clip := &videoClip{codec: "h264"}
+	dur := time.Second / time.Duration(fps)
+	start, haveSlice, key := -1, false, false
+	flush := func(end int) {
+		if start >= 0 && haveSlice {
+			clip.frames = append(clip.frames, clipFrame{data: raw[start:end], duration: dur, key: key})
+		}
+	}
+	for _, nal := range annexBNALs(raw) {
+		typ := raw[nal.payload] & 0x1f
+		newAU := false
+		switch typ {
+		case 9, 7, 8, 6: // AUD, SPS, PPS, SEI
+			newAU = haveSlice
+		case 1, 5: // non-IDR / IDR slice
+			// first_mb_in_slice is ue(v); a leading 1 bit encodes 0.
+			newAU = haveSlice && nal.payload+1 < len(raw) && raw[nal.payload+1]&0x80 != 0
+		}
+		if newAU || start < 0 {
+			flush(nal.start)
+			start, haveSlice, key = nal.start, false, false
+		}
+		if typ == 1 || typ == 5 {
+			haveSlice = true
+		}
+		if typ == 5 {
+			key = true
+		}
+	}
+	flush(len(raw))
+	if len(clip.frames) == 0 {
+		return nil, fmt.Errorf("%s: no H.264 access units found", path)
+	}
+	return clip, nil
+}
+
+type annexBNAL struct {
+	start   int // offset of the start code
+	payload int // offset of the NAL header byte
+}
+
+// annexBNALs finds every 00 00 01 / 00 00 00 01 start code.
+func annexBNALs(b []byte) []annexBNAL {
+	var nals []annexBNAL
+	for i := 0; i+3 < len(b); i++ {
+		if b[i] != 0 || b[i+1] != 0 {
+			continue
+		}
+		switch {
+		case b[i+2] == 1:
+			nals = append(nals, annexBNAL{start: i, payload: i + 3})
+			i += 2
+		case b[i+2] == 0 && b[i+3] == 1 && i+4 < len(b):
+			nals = append(nals, annexBNAL{start: i, payload: i + 4})
+			i += 3
+		}
+	}
+	return nals
+}
+
+func encodeVideoFrame(seq int, payload []byte, key bool) []byte {
+	buf := make([]byte, videoHeaderLen+len(payload))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(seq))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(time.Now().UnixNano()))
+	if key {
+		buf[16] |= flagKeyframe
+	}
+	copy(buf[videoHeaderLen:], payload)
+	return buf
+}
+
+// pacer schedules a stream's frames against a running deadline. If the
+// writer falls more than one frame behind (write stall, checkpoint freeze)
+// it resyncs to now instead of bursting the backlog.
+type pacer struct {
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newPacer() *pacer {
+	return &pacer{deadline: time.Now(), timer: time.NewTimer(0)}
+}
+
+// advance schedules the next frame wait after the one just sent. A
+// deadline more than a frame behind (a freeze) or ahead (the clock went
+// back, e.g. across a CRIU restore) restarts the schedule from now.
+func (p *pacer) advance(wait time.Duration) {
+	p.deadline = p.deadline.Add(wait)
+	now := time.Now()
+	if p.deadline.Before(now.Add(-wait)) || p.deadline.After(now.Add(wait)) {
+		p.deadline = now.Add(wait)
+	}
+	p.timer.Reset(time.Until(p.deadline))
+}
+
+// hold re-arms the timer d from now without sending (e.g. while quiesced).
+func (p *pacer) hold(d time.Duration) {
+	p.deadline = time.Now().Add(d)
+	p.timer.Reset(d)
+}
+
+const (
+	audioFrameDuration = 20 * time.Millisecond
+	audioToneHz        = 440
+	// Opus TOC byte: config 31 (CELT fullband, 20 ms), mono, one frame.
+	opusTOC20ms = 31<<3 | 0
+)
+
+// audioSource emits Opus-shaped audio packets every 20 ms: a real TOC byte
+// followed by a quantised 440 Hz sine sized to the target bitrate. There is
+// no Opus encoder in the tree, so the payload reproduces packet size and
+// cadence, not decodable audio.
+type audioSource struct {
+	size  int
+	phase float64
+}
+
+func newAudioSource(bps int) *audioSource {
+	size := bps / 8 * int(audioFrameDuration) / int(time.Second)
+	return &audioSource{size: max(size, 2)}
+}
+
+func (a *audioSource) next(seq int) (int, []byte, time.Duration) {
+	payload := make([]byte, a.size)
+	payload[0] = opusTOC20ms
+	step := 2 * math.Pi * audioToneHz * audioFrameDuration.Seconds() / float64(a.size-1)
+	for i := 1; i < len(payload); i++ {
+		payload[i] = byte(127 + 127*math.Sin(a.phase))
+		a.phase += step
+	}
+	a.phase = math.Mod(a.phase, 2*math.Pi)
+	buf := encodeVideoFrame(seq, payload, false)
+	buf[16] |= flagAudio
+	return websocket.BinaryMessage, buf, audioFrameDuration
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func sentSize(t *testing.T, s *syntheticSource, scale float64) int {
+	t.Helper()
+	s.setScale(scale)
+	_, data, _ := s.next(1)
+	var msg dataMsg
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Size != len(msg.Padding) {
+		t.Fatalf("size %d, padding %d bytes", msg.Size, len(msg.Padding))
+	}
+	return msg.Size
+}
+
+func TestSyntheticSourceScaleAboveNominal(t *testing.T) {
+	// gop 1: every frame is a keyframe of the same size.
+	s := newSyntheticSource(30, 1000, 1, 1)
+	nominal := sentSize(t, s, 1)
+	if nominal != 1000 {
+		t.Fatalf("nominal frame: got %d bytes, want 1000", nominal)
+	}
+	for _, scale := range []float64{0.5, 1.5, maxProfileFactor} {
+		if got, want := sentSize(t, s, scale), int(1000*scale); got != want {
+			t.Errorf("scale %g: got %d bytes, want %d", scale, got, want)
+		}
+	}
+}
+
+func TestParseProfileFactorBound(t *testing.T) {
+	if _, err := parseProfile("square:low=0.5:high=1.5:period=10s", time.Time{}); err != nil {
+		t.Errorf("high=1.5: %v", err)
+	}
+	if _, err := parseProfile("ramp:high=5", time.Time{}); err == nil {
+		t.Errorf("high=5 above maxProfileFactor: want an error")
+	}
+}
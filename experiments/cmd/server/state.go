@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sessionState is the lightweight server state written to -state-file.
+// Restoring it keeps total_clients, uptime and client IDs monotonic across
+// a cold restart, so a restarted server doesn't look like a metrics reset
+// to the collector. The instance ID is deliberately not persisted: it is
+// what tells a CRIU restore apart from a restart.
+type sessionState struct {
+	SavedAt          time.Time   `json:"saved_at"`
+	StartTime        time.Time   `json:"start_time"`
+	NextClientID     uint64      `json:"next_client_id"`
+	TotalClients     int64       `json:"total_clients"`
+	BytesSent        uint64      `json:"bytes_sent"`
+	BytesReceived    uint64      `json:"bytes_received"`
+	KeyframeRequests int64       `json:"keyframe_requests"`
+	Peers            []peerState `json:"peers"`
+}
+
+type peerState struct {
+	ID        uint64    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	BytesSent uint64    `json:"bytes_sent"`
+	BytesRecv uint64    `json:"bytes_received"`
+	// Session is the peer's resume token; restored peers can reconnect
+	// with it for -session-ttl after a cold restart.
+	Session string `json:"session,omitempty"`
+	Resumes int    `json:"session_resumes,omitempty"`
+}
+
+func (s *server) snapshotState() sessionState {
+	st := sessionState{
+		SavedAt:          time.Now(),
+		StartTime:        s.startTime,
+		TotalClients:     s.totalClients.Load(),
+		BytesSent:        s.bytesSent.Load(),
+		BytesReceived:    s.bytesRecv.Load(),
+		KeyframeRequests: s.keyframeRequests.Load(),
+		Peers:            []peerState{},
+	}
+	s.mu.RLock()
+	st.NextClientID = s.nextClientID
+	for _, c := range s.clients {
+		st.Peers = append(st.Peers, peerState{
+			ID:        c.id,
+			CreatedAt: c.createdAt,
+			BytesSent: c.bytesSent.Load(),
+			BytesRecv: c.bytesRecv.Load(),
+			Session:   c.sess.token,
+			Resumes:   c.sess.resumes,
+		})
+	}
+	s.mu.RUnlock()
+	return st
+}
+
+// saveState writes the state via a temp file and rename, so a crash or a
+// checkpoint mid-write never leaves a truncated file behind.
+func (s *server) saveState(path string) error {
+	data, err := json.Marshal(s.snapshotState())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadState restores counters from path. A missing file is not an error:
+// it is the first start.
+func (s *server) loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st sessionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	if !st.StartTime.IsZero() {
+		s.startTime = st.StartTime
+	}
+	s.nextClientID = st.NextClientID
+	s.totalClients.Store(st.TotalClients)
+	s.bytesSent.Store(st.BytesSent)
+	s.bytesRecv.Store(st.BytesReceived)
+	s.keyframeRequests.Store(st.KeyframeRequests)
+	now := time.Now()
+	for _, p := range st.Peers {
+		if p.Session == "" {
+			continue
+		}
+		s.sessions[p.Session] = &session{
+			token: p.Session, id: p.ID, createdAt: p.CreatedAt, resumes: p.Resumes,
+			bytesSent: p.BytesSent, bytesRecv: p.BytesRecv, closedAt: now,
+		}
+	}
+	log.Printf("Restored state from %s (saved %s ago): total_clients=%d, %d peers were connected",
+		path, time.Since(st.SavedAt).Round(time.Millisecond), st.TotalClients, len(st.Peers))
+	s.events.emit("state_restored", nil, map[string]any{
+		"saved_at": st.SavedAt, "total_clients": st.TotalClients, "peers": len(st.Peers),
+	})
+	return nil
+}
+
+// persistState saves the state every interval until the process exits.
+func (s *server) persistState(path string, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if err := s.saveState(path); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// tcpStats is the subset of the kernel's struct tcp_info the server reports.
+// Unlike the payload counters these are what actually left the socket:
+// WebSocket framing, retransmissions and control frames included.
+type tcpStats struct {
+	bytesSent    uint64 // tcpi_bytes_sent (Linux 4.19+), else bytes_acked
+	bytesAcked   uint64
+	bytesRetrans uint64
+	totalRetrans uint32
+	notSent      uint32 // bytes queued but not yet sent
+	rtt          time.Duration
+}
+
+// Offsets into struct tcp_info (include/uapi/linux/tcp.h). syscall.TCPInfo
+// stops before the 64-bit byte counters, so the server reads the raw struct.
+const (
+	tcpiRTT          = 68
+	tcpiTotalRetrans = 100
+	tcpiBytesAcked   = 120
+	tcpiNotSent      = 144
+	tcpiBytesSent    = 200
+	tcpiBytesRetrans = 208
+	tcpInfoLen       = 232
+)
+
+// readTCPInfo queries TCP_INFO on the TCP connection underneath c.
+func readTCPInfo(c net.Conn) (tcpStats, error) {
+	var st tcpStats
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return st, fmt.Errorf("not a TCP connection")
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return st, err
+	}
+	var buf [tcpInfoLen]byte
+	n := uint32(len(buf))
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&n)), 0)
+	})
+	if err != nil {
+		return st, err
+	}
+	if errno != 0 {
+		return st, errno
+	}
+
+	le := binary.NativeEndian
+	if n >= tcpiBytesAcked+8 {
+		st.bytesAcked = le.Uint64(buf[tcpiBytesAcked:])
+		st.bytesSent = st.bytesAcked
+	}
+	st.rtt = time.Duration(le.Uint32(buf[tcpiRTT:])) * time.Microsecond
+	st.totalRetrans = le.Uint32(buf[tcpiTotalRetrans:])
+	if n >= tcpiNotSent+4 {
+		st.notSent = le.Uint32(buf[tcpiNotSent:])
+	}
+	if n >= tcpiBytesRetrans+8 {
+		st.bytesSent = le.Uint64(buf[tcpiBytesSent:])
+		st.bytesRetrans = le.Uint64(buf[tcpiBytesRetrans:])
+	}
+	return st, nil
+}
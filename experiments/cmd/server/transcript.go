@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// transcript is one peer's -transcript-dir file: a JSON line per signaling
+// step, the WebSocket handshake and every control message, in and out. It
+// is the WebSocket counterpart of an SDP offer/answer and candidate log,
+// for checking which path a peer negotiated. A nil transcript discards.
+type transcript struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// transcriptEntry is one line. Dir is "in" (from the peer), "out" (to it)
+// or empty for local events such as the close.
+type transcriptEntry struct {
+	Time   string         `json:"time"`
+	TsMs   int64          `json:"ts_ms"`
+	Dir    string         `json:"dir,omitempty"`
+	Type   string         `json:"type"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+// openTranscript appends to dir/peer-<id>.jsonl, so a resumed session
+// continues its peer's file. It returns nil without -transcript-dir or if
+// the file cannot be opened.
+func openTranscript(dir string, id uint64) *transcript {
+	if dir == "" {
+		return nil
+	}
+	path := filepath.Join(dir, fmt.Sprintf("peer-%d.jsonl", id))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("transcript: %v", err)
+		return nil
+	}
+	return &transcript{f: f}
+}
+
+func (t *transcript) record(dir, typ string, fields map[string]any) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	data, _ := json.Marshal(transcriptEntry{
+		Time: now.Format(time.RFC3339Nano), TsMs: now.UnixMilli(),
+		Dir: dir, Type: typ, Fields: fields,
+	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.f.Write(append(data, '\n'))
+}
+
+func (t *transcript) close() {
+	if t == nil {
+		return
+	}
+	t.f.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Datagram header written by cmd/pktgen: magic, flow ID (random per pktgen
+// run), sequence number, send time in Unix nanoseconds.
+const (
+	pktgenMagic     = "P4PG"
+	pktgenHeaderLen = 24
+)
+
+// udpSink counts cmd/pktgen's constant-rate flow (-udp-sink-addr). With no
+// TCP, WebSocket or encoder in the way, the gaps between datagrams are the
+// raw datapath downtime of a migration, at the flow's packet interval.
+type udpSink struct {
+	mu    sync.Mutex
+	flows map[uint32]*udpFlow
+	total udpSinkStats
+}
+
+type udpFlow struct {
+	nextSeq uint64
+	lastAt  time.Time
+}
+
+// udpSinkStats is the udp_sink object of /metrics, summed over flows.
+type udpSinkStats struct {
+	Flows     int     `json:"flows"`
+	Packets   uint64  `json:"packets"`
+	Bytes     uint64  `json:"bytes"`
+	Lost      uint64  `json:"lost"`
+	Reordered uint64  `json:"reordered"`
+	Gaps      uint64  `json:"gaps"`
+	MaxGapMs  float64 `json:"max_gap_ms"`
+	LastGapMs float64 `json:"last_gap_ms"`
+}
+
+// serveUDPSink reads datagrams from pc until it is closed. Gaps longer
+// than -udp-gap-event become udp_gap events.
+func (s *server) serveUDPSink(pc net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			log.Printf("UDP sink: %v", err)
+			return
+		}
+		now := time.Now()
+		if n < pktgenHeaderLen || string(buf[:4]) != pktgenMagic {
+			continue
+		}
+		flow := binary.BigEndian.Uint32(buf[4:8])
+		seq := binary.BigEndian.Uint64(buf[8:16])
+		sentNs := int64(binary.BigEndian.Uint64(buf[16:24]))
+		s.udpSink.observe(s, flow, seq, sentNs, n, now, from)
+	}
+}
+
+func (u *udpSink) observe(s *server, flow uint32, seq uint64, sentNs int64, n int, now time.Time, from net.Addr) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	f := u.flows[flow]
+	if f == nil {
+		f = &udpFlow{nextSeq: seq}
+		u.flows[flow] = f
+		u.total.Flows = len(u.flows)
+		log.Printf("UDP sink: new flow %08x from %s", flow, from)
+	}
+	u.total.Packets++
+	u.total.Bytes += uint64(n)
+	if seq < f.nextSeq {
+		// Late: it was counted as lost when the gap opened.
+		u.total.Reordered++
+		if u.total.Lost > 0 {
+			u.total.Lost--
+		}
+		return
+	}
+	lost := seq - f.nextSeq
+	u.total.Lost += lost
+	f.nextSeq = seq + 1
+	if !f.lastAt.IsZero() {
+		gap := wallSub(now, f.lastAt)
+		gapMs := float64(gap) / float64(time.Millisecond)
+		u.total.MaxGapMs = max(u.total.MaxGapMs, gapMs)
+		if *udpGapEvent > 0 && gap >= *udpGapEvent {
+			u.total.Gaps++
+			u.total.LastGapMs = gapMs
+			s.events.emit("udp_gap", nil, map[string]any{
+				"flow":             flow,
+				"gap_ms":           gapMs,
+				"lost":             lost,
+				"gap_start_ms":     f.lastAt.UnixMilli(),
+				"first_sent_ns":    sentNs,
+				"first_recv_ns":    now.UnixNano(),
+				"resumed_from_seq": seq,
+			})
+			log.Printf("UDP sink: flow %08x gap %.1f ms, %d lost", flow, gapMs, lost)
+		}
+	}
+	f.lastAt = now
+}
+
+// snapshot returns the totals; nil when the sink is off.
+func (u *udpSink) snapshot() *udpSinkStats {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	st := u.total
+	return &st
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webhook POSTs events to -webhook-url, each as the JSON object GET /events
+// returns, so a controller can react to peers, restores and keyframe storms
+// as they happen instead of polling /metrics. Delivery is best effort: one
+// attempt per event, from a queue that drops events when the receiver falls
+// behind. A nil webhook discards.
+type webhook struct {
+	url     string
+	types   map[string]bool // nil = all
+	client  http.Client
+	queue   chan event
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// webhookQueue is how many events may wait for delivery.
+const webhookQueue = 1024
+
+// newWebhook delivers the events in types (comma-separated, empty = all).
+func newWebhook(url, types string) *webhook {
+	h := &webhook{
+		url:    url,
+		client: http.Client{Timeout: 2 * time.Second},
+		queue:  make(chan event, webhookQueue),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			if h.types == nil {
+				h.types = map[string]bool{}
+			}
+			h.types[t] = true
+		}
+	}
+	go h.loop()
+	return h
+}
+
+func (h *webhook) send(ev event) {
+	if h == nil || (h.types != nil && !h.types[ev.Type]) {
+		return
+	}
+	select {
+	case h.queue <- ev:
+	default:
+		h.dropped.Add(1)
+	}
+}
+
+func (h *webhook) loop() {
+	defer close(h.done)
+	failing := false
+	for {
+		var ev event
+		select {
+		case ev = <-h.queue:
+		case <-h.stop:
+			// Deliver what is queued, then stop.
+			select {
+			case ev = <-h.queue:
+			default:
+				return
+			}
+		}
+		err := h.post(ev)
+		switch {
+		case err != nil:
+			h.failed.Add(1)
+			if !failing {
+				log.Printf("webhook: %v (logging again once it recovers)", err)
+			}
+			failing = true
+		case failing:
+			log.Printf("webhook: delivering again")
+			failing = false
+		}
+	}
+}
+
+func (h *webhook) post(ev event) error {
+	data, _ := json.Marshal(ev)
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", h.url, resp.Status)
+	}
+	return nil
+}
+
+// webhookStats counts undelivered events for /metrics: dropped from a full
+// queue, failed on the POST.
+type webhookStats struct {
+	Dropped uint64 `json:"dropped"`
+	Failed  uint64 `json:"failed"`
+}
+
+func (h *webhook) snapshot() *webhookStats {
+	if h == nil {
+		return nil
+	}
+	return &webhookStats{Dropped: h.dropped.Load(), Failed: h.failed.Load()}
+}
+
+// close delivers the queued events for up to timeout.
+func (h *webhook) close(timeout time.Duration) {
+	if h == nil {
+		return
+	}
+	close(h.stop)
+	select {
+	case <-h.done:
+	case <-time.After(timeout):
+	}
+}
+
+// keyframeStorm spots bursts of keyframe requests: -keyframe-storm or more
+// PLI/FIR from all peers within keyframeStormWindow, as after a restore
+// when every decoder lost its reference at once. Each window that reaches
+// the threshold reports once.
+type keyframeStorm struct {
+	mu       sync.Mutex
+	start    time.Time
+	n        int
+	reported bool
+}
+
+const keyframeStormWindow = time.Second
+
+// observe counts a request at now and returns the window's count the
+// first time it reaches threshold, 0 otherwise.
+func (k *keyframeStorm) observe(now time.Time, threshold int) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if wallSub(now, k.start) >= keyframeStormWindow {
+		k.start, k.n, k.reported = now, 0, false
+	}
+	k.n++
+	if threshold <= 0 || k.reported || k.n < threshold {
+		return 0
+	}
+	k.reported = true
+	return k.n
+}
@@ -0,0 +1,55 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"cpu.stat": "usage_usec 1500\nuser_usec 1000\nsystem_usec 500\nnr_periods 10\nnr_throttled 2\nthrottled_usec 40\n" +
+			"nr_bursts 0\nburst_usec 0\n",
+		"memory.current": "18825216\n",
+		"io.stat":        "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n259:0 rbytes=100 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CPUUsageNs != 1_500_000 || m.CPUUserNs != 1_000_000 || m.CPUSystemNs != 500_000 {
+		t.Errorf("cpu: %+v", m)
+	}
+	if m.NrThrottled != 2 || m.ThrottledNs != 40_000 {
+		t.Errorf("throttling: %+v", m)
+	}
+	if m.MemoryBytes != 18825216 || m.IOReadBytes != 4196 || m.IOWriteBytes != 8192 {
+		t.Errorf("memory/io: %+v", m)
+	}
+
+	// Without the io controller there is no io.stat.
+	os.Remove(filepath.Join(dir, "io.stat"))
+	if m, err := Read(dir); err != nil || m.IOReadBytes != 0 {
+		t.Errorf("without io.stat: %+v, %v", m, err)
+	}
+	os.Remove(filepath.Join(dir, "memory.current"))
+	if _, err := Read(dir); err == nil {
+		t.Error("no error without memory.current")
+	}
+}
+
+func TestDirSelf(t *testing.T) {
+	dir, err := Dir(os.Getpid())
+	if err != nil {
+		t.Skipf("no cgroup v2: %v", err)
+	}
+	if !strings.HasPrefix(dir, Root) {
+		t.Errorf("Dir = %q, not under %s", dir, Root)
+	}
+}
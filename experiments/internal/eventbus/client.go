@@ -0,0 +1,144 @@
+package eventbus
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// queueSize bounds the events waiting for the bus; beyond it they are
+// dropped rather than stalling the publisher.
+const queueSize = 1024
+
+// Client publishes events in the background. Publish never blocks, and a
+// nil *Client is valid and discards everything, so callers do not have to
+// check whether a bus was configured.
+type Client struct {
+	conn   *grpc.ClientConn
+	source string
+	host   string
+	queue  chan Event
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu   sync.Mutex
+	tags map[string]any
+}
+
+// Dial prepares a client for the bus at addr. The connection is made
+// lazily and retried, so the bus may come up after the publisher.
+func Dial(addr, source string) (*Client, error) {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})))
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	c := &Client{conn: conn, source: source, host: host, queue: make(chan Event, queueSize), stop: make(chan struct{}), done: make(chan struct{})}
+	go c.loop()
+	return c, nil
+}
+
+// Tag adds key to the fields of every event published from now on, such
+// as the number of the migration a ping-pong run is in. Fields passed to
+// Publish win over tags.
+func (c *Client) Tag(key string, value any) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tags == nil {
+		c.tags = map[string]any{}
+	}
+	c.tags[key] = value
+}
+
+// Publish timestamps an event now and queues it.
+func (c *Client) Publish(typ string, fields map[string]any) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if len(c.tags) > 0 {
+		merged := make(map[string]any, len(c.tags)+len(fields))
+		for k, v := range c.tags {
+			merged[k] = v
+		}
+		for k, v := range fields {
+			merged[k] = v
+		}
+		fields = merged
+	}
+	c.mu.Unlock()
+	ev := Event{TsUnixNano: time.Now().UnixNano(), Source: c.source, Host: c.host, Type: typ, Fields: fields}
+	select {
+	case c.queue <- ev:
+	default:
+	}
+}
+
+// loop sends whatever is queued in one call. A failed batch is dropped
+// after the first error of a streak is logged: events are for analysis,
+// and a missing bus must not hold up the experiment.
+func (c *Client) loop() {
+	defer close(c.done)
+	failing := false
+	for {
+		var first Event
+		stopping := false
+		select {
+		case first = <-c.queue:
+		case <-c.stop:
+			stopping = true
+		}
+		b := &batch{}
+		if !stopping {
+			b.Events = append(b.Events, first)
+		}
+	drain:
+		for len(b.Events) < queueSize {
+			select {
+			case ev := <-c.queue:
+				b.Events = append(b.Events, ev)
+			default:
+				break drain
+			}
+		}
+		if len(b.Events) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			err := c.conn.Invoke(ctx, publishMethod, b, new(ack))
+			cancel()
+			switch {
+			case err != nil && !failing:
+				log.Printf("event bus: %v (dropping %d events)", err, len(b.Events))
+				failing = true
+			case err == nil && failing:
+				log.Printf("event bus: reachable again")
+				failing = false
+			}
+		}
+		if stopping {
+			return
+		}
+	}
+}
+
+// Close sends what is still queued, waiting at most timeout.
+func (c *Client) Close(timeout time.Duration) {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+	}
+	c.conn.Close()
+}
@@ -0,0 +1,60 @@
+// Package eventbus is the gRPC service the experiment components publish
+// timestamped events to (migration_started, checkpoint_done, restore_done,
+// first_packet_after_gap, ...). One process, normally the collector, hosts
+// the bus and appends every event to a single events.jsonl, so the
+// cross-component timeline of a run no longer has to be pieced together
+// from four differently formatted logs.
+//
+// There is no .proto: messages are JSON over gRPC with a forced codec, the
+// same way internal/p4rt hand-encodes BF Runtime instead of generating it.
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const publishMethod = "/eventbus.EventBus/Publish"
+
+// Event is one line of events.jsonl. TsUnixNano is the publisher's clock
+// when the event happened; RecvUnixNano is the bus host's clock when it
+// arrived, which bounds the skew between the two.
+type Event struct {
+	TsUnixNano   int64          `json:"ts_unix_nano"`
+	Time         string         `json:"time"`
+	RecvUnixNano int64          `json:"recv_unix_nano"`
+	Source       string         `json:"source"`
+	Host         string         `json:"host,omitempty"`
+	Type         string         `json:"type"`
+	Fields       map[string]any `json:"fields,omitempty"`
+}
+
+type batch struct {
+	Events []Event `json:"events"`
+}
+
+type ack struct {
+	Accepted int `json:"accepted"`
+}
+
+// jsonCodec marshals the bus messages as JSON. Its name stays "proto" so
+// the default content-type works with any gRPC tooling in between.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "proto" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	switch v.(type) {
+	case *batch, *ack:
+		return json.Marshal(v)
+	}
+	return nil, fmt.Errorf("eventbus: cannot marshal %T", v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	switch v.(type) {
+	case *batch, *ack:
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("eventbus: cannot unmarshal into %T", v)
+}
@@ -0,0 +1,142 @@
+package eventbus
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []Event
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("%s: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// The orchestrator tags its events with the migration; the server passes
+// the tags of migration_started on to events from other publishers.
+func TestMigrationTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	srv, err := NewServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+
+	orch, err := Dial(lis.Addr().String(), "orchestrator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg, err := Dial(lis.Addr().String(), "loadgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg.Publish("first_packet_after_gap", map[string]any{"gap_ms": 1.0})
+	lg.Close(2 * time.Second)
+
+	orch.Tag("migration", 2)
+	orch.Tag("direction", "back")
+	orch.Publish("migration_started", map[string]any{"mode": "stop"})
+	orch.Publish("checkpoint_done", map[string]any{"migration": 7})
+	orch.Close(2 * time.Second)
+
+	lg, err = Dial(lis.Addr().String(), "loadgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg.Publish("first_packet_after_gap", map[string]any{"gap_ms": 250.0})
+	lg.Close(2 * time.Second)
+	srv.Close()
+
+	events := readEvents(t, path)
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4", len(events))
+	}
+	if _, ok := events[0].Fields["migration"]; ok {
+		t.Errorf("event before any migration is tagged: %v", events[0].Fields)
+	}
+	if f := events[1].Fields; f["migration"] != 2.0 || f["direction"] != "back" || f["mode"] != "stop" {
+		t.Errorf("migration_started fields %v", f)
+	}
+	if f := events[2].Fields; f["migration"] != 7.0 {
+		t.Errorf("published field lost to the tag: %v", f)
+	}
+	if f := events[3].Fields; events[3].Source != "loadgen" || f["migration"] != 2.0 || f["direction"] != "back" || f["gap_ms"] != 250.0 {
+		t.Errorf("loadgen event after migration_started: %s %v", events[3].Source, f)
+	}
+}
+
+func TestNilClient(t *testing.T) {
+	var c *Client
+	c.Tag("migration", 1)
+	c.Publish("anything", nil)
+	c.Close(time.Second)
+}
+
+func TestRoute(t *testing.T) {
+	dir := t.TempDir()
+	srv, err := NewServer(filepath.Join(dir, "events.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Route("peer_snapshot", filepath.Join(dir, "peers.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	srv.Publish("orchestrator", "migration_started", map[string]any{"migration": 1})
+	srv.Publish("loadgen", "peer_snapshot", map[string]any{"id": 0})
+	srv.Publish("orchestrator", "restore_done", nil)
+	srv.Close()
+
+	events := readEvents(t, filepath.Join(dir, "events.jsonl"))
+	if len(events) != 2 || events[0].Type != "migration_started" || events[1].Type != "restore_done" {
+		t.Errorf("events.jsonl: %+v", events)
+	}
+	peers := readEvents(t, filepath.Join(dir, "peers.jsonl"))
+	if len(peers) != 1 || peers[0].Type != "peer_snapshot" || peers[0].Fields["migration"] != 1.0 {
+		t.Errorf("peers.jsonl: %+v", peers)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	srv, err := NewServer(filepath.Join(t.TempDir(), "events.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	ch := make(chan Event, 1)
+	srv.Notify("migration_started", ch)
+	srv.Publish("orchestrator", "checkpoint_done", nil)
+	srv.Publish("orchestrator", "migration_started", map[string]any{"migration": 1})
+	srv.Publish("orchestrator", "migration_started", map[string]any{"migration": 2})
+	select {
+	case ev := <-ch:
+		if ev.Type != "migration_started" || ev.Fields["migration"] != 1 {
+			t.Errorf("got %+v", ev)
+		}
+	default:
+		t.Fatal("no event")
+	}
+	if len(ch) != 0 {
+		t.Error("full channel was not skipped")
+	}
+}
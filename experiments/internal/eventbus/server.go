@@ -0,0 +1,165 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Server hosts the bus and appends every event to a JSONL file in arrival
+// order.
+//
+// In a ping-pong run the orchestrator tags its events with the migration
+// number and direction. The server copies the tags of the latest
+// migration_started onto every later event that lacks them, so the
+// loadgen's and probes' events are attributed to a migration too.
+type Server struct {
+	mu     sync.Mutex
+	file   *os.File
+	routes map[string]*os.File // event type -> file, see Route
+	notify map[string][]chan<- Event
+	host   string
+	grpc   *grpc.Server
+	tags   map[string]any
+}
+
+// migrationTags are the fields copied from migration_started.
+var migrationTags = []string{"migration", "direction"}
+
+// recorder is the handler type of the service description.
+type recorder interface {
+	record(events []Event) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "eventbus.EventBus",
+	HandlerType: (*recorder)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Publish",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			in := new(batch)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if err := srv.(recorder).record(in.Events); err != nil {
+				return nil, err
+			}
+			return &ack{Accepted: len(in.Events)}, nil
+		},
+	}},
+}
+
+// NewServer opens path for appending.
+func NewServer(path string) (*Server, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	s := &Server{file: f, host: host}
+	s.grpc = grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}))
+	s.grpc.RegisterService(&serviceDesc, s)
+	return s, nil
+}
+
+// Route appends events of type typ to their own file at path instead of
+// the events file, for high-rate data such as the loadgen's per-peer
+// snapshots that would drown the timeline.
+func (s *Server) Route(typ, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.routes == nil {
+		s.routes = map[string]*os.File{}
+	}
+	if old := s.routes[typ]; old != nil {
+		old.Close()
+	}
+	s.routes[typ] = f
+	return nil
+}
+
+// Notify sends every recorded event of type typ to ch, such as
+// migration_started to a collector that samples faster during
+// migrations. Events are dropped if ch is full; the file still has them.
+func (s *Server) Notify(typ string, ch chan<- Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.notify == nil {
+		s.notify = map[string][]chan<- Event{}
+	}
+	s.notify[typ] = append(s.notify[typ], ch)
+}
+
+// Serve accepts publishers on lis until Close.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// Publish records an event of the hosting process itself.
+func (s *Server) Publish(source, typ string, fields map[string]any) {
+	now := time.Now()
+	s.record([]Event{{TsUnixNano: now.UnixNano(), Source: source, Host: s.host, Type: typ, Fields: fields}})
+}
+
+func (s *Server) record(events []Event) error {
+	recv := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ev := range events {
+		if ev.Type == "migration_started" && ev.Fields["migration"] != nil {
+			s.tags = map[string]any{}
+			for _, k := range migrationTags {
+				s.tags[k] = ev.Fields[k]
+			}
+		} else if len(s.tags) > 0 {
+			fields := make(map[string]any, len(ev.Fields)+len(s.tags))
+			for k, v := range s.tags {
+				fields[k] = v
+			}
+			for k, v := range ev.Fields {
+				fields[k] = v
+			}
+			ev.Fields = fields
+		}
+		ev.RecvUnixNano = recv
+		ev.Time = time.Unix(0, ev.TsUnixNano).UTC().Format(time.RFC3339Nano)
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		f := s.file
+		if r := s.routes[ev.Type]; r != nil {
+			f = r
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return err
+		}
+		for _, ch := range s.notify[ev.Type] {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// Close lets in-flight publishes finish and closes the file.
+func (s *Server) Close() error {
+	s.grpc.GracefulStop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.routes {
+		f.Close()
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,117 @@
+// Package histogram is an HDR-style latency histogram: log-linear buckets
+// with a fixed relative error (under 0.4%) from 1 to 2^63, so a whole run
+// of inter-frame gaps or RTTs fits in a few kB per peer and its tail
+// percentiles are exact to that error instead of hidden in per-second
+// averages.
+package histogram
+
+import "math/bits"
+
+// subBits sets the precision: values below 2^subBits have their own
+// bucket, larger ones share a bucket with values within 1/2^subBits.
+const subBits = 8
+
+// Histogram counts non-negative integer values, such as microseconds. The
+// zero value is empty and ready to use. It is not safe for concurrent use.
+type Histogram struct {
+	counts   []uint64
+	total    uint64
+	sum      float64
+	min, max int64
+}
+
+func index(v int64) int {
+	if v < 1<<subBits {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBits - 1
+	return (shift+1)<<subBits + int(v>>shift) - 1<<subBits
+}
+
+// highest is the largest value that lands in bucket i.
+func highest(i int) int64 {
+	shift := i>>subBits - 1
+	if shift < 0 {
+		return int64(i)
+	}
+	low := int64(i&(1<<subBits-1)+1<<subBits) << shift
+	return low + 1<<shift - 1
+}
+
+// Record counts v; negative values count as 0.
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	i := index(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+	h.sum += float64(v)
+}
+
+// Merge adds the counts of o.
+func (h *Histogram) Merge(o *Histogram) {
+	if o.total == 0 {
+		return
+	}
+	if len(o.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, len(o.counts)-len(h.counts))...)
+	}
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	if h.total == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	h.max = max(h.max, o.max)
+	h.total += o.total
+	h.sum += o.sum
+}
+
+// Count is the number of recorded values.
+func (h *Histogram) Count() uint64 { return h.total }
+
+// Min and Max are the exact extremes, 0 when empty.
+func (h *Histogram) Min() int64 { return h.min }
+func (h *Histogram) Max() int64 { return h.max }
+
+// Mean is the exact mean, 0 when empty.
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// ValueAt is the value at percentile p (0-100): the highest value of the
+// bucket holding it, capped at Max. 0 when empty.
+func (h *Histogram) ValueAt(p float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	if p >= 100 {
+		return h.max
+	}
+	// The rank of the value, 1-based, rounded up like HdrHistogram.
+	rank := uint64(p / 100 * float64(h.total))
+	if float64(rank) < p/100*float64(h.total) || rank == 0 {
+		rank++
+	}
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(highest(i), h.max)
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,71 @@
+package histogram
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuckets(t *testing.T) {
+	// Every value lies in its bucket, and buckets are contiguous.
+	prev := -1
+	for _, v := range []int64{0, 1, 255, 256, 257, 511, 512, 513, 1000, 123456, 1 << 40, math.MaxInt64} {
+		i := index(v)
+		if v > highest(i) || (i > 0 && v <= highest(i-1)) {
+			t.Errorf("%d in bucket %d, [%d, %d]", v, i, highest(i-1)+1, highest(i))
+		}
+		if i < prev {
+			t.Errorf("index(%d) = %d < %d", v, i, prev)
+		}
+		prev = i
+	}
+	for i := 1; i < 4096; i++ {
+		if lo, hi := highest(i-1)+1, highest(i); index(lo) != i || index(hi) != i {
+			t.Fatalf("bucket %d [%d, %d] maps to %d, %d", i, lo, hi, index(lo), index(hi))
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var h Histogram
+	if h.ValueAt(99) != 0 || h.Mean() != 0 {
+		t.Error("empty histogram not zero")
+	}
+	for v := int64(1); v <= 10000; v++ {
+		h.Record(v)
+	}
+	if h.Count() != 10000 || h.Min() != 1 || h.Max() != 10000 || h.Mean() != 5000.5 {
+		t.Errorf("count %d min %d max %d mean %g", h.Count(), h.Min(), h.Max(), h.Mean())
+	}
+	for _, tc := range []struct {
+		p    float64
+		want int64
+	}{{0, 1}, {50, 5000}, {90, 9000}, {99, 9900}, {99.9, 9990}, {100, 10000}} {
+		got := h.ValueAt(tc.p)
+		if math.Abs(float64(got-tc.want)) > float64(tc.want)/256+1 {
+			t.Errorf("ValueAt(%g) = %d, want %d within 1/256", tc.p, got, tc.want)
+		}
+	}
+
+	// One long freeze among many short gaps is the p99.99 and max, not
+	// averaged away.
+	var g, all Histogram
+	for i := 0; i < 9999; i++ {
+		g.Record(20_000)
+	}
+	g.Record(3_000_000)
+	if v := g.ValueAt(99); v < 20_000 || v > 20_100 {
+		t.Errorf("p99 = %d", v)
+	}
+	if v := g.ValueAt(99.99); v < 20_000 || v > 20_100 {
+		t.Errorf("p99.99 = %d", v)
+	}
+	if v := g.ValueAt(99.995); v != 3_000_000 {
+		t.Errorf("p99.995 = %d", v)
+	}
+	all.Merge(&h)
+	all.Merge(&g)
+	all.Merge(&Histogram{})
+	if all.Count() != 20000 || all.Min() != 1 || all.Max() != 3_000_000 {
+		t.Errorf("merged: count %d min %d max %d", all.Count(), all.Min(), all.Max())
+	}
+}
@@ -0,0 +1,155 @@
+// Package metricsmodel holds the /metrics response types the collector
+// reads, the schema of the CSV it writes and the container stats podman
+// reports, so every reader decodes them the same way. The servers and
+// loadgens keep their own copies of the wire format; these must match
+// them field for field.
+package metricsmodel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServerMetrics is the stream server's /metrics (cmd/server, cmd/h3server).
+// TimestampNs, here and in the other responses, is the responding host's
+// clock when it took the snapshot.
+type ServerMetrics struct {
+	TimestampNs      int64   `json:"timestamp_unix_nano"`
+	ConnectedClients int     `json:"connected_clients"`
+	TotalClients     int64   `json:"total_clients"`
+	BytesSent        uint64  `json:"bytes_sent"`
+	BytesReceived    uint64  `json:"bytes_received"`
+	WireBytesSent    uint64  `json:"wire_bytes_sent"`
+	UptimeSeconds    float64 `json:"uptime_seconds"`
+	CPUPercent       float64 `json:"cpu_percent"`
+	MemoryMB         float64 `json:"memory_mb"`
+	SessionsResumed  uint64  `json:"sessions_resumed"`
+	SessionSetup     struct {
+		Count  uint64  `json:"count"`
+		LastMs float64 `json:"last_ms"`
+	} `json:"session_setup"`
+}
+
+// LoadgenMetrics is the loadgen's /metrics (cmd/loadgen, cmd/h3loadgen).
+type LoadgenMetrics struct {
+	TimestampNs      int64   `json:"timestamp_unix_nano"`
+	ConnectedClients int     `json:"connected_clients"`
+	AvgRttMs         float64 `json:"avg_rtt_ms"`
+	P50RttMs         float64 `json:"p50_rtt_ms"`
+	P95RttMs         float64 `json:"p95_rtt_ms"`
+	P99RttMs         float64 `json:"p99_rtt_ms"`
+	MaxRttMs         float64 `json:"max_rtt_ms"`
+	JitterMs         float64 `json:"jitter_ms"`
+	ConnectionDrops  int64   `json:"connection_drops"`
+}
+
+// ProbeMetrics is cmd/ebpfprobe's /metrics: kernel packet counters for the
+// server IP on one node's interface.
+type ProbeMetrics struct {
+	PacketsIn    uint64  `json:"packets_in"`
+	PacketsOut   uint64  `json:"packets_out"`
+	RxDropped    uint64  `json:"rx_dropped"`
+	TxDropped    uint64  `json:"tx_dropped"`
+	Gaps         uint64  `json:"gaps"`
+	MaxGapMs     float64 `json:"max_gap_ms"`
+	LastPacketNs int64   `json:"last_packet_unix_nano"`
+	TimestampNs  int64   `json:"timestamp_unix_nano"`
+}
+
+// CgroupMetrics is cmd/cgroupprobe's /metrics: the server container's
+// cgroup v2 counters, raw and cumulative since the container started.
+// Running is false on a node the container is not on, where the counters
+// are zero.
+type CgroupMetrics struct {
+	TimestampNs  int64  `json:"timestamp_unix_nano"`
+	Container    string `json:"container"`
+	Running      bool   `json:"running"`
+	CPUUsageNs   uint64 `json:"cpu_usage_ns"`
+	CPUUserNs    uint64 `json:"cpu_user_ns"`
+	CPUSystemNs  uint64 `json:"cpu_system_ns"`
+	NrThrottled  uint64 `json:"nr_throttled"`
+	ThrottledNs  uint64 `json:"throttled_ns"`
+	MemoryBytes  uint64 `json:"memory_bytes"`
+	IOReadBytes  uint64 `json:"io_read_bytes"`
+	IOWriteBytes uint64 `json:"io_write_bytes"`
+}
+
+// ContainerStats is one container's line of `podman stats`, with the
+// human-readable values converted.
+type ContainerStats struct {
+	ID         string
+	Name       string
+	CPUPercent float64
+	MemBytes   uint64
+	MemLimit   uint64
+	MemPercent float64
+	NetInput   uint64
+	NetOutput  uint64
+	BlockRead  uint64
+	BlockWrite uint64
+	PIDs       int
+}
+
+// ParsePercent parses "12.34%" (or "--", which podman prints for a
+// container that is not running, as 0).
+func ParsePercent(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "--" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+}
+
+// units covers the decimal units podman prints (via go-units HumanSize)
+// and the binary ones other tools use.
+var units = map[string]float64{
+	"b":  1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+// ParseSize parses a size such as "12.5MB", "980kB", "1.2GiB" or "0B"
+// into bytes.
+func ParseSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "--" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("size %q: %w", s, err)
+	}
+	unit := strings.ToLower(strings.TrimSpace(s[i:]))
+	if unit == "" {
+		return uint64(v), nil
+	}
+	m, ok := units[unit]
+	if !ok {
+		return 0, fmt.Errorf("size %q: unknown unit %q", s, unit)
+	}
+	return uint64(v*m + 0.5), nil
+}
+
+// ParsePair parses "used / total" pairs like podman's mem_usage,
+// net_io and block_io.
+func ParsePair(s string) (a, b uint64, err error) {
+	if t := strings.TrimSpace(s); t == "" || t == "--" {
+		return 0, 0, nil
+	}
+	x, y, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q is not a pair", s)
+	}
+	if a, err = ParseSize(x); err != nil {
+		return 0, 0, err
+	}
+	if b, err = ParseSize(y); err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
@@ -0,0 +1,82 @@
+package metricsmodel
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want uint64
+	}{
+		{"0B", 0},
+		{"512B", 512},
+		{"980kB", 980_000},
+		{"12.5MB", 12_500_000},
+		{"1.2GB", 1_200_000_000},
+		{"4KiB", 4096},
+		{"1.5 MiB", 1_572_864},
+		{" 3GiB ", 3 << 30},
+		{"42", 42},
+		{"--", 0},
+		{"", 0},
+	} {
+		got, err := ParseSize(tc.in)
+		if err != nil {
+			t.Errorf("ParseSize(%q): %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{"MB", "12XB", "1.2.3kB"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q): no error", in)
+		}
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	for in, want := range map[string]float64{"12.34%": 12.34, "0.00%": 0, "--": 0, "100%": 100} {
+		got, err := ParsePercent(in)
+		if err != nil || got != want {
+			t.Errorf("ParsePercent(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParsePercent("n/a"); err == nil {
+		t.Error("ParsePercent(n/a): no error")
+	}
+}
+
+func TestParsePair(t *testing.T) {
+	a, b, err := ParsePair("12.3MB / 16.7GB")
+	if err != nil || a != 12_300_000 || b != 16_700_000_000 {
+		t.Errorf("ParsePair = %d, %d, %v", a, b, err)
+	}
+	if a, b, err := ParsePair("--"); err != nil || a != 0 || b != 0 {
+		t.Errorf("ParsePair(--) = %d, %d, %v", a, b, err)
+	}
+	if _, _, err := ParsePair("12MB"); err == nil {
+		t.Error("ParsePair without a slash: no error")
+	}
+}
+
+// The server writes session_setup as a nested object; the collector's
+// session_setups column comes from it.
+func TestServerMetricsJSON(t *testing.T) {
+	body := `{"connected_clients":4,"total_clients":9,"bytes_sent":1048576,"bytes_received":2048,
+		"wire_bytes_sent":1100000,"uptime_seconds":12.5,"cpu_percent":3.25,"memory_mb":18.4,
+		"sessions_resumed":2,"session_setup":{"count":7,"last_ms":1.75},"unknown":true}`
+	var m ServerMetrics
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.ConnectedClients != 4 || m.TotalClients != 9 || m.BytesSent != 1048576 || m.WireBytesSent != 1100000 {
+		t.Errorf("counters: %+v", m)
+	}
+	if m.SessionSetup.Count != 7 || m.SessionSetup.LastMs != 1.75 || m.SessionsResumed != 2 {
+		t.Errorf("sessions: %+v", m)
+	}
+}
@@ -0,0 +1,105 @@
+package metricsmodel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SchemaVersion is the layout of the collector CSV, written to every row's
+// schema_version column and to the schema sidecar. Bump it when a column
+// is renamed or changes type, unit or meaning; new columns do not need a
+// bump since readers look columns up by name. CSVs with neither are
+// version 1, from before there was a schema. Version 3 added marker rows,
+// which are no samples; see MarkerEvents.
+const SchemaVersion = 3
+
+// Column describes one CSV column. Type is int, float, bool (0/1),
+// string or time (RFC 3339); Source is what measured it: collector,
+// server, loadgen, probe, cgroup, container stats, ping, switch,
+// migration flag or event bus.
+type Column struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Unit   string `json:"unit,omitempty"`
+	Source string `json:"source"`
+}
+
+// Schema is the sidecar the collector writes next to its CSV.
+type Schema struct {
+	Version int      `json:"version"`
+	Columns []Column `json:"columns"`
+}
+
+// Names are the column names in order, the CSV header.
+func (s Schema) Names() []string {
+	names := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// SchemaPath is the sidecar of the CSV at csvPath: metrics.csv has
+// metrics.schema.json.
+func SchemaPath(csvPath string) string {
+	return strings.TrimSuffix(csvPath, ".csv") + ".schema.json"
+}
+
+// WriteSchema writes s as indented JSON to path.
+func WriteSchema(path string, s Schema) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReadSchema reads a sidecar. A missing file is an error satisfying
+// errors.Is(err, fs.ErrNotExist).
+func ReadSchema(path string) (Schema, error) {
+	var s Schema
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Version < 1 {
+		return s, fmt.Errorf("%s: no schema version", path)
+	}
+	return s, nil
+}
+
+// MarkerEvents are the events the collector writes a marker row for when it
+// hosts the event bus: a row at the event's timestamp with the event type in
+// migration_phase and only the timestamp, migration and schema_version
+// columns filled in. Sample rows have migration_phase empty. All but
+// restored are the orchestrator's; restored comes from the server itself,
+// the restore as seen from inside the container.
+var MarkerEvents = []string{
+	"migration_started",
+	"checkpoint_start", "checkpoint_done",
+	"transfer_start", "transfer_done",
+	"restore_start", "restore_done",
+	"restored",
+	"switch_updated",
+	"migration_done", "migration_failed",
+}
+
+// Phase is a migration phase, the span from the marker Start to the
+// marker End.
+type Phase struct {
+	Name, Start, End string
+}
+
+// Phases are the phases of a migration in order. The switch update starts
+// when the restore is done.
+var Phases = []Phase{
+	{"checkpoint", "checkpoint_start", "checkpoint_done"},
+	{"transfer", "transfer_start", "transfer_done"},
+	{"restore", "restore_start", "restore_done"},
+	{"switch", "restore_done", "switch_updated"},
+}
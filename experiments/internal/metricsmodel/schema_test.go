@@ -0,0 +1,39 @@
+package metricsmodel
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaPath(t *testing.T) {
+	if got := SchemaPath("run/iter_1/metrics.csv"); got != "run/iter_1/metrics.schema.json" {
+		t.Errorf("SchemaPath = %q", got)
+	}
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.schema.json")
+	if _, err := ReadSchema(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing sidecar: %v", err)
+	}
+	s := Schema{Version: SchemaVersion, Columns: []Column{
+		{Name: "timestamp_unix_milli", Type: "int", Unit: "ms", Source: "collector"},
+		{Name: "migration_direction", Type: "string", Source: "migration flag"},
+	}}
+	if err := WriteSchema(path, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("ReadSchema = %+v, want %+v", got, s)
+	}
+	if names := got.Names(); !reflect.DeepEqual(names, []string{"timestamp_unix_milli", "migration_direction"}) {
+		t.Errorf("Names = %v", names)
+	}
+}
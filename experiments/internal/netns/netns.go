@@ -0,0 +1,132 @@
+// Package netns opens sockets inside another network namespace with
+// setns(2), so tools can talk to the loadgen or server namespace directly
+// instead of going through sudo nsenter for every call. It still needs
+// CAP_SYS_ADMIN, but only once per process rather than an exec per sample.
+//
+// A namespace only applies to the OS thread that entered it; sockets keep
+// the namespace they were created in, so Do creates them on a locked
+// thread and the rest of the program uses them from anywhere.
+package netns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Path resolves a namespace spec: a PID ("12345", the namespace of that
+// process, e.g. a container's from podman inspect), a path
+// ("/proc/1/ns/net", "/var/run/netns/x") or a name created by ip netns.
+func Path(spec string) string {
+	if _, err := strconv.Atoi(spec); err == nil {
+		return "/proc/" + spec + "/ns/net"
+	}
+	if strings.Contains(spec, "/") {
+		return spec
+	}
+	return "/run/netns/" + spec
+}
+
+// Enter moves the calling thread into the namespace at path for good and
+// locks the goroutine to it. For single-purpose tools that would
+// otherwise be started under nsenter.
+func Enter(path string) error {
+	runtime.LockOSThread()
+	return setns(path)
+}
+
+// Do runs fn on a thread in the namespace at path and switches back. If
+// switching back fails, the thread is left locked so the runtime discards
+// it rather than reuse it in the wrong namespace.
+func Do(path string, fn func() error) error {
+	runtime.LockOSThread()
+	self, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer self.Close()
+	if err := setns(path); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	fnErr := fn()
+	if err := unix.Setns(int(self.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("netns: back from %s: %w", path, err)
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
+
+func setns(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("netns: %w", err)
+	}
+	defer f.Close()
+	if err := unix.Setns(int(f.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("netns: enter %s: %w", path, err)
+	}
+	return nil
+}
+
+// DialContext is net.Dialer.DialContext with the socket created in the
+// namespace at path; an empty path dials from the current one.
+func DialContext(ctx context.Context, path, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if path == "" {
+		return d.DialContext(ctx, network, addr)
+	}
+	var c net.Conn
+	err := Do(path, func() (err error) {
+		c, err = d.DialContext(ctx, network, addr)
+		return err
+	})
+	return c, err
+}
+
+// DialUDP is net.DialUDP in the namespace at path.
+func DialUDP(path string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	if path == "" {
+		return net.DialUDP("udp", laddr, raddr)
+	}
+	var c *net.UDPConn
+	err := Do(path, func() (err error) {
+		c, err = net.DialUDP("udp", laddr, raddr)
+		return err
+	})
+	return c, err
+}
+
+// ListenPacket is net.ListenPacket in the namespace at path.
+func ListenPacket(path, network, addr string) (net.PacketConn, error) {
+	if path == "" {
+		return net.ListenPacket(network, addr)
+	}
+	var c net.PacketConn
+	err := Do(path, func() (err error) {
+		c, err = net.ListenPacket(network, addr)
+		return err
+	})
+	return c, err
+}
+
+// HTTPClient returns a client whose connections are made in the
+// namespace at path. Keep-alives keep the setns cost to the first request
+// of each connection.
+func HTTPClient(path string, timeout time.Duration) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return DialContext(ctx, path, network, addr)
+	}
+	return &http.Client{Timeout: timeout, Transport: tr}
+}
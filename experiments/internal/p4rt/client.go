@@ -0,0 +1,209 @@
+// Package p4rt is a small BF Runtime gRPC client for the load balancer
+// program on the Tofino2. It covers what a migration needs: rewriting the
+// forward entries of the server IP in one atomic write and reading them
+// back, without going through the Python controller's HTTP API.
+package p4rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const service = "/bfrt_proto.BfRuntime/"
+
+// Update type and write atomicity from bfruntime.proto.
+const (
+	updateModify    = 2
+	atomicDataplane = 2
+)
+
+// Client is one BF Runtime session. It subscribes with its own client ID
+// and names the program on every request instead of binding to it, so it
+// can run next to the controller, which holds the binding.
+type Client struct {
+	conn     *grpc.ClientConn
+	stream   grpc.ClientStream
+	cancel   context.CancelFunc
+	deviceID uint32
+	clientID uint32
+	p4Name   string
+	Info     *Info
+}
+
+// Dial connects to bf_switchd (usually :50052), subscribes, and loads the
+// bfrt info of program p4Name from the switch. An empty p4Name uses the
+// first program the switch reports.
+func Dial(ctx context.Context, addr string, deviceID, clientID uint32, p4Name string) (*Client, error) {
+	return dial(ctx, addr, deviceID, clientID, p4Name, false)
+}
+
+func dial(ctx context.Context, addr string, deviceID, clientID uint32, p4Name string, learn bool) (*Client, error) {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, deviceID: deviceID, clientID: clientID, p4Name: p4Name}
+	if err := c.subscribe(ctx, learn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribe: %w", err)
+	}
+	if err := c.loadInfo(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("get pipeline config: %w", err)
+	}
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	c.cancel()
+	return c.conn.Close()
+}
+
+// subscribe opens the stream channel, which the server requires before it
+// accepts requests from a client ID. The stream stays open for the
+// lifetime of the client. With learn set the server also sends digests
+// on it; otherwise the client asks for no notifications at all.
+func (c *Client) subscribe(ctx context.Context, learn bool) error {
+	sctx, cancel := context.WithCancel(context.Background())
+	desc := &grpc.StreamDesc{StreamName: "StreamChannel", ServerStreams: true, ClientStreams: true}
+	stream, err := c.conn.NewStream(sctx, desc, service+"StreamChannel")
+	if err != nil {
+		cancel()
+		return err
+	}
+	sub := new(message).bool(1, true).uint(2, uint64(c.deviceID)).
+		msg(3, new(message).bool(1, learn))
+	req := new(message).uint(1, uint64(c.clientID)).msg(2, sub)
+	if err := stream.SendMsg(req); err != nil {
+		cancel()
+		return err
+	}
+
+	got := make(chan error, 1)
+	go func() {
+		var resp message
+		if err := stream.RecvMsg(&resp); err != nil {
+			got <- err
+			return
+		}
+		f, err := decode(resp.b)
+		if err != nil {
+			got <- err
+			return
+		}
+		sf, _ := decode(f.first(1))
+		status, _ := decode(sf.first(4))
+		if code := status.varints[1]; code != 0 {
+			got <- fmt.Errorf("code %d: %s", code, status.first(2))
+			return
+		}
+		got <- nil
+	}()
+	select {
+	case err = <-got:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(5 * time.Second):
+		err = errors.New("no subscribe response")
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.stream, c.cancel = stream, cancel
+	return nil
+}
+
+func (c *Client) loadInfo(ctx context.Context) error {
+	req := new(message).uint(1, uint64(c.deviceID)).uint(2, uint64(c.clientID))
+	var resp message
+	if err := c.conn.Invoke(ctx, service+"GetForwardingPipelineConfig", req, &resp); err != nil {
+		return err
+	}
+	f, err := decode(resp.b)
+	if err != nil {
+		return err
+	}
+	for _, raw := range f.bytes[1] {
+		cfg, err := decode(raw)
+		if err != nil {
+			return err
+		}
+		name := string(cfg.first(1))
+		if c.p4Name != "" && name != c.p4Name {
+			continue
+		}
+		c.p4Name = name
+		c.Info, err = ParseInfo(cfg.first(2))
+		return err
+	}
+	if c.p4Name == "" {
+		return errors.New("switch reports no P4 program")
+	}
+	return fmt.Errorf("program %s is not loaded", c.p4Name)
+}
+
+// target addresses all pipes, like gc.Target(device_id, pipe_id=0xFFFF).
+func (c *Client) target() *message {
+	return new(message).uint(1, uint64(c.deviceID)).uint(2, 0xFFFF).uint(3, 0xFF).uint(4, 0xFF)
+}
+
+// write sends updates as one dataplane-atomic WriteRequest: the switch
+// applies all of them between two packets or none of them.
+func (c *Client) write(ctx context.Context, updates ...*message) error {
+	req := new(message).msg(1, c.target()).uint(2, uint64(c.clientID))
+	for _, u := range updates {
+		req.msg(3, u)
+	}
+	req.uint(4, atomicDataplane).string(5, c.p4Name)
+	var resp message
+	return c.conn.Invoke(ctx, service+"Write", req, &resp)
+}
+
+// read returns the table entries matching entity.
+func (c *Client) read(ctx context.Context, entity *message) ([][]byte, error) {
+	req := new(message).msg(1, c.target()).uint(2, uint64(c.clientID)).msg(3, entity).string(4, c.p4Name)
+	desc := &grpc.StreamDesc{StreamName: "Read", ServerStreams: true}
+	stream, err := c.conn.NewStream(ctx, desc, service+"Read")
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	var entries [][]byte
+	for {
+		var resp message
+		err := stream.RecvMsg(&resp)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		f, err := decode(resp.b)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range f.bytes[1] {
+			ef, err := decode(e)
+			if err != nil {
+				return nil, err
+			}
+			if te := ef.first(1); te != nil {
+				entries = append(entries, te)
+			}
+		}
+	}
+}
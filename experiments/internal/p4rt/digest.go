@@ -0,0 +1,110 @@
+package p4rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FlowDigest is the digest load_balancer/t2na_load_balancer.p4 sends for
+// the first packet of a TCP flow and whenever its egress port changes.
+const FlowDigest = "pipe.SwitchIngressDeparser.flow_digest"
+
+// Digest is one entry of a DigestList: the packed fields of one packet,
+// by field name, big-endian as the data plane wrote them.
+type Digest struct {
+	Name     string
+	Fields   map[string][]byte
+	Received time.Time
+}
+
+// Uint returns field name as an integer (0 if the digest lacks it).
+func (d Digest) Uint(name string) uint64 {
+	return decodeUint(d.Fields[name])
+}
+
+// DialDigests is Dial for a client that receives the program's digests.
+// BF Runtime only delivers digests to clients bound to the program, so it
+// binds as well. A BIND without a config just associates the client ID
+// with the loaded program; the controller's entries are not touched.
+func DialDigests(ctx context.Context, addr string, deviceID, clientID uint32, p4Name string) (*Client, error) {
+	c, err := dial(ctx, addr, deviceID, clientID, p4Name, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.bind(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("bind %s: %w", c.p4Name, err)
+	}
+	return c, nil
+}
+
+// bind sends SetForwardingPipelineConfig with action BIND (0) and only the
+// program name, which associates the client ID with the loaded program.
+func (c *Client) bind(ctx context.Context) error {
+	req := new(message).uint(1, uint64(c.deviceID)).uint(2, uint64(c.clientID)).
+		msg(6, new(message).string(1, c.p4Name))
+	var resp message
+	return c.conn.Invoke(ctx, service+"SetForwardingPipelineConfig", req, &resp)
+}
+
+// RecvDigests blocks until the switch sends the next DigestList, acks it
+// and returns its entries. Other stream messages are skipped. Only a
+// client from DialDigests receives any, and only one goroutine may call
+// it at a time.
+func (c *Client) RecvDigests() ([]Digest, error) {
+	for {
+		var resp message
+		if err := c.stream.RecvMsg(&resp); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		f, err := decode(resp.b)
+		if err != nil {
+			return nil, err
+		}
+		if f.first(6) != nil {
+			return nil, errors.New("switch reported a stream error")
+		}
+		raw := f.first(2)
+		if raw == nil {
+			continue
+		}
+		dl, err := decode(raw)
+		if err != nil {
+			return nil, err
+		}
+		digestID, listID := dl.varints[2], dl.varints[3]
+		ack := new(message).uint(1, digestID).uint(2, listID)
+		if err := c.stream.SendMsg(new(message).uint(1, uint64(c.clientID)).msg(3, ack)); err != nil {
+			return nil, fmt.Errorf("ack digest list %d: %w", listID, err)
+		}
+		l, ok := c.Info.learns[uint32(digestID)]
+		if !ok {
+			return nil, fmt.Errorf("unknown digest id %d", digestID)
+		}
+		out := make([]Digest, 0, len(dl.bytes[4]))
+		for _, data := range dl.bytes[4] {
+			td, err := decode(data)
+			if err != nil {
+				return nil, err
+			}
+			d := Digest{Name: l.Name, Fields: make(map[string][]byte, len(l.Fields)), Received: now}
+			for _, raw := range td.bytes[2] {
+				df, err := decode(raw)
+				if err != nil {
+					return nil, err
+				}
+				id := uint32(df.varints[1])
+				for _, fl := range l.Fields {
+					if fl.ID == id {
+						d.Fields[fl.Name] = df.first(2)
+					}
+				}
+			}
+			out = append(out, d)
+		}
+		return out, nil
+	}
+}
@@ -0,0 +1,130 @@
+package p4rt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Info is the part of a program's bfrt.json needed to address tables by
+// name: table, key field, action and action parameter IDs and widths, and
+// the digests (learn filters) the program sends.
+type Info struct {
+	tables map[string]*Table
+	learns map[uint32]*Learn
+}
+
+type Table struct {
+	Name    string   `json:"name"`
+	ID      uint32   `json:"id"`
+	Keys    []Field  `json:"key"`
+	Actions []Action `json:"action_specs"`
+}
+
+type Action struct {
+	Name   string  `json:"name"`
+	ID     uint32  `json:"id"`
+	Params []Field `json:"data"`
+}
+
+// Learn is one digest of the program, e.g.
+// pipe.SwitchIngressDeparser.flow_digest.
+type Learn struct {
+	Name   string  `json:"name"`
+	ID     uint32  `json:"id"`
+	Fields []Field `json:"fields"`
+}
+
+type Field struct {
+	Name string `json:"name"`
+	ID   uint32 `json:"id"`
+	Type struct {
+		Type  string `json:"type"`
+		Width int    `json:"width"`
+	} `json:"type"`
+}
+
+// ParseInfo reads bfrt.json, as written by the compiler or returned by
+// GetForwardingPipelineConfig.
+func ParseInfo(data []byte) (*Info, error) {
+	var doc struct {
+		Tables []*Table `json:"tables"`
+		Learns []*Learn `json:"learn_filters"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse bfrt info: %w", err)
+	}
+	info := &Info{tables: make(map[string]*Table, len(doc.Tables)), learns: make(map[uint32]*Learn, len(doc.Learns))}
+	for _, t := range doc.Tables {
+		info.tables[t.Name] = t
+	}
+	for _, l := range doc.Learns {
+		info.learns[l.ID] = l
+	}
+	return info, nil
+}
+
+// Table looks a table up by its full name, e.g. pipe.SwitchIngress.forward.
+func (i *Info) Table(name string) (*Table, error) {
+	t, ok := i.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s not in bfrt info", name)
+	}
+	return t, nil
+}
+
+func (t *Table) key(name string) (Field, error) {
+	for _, k := range t.Keys {
+		if k.Name == name {
+			return k, nil
+		}
+	}
+	return Field{}, fmt.Errorf("%s: no key field %s", t.Name, name)
+}
+
+func (t *Table) action(name string) (Action, error) {
+	for _, a := range t.Actions {
+		if a.Name == name {
+			return a, nil
+		}
+	}
+	return Action{}, fmt.Errorf("%s: no action %s", t.Name, name)
+}
+
+func (t *Table) actionByID(id uint32) (Action, bool) {
+	for _, a := range t.Actions {
+		if a.ID == id {
+			return a, true
+		}
+	}
+	return Action{}, false
+}
+
+func (a Action) param(name string) (Field, error) {
+	for _, p := range a.Params {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Field{}, fmt.Errorf("action %s: no parameter %s", a.Name, name)
+}
+
+// encode packs v big-endian into the field's width, as BF Runtime expects.
+func (f Field) encode(v uint64) ([]byte, error) {
+	if f.Type.Width < 64 && v >= 1<<f.Type.Width {
+		return nil, fmt.Errorf("%s: %d does not fit in %d bits", f.Name, v, f.Type.Width)
+	}
+	b := make([]byte, (f.Type.Width+7)/8)
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b, nil
+}
+
+func decodeUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
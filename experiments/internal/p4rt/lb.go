@@ -0,0 +1,177 @@
+package p4rt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Tables and actions of load_balancer/t2na_load_balancer.p4 that decide
+// which switch port carries traffic for a server IP.
+const (
+	ForwardTable    = "pipe.SwitchIngress.forward"
+	ArpForwardTable = "pipe.SwitchIngress.arp_forward"
+
+	actionSetPort        = "SwitchIngress.set_egress_port"
+	actionSetPortWithMAC = "SwitchIngress.set_egress_port_with_mac"
+)
+
+// ForwardEntry is one forward or arp_forward entry as read back from the
+// switch. MAC is nil when the action does not rewrite the destination.
+type ForwardEntry struct {
+	Table  string
+	IP     netip.Addr
+	Action string
+	Port   uint16
+	MAC    net.HardwareAddr
+}
+
+func (e ForwardEntry) String() string {
+	s := fmt.Sprintf("%s %s -> port %d (%s", e.Table, e.IP, e.Port, e.Action)
+	if e.MAC != nil {
+		s += ", dst_mac " + e.MAC.String()
+	}
+	return s + ")"
+}
+
+// Retarget points the forward and arp_forward entries of ip at port in a
+// single dataplane-atomic write, so no packet sees the new forward entry
+// with the old ARP one or the other way round. With mac set the forward
+// entry also rewrites the Ethernet destination. Both entries must exist,
+// as for the controller's /updateForward.
+func (c *Client) Retarget(ctx context.Context, ip netip.Addr, port uint16, mac net.HardwareAddr) error {
+	fwd, err := c.forwardEntry(ForwardTable, "hdr.ipv4.dst_addr", ip, port, mac)
+	if err != nil {
+		return err
+	}
+	arp, err := c.forwardEntry(ArpForwardTable, "hdr.arp.target_proto_addr", ip, port, nil)
+	if err != nil {
+		return err
+	}
+	return c.write(ctx, update(updateModify, fwd), update(updateModify, arp))
+}
+
+// ReadForward returns the forward and arp_forward entries of ip. A table
+// without an entry for ip is left out.
+func (c *Client) ReadForward(ctx context.Context, ip netip.Addr) ([]ForwardEntry, error) {
+	var out []ForwardEntry
+	for _, tk := range [][2]string{{ForwardTable, "hdr.ipv4.dst_addr"}, {ArpForwardTable, "hdr.arp.target_proto_addr"}} {
+		t, err := c.Info.Table(tk[0])
+		if err != nil {
+			return nil, err
+		}
+		key, err := exactKey(t, tk[1], ip)
+		if err != nil {
+			return nil, err
+		}
+		te := new(message).uint(1, uint64(t.ID)).msg(2, key)
+		entries, err := c.read(ctx, new(message).msg(1, te))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", t.Name, err)
+		}
+		for _, raw := range entries {
+			e, err := parseForwardEntry(t, ip, raw)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// forwardEntry builds a TableEntry for a table keyed on one IPv4 address
+// whose actions are set_egress_port / set_egress_port_with_mac.
+func (c *Client) forwardEntry(table, keyName string, ip netip.Addr, port uint16, mac net.HardwareAddr) (*message, error) {
+	t, err := c.Info.Table(table)
+	if err != nil {
+		return nil, err
+	}
+	key, err := exactKey(t, keyName, ip)
+	if err != nil {
+		return nil, err
+	}
+	actionName := actionSetPort
+	if mac != nil {
+		actionName = actionSetPortWithMAC
+	}
+	a, err := t.action(actionName)
+	if err != nil {
+		return nil, err
+	}
+	data := new(message).uint(1, uint64(a.ID))
+	portField, err := a.param("port")
+	if err != nil {
+		return nil, err
+	}
+	pb, err := portField.encode(uint64(port))
+	if err != nil {
+		return nil, err
+	}
+	data.msg(2, new(message).uint(1, uint64(portField.ID)).bytes(2, pb))
+	if mac != nil {
+		macField, err := a.param("dst_mac")
+		if err != nil {
+			return nil, err
+		}
+		if len(mac) != 6 {
+			return nil, fmt.Errorf("dst_mac %s is not a 48-bit MAC", mac)
+		}
+		data.msg(2, new(message).uint(1, uint64(macField.ID)).bytes(2, mac))
+	}
+	return new(message).uint(1, uint64(t.ID)).msg(2, key).msg(3, data), nil
+}
+
+func exactKey(t *Table, name string, ip netip.Addr) (*message, error) {
+	k, err := t.key(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ip.Is4() {
+		return nil, fmt.Errorf("%s: %s is not an IPv4 address", t.Name, ip)
+	}
+	v := ip.As4()
+	exact := new(message).bytes(1, v[:])
+	return new(message).msg(1, new(message).uint(1, uint64(k.ID)).msg(2, exact)), nil
+}
+
+func update(typ uint64, tableEntry *message) *message {
+	return new(message).uint(1, typ).msg(2, new(message).msg(1, tableEntry))
+}
+
+func parseForwardEntry(t *Table, ip netip.Addr, raw []byte) (ForwardEntry, error) {
+	e := ForwardEntry{Table: t.Name, IP: ip}
+	te, err := decode(raw)
+	if err != nil {
+		return e, err
+	}
+	data, err := decode(te.first(3))
+	if err != nil {
+		return e, err
+	}
+	a, ok := t.actionByID(uint32(data.varints[1]))
+	if !ok {
+		return e, fmt.Errorf("%s: unknown action id %d", t.Name, data.varints[1])
+	}
+	e.Action = a.Name
+	for _, raw := range data.bytes[2] {
+		df, err := decode(raw)
+		if err != nil {
+			return e, err
+		}
+		id, val := uint32(df.varints[1]), df.first(2)
+		for _, p := range a.Params {
+			if p.ID != id {
+				continue
+			}
+			switch p.Name {
+			case "port":
+				e.Port = uint16(decodeUint(val))
+			case "dst_mac":
+				e.MAC = net.HardwareAddr(val)
+			}
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,113 @@
+package p4rt
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// There is no Go module for bfruntime.proto, so the handful of messages
+// this package needs are encoded by hand with protowire. Field numbers
+// follow bfruntime.proto from the SDE.
+
+// rawCodec passes pre-encoded protobuf bytes through gRPC unchanged.
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "proto" }
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(*message)
+	if !ok {
+		return nil, fmt.Errorf("p4rt: cannot marshal %T", v)
+	}
+	return m.b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(*message)
+	if !ok {
+		return fmt.Errorf("p4rt: cannot unmarshal into %T", v)
+	}
+	m.b = append(m.b[:0], data...)
+	return nil
+}
+
+// message is one encoded protobuf message.
+type message struct{ b []byte }
+
+func (m *message) uint(num protowire.Number, v uint64) *message {
+	if v != 0 {
+		m.b = protowire.AppendTag(m.b, num, protowire.VarintType)
+		m.b = protowire.AppendVarint(m.b, v)
+	}
+	return m
+}
+
+func (m *message) bool(num protowire.Number, v bool) *message {
+	return m.uint(num, protowire.EncodeBool(v))
+}
+
+func (m *message) bytes(num protowire.Number, v []byte) *message {
+	m.b = protowire.AppendTag(m.b, num, protowire.BytesType)
+	m.b = protowire.AppendBytes(m.b, v)
+	return m
+}
+
+func (m *message) string(num protowire.Number, v string) *message {
+	if v != "" {
+		m.bytes(num, []byte(v))
+	}
+	return m
+}
+
+func (m *message) msg(num protowire.Number, sub *message) *message {
+	return m.bytes(num, sub.b)
+}
+
+// fields decodes one level of a message into its varint and
+// length-delimited fields, keyed by field number in wire order.
+type fields struct {
+	varints map[protowire.Number]uint64
+	bytes   map[protowire.Number][][]byte
+}
+
+func decode(b []byte) (fields, error) {
+	f := fields{varints: map[protowire.Number]uint64{}, bytes: map[protowire.Number][][]byte{}}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return f, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return f, protowire.ParseError(n)
+			}
+			f.varints[num] = v
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return f, protowire.ParseError(n)
+			}
+			f.bytes[num] = append(f.bytes[num], v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return f, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return f, nil
+}
+
+func (f fields) first(num protowire.Number) []byte {
+	if v := f.bytes[num]; len(v) > 0 {
+		return v[0]
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package podman
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+)
+
+// Docker drives Docker's experimental checkpointing (dockerd with
+// "experimental": true). It differs from podman where the migration
+// notices:
+//
+//   - docker checkpoint create has no export, so the checkpoint directory
+//     is tarred into CheckpointOptions.Export, together with the network
+//     and image the container is recreated from on the target. Arguments
+//     given to docker run beyond those are not carried over.
+//   - There are no pre-checkpoints; those commands fail.
+//   - TCP connections are kept through tcp-established in
+//     /etc/criu/runc.conf, which runc reads, rather than a flag.
+type Docker struct{}
+
+// dockerCheckpoint is the checkpoint name inside the archive.
+const dockerCheckpoint = "migration"
+
+// dockerCreateArgs is what docker create needs to recreate the container
+// on the target: its network, static IP and image.
+const dockerCreateArgs = `--network {{.HostConfig.NetworkMode}}{{range .NetworkSettings.Networks}} --ip {{.IPAddress}}{{end}} {{.Config.Image}}`
+
+const criuTCPEstablished = "sudo mkdir -p /etc/criu && (grep -qx tcp-established /etc/criu/runc.conf 2>/dev/null || echo tcp-established | sudo tee -a /etc/criu/runc.conf >/dev/null)"
+
+func unsupported(what string) string {
+	return fmt.Sprintf("echo 'docker: %s not supported' >&2; false", what)
+}
+
+func (Docker) Name() string { return "docker" }
+
+// CheckpointCmd stops and checkpoints name into o.Export. The container
+// is inspected first: once stopped it has no address.
+func (Docker) CheckpointCmd(name string, o CheckpointOptions) string {
+	if o.PreCheckpoint || o.WithPrevious {
+		return unsupported("pre-checkpoints")
+	}
+	d := o.Export + ".d"
+	return fmt.Sprintf("%s && sudo rm -rf %[2]s && sudo mkdir -p %[2]s"+
+		" && sudo docker inspect --format '%[3]s' %[4]s | sudo tee %[2]s/create.args >/dev/null"+
+		" && sudo docker checkpoint create --checkpoint-dir %[2]s %[4]s %[5]s"+
+		" && sudo tar -C %[2]s -cf %[6]s . && sudo rm -rf %[2]s && sudo chmod a+r %[6]s",
+		criuTCPEstablished, d, dockerCreateArgs, name, dockerCheckpoint, o.Export)
+}
+
+// RestoreCmd recreates o.Name from the archive and starts it from the
+// checkpoint.
+func (Docker) RestoreCmd(o RestoreOptions) string {
+	if o.ImportPrevious != "" {
+		return unsupported("pre-checkpoints")
+	}
+	if o.Name == "" {
+		return unsupported("restore without a container name")
+	}
+	d := o.Import + ".d"
+	return fmt.Sprintf("%s && sudo rm -rf %[2]s && sudo mkdir -p %[2]s && sudo tar -C %[2]s -xf %[3]s"+
+		" && sudo docker create --name %[4]s $(sudo cat %[2]s/create.args) >/dev/null"+
+		" && sudo docker start --checkpoint-dir %[2]s --checkpoint %[5]s %[4]s",
+		criuTCPEstablished, d, o.Import, o.Name, dockerCheckpoint)
+}
+
+func (Docker) RemoveCmd(names ...string) string {
+	return "sudo docker rm -f " + strings.Join(names, " ")
+}
+
+func (Docker) KillCmd(name, signal string) string {
+	return fmt.Sprintf("sudo docker kill --signal %s %s", signal, name)
+}
+
+func (Docker) PauseCmd(name string) string   { return "sudo docker pause " + name }
+func (Docker) UnpauseCmd(name string) string { return "sudo docker unpause " + name }
+func (Docker) StartCmd(name string) string   { return "sudo docker start " + name }
+
+func (Docker) RenameCmd(from, to string) string {
+	return fmt.Sprintf("sudo docker rename %s %s", from, to)
+}
+
+func (Docker) EventsCmd(name string) string {
+	return "sudo docker events --format '{{json .}}' --filter type=container --filter container=" + name
+}
+
+// dockerEvent is one line of `docker events --format '{{json .}}'`.
+type dockerEvent struct {
+	Type   string
+	Action string
+	Actor  struct {
+		Attributes map[string]string
+	}
+}
+
+// dockerStatus maps Docker's actions to podman's statuses where they
+// differ, so Event.Status means the same for both.
+var dockerStatus = map[string]string{"die": "died", "destroy": "remove"}
+
+// ParseEvent parses one line of EventsCmd's output.
+func (Docker) ParseEvent(line []byte) (Event, error) {
+	var d dockerEvent
+	if err := json.Unmarshal(line, &d); err != nil {
+		return Event{}, fmt.Errorf("docker event: %w", err)
+	}
+	e := Event{Name: d.Actor.Attributes["name"], Status: d.Action, Type: d.Type}
+	if s, ok := dockerStatus[e.Status]; ok {
+		e.Status = s
+	}
+	return e, nil
+}
+
+func (Docker) PID(ctx context.Context, r Runner, name string) (string, error) {
+	return pid(ctx, r, "docker", name)
+}
+
+// Inspect inspects the container. Docker's output has the same fields
+// podman's does, with a leading slash on the name.
+func (Docker) Inspect(ctx context.Context, r Runner, name string) (Container, error) {
+	out, err := r.Run(ctx, "sudo docker inspect "+name+" 2>/dev/null || echo '[]'")
+	if err != nil {
+		return Container{}, err
+	}
+	cs, err := ParseInspect([]byte(out))
+	if err != nil || len(cs) == 0 {
+		return Container{Name: name}, err
+	}
+	cs[0].Name = strings.TrimPrefix(cs[0].Name, "/")
+	return cs[0], nil
+}
+
+func (Docker) Stats(ctx context.Context, r Runner, name string) (metricsmodel.ContainerStats, error) {
+	out, err := r.Run(ctx, "sudo docker stats --no-stream --format '{{json .}}' "+name)
+	if err != nil {
+		return metricsmodel.ContainerStats{}, err
+	}
+	stats, err := ParseDockerStats([]byte(out))
+	if err != nil {
+		return metricsmodel.ContainerStats{}, err
+	}
+	if len(stats) == 0 {
+		return metricsmodel.ContainerStats{}, fmt.Errorf("no stats for %s", name)
+	}
+	return stats[0], nil
+}
+
+// dockerStatsLine is one line of `docker stats --format '{{json .}}'`,
+// the same strings as podman's under other keys.
+type dockerStatsLine struct {
+	ID       string
+	Name     string
+	CPUPerc  string
+	MemUsage string
+	MemPerc  string
+	NetIO    string
+	BlockIO  string
+	PIDs     string
+}
+
+// ParseDockerStats parses the output of `docker stats --no-stream
+// --format '{{json .}}'`, one object per line.
+func ParseDockerStats(data []byte) ([]metricsmodel.ContainerStats, error) {
+	var out []metricsmodel.ContainerStats
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var d dockerStatsLine
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+			return nil, fmt.Errorf("docker stats: %w", err)
+		}
+		s, err := statsLine{
+			ID: d.ID, Name: d.Name, CPUPercent: d.CPUPerc, MemUsage: d.MemUsage, MemPercent: d.MemPerc,
+			NetIO: d.NetIO, BlockIO: d.BlockIO, PIDs: json.RawMessage(strconv.Quote(d.PIDs)),
+		}.stats()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, sc.Err()
+}
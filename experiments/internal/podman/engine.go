@@ -0,0 +1,70 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+)
+
+// Engine is the container CLI on the nodes. Podman is the default;
+// Docker covers testbeds that only have Docker with experimental
+// checkpointing.
+type Engine interface {
+	Name() string
+	CheckpointCmd(name string, o CheckpointOptions) string
+	RestoreCmd(o RestoreOptions) string
+	RemoveCmd(names ...string) string
+	KillCmd(name, signal string) string
+	PauseCmd(name string) string
+	UnpauseCmd(name string) string
+	StartCmd(name string) string
+	RenameCmd(from, to string) string
+	EventsCmd(name string) string
+	ParseEvent(line []byte) (Event, error)
+	PID(ctx context.Context, r Runner, name string) (string, error)
+	Inspect(ctx context.Context, r Runner, name string) (Container, error)
+	Stats(ctx context.Context, r Runner, name string) (metricsmodel.ContainerStats, error)
+}
+
+// Engines names the engines for -engine flags.
+const Engines = "podman or docker"
+
+// NewEngine returns the engine called name.
+func NewEngine(name string) (Engine, error) {
+	switch name {
+	case "podman":
+		return Podman{}, nil
+	case "docker":
+		return Docker{}, nil
+	}
+	return nil, fmt.Errorf("unknown container engine %q (want %s)", name, Engines)
+}
+
+// Podman is the Engine of this package's functions.
+type Podman struct{}
+
+func (Podman) Name() string { return "podman" }
+
+func (Podman) CheckpointCmd(name string, o CheckpointOptions) string { return CheckpointCmd(name, o) }
+func (Podman) RestoreCmd(o RestoreOptions) string                    { return RestoreCmd(o) }
+func (Podman) RemoveCmd(names ...string) string                      { return RemoveCmd(names...) }
+func (Podman) KillCmd(name, signal string) string                    { return KillCmd(name, signal) }
+func (Podman) PauseCmd(name string) string                           { return PauseCmd(name) }
+func (Podman) UnpauseCmd(name string) string                         { return UnpauseCmd(name) }
+func (Podman) StartCmd(name string) string                           { return StartCmd(name) }
+func (Podman) RenameCmd(from, to string) string                      { return RenameCmd(from, to) }
+func (Podman) EventsCmd(name string) string                          { return EventsCmd(name) }
+func (Podman) ParseEvent(line []byte) (Event, error)                 { return ParseEvent(line) }
+
+func (Podman) PID(ctx context.Context, r Runner, name string) (string, error) {
+	return PID(ctx, r, name)
+}
+
+func (Podman) Inspect(ctx context.Context, r Runner, name string) (Container, error) {
+	return Inspect(ctx, r, name)
+}
+
+func (Podman) Stats(ctx context.Context, r Runner, name string) (metricsmodel.ContainerStats, error) {
+	return Stats(ctx, r, name)
+}
@@ -0,0 +1,274 @@
+// Package podman builds the podman commands a migration runs on the lab
+// nodes and parses what podman prints back; Docker implements the same
+// Engine for testbeds without podman. Everything runs through sudo
+// in a shell on some node, local or over SSH, so the commands are
+// scripts handed to a Runner rather than exec calls.
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stano45/p4containerflow-tofino2/experiments/internal/metricsmodel"
+)
+
+// Runner runs a shell script on a node and returns its trimmed stdout;
+// sshmux.Host is one.
+type Runner interface {
+	Run(ctx context.Context, script string) (string, error)
+}
+
+// CheckpointOptions are the flags of `podman container checkpoint` the
+// migration modes differ in.
+type CheckpointOptions struct {
+	Export        string // archive to write
+	PreCheckpoint bool   // memory-only pre-dump, the container keeps running
+	WithPrevious  bool   // only dump what changed since the last pre-dump
+}
+
+// CheckpointCmd checkpoints name into o.Export, keeping established TCP
+// connections, and makes the archive readable for the transfer.
+func CheckpointCmd(name string, o CheckpointOptions) string {
+	var flags string
+	if o.PreCheckpoint {
+		flags += "--pre-checkpoint "
+	}
+	if o.WithPrevious {
+		flags += "--with-previous "
+	}
+	return fmt.Sprintf("sudo podman container checkpoint %s--export %s --compress none --keep --tcp-established %s && sudo chmod a+r %[2]s",
+		flags, o.Export, name)
+}
+
+// RestoreOptions are the flags of `podman container restore`.
+type RestoreOptions struct {
+	Import         string // checkpoint archive
+	ImportPrevious string // pre-checkpoint archive, if any
+	Name           string // container to restore; podman reads it from the archive, Docker needs it
+}
+
+// RestoreCmd restores from o.Import with the source's IP and connections.
+// The static MAC is not kept: the macvlan is recreated on the target.
+func RestoreCmd(o RestoreOptions) string {
+	var flags string
+	if o.ImportPrevious != "" {
+		flags = "--import-previous " + o.ImportPrevious + " "
+	}
+	return fmt.Sprintf("sudo podman container restore %s--import %s --keep --tcp-established --ignore-static-mac", flags, o.Import)
+}
+
+// RemoveCmd force-removes the containers.
+func RemoveCmd(names ...string) string {
+	return "sudo podman rm -f " + strings.Join(names, " ")
+}
+
+// KillCmd sends signal (e.g. SIGUSR2) to the container's main process.
+func KillCmd(name, signal string) string {
+	return fmt.Sprintf("sudo podman kill --signal %s %s", signal, name)
+}
+
+// PauseCmd freezes the container's processes; UnpauseCmd thaws them.
+func PauseCmd(name string) string {
+	return "sudo podman pause " + name
+}
+
+func UnpauseCmd(name string) string {
+	return "sudo podman unpause " + name
+}
+
+// StartCmd starts a stopped or killed container again.
+func StartCmd(name string) string {
+	return "sudo podman start " + name
+}
+
+// RenameCmd renames a container.
+func RenameCmd(from, to string) string {
+	return fmt.Sprintf("sudo podman rename %s %s", from, to)
+}
+
+// PID returns the host PID of the container's main process, and an error
+// if it is not running.
+func PID(ctx context.Context, r Runner, name string) (string, error) {
+	return pid(ctx, r, "podman", name)
+}
+
+func pid(ctx context.Context, r Runner, cli, name string) (string, error) {
+	pid, err := r.Run(ctx, "sudo "+cli+" inspect --format '{{.State.Pid}}' "+name)
+	if err != nil {
+		return "", err
+	}
+	if pid == "" || pid == "0" {
+		return "", fmt.Errorf("container %s has no PID", name)
+	}
+	return pid, nil
+}
+
+// Container is what `podman inspect` says about where a container runs.
+// A container that does not exist is not Running and has no address.
+type Container struct {
+	Name      string
+	Running   bool
+	StartedAt time.Time
+	IP        string
+	MAC       string
+}
+
+// Inspect inspects the container.
+func Inspect(ctx context.Context, r Runner, name string) (Container, error) {
+	out, err := r.Run(ctx, "sudo podman inspect --format json "+name+" 2>/dev/null || echo '[]'")
+	if err != nil {
+		return Container{}, err
+	}
+	cs, err := ParseInspect([]byte(out))
+	if err != nil || len(cs) == 0 {
+		return Container{Name: name}, err
+	}
+	return cs[0], nil
+}
+
+// inspectLine is the part of `podman inspect --format json` Inspect
+// reads. A macvlan container has its address under Networks only.
+type inspectLine struct {
+	Name  string
+	State struct {
+		Running   bool
+		StartedAt time.Time
+	}
+	NetworkSettings struct {
+		IPAddress  string
+		MacAddress string
+		Networks   map[string]struct {
+			IPAddress  string
+			MacAddress string
+		}
+	}
+}
+
+// ParseInspect parses the output of `podman inspect --format json`.
+func ParseInspect(data []byte) ([]Container, error) {
+	var lines []inspectLine
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return nil, fmt.Errorf("podman inspect: %w", err)
+	}
+	out := make([]Container, 0, len(lines))
+	for _, l := range lines {
+		c := Container{Name: l.Name, Running: l.State.Running, StartedAt: l.State.StartedAt,
+			IP: l.NetworkSettings.IPAddress, MAC: l.NetworkSettings.MacAddress}
+		// With several networks the first by name wins, so repeated
+		// inspects agree.
+		nets := make([]string, 0, len(l.NetworkSettings.Networks))
+		for n := range l.NetworkSettings.Networks {
+			nets = append(nets, n)
+		}
+		sort.Strings(nets)
+		for _, n := range nets {
+			if c.IP != "" {
+				break
+			}
+			c.IP, c.MAC = l.NetworkSettings.Networks[n].IPAddress, l.NetworkSettings.Networks[n].MacAddress
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// EventsCmd streams the container's lifecycle events, one JSON object per
+// line, until it is killed.
+func EventsCmd(name string) string {
+	return "sudo podman events --format json --filter type=container --filter container=" + name
+}
+
+// Event is one line of `podman events --format json`. Status is start,
+// restore, checkpoint, died, stop, remove, pause, unpause and so on.
+type Event struct {
+	Name   string `json:"Name"`
+	Status string `json:"Status"`
+	Type   string `json:"Type"`
+}
+
+// ParseEvent parses one line of EventsCmd's output.
+func ParseEvent(line []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, fmt.Errorf("podman event: %w", err)
+	}
+	return e, nil
+}
+
+// Stats samples `podman stats` once for the container.
+func Stats(ctx context.Context, r Runner, name string) (metricsmodel.ContainerStats, error) {
+	out, err := r.Run(ctx, "sudo podman stats --no-stream --format json "+name)
+	if err != nil {
+		return metricsmodel.ContainerStats{}, err
+	}
+	stats, err := ParseStats([]byte(out))
+	if err != nil {
+		return metricsmodel.ContainerStats{}, err
+	}
+	if len(stats) == 0 {
+		return metricsmodel.ContainerStats{}, fmt.Errorf("no stats for %s", name)
+	}
+	return stats[0], nil
+}
+
+// statsLine is one element of `podman stats --format json`, where every
+// value is a human-readable string.
+type statsLine struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CPUPercent string `json:"cpu_percent"`
+	MemUsage   string `json:"mem_usage"`
+	MemPercent string `json:"mem_percent"`
+	NetIO      string `json:"net_io"`
+	BlockIO    string `json:"block_io"`
+	// A string in most podman versions, a number in some.
+	PIDs json.RawMessage `json:"pids"`
+}
+
+// ParseStats parses the output of `podman stats --no-stream --format json`.
+func ParseStats(data []byte) ([]metricsmodel.ContainerStats, error) {
+	var lines []statsLine
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return nil, fmt.Errorf("podman stats: %w", err)
+	}
+	out := make([]metricsmodel.ContainerStats, 0, len(lines))
+	for _, l := range lines {
+		s, err := l.stats()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (l statsLine) stats() (metricsmodel.ContainerStats, error) {
+	s := metricsmodel.ContainerStats{ID: l.ID, Name: l.Name}
+	var err error
+	if s.CPUPercent, err = metricsmodel.ParsePercent(l.CPUPercent); err != nil {
+		return s, fmt.Errorf("%s cpu_percent: %w", l.Name, err)
+	}
+	if s.MemPercent, err = metricsmodel.ParsePercent(l.MemPercent); err != nil {
+		return s, fmt.Errorf("%s mem_percent: %w", l.Name, err)
+	}
+	if s.MemBytes, s.MemLimit, err = metricsmodel.ParsePair(l.MemUsage); err != nil {
+		return s, fmt.Errorf("%s mem_usage: %w", l.Name, err)
+	}
+	if s.NetInput, s.NetOutput, err = metricsmodel.ParsePair(l.NetIO); err != nil {
+		return s, fmt.Errorf("%s net_io: %w", l.Name, err)
+	}
+	if s.BlockRead, s.BlockWrite, err = metricsmodel.ParsePair(l.BlockIO); err != nil {
+		return s, fmt.Errorf("%s block_io: %w", l.Name, err)
+	}
+	if pids := strings.Trim(string(l.PIDs), `"`); pids != "" && pids != "--" {
+		if s.PIDs, err = strconv.Atoi(pids); err != nil {
+			return s, fmt.Errorf("%s pids: %w", l.Name, err)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,239 @@
+package podman
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeRunner answers every script with out and err and records the last
+// script.
+type fakeRunner struct {
+	out    string
+	err    error
+	script string
+}
+
+func (f *fakeRunner) Run(_ context.Context, script string) (string, error) {
+	f.script = script
+	return f.out, f.err
+}
+
+func TestCheckpointCmd(t *testing.T) {
+	for _, tc := range []struct {
+		o    CheckpointOptions
+		want string
+	}{
+		{CheckpointOptions{Export: "/tmp/c/checkpoint.tar"},
+			"sudo podman container checkpoint --export /tmp/c/checkpoint.tar --compress none --keep --tcp-established srv && sudo chmod a+r /tmp/c/checkpoint.tar"},
+		{CheckpointOptions{Export: "/tmp/c/pre.tar", PreCheckpoint: true},
+			"sudo podman container checkpoint --pre-checkpoint --export /tmp/c/pre.tar --compress none --keep --tcp-established srv && sudo chmod a+r /tmp/c/pre.tar"},
+		{CheckpointOptions{Export: "/tmp/c/checkpoint.tar", WithPrevious: true},
+			"sudo podman container checkpoint --with-previous --export /tmp/c/checkpoint.tar --compress none --keep --tcp-established srv && sudo chmod a+r /tmp/c/checkpoint.tar"},
+	} {
+		if got := CheckpointCmd("srv", tc.o); got != tc.want {
+			t.Errorf("CheckpointCmd(%+v)\n got %s\nwant %s", tc.o, got, tc.want)
+		}
+	}
+}
+
+func TestRestoreCmd(t *testing.T) {
+	got := RestoreCmd(RestoreOptions{Import: "/tmp/c/checkpoint.tar"})
+	want := "sudo podman container restore --import /tmp/c/checkpoint.tar --keep --tcp-established --ignore-static-mac"
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+	got = RestoreCmd(RestoreOptions{Import: "/tmp/c/checkpoint.tar", ImportPrevious: "/tmp/c/pre.tar"})
+	want = "sudo podman container restore --import-previous /tmp/c/pre.tar --import /tmp/c/checkpoint.tar --keep --tcp-established --ignore-static-mac"
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestPID(t *testing.T) {
+	ctx := context.Background()
+	r := &fakeRunner{out: "4242"}
+	pid, err := PID(ctx, r, "srv")
+	if err != nil || pid != "4242" {
+		t.Errorf("PID = %q, %v", pid, err)
+	}
+	if r.script != "sudo podman inspect --format '{{.State.Pid}}' srv" {
+		t.Errorf("script %q", r.script)
+	}
+	for _, out := range []string{"0", ""} {
+		if _, err := PID(ctx, &fakeRunner{out: out}, "srv"); err == nil {
+			t.Errorf("PID with output %q: no error", out)
+		}
+	}
+	if _, err := PID(ctx, &fakeRunner{err: errors.New("no such container")}, "srv"); err == nil {
+		t.Error("PID with failing runner: no error")
+	}
+}
+
+// Output of podman 4.9 `podman stats --no-stream --format json`.
+const statsJSON = `[
+ {
+  "id": "3f2a9c1e5b7d",
+  "name": "stream-server",
+  "cpu_time": "1.52s",
+  "cpu_percent": "2.41%",
+  "avg_cpu": "2.30%",
+  "mem_usage": "18.35MB / 67.15GB",
+  "mem_percent": "0.03%",
+  "net_io": "1.024MB / 98.3MB",
+  "block_io": "0B / 4.096kB",
+  "pids": "7"
+ },
+ {
+  "id": "77aa01bc9e10",
+  "name": "stopped",
+  "cpu_percent": "--",
+  "mem_usage": "--",
+  "mem_percent": "--",
+  "net_io": "--",
+  "block_io": "--",
+  "pids": 0
+ }
+]`
+
+func TestParseStats(t *testing.T) {
+	stats, err := ParseStats([]byte(statsJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d containers", len(stats))
+	}
+	s := stats[0]
+	if s.Name != "stream-server" || s.CPUPercent != 2.41 || s.MemPercent != 0.03 || s.PIDs != 7 {
+		t.Errorf("stream-server: %+v", s)
+	}
+	if s.MemBytes != 18_350_000 || s.MemLimit != 67_150_000_000 {
+		t.Errorf("mem: %d / %d", s.MemBytes, s.MemLimit)
+	}
+	if s.NetInput != 1_024_000 || s.NetOutput != 98_300_000 || s.BlockRead != 0 || s.BlockWrite != 4096 {
+		t.Errorf("io: %+v", s)
+	}
+	if st := stats[1]; st.MemBytes != 0 || st.CPUPercent != 0 || st.PIDs != 0 {
+		t.Errorf("stopped: %+v", st)
+	}
+	if _, err := ParseStats([]byte(`[{"name":"x","mem_usage":"12QB / 1GB"}]`)); err == nil {
+		t.Error("bad unit: no error")
+	}
+}
+
+func TestStats(t *testing.T) {
+	r := &fakeRunner{out: statsJSON}
+	s, err := Stats(context.Background(), r, "stream-server")
+	if err != nil || s.Name != "stream-server" {
+		t.Errorf("Stats = %+v, %v", s, err)
+	}
+	if r.script != "sudo podman stats --no-stream --format json stream-server" {
+		t.Errorf("script %q", r.script)
+	}
+	if _, err := Stats(context.Background(), &fakeRunner{out: "[]"}, "gone"); err == nil {
+		t.Error("empty stats: no error")
+	}
+}
+
+// Trimmed output of podman 4.9 `podman inspect --format json` for a
+// container on a macvlan network.
+const inspectJSON = `[
+ {
+  "Id": "3f2a9c1e5b7d",
+  "Name": "stream-server",
+  "State": {"Status": "running", "Running": true, "Pid": 4242, "StartedAt": "2026-01-01T12:00:00.5+01:00"},
+  "NetworkSettings": {
+   "IPAddress": "",
+   "MacAddress": "",
+   "Networks": {
+    "switch-net": {"IPAddress": "192.168.12.2", "MacAddress": "02:42:c0:a8:0c:02"}
+   }
+  }
+ }
+]`
+
+func TestInspect(t *testing.T) {
+	r := &fakeRunner{out: inspectJSON}
+	c, err := Inspect(context.Background(), r, "stream-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Running || c.IP != "192.168.12.2" || c.MAC != "02:42:c0:a8:0c:02" || c.StartedAt.UnixMilli() != 1767265200500 {
+		t.Errorf("got %+v", c)
+	}
+	c, err = Inspect(context.Background(), &fakeRunner{out: "[]"}, "gone")
+	if err != nil || c.Running || c.Name != "gone" {
+		t.Errorf("missing container: %+v, %v", c, err)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	e, err := ParseEvent([]byte(`{"ID":"3f2a9c1e5b7d","Image":"localhost/stream-server:latest","Name":"stream-server","Status":"restore","Time":"2026-01-01T12:00:00.5+01:00","Type":"container","Attributes":{}}`))
+	if err != nil || e.Name != "stream-server" || e.Status != "restore" || e.Type != "container" {
+		t.Errorf("got %+v, %v", e, err)
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	for _, name := range []string{"podman", "docker"} {
+		e, err := NewEngine(name)
+		if err != nil || e.Name() != name {
+			t.Errorf("NewEngine(%q) = %v, %v", name, e, err)
+		}
+	}
+	if _, err := NewEngine("lxc"); err == nil {
+		t.Error("NewEngine(lxc): no error")
+	}
+}
+
+func TestDockerRestoreCmd(t *testing.T) {
+	got := Docker{}.RestoreCmd(RestoreOptions{Import: "/tmp/c/checkpoint.tar", Name: "srv"})
+	for _, want := range []string{
+		"sudo tar -C /tmp/c/checkpoint.tar.d -xf /tmp/c/checkpoint.tar",
+		"sudo docker create --name srv $(sudo cat /tmp/c/checkpoint.tar.d/create.args)",
+		"sudo docker start --checkpoint-dir /tmp/c/checkpoint.tar.d --checkpoint migration srv",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s\nlacks %s", got, want)
+		}
+	}
+	for _, o := range []RestoreOptions{
+		{Import: "/tmp/c/checkpoint.tar"},
+		{Import: "/tmp/c/checkpoint.tar", ImportPrevious: "/tmp/c/pre.tar", Name: "srv"},
+	} {
+		if got := (Docker{}).RestoreCmd(o); !strings.HasSuffix(got, "; false") {
+			t.Errorf("RestoreCmd(%+v) = %s, want a failing script", o, got)
+		}
+	}
+}
+
+func TestDockerParseEvent(t *testing.T) {
+	line := `{"status":"die","id":"3f2a9c1e5b7d","from":"stream-server:latest","Type":"container","Action":"die","Actor":{"ID":"3f2a9c1e5b7d","Attributes":{"exitCode":"137","name":"stream-server"}},"scope":"local","time":1760000000}`
+	e, err := Docker{}.ParseEvent([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Event{Name: "stream-server", Status: "died", Type: "container"}); e != want {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+}
+
+// Output of Docker 24 `docker stats --no-stream --format '{{json .}}'`.
+const dockerStatsJSON = `{"BlockIO":"8.19kB / 0B","CPUPerc":"2.41%","Container":"stream-server","ID":"3f2a9c1e5b7d","MemPerc":"0.61%","MemUsage":"48.2MiB / 7.6GiB","Name":"stream-server","NetIO":"1.2MB / 95.4MB","PIDs":"7"}
+`
+
+func TestParseDockerStats(t *testing.T) {
+	stats, err := ParseDockerStats([]byte(dockerStatsJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("%d entries", len(stats))
+	}
+	s := stats[0]
+	if s.Name != "stream-server" || s.CPUPercent != 2.41 || s.MemBytes != 50541363 || s.NetOutput != 95.4e6 || s.BlockRead != 8190 || s.PIDs != 7 {
+		t.Errorf("got %+v", s)
+	}
+}
@@ -0,0 +1,115 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type label struct{ name, value string }
+
+type point struct {
+	value float64
+	ms    int64
+}
+
+type timeSeries struct {
+	labels []label
+	points []point
+}
+
+// series groups a batch by label set, with the client's labels added and
+// the labels sorted by name as receivers expect. Points keep the order
+// they were pushed in, which is time order for a single caller.
+func (c *Client) series(batch []Sample) []timeSeries {
+	var out []timeSeries
+	index := map[string]int{}
+	for _, s := range batch {
+		labels := []label{{"__name__", s.Name}}
+		for k, v := range c.labels {
+			if _, ok := s.Labels[k]; !ok {
+				labels = append(labels, label{k, v})
+			}
+		}
+		for k, v := range s.Labels {
+			labels = append(labels, label{k, v})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		var key strings.Builder
+		for _, l := range labels {
+			key.WriteString(l.name + "\x00" + l.value + "\x00")
+		}
+		i, ok := index[key.String()]
+		if !ok {
+			i = len(out)
+			index[key.String()] = i
+			out = append(out, timeSeries{labels: labels})
+		}
+		out[i].points = append(out[i].points, point{s.Value, s.Time.UnixMilli()})
+	}
+	return out
+}
+
+// encodeWriteRequest is the snappy-compressed prometheus.WriteRequest
+// protobuf of the series:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []timeSeries) []byte {
+	var req []byte
+	for _, ts := range series {
+		var b []byte
+		for _, l := range ts.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			b = protowire.AppendTag(b, 1, protowire.BytesType)
+			b = protowire.AppendBytes(b, lb)
+		}
+		for _, p := range ts.points {
+			var pb []byte
+			pb = protowire.AppendTag(pb, 1, protowire.Fixed64Type)
+			pb = protowire.AppendFixed64(pb, math.Float64bits(p.value))
+			pb = protowire.AppendTag(pb, 2, protowire.VarintType)
+			pb = protowire.AppendVarint(pb, uint64(p.ms))
+			b = protowire.AppendTag(b, 2, protowire.BytesType)
+			b = protowire.AppendBytes(b, pb)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, b)
+	}
+	return snappyBlock(req)
+}
+
+// snappyBlock frames data in the snappy block format as one literal,
+// without compressing it. Any snappy decoder accepts that, and a second's
+// samples are small enough that the saving is not worth a dependency.
+func snappyBlock(data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(data)))
+	if len(data) == 0 {
+		return b
+	}
+	// A literal's tag holds its length minus one, in the tag itself below
+	// 60 and in the 1 to 4 little-endian bytes after it above.
+	n := uint32(len(data) - 1)
+	switch {
+	case n < 60:
+		b = append(b, byte(n)<<2)
+	case n < 1<<8:
+		b = append(b, 60<<2, byte(n))
+	case n < 1<<16:
+		b = append(b, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		b = append(b, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		b = append(b, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(b, data...)
+}
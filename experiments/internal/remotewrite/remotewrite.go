@@ -0,0 +1,181 @@
+// Package remotewrite pushes samples to a Prometheus remote-write
+// endpoint (Prometheus with --web.enable-remote-write-receiver, Mimir,
+// VictoriaMetrics, ...), so a testbed with its own monitoring stack sees
+// the measurements live without scraping the nodes.
+//
+// Samples are buffered and sent in the background. A failed request is
+// retried with backoff while new samples keep buffering; when the buffer
+// is full the oldest samples are dropped, so a dead endpoint costs memory
+// up to a bound and never stalls the caller.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// bufferSize bounds the samples waiting to be sent.
+	bufferSize = 100_000
+	// maxBatch is the most samples per request.
+	maxBatch   = 5000
+	minBackoff = 250 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// Sample is one value of the series Name{Labels}.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	Time   time.Time
+}
+
+// Client sends samples to one endpoint. A nil *Client is valid and
+// discards everything, like an eventbus.Client.
+type Client struct {
+	url    string
+	labels map[string]string
+	http   *http.Client
+
+	mu      sync.Mutex
+	buf     []Sample
+	dropped int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New starts a client for the remote-write URL. labels are added to every
+// series, e.g. the scenario and iteration of a run.
+func New(url string, labels map[string]string) *Client {
+	c := &Client{
+		url:    url,
+		labels: labels,
+		http:   &http.Client{Timeout: 5 * time.Second},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+// Push queues samples. It never blocks.
+func (c *Client) Push(samples ...Sample) {
+	if c == nil || len(samples) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.buf = append(c.buf, samples...)
+	if over := len(c.buf) - bufferSize; over > 0 {
+		c.buf = append(c.buf[:0], c.buf[over:]...)
+		c.dropped += over
+	}
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes up to maxBatch samples from the buffer.
+func (c *Client) take() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped > 0 {
+		log.Printf("remote write: buffer full, dropped the %d oldest samples", c.dropped)
+		c.dropped = 0
+	}
+	n := min(len(c.buf), maxBatch)
+	batch := make([]Sample, n)
+	copy(batch, c.buf)
+	c.buf = append(c.buf[:0], c.buf[n:]...)
+	return batch
+}
+
+// loop sends the buffer whenever Push wakes it. A batch that fails with a
+// retryable error is retried until it goes through or Close is called;
+// one the endpoint rejects (4xx other than 429) is dropped, as the spec
+// requires, since resending it cannot succeed.
+func (c *Client) loop() {
+	defer close(c.done)
+	failing := false
+	for {
+		stopping := false
+		select {
+		case <-c.wake:
+		case <-c.stop:
+			stopping = true
+		}
+		for batch := c.take(); len(batch) > 0; batch = c.take() {
+			body := encodeWriteRequest(c.series(batch))
+			backoff := minBackoff
+			for {
+				retry, err := c.send(body)
+				switch {
+				case err != nil && !failing:
+					log.Printf("remote write: %v", err)
+					failing = true
+				case err == nil && failing:
+					log.Printf("remote write: %s reachable again", c.url)
+					failing = false
+				}
+				if !retry || stopping {
+					break
+				}
+				select {
+				case <-time.After(backoff):
+				case <-c.stop:
+					stopping = true
+				}
+				backoff = min(2*backoff, maxBackoff)
+			}
+		}
+		if stopping {
+			return
+		}
+	}
+}
+
+// send posts one WriteRequest and reports whether it is worth retrying.
+func (c *Client) send(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s: %s", c.url, resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Close sends what is still buffered, waiting at most timeout; a failing
+// endpoint gets one more attempt.
+func (c *Client) Close(timeout time.Duration) {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+	}
+}
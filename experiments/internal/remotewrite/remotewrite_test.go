@@ -0,0 +1,202 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// unsnappy decodes a snappy block made of literals only, which is all
+// snappyBlock writes.
+func unsnappy(t *testing.T, b []byte) []byte {
+	t.Helper()
+	n, k := binary.Uvarint(b)
+	b = b[k:]
+	var out []byte
+	for len(b) > 0 {
+		tag := b[0]
+		if tag&3 != 0 {
+			t.Fatalf("not a literal: tag %#x", tag)
+		}
+		l := int(tag >> 2)
+		b = b[1:]
+		if l >= 60 {
+			w := l - 59
+			l = 0
+			for i := w - 1; i >= 0; i-- {
+				l = l<<8 | int(b[i])
+			}
+			b = b[w:]
+		}
+		out = append(out, b[:l+1]...)
+		b = b[l+1:]
+	}
+	if uint64(len(out)) != n {
+		t.Fatalf("decoded %d bytes, header says %d", len(out), n)
+	}
+	return out
+}
+
+// decode turns a WriteRequest back into series, failing on anything
+// encodeWriteRequest would not write.
+func decode(t *testing.T, b []byte) []timeSeries {
+	t.Helper()
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = fn(num, typ, b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	var out []timeSeries
+	fields(b, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		tsb, n := protowire.ConsumeBytes(b)
+		var ts timeSeries
+		fields(tsb, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			mb, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				var l label
+				fields(mb, func(num protowire.Number, _ protowire.Type, b []byte) int {
+					s, n := protowire.ConsumeString(b)
+					if num == 1 {
+						l.name = s
+					} else {
+						l.value = s
+					}
+					return n
+				})
+				ts.labels = append(ts.labels, l)
+			case 2:
+				var p point
+				fields(mb, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						v, n := protowire.ConsumeFixed64(b)
+						p.value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					p.ms = int64(v)
+					return n
+				})
+				ts.points = append(ts.points, p)
+			}
+			return n
+		})
+		out = append(out, ts)
+		return n
+	})
+	return out
+}
+
+func TestSnappyBlockLengths(t *testing.T) {
+	for _, n := range []int{0, 1, 60, 61, 300, 70_000} {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		if got := unsnappy(t, snappyBlock(data)); len(got) != n || (n > 0 && !reflect.DeepEqual(got, data)) {
+			t.Errorf("%d bytes did not round-trip", n)
+		}
+	}
+}
+
+func TestSeries(t *testing.T) {
+	c := &Client{labels: map[string]string{"scenario": "baseline", "node": "default"}}
+	t0 := time.UnixMilli(1_700_000_000_000)
+	got := decode(t, unsnappy(t, encodeWriteRequest(c.series([]Sample{
+		{Name: "p4cf_bytes_sent", Value: 1, Time: t0},
+		{Name: "p4cf_ping_rtt_ms", Labels: map[string]string{"node": "lakewood"}, Value: 0.25, Time: t0},
+		{Name: "p4cf_bytes_sent", Value: 2, Time: t0.Add(time.Second)},
+	}))))
+	want := []timeSeries{
+		{
+			labels: []label{{"__name__", "p4cf_bytes_sent"}, {"node", "default"}, {"scenario", "baseline"}},
+			points: []point{{1, t0.UnixMilli()}, {2, t0.UnixMilli() + 1000}},
+		},
+		{
+			labels: []label{{"__name__", "p4cf_ping_rtt_ms"}, {"node", "lakewood"}, {"scenario", "baseline"}},
+			points: []point{{0.25, t0.UnixMilli()}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("series:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+// A 5xx is retried until it goes through; a 4xx is dropped.
+func TestRetry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		statuses = []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest, http.StatusOK}
+		bodies   [][]byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("headers: %v", r.Header)
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == http.StatusOK {
+			bodies = append(bodies, b)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, nil)
+	now := time.Now()
+	c.Push(Sample{Name: "a", Value: 1, Time: now})
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return len(bodies) == 1 })
+	c.Push(Sample{Name: "b", Value: 2, Time: now}) // rejected with 400
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return len(statuses) == 1 })
+	c.Push(Sample{Name: "c", Value: 3, Time: now})
+	c.Close(5 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for _, b := range bodies {
+		for _, ts := range decode(t, unsnappy(t, b)) {
+			names = append(names, ts.labels[0].value)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"a", "c"}) {
+		t.Errorf("delivered %v, want [a c]", names)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNilClient(t *testing.T) {
+	var c *Client
+	c.Push(Sample{Name: "a"})
+	c.Close(time.Second)
+}
@@ -0,0 +1,88 @@
+// Package sshmux runs shell scripts on the lab nodes over SSH, sharing one
+// ControlMaster connection per node so that each step of a migration costs
+// a round trip instead of a handshake.
+package sshmux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mux owns a private control path directory. Each orchestrator run gets
+// its own: masters left over from before a migration point at a topology
+// that no longer exists.
+type Mux struct {
+	dir  string
+	opts []string
+}
+
+// New creates the control directory and appends the multiplexing options
+// to base (the usual -ssh-opts string). Masters exit persist after their
+// last session.
+func New(base string, persist time.Duration) (*Mux, error) {
+	dir, err := os.MkdirTemp("", "p4cf-ssh-mux-")
+	if err != nil {
+		return nil, err
+	}
+	opts := append(strings.Fields(base),
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath="+filepath.Join(dir, "%r@%h:%p"),
+		"-o", fmt.Sprintf("ControlPersist=%d", int(persist.Seconds())))
+	return &Mux{dir: dir, opts: opts}, nil
+}
+
+// Opts returns the ssh options, for callers that run scp or rsync -e.
+func (m *Mux) Opts() []string {
+	return append([]string{}, m.opts...)
+}
+
+// Host returns a Host that goes through the mux.
+func (m *Mux) Host(name, addr string) Host {
+	return Host{Name: name, Addr: addr, Opts: m.opts}
+}
+
+// Close removes the control directory; the masters notice their socket is
+// gone and exit after ControlPersist.
+func (m *Mux) Close() error {
+	return os.RemoveAll(m.dir)
+}
+
+// Host runs shell commands on one node: over SSH with Opts, or locally
+// when Addr is empty (running on that node itself, like CR_RUN_LOCAL=1 in
+// cr_hw.sh).
+type Host struct {
+	Name string
+	Addr string
+	Opts []string
+}
+
+func (h Host) Command(ctx context.Context, script string) *exec.Cmd {
+	if h.Addr == "" {
+		return exec.CommandContext(ctx, "bash", "-c", script)
+	}
+	args := append(append([]string{}, h.Opts...), h.Addr, script)
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// Run executes script and returns its trimmed stdout. On failure the error
+// carries stderr, which is where podman and CRIU explain themselves.
+func (h Host) Run(ctx context.Context, script string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := h.Command(ctx, script)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%s: %w: %s", h.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Try runs script and ignores failure, for best-effort cleanup steps.
+func (h Host) Try(ctx context.Context, script string) {
+	h.Run(ctx, script)
+}
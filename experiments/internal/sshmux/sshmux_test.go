@@ -0,0 +1,85 @@
+package sshmux
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A Host without an address runs locally, which is what these tests use.
+
+func TestRunLocal(t *testing.T) {
+	h := Host{Name: "local"}
+	out, err := h.Run(context.Background(), "echo '  hello  '; echo world >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello" {
+		t.Errorf("stdout %q, want trimmed %q", out, "hello")
+	}
+}
+
+func TestRunErrorCarriesStderr(t *testing.T) {
+	h := Host{Name: "target"}
+	_, err := h.Run(context.Background(), "echo partial; echo 'no such container' >&2; exit 3")
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"target:", "exit status 3", "no such container"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err, want)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := (Host{Name: "local"}).Run(ctx, "sleep 5"); err == nil {
+		t.Error("no error after the context expired")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("took %s", d)
+	}
+}
+
+func TestCommandSSH(t *testing.T) {
+	h := Host{Name: "source", Addr: "user@node", Opts: []string{"-o", "BatchMode=yes"}}
+	cmd := h.Command(context.Background(), "uptime")
+	want := []string{"ssh", "-o", "BatchMode=yes", "user@node", "uptime"}
+	if !slices.Equal(cmd.Args, want) {
+		t.Errorf("args %q, want %q", cmd.Args, want)
+	}
+}
+
+func TestMux(t *testing.T) {
+	m, err := New("-o BatchMode=yes  -o ConnectTimeout=10", 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := m.Opts()
+	if !slices.Equal(opts[:4], []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}) {
+		t.Errorf("base options not kept: %q", opts)
+	}
+	for _, want := range []string{"ControlMaster=auto", "ControlPath=" + m.dir + "/%r@%h:%p", "ControlPersist=30"} {
+		if !slices.Contains(opts, want) {
+			t.Errorf("options %q lack %q", opts, want)
+		}
+	}
+	if h := m.Host("target", "user@node"); h.Name != "target" || !slices.Equal(h.Opts, opts) {
+		t.Errorf("host %+v", h)
+	}
+	if _, err := os.Stat(m.dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(m.dir); !os.IsNotExist(err) {
+		t.Errorf("control dir still there: %v", err)
+	}
+}